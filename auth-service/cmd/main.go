@@ -2,23 +2,35 @@ package main
 
 import (
 	logger "auth-service/internal"
+	"auth-service/internal/domain"
 	"auth-service/internal/handlers"
+	"auth-service/internal/middlewares"
+	httpRepos "auth-service/internal/repository/http"
 	postgresRepos "auth-service/internal/repository/postgres"
 	redisRepos "auth-service/internal/repository/redis"
 	"auth-service/internal/services"
 	"context"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
-	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/redis/go-redis/v9"
 	"go.uber.org/zap"
 )
 
 var (
-	authHandler *handlers.AuthHandler
+	authHandler   *handlers.AuthHandler
+	userHandler   *handlers.UserHandler
+	inviteHandler *handlers.InviteHandler
+	exportHandler *handlers.ExportHandler
+
+	tokenService *services.TokenService
 )
 
 func main() {
@@ -41,11 +53,12 @@ func main() {
 
 	logger.Info(dbConnString)
 
-	db, err := pgx.Connect(context.Background(), dbConnString)
+	// Handlers and background workers share pool, every query and transaction takes its own connection
+	db, err := pgxpool.New(context.Background(), dbConnString)
 	if err != nil {
 		logger.Fatal("Cannot open DB connection", zap.Error(err))
 	}
-	defer db.Close(context.Background())
+	defer db.Close()
 
 	// Open Redis connection
 	redisPort := os.Getenv("REDIS_PORT")
@@ -61,16 +74,95 @@ func main() {
 
 	// Initialize repositories
 	tokenRepository := redisRepos.NewRedisTokenRepo(client)
+	challengeRepository := redisRepos.NewRedisChallengeRepo(client)
+	eventPublisher := redisRepos.NewRedisEventPublisher(client)
 	userRepository := postgresRepos.NewPostgresUserRepo(db)
+	inviteRepository := postgresRepos.NewPostgresInviteRepo(db)
+	authEventRepository := postgresRepos.NewPostgresAuthEventRepo(db)
+	exportRepository := postgresRepos.NewPostgresExportRepo(db)
+	messageExportRepository := httpRepos.NewHTTPMessageExportRepo(os.Getenv("MESSAGE_SERVICE_ADDR"), os.Getenv("INTERNAL_API_KEY"))
 	logger.Info("Initialized repositories")
 
+	// Services which accept exchanged tokens, separated by comma
+	tokenAudiences := []string{"message-service"}
+	if audiences := os.Getenv("TOKEN_AUDIENCES"); audiences != "" {
+		tokenAudiences = strings.Split(audiences, ",")
+	}
+
+	tokenExchangeTTL := 5 * time.Minute
+	if ttl, err := time.ParseDuration(os.Getenv("TOKEN_EXCHANGE_TTL")); err == nil {
+		tokenExchangeTTL = ttl
+	}
+
+	// open, invite or closed
+	registrationMode := domain.RegistrationMode(os.Getenv("REGISTRATION_MODE"))
+	if registrationMode == "" {
+		registrationMode = domain.RegistrationOpen
+	}
+	if !registrationMode.IsValid() {
+		logger.Fatal("Invalid REGISTRATION_MODE", zap.String("mode", string(registrationMode)))
+	}
+
+	// Proof-of-work difficulty in bits, every bit makes puzzle twice harder
+	powBaseDifficulty := 18
+	if difficulty, err := strconv.Atoi(os.Getenv("POW_BASE_DIFFICULTY")); err == nil {
+		powBaseDifficulty = difficulty
+	}
+
+	powMaxDifficulty := 26
+	if difficulty, err := strconv.Atoi(os.Getenv("POW_MAX_DIFFICULTY")); err == nil {
+		powMaxDifficulty = difficulty
+	}
+
+	// How long user can change his mind after asking to delete account
+	deletionGracePeriod := 30 * 24 * time.Hour
+	if period, err := time.ParseDuration(os.Getenv("ACCOUNT_DELETION_GRACE_PERIOD")); err == nil {
+		deletionGracePeriod = period
+	}
+
+	// Where data exports are built and how long download link lives
+	exportDir := os.Getenv("EXPORT_DIR")
+	if exportDir == "" {
+		exportDir = filepath.Join(os.TempDir(), "exports")
+	}
+
+	exportTTL := 24 * time.Hour
+	if ttl, err := time.ParseDuration(os.Getenv("EXPORT_TTL")); err == nil {
+		exportTTL = ttl
+	}
+
 	// Initialize services
-	tokenService := services.NewTokenService(tokenRepository)
-	userService := services.NewUserService(userRepository)
+	tokenService = services.NewTokenService(tokenRepository, tokenAudiences, tokenExchangeTTL)
+	userService := services.NewUserService(userRepository, registrationMode)
+	inviteService := services.NewInviteService(inviteRepository)
+	challengeService := services.NewChallengeService(challengeRepository, powBaseDifficulty, powMaxDifficulty)
+	deletionService := services.NewDeletionService(userRepository, deletionGracePeriod)
+	authEventService := services.NewAuthEventService(authEventRepository)
+	exportService := services.NewExportService(exportRepository)
 	logger.Info("Initialized services")
 
+	// Start background workers
+	deletionWorker := services.NewDeletionWorker(userRepository, tokenRepository, eventPublisher, time.Minute)
+	go deletionWorker.Run(context.Background())
+
+	exportWorker := services.NewExportWorker(
+		exportRepository,
+		userRepository,
+		tokenRepository,
+		authEventRepository,
+		messageExportRepository,
+		exportDir,
+		exportTTL,
+		10*time.Second,
+	)
+	go exportWorker.Run(context.Background())
+	logger.Info("Started workers")
+
 	// Initialize handlers
-	authHandler = handlers.NewAuthHandler(tokenService, userService)
+	authHandler = handlers.NewAuthHandler(tokenService, userService, challengeService, authEventService)
+	userHandler = handlers.NewUserHandler(userService, deletionService, authEventService)
+	exportHandler = handlers.NewExportHandler(exportService, authEventService)
+	inviteHandler = handlers.NewInviteHandler(inviteService, userService)
 	logger.Info("Initialized handlers")
 
 	service_address := fmt.Sprintf("0.0.0.0:%s", os.Getenv("SERVICE_PORT"))
@@ -80,6 +172,18 @@ func main() {
 
 func InitRouter() *gin.Engine {
 	router := gin.Default()
+	// Registrations are limited by client IP, so X-Forwarded-For is believed only from our own proxies.
+	// Without TRUSTED_PROXIES (comma separated IPs or CIDRs) the address of connection is used
+	var trustedProxies []string
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			trustedProxies = append(trustedProxies, proxy)
+		}
+	}
+	if err := router.SetTrustedProxies(trustedProxies); err != nil {
+		logger.Fatal("Invalid TRUSTED_PROXIES", zap.Error(err))
+	}
+
 	config := cors.DefaultConfig()
 	config.AllowAllOrigins = true
 	router.Use(cors.New(config))
@@ -88,7 +192,30 @@ func InitRouter() *gin.Engine {
 	authRouter := router.Group("/auth")
 	authRouter.POST("/login", authHandler.Auth)
 	authRouter.GET("/validate", authHandler.Validate)
+	authRouter.GET("/challenge", authHandler.Challenge)
 	authRouter.POST("/register", authHandler.Register)
+	authRouter.POST("/token", authHandler.ExchangeToken)
+	authRouter.POST("/guest", authHandler.RegisterGuest)
+
+	// Download link has its own token
+	router.GET("/exports/:id/download", exportHandler.Download)
+
+	// End-points with session only
+	protected := router.Group("/")
+	protected.Use(middlewares.SessionMiddleware(tokenService))
+
+	protected.POST("/auth/upgrade", authHandler.UpgradeGuest)
+	protected.GET("/users/search", userHandler.SearchUsers)
+	protected.GET("/users/me", userHandler.GetMe)
+	protected.DELETE("/users/me", userHandler.DeleteMe)
+	protected.POST("/users/me/restore", userHandler.RestoreMe)
+	protected.POST("/users/me/export", exportHandler.RequestExport)
+	protected.GET("/users/me/export/:id", exportHandler.GetExport)
+
+	protected.POST("/invites", inviteHandler.CreateInvite)
+	protected.GET("/invites", inviteHandler.GetInvites)
+	protected.GET("/invites/invited", inviteHandler.GetInvitedUsers)
+	protected.DELETE("/invites/:code", inviteHandler.RevokeInvite)
 
 	return router
 }
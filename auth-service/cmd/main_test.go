@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestTrustedProxies(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name           string
+		trustedProxies string
+		remoteAddr     string
+		forwardedFor   string
+		want           string
+	}{
+		{name: "no trusted proxies", trustedProxies: "", remoteAddr: "10.0.0.5:4000", forwardedFor: "1.2.3.4", want: "10.0.0.5"},
+		{name: "trusted proxy", trustedProxies: "10.0.0.5", remoteAddr: "10.0.0.5:4000", forwardedFor: "1.2.3.4", want: "1.2.3.4"},
+		{name: "trusted network", trustedProxies: " 172.16.0.0/12, 10.0.0.0/8 ", remoteAddr: "10.0.0.5:4000", forwardedFor: "1.2.3.4", want: "1.2.3.4"},
+		{name: "untrusted proxy", trustedProxies: "10.0.0.6", remoteAddr: "10.0.0.5:4000", forwardedFor: "1.2.3.4", want: "10.0.0.5"},
+		{name: "client sends own header", trustedProxies: "10.0.0.5", remoteAddr: "10.0.0.5:4000", forwardedFor: "6.6.6.6, 1.2.3.4", want: "1.2.3.4"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TRUSTED_PROXIES", tt.trustedProxies)
+			router := InitRouter()
+			router.GET("/client-ip", func(c *gin.Context) {
+				c.String(http.StatusOK, c.ClientIP())
+			})
+
+			request := httptest.NewRequest(http.MethodGet, "/client-ip", nil)
+			request.RemoteAddr = tt.remoteAddr
+			request.Header.Set("X-Forwarded-For", tt.forwardedFor)
+			response := httptest.NewRecorder()
+			router.ServeHTTP(response, request)
+
+			if got := response.Body.String(); got != tt.want {
+				t.Errorf("client IP %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,6 +1,9 @@
 package auth
 
 import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
 	"os"
 	"time"
 
@@ -10,16 +13,38 @@ import (
 // So our config is useless? :(
 var jwtSecret = []byte(os.Getenv("JWT_SECRET"))
 
+const (
+	// Issuer is written in every token we sign, so services know who made it
+	Issuer = "auth-service"
+	// SessionAudience is audience of session tokens. Session token is good only for auth service itself,
+	// other services must exchange it for a token with their own audience
+	SessionAudience = "auth-service"
+
+	SessionTokenTTL = 12 * time.Hour
+)
+
 type Claims struct {
-	UserID int `json:"user_id"`
+	UserID int    `json:"user_id"`
+	Role   string `json:"role,omitempty"`
+	// Session which token was exchanged from, see SessionID. Session tokens don't have it
+	SessionID string `json:"sid,omitempty"`
 	jwt.RegisteredClaims
 }
 
-func GenerateToken(userID int) (string, error) {
+func GenerateToken(userID int, role string) (string, error) {
+	return GenerateAudienceToken(userID, role, SessionAudience, "", SessionTokenTTL)
+}
+
+// GenerateAudienceToken makes token that is valid only for one audience (service) and one session
+func GenerateAudienceToken(userID int, role string, audience string, sessionID string, ttl time.Duration) (string, error) {
 	claims := &Claims{
-		UserID: userID,
+		UserID:    userID,
+		Role:      role,
+		SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(12 * time.Hour)),
+			Issuer:    Issuer,
+			Audience:  jwt.ClaimStrings{audience},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 		},
 	}
@@ -28,12 +53,31 @@ func GenerateToken(userID int) (string, error) {
 	return token.SignedString(jwtSecret)
 }
 
+// SessionID identifies session by its token. Token itself is not put into other tokens,
+// and new login or logout changes the ID
+func SessionID(sessionToken string) string {
+	hash := sha256.Sum256([]byte(sessionToken))
+	return base64.RawURLEncoding.EncodeToString(hash[:16])
+}
+
 func ValidateToken(tokenString string) (*Claims, error) {
 	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
+		// Never trust "alg" from token header
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("unexpected signing method")
+		}
 		return jwtSecret, nil
 	})
 
+	// Malformed token, nothing was parsed
+	if token == nil {
+		return nil, err
+	}
+
 	if claims, ok := token.Claims.(*Claims); ok && token.Valid {
+		if !claims.VerifyIssuer(Issuer, true) {
+			return nil, errors.New("unknown token issuer")
+		}
 		return claims, nil
 	}
 	return nil, err
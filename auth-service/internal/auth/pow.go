@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/bits"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Challenges are signed with own secret, if it is not set - with JWT secret
+var powSecret = func() []byte {
+	if secret := os.Getenv("POW_SECRET"); secret != "" {
+		return []byte(secret)
+	}
+	return jwtSecret
+}()
+
+// Challenge is hashcash-like puzzle. Client must find such solution, that
+// sha256(challenge + ":" + solution) starts with Difficulty zero bits.
+// Server keeps nothing, everything it needs is in the signed challenge string
+type Challenge struct {
+	Nonce      string
+	Difficulty int
+	ExpiresAt  time.Time
+	// Challenge is valid only for IP it was issued to
+	ClientHash string
+}
+
+func IssueChallenge(clientIP string, difficulty int, ttl time.Duration) (string, *Challenge, error) {
+	nonceBytes := make([]byte, 16)
+	if _, err := rand.Read(nonceBytes); err != nil {
+		return "", nil, err
+	}
+
+	challenge := &Challenge{
+		Nonce:      hex.EncodeToString(nonceBytes),
+		Difficulty: difficulty,
+		ExpiresAt:  time.Now().Add(ttl),
+		ClientHash: hashClient(clientIP),
+	}
+
+	payload := fmt.Sprintf("%s:%d:%d:%s", challenge.Nonce, challenge.Difficulty, challenge.ExpiresAt.Unix(), challenge.ClientHash)
+	encodedPayload := base64.RawURLEncoding.EncodeToString([]byte(payload))
+
+	return encodedPayload + "." + sign(encodedPayload), challenge, nil
+}
+
+// VerifyChallenge checks signature, expiration, client and solution itself.
+// It doesn't know whether challenge was already used, caller must check it
+func VerifyChallenge(challengeString string, clientIP string, solution string) (*Challenge, error) {
+	encodedPayload, signature, found := strings.Cut(challengeString, ".")
+	if !found {
+		return nil, errors.New("malformed challenge")
+	}
+
+	if !hmac.Equal([]byte(signature), []byte(sign(encodedPayload))) {
+		return nil, errors.New("invalid challenge signature")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return nil, errors.New("malformed challenge")
+	}
+
+	parts := strings.Split(string(payload), ":")
+	if len(parts) != 4 {
+		return nil, errors.New("malformed challenge")
+	}
+
+	difficulty, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return nil, errors.New("malformed challenge")
+	}
+
+	expiresAt, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return nil, errors.New("malformed challenge")
+	}
+
+	challenge := &Challenge{
+		Nonce:      parts[0],
+		Difficulty: difficulty,
+		ExpiresAt:  time.Unix(expiresAt, 0),
+		ClientHash: parts[3],
+	}
+
+	if time.Now().After(challenge.ExpiresAt) {
+		return nil, errors.New("challenge expired")
+	}
+
+	if challenge.ClientHash != hashClient(clientIP) {
+		return nil, errors.New("challenge was issued to another client")
+	}
+
+	hash := sha256.Sum256([]byte(challengeString + ":" + solution))
+	if leadingZeroBits(hash[:]) < challenge.Difficulty {
+		return nil, errors.New("wrong solution")
+	}
+
+	return challenge, nil
+}
+
+func sign(data string) string {
+	mac := hmac.New(sha256.New, powSecret)
+	mac.Write([]byte(data))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func hashClient(clientIP string) string {
+	hash := sha256.Sum256([]byte(clientIP))
+	return hex.EncodeToString(hash[:8])
+}
+
+func leadingZeroBits(hash []byte) int {
+	count := 0
+	for _, b := range hash {
+		if b == 0 {
+			count += 8
+			continue
+		}
+		return count + bits.LeadingZeros8(b)
+	}
+	return count
+}
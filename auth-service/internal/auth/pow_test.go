@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// solve finds solution of challenge, or wrong one if valid is false. Low difficulty takes a few hundred tries
+func solve(challenge string, difficulty int, valid bool) string {
+	for i := 0; ; i++ {
+		solution := strconv.Itoa(i)
+		hash := sha256.Sum256([]byte(challenge + ":" + solution))
+		if (leadingZeroBits(hash[:]) >= difficulty) == valid {
+			return solution
+		}
+	}
+}
+
+func TestVerifyChallenge(t *testing.T) {
+	const (
+		clientIP   = "1.2.3.4"
+		difficulty = 8
+	)
+
+	tests := []struct {
+		name string
+		ttl  time.Duration
+		// What client sends back
+		clientIP string
+		tamper   func(challenge string) string
+		wrong    bool
+		wantErr  bool
+	}{
+		{name: "solved", ttl: time.Minute, clientIP: clientIP},
+		{name: "expired", ttl: -time.Second, clientIP: clientIP, wantErr: true},
+		{name: "other client", ttl: time.Minute, clientIP: "5.6.7.8", wantErr: true},
+		{name: "wrong solution", ttl: time.Minute, clientIP: clientIP, wrong: true, wantErr: true},
+		{name: "forged signature", ttl: time.Minute, clientIP: clientIP, tamper: func(challenge string) string { return challenge + "x" }, wantErr: true},
+		{name: "no signature", ttl: time.Minute, clientIP: clientIP, tamper: func(challenge string) string { return "payload" }, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			challenge, _, err := IssueChallenge(clientIP, difficulty, tt.ttl)
+			if err != nil {
+				t.Fatalf("cannot issue challenge: %v", err)
+			}
+			if tt.tamper != nil {
+				challenge = tt.tamper(challenge)
+			}
+
+			solution := solve(challenge, difficulty, !tt.wrong)
+			verified, err := VerifyChallenge(challenge, tt.clientIP, solution)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && verified.Difficulty != difficulty {
+				t.Errorf("difficulty %d, want %d", verified.Difficulty, difficulty)
+			}
+		})
+	}
+}
+
+func TestLeadingZeroBits(t *testing.T) {
+	tests := []struct {
+		hash []byte
+		want int
+	}{
+		{hash: []byte{0x80, 0}, want: 0},
+		{hash: []byte{0x01, 0}, want: 7},
+		{hash: []byte{0, 0x40}, want: 9},
+		{hash: []byte{0, 0}, want: 16},
+	}
+
+	for _, tt := range tests {
+		if got := leadingZeroBits(tt.hash); got != tt.want {
+			t.Errorf("leadingZeroBits(%x) = %d, want %d", tt.hash, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package domain
+
+import (
+	"auth-service/internal/utils"
+	"time"
+)
+
+type AuthEventType string
+
+const (
+	AuthEventRegister          AuthEventType = "register"
+	AuthEventGuestRegister     AuthEventType = "guest_register"
+	AuthEventLogin             AuthEventType = "login"
+	AuthEventGuestUpgrade      AuthEventType = "guest_upgrade"
+	AuthEventDeletionRequested AuthEventType = "deletion_requested"
+	AuthEventDeletionCancelled AuthEventType = "deletion_cancelled"
+	AuthEventExportRequested   AuthEventType = "export_requested"
+)
+
+type (
+	// Auth events is history of what happened with account. User can get it in data export
+	AuthEventRepository interface {
+		RecordEvent(event *AuthEvent) *utils.APIError
+		GetUserEvents(userID int) ([]AuthEvent, *utils.APIError)
+	}
+
+	AuthEvent struct {
+		ID        int64         `json:"id"`
+		UserID    int           `json:"user_id"`
+		Type      AuthEventType `json:"type"`
+		IP        string        `json:"ip"`
+		UserAgent string        `json:"user_agent"`
+		CreatedAt time.Time     `json:"created_at"`
+	}
+)
@@ -0,0 +1,25 @@
+package domain
+
+import (
+	"auth-service/internal/utils"
+	"context"
+	"time"
+)
+
+type (
+	ChallengeRepository interface {
+		// Returns false if challenge was already used
+		MarkChallengeUsed(ctx context.Context, nonce string, ttl time.Duration) (bool, *utils.APIError)
+		// How many users were registered from this client in current window
+		GetRegistrationCount(ctx context.Context, clientIP string) (int, *utils.APIError)
+		IncrementRegistrationCount(ctx context.Context, clientIP string, window time.Duration) *utils.APIError
+	}
+
+	// DTO for issued challenge
+	ChallengeResponse struct {
+		Challenge  string    `json:"challenge"`
+		Difficulty int       `json:"difficulty"`
+		Algorithm  string    `json:"algorithm"`
+		ExpiresAt  time.Time `json:"expires_at"`
+	}
+)
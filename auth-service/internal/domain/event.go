@@ -0,0 +1,27 @@
+package domain
+
+import (
+	"auth-service/internal/utils"
+	"context"
+	"time"
+)
+
+type EventType string
+
+const (
+	// User account and all his personal data were erased
+	UserDeletedEvent EventType = "user.deleted"
+)
+
+type (
+	// EventPublisher sends events to other services
+	EventPublisher interface {
+		PublishUserEvent(ctx context.Context, event *UserEvent) *utils.APIError
+	}
+
+	UserEvent struct {
+		Type       EventType `json:"type"`
+		UserID     int       `json:"user_id"`
+		OccurredAt time.Time `json:"occurred_at"`
+	}
+)
@@ -0,0 +1,54 @@
+package domain
+
+import (
+	"auth-service/internal/utils"
+	"context"
+	"io"
+	"time"
+)
+
+type ExportStatus string
+
+const (
+	ExportPending ExportStatus = "pending"
+	ExportRunning ExportStatus = "running"
+	ExportReady   ExportStatus = "ready"
+	ExportFailed  ExportStatus = "failed"
+	// File was removed, ask for new export
+	ExportExpired ExportStatus = "expired"
+)
+
+type (
+	ExportRepository interface {
+		CreateExportJob(job *ExportJob) *utils.APIError
+		GetExportJob(id string) (*ExportJob, *utils.APIError)
+		// Pending or running job of user, if he has one
+		GetActiveExportJob(userID int) (*ExportJob, *utils.APIError)
+		// Marks the oldest pending job running and returns it, nil if there is none.
+		// Job is claimed in one statement, so two workers never get the same job
+		ClaimPendingExportJob(startedAt time.Time) (*ExportJob, *utils.APIError)
+		// Running jobs started before startedBefore become pending again, their worker is gone. Returns how many
+		RequeueStaleExportJobs(startedBefore time.Time) (int64, *utils.APIError)
+		GetExpiredExportJobs(limit int) ([]ExportJob, *utils.APIError)
+		UpdateExportJob(job *ExportJob) *utils.APIError
+	}
+
+	// Messages live in message service, so we ask it for them
+	MessageExportRepository interface {
+		// Writes JSON array of all messages user sent or received
+		WriteUserMessages(ctx context.Context, userID int, w io.Writer) *utils.APIError
+	}
+
+	ExportJob struct {
+		ID            string       `json:"id"`
+		UserID        int          `json:"user_id"`
+		Status        ExportStatus `json:"status"`
+		FilePath      string       `json:"-"`
+		DownloadToken string       `json:"-"`
+		Error         string       `json:"error,omitempty"`
+		CreatedAt     time.Time    `json:"created_at"`
+		StartedAt     *time.Time   `json:"-"`
+		FinishedAt    *time.Time   `json:"finished_at,omitempty"`
+		ExpiresAt     *time.Time   `json:"expires_at,omitempty"`
+	}
+)
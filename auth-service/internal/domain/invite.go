@@ -0,0 +1,46 @@
+package domain
+
+import (
+	"auth-service/internal/utils"
+	"time"
+)
+
+type RegistrationMode string
+
+const (
+	// Anybody can register, invite code is optional
+	RegistrationOpen RegistrationMode = "open"
+	// Only with invite code
+	RegistrationInviteOnly RegistrationMode = "invite"
+	// Nobody can register
+	RegistrationClosed RegistrationMode = "closed"
+)
+
+func (m RegistrationMode) IsValid() bool {
+	switch m {
+	case RegistrationOpen, RegistrationInviteOnly, RegistrationClosed:
+		return true
+	default:
+		return false
+	}
+}
+
+type (
+	InviteRepository interface {
+		CreateInvite(invite *Invite) *utils.APIError
+		GetInvite(code string) (*Invite, *utils.APIError)
+		GetInvitesByCreator(creatorID int) ([]Invite, *utils.APIError)
+		RevokeInvite(code string) *utils.APIError
+	}
+
+	Invite struct {
+		Code      string     `json:"code"`
+		CreatedBy int        `json:"created_by"`
+		Role      UserRole   `json:"role"`
+		MaxUses   int        `json:"max_uses"`
+		Uses      int        `json:"uses"`
+		ExpiresAt *time.Time `json:"expires_at"`
+		RevokedAt *time.Time `json:"revoked_at,omitempty"`
+		CreatedAt time.Time  `json:"created_at"`
+	}
+)
@@ -6,17 +6,43 @@ import (
 	"time"
 )
 
+// Token exchange (RFC 8693) identifiers
+const (
+	TokenExchangeGrantType = "urn:ietf:params:oauth:grant-type:token-exchange"
+	AccessTokenType        = "urn:ietf:params:oauth:token-type:access_token"
+	JWTTokenType           = "urn:ietf:params:oauth:token-type:jwt"
+)
+
+// Error codes of OAuth token endpoint (RFC 6749 and RFC 8693)
+const (
+	TokenErrInvalidRequest       = "invalid_request"
+	TokenErrInvalidGrant         = "invalid_grant"
+	TokenErrInvalidTarget        = "invalid_target"
+	TokenErrUnsupportedGrantType = "unsupported_grant_type"
+	TokenErrServerError          = "server_error"
+)
+
 type (
 	TokenRepository interface {
 		SaveToken(ctx context.Context, userID int, fingerprintHash string, token *Token) *utils.APIError
 		GetToken(ctx context.Context, userID int, fingerprintHash string) (string, *utils.APIError)
 		IsTokenExists(ctx context.Context, token string) bool
+		// Removes tokens of all sessions of user
+		DeleteUserTokens(ctx context.Context, userID int) *utils.APIError
+		GetUserSessions(ctx context.Context, userID int) ([]Session, *utils.APIError)
 	}
 
 	Token struct {
 		UserID    int       `json:"user_id"`
 		Token     string    `json:"token"`
+		Audience  string    `json:"-"`
 		IssuedAt  time.Time `json:"-"`
 		ExpiresAt time.Time `json:"-"`
 	}
+
+	// One logged in client of user
+	Session struct {
+		Fingerprint string    `json:"fingerprint"`
+		ExpiresAt   time.Time `json:"expires_at"`
+	}
 )
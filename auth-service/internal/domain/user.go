@@ -5,30 +5,78 @@ import (
 	"time"
 )
 
+type UserRole string
+
+const (
+	RoleUser UserRole = "user"
+	// Guest is anonymous user without password. He can try the messenger, but with limits
+	RoleGuest UserRole = "guest"
+	RoleAdmin UserRole = "admin"
+)
+
+func (r UserRole) IsValid() bool {
+	switch r {
+	case RoleUser, RoleGuest, RoleAdmin:
+		return true
+	default:
+		return false
+	}
+}
+
+// CanListDirectory says whether user can search other users
+func (r UserRole) CanListDirectory() bool {
+	return r != RoleGuest
+}
+
 type (
 	UserRepository interface {
 		CreateUser(user *User) (int, *utils.APIError)
+		// Redeems invite and creates user in one transaction. Role and inviter are taken from invite
+		CreateUserWithInvite(user *User, inviteCode string) (int, *utils.APIError)
+		GetInvitedUsers(inviterID int) ([]User, *utils.APIError)
 		GetUserByID(id int) (*User, *utils.APIError)
 		GetUserByUsername(username string) (*User, *utils.APIError)
+		// Sets username and password of guest and makes him regular user. ID stays the same
+		UpgradeGuest(id int, username string, password string) *utils.APIError
+		SearchUsers(usernamePrefix string, limit int) ([]User, *utils.APIError)
+		// Account deletion. User is deleted when grace period is over, until then he can cancel it
+		ScheduleDeletion(id int, deleteAfter time.Time) *utils.APIError
+		CancelDeletion(id int) *utils.APIError
+		GetUsersDueForDeletion(limit int) ([]User, *utils.APIError)
+		// Erases all personal data of user. Row itself stays as anonymous tombstone,
+		// so messages can be kept if erasure policy of message service says so.
+		// Fails with 409 if deletion was cancelled or user is already erased
+		EraseUser(id int) *utils.APIError
+		// Erased users which deletion event is not published yet
+		GetUsersPendingDeletionEvent(limit int) ([]int, *utils.APIError)
+		MarkDeletionEventPublished(id int) *utils.APIError
 	}
 
 	User struct {
-		ID        int       `json:"id"`
-		Username  string    `json:"username"`
-		Password  string    `json:"password"`
-		CreatedAt time.Time `json:"created_at"`
+		ID        int      `json:"id"`
+		Username  string   `json:"username"`
+		Password  string   `json:"password"`
+		Role      UserRole `json:"role"`
+		InvitedBy *int     `json:"invited_by,omitempty"`
+		// Set when user asked to delete his account
+		DeleteAfter *time.Time `json:"delete_after,omitempty"`
+		CreatedAt   time.Time  `json:"created_at"`
 	}
 
 	// DTO for user data
 	UserResponse struct {
-		ID       int    `json:"id"`
-		Username string `json:"username"`
+		ID          int        `json:"id"`
+		Username    string     `json:"username"`
+		Role        UserRole   `json:"role"`
+		DeleteAfter *time.Time `json:"delete_after,omitempty"`
 	}
 )
 
 func (user *User) ToUserResponse() *UserResponse {
 	return &UserResponse{
-		ID:       user.ID,
-		Username: user.Username,
+		ID:          user.ID,
+		Username:    user.Username,
+		Role:        user.Role,
+		DeleteAfter: user.DeleteAfter,
 	}
 }
@@ -8,26 +8,50 @@ import (
 	"context"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
 type AuthHandler struct {
-	tokenService *services.TokenService
-	userService  *services.UserService
+	tokenService     *services.TokenService
+	userService      *services.UserService
+	challengeService *services.ChallengeService
+	authEventService *services.AuthEventService
 }
 
-func NewAuthHandler(tokenService *services.TokenService, userService *services.UserService) *AuthHandler {
+func NewAuthHandler(
+	tokenService *services.TokenService,
+	userService *services.UserService,
+	challengeService *services.ChallengeService,
+	authEventService *services.AuthEventService,
+) *AuthHandler {
 	return &AuthHandler{
-		tokenService: tokenService,
-		userService:  userService,
+		tokenService:     tokenService,
+		userService:      userService,
+		challengeService: challengeService,
+		authEventService: authEventService,
 	}
 }
 
+// Challenge gives proof-of-work puzzle, which must be solved before registration
+func (h *AuthHandler) Challenge(ctx *gin.Context) {
+	challenge, apiErr := h.challengeService.CreateChallenge(context.Background(), ctx.ClientIP())
+	if apiErr != nil {
+		ctx.JSON(apiErr.Code, gin.H{"details": apiErr.Details, "error": apiErr.Message})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, challenge)
+}
+
 func (h *AuthHandler) Register(ctx *gin.Context) {
 	var requestForm struct {
-		Username string `json:"username"`
-		Password string `json:"password"`
+		Username   string `json:"username"`
+		Password   string `json:"password"`
+		InviteCode string `json:"invite_code"`
+		Challenge  string `json:"challenge"`
+		Solution   string `json:"solution"`
 	}
 
 	if err := ctx.ShouldBindJSON(&requestForm); err != nil {
@@ -35,19 +59,27 @@ func (h *AuthHandler) Register(ctx *gin.Context) {
 		return
 	}
 
+	apiErr := h.challengeService.VerifyChallenge(context.Background(), requestForm.Challenge, requestForm.Solution, ctx.ClientIP())
+	if apiErr != nil {
+		ctx.JSON(apiErr.Code, gin.H{"details": apiErr.Details, "error": apiErr.Message})
+		return
+	}
+
 	user := &domain.User{
 		Username: requestForm.Username,
 		Password: requestForm.Password,
 	}
 
-	createdUser, apiErr := h.userService.CreateUser(user)
+	createdUser, apiErr := h.userService.CreateUser(user, requestForm.InviteCode)
 	if apiErr != nil {
 		ctx.JSON(apiErr.Code, gin.H{"details": apiErr.Details, "error": apiErr.Message})
 		return
 	}
+	h.challengeService.RecordRegistration(context.Background(), ctx.ClientIP())
+	h.authEventService.Record(createdUser.ID, domain.AuthEventRegister, ctx.ClientIP(), ctx.GetHeader("User-Agent"))
 
 	fingerprint := utils.GenerateFingerprint(ctx)
-	token, apiErr := h.tokenService.CreateToken(context.Background(), createdUser.ID, fingerprint)
+	token, apiErr := h.tokenService.CreateToken(context.Background(), createdUser.ID, createdUser.Role, fingerprint)
 
 	if apiErr != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"details": "Internal server error", "error": "Cannot authorizate"})
@@ -57,6 +89,78 @@ func (h *AuthHandler) Register(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, gin.H{"token": token.Token})
 }
 
+// RegisterGuest lets people try messenger without registration
+func (h *AuthHandler) RegisterGuest(ctx *gin.Context) {
+	// Guests are as easy for bots as registration, so they solve the same puzzle
+	var requestForm struct {
+		Challenge string `json:"challenge"`
+		Solution  string `json:"solution"`
+	}
+
+	if err := ctx.ShouldBindJSON(&requestForm); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input data"})
+		return
+	}
+
+	apiErr := h.challengeService.VerifyChallenge(context.Background(), requestForm.Challenge, requestForm.Solution, ctx.ClientIP())
+	if apiErr != nil {
+		ctx.JSON(apiErr.Code, gin.H{"details": apiErr.Details, "error": apiErr.Message})
+		return
+	}
+
+	guest, apiErr := h.userService.CreateGuest()
+	if apiErr != nil {
+		ctx.JSON(apiErr.Code, gin.H{"details": apiErr.Details, "error": apiErr.Message})
+		return
+	}
+	h.challengeService.RecordRegistration(context.Background(), ctx.ClientIP())
+	h.authEventService.Record(guest.ID, domain.AuthEventGuestRegister, ctx.ClientIP(), ctx.GetHeader("User-Agent"))
+
+	fingerprint := utils.GenerateFingerprint(ctx)
+	token, apiErr := h.tokenService.CreateToken(context.Background(), guest.ID, guest.Role, fingerprint)
+
+	if apiErr != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"details": "Internal server error", "error": "Cannot authorizate"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"token": token.Token, "user": guest.ToUserResponse()})
+}
+
+// UpgradeGuest turns guest into full account. Route is protected, so user is already in context
+func (h *AuthHandler) UpgradeGuest(ctx *gin.Context) {
+	var requestForm struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+
+	if err := ctx.ShouldBindJSON(&requestForm); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input data"})
+		return
+	}
+
+	userID := ctx.GetInt("user_id")
+
+	user, apiErr := h.userService.UpgradeGuest(userID, requestForm.Username, requestForm.Password)
+	if apiErr != nil {
+		ctx.JSON(apiErr.Code, gin.H{"details": apiErr.Details, "error": apiErr.Message})
+		return
+	}
+
+	h.authEventService.Record(user.ID, domain.AuthEventGuestUpgrade, ctx.ClientIP(), ctx.GetHeader("User-Agent"))
+
+	// Role is in token, so guest token must be replaced
+	fingerprint := utils.GenerateFingerprint(ctx)
+	token, apiErr := h.tokenService.CreateToken(context.Background(), user.ID, user.Role, fingerprint)
+
+	if apiErr != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"details": "Internal server error", "error": "Cannot authorizate"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"token": token.Token, "user": user.ToUserResponse()})
+}
+
 func (h *AuthHandler) Auth(ctx *gin.Context) {
 
 	var authForm struct {
@@ -93,13 +197,15 @@ func (h *AuthHandler) Auth(ctx *gin.Context) {
 	// There would be a problem here with repeating records
 	fingerprint := utils.GenerateFingerprint(ctx)
 
-	token, apiErr := h.tokenService.CreateToken(context.Background(), user.ID, fingerprint)
+	token, apiErr := h.tokenService.CreateToken(context.Background(), user.ID, user.Role, fingerprint)
 
 	if apiErr != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"details": "Internal server error", "error": "Cannot authorizate"})
 		return
 	}
 
+	h.authEventService.Record(user.ID, domain.AuthEventLogin, ctx.ClientIP(), ctx.GetHeader("User-Agent"))
+
 	ctx.JSON(http.StatusOK, gin.H{"token": token.Token})
 }
 
@@ -115,12 +221,79 @@ func (h *AuthHandler) Validate(ctx *gin.Context) {
 	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
 
 	fingerprint := utils.GenerateFingerprint(ctx)
-	tokenClaims, apiErr := h.tokenService.ValidateToken(context.Background(), tokenString, fingerprint)
+
+	// Services ask to validate tokens made for them, no audience means session token
+	var tokenClaims *auth.Claims
+	var apiErr *utils.APIError
+	if audience := ctx.Query("audience"); audience != "" {
+		tokenClaims, apiErr = h.tokenService.ValidateAudienceToken(context.Background(), tokenString, fingerprint, audience)
+	} else {
+		tokenClaims, apiErr = h.tokenService.ValidateToken(context.Background(), tokenString, fingerprint)
+	}
 
 	if tokenClaims == nil || apiErr != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{"valid": "no"})
 		return
 	}
 
-	ctx.JSON(http.StatusOK, gin.H{"valid": "yes", "user_id": tokenClaims.UserID})
+	// Tokens made before roles appeared have no role, they are regular users
+	role := tokenClaims.Role
+	if role == "" {
+		role = string(domain.RoleUser)
+	}
+
+	response := gin.H{"valid": "yes", "user_id": tokenClaims.UserID, "role": role}
+	// Services close long connections when token expires
+	if tokenClaims.ExpiresAt != nil {
+		response["expires_at"] = tokenClaims.ExpiresAt.Unix()
+	}
+	ctx.JSON(http.StatusOK, response)
+}
+
+// ExchangeToken is token endpoint from RFC 8693. Here we speak OAuth language,
+// so request is form encoded and errors look like {"error": "...", "error_description": "..."}
+func (h *AuthHandler) ExchangeToken(ctx *gin.Context) {
+	// Tokens must not be cached anywhere
+	ctx.Header("Cache-Control", "no-store")
+	ctx.Header("Pragma", "no-cache")
+
+	if ctx.PostForm("grant_type") != domain.TokenExchangeGrantType {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": domain.TokenErrUnsupportedGrantType})
+		return
+	}
+
+	subjectToken := ctx.PostForm("subject_token")
+	subjectTokenType := ctx.PostForm("subject_token_type")
+	audience := ctx.PostForm("audience")
+
+	if subjectToken == "" || audience == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": domain.TokenErrInvalidRequest, "error_description": "subject_token and audience are required"})
+		return
+	}
+
+	if subjectTokenType != domain.AccessTokenType && subjectTokenType != domain.JWTTokenType {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": domain.TokenErrInvalidRequest, "error_description": "Unsupported subject_token_type"})
+		return
+	}
+
+	// We can issue only access tokens
+	requestedTokenType := ctx.PostForm("requested_token_type")
+	if requestedTokenType != "" && requestedTokenType != domain.AccessTokenType && requestedTokenType != domain.JWTTokenType {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": domain.TokenErrInvalidRequest, "error_description": "Unsupported requested_token_type"})
+		return
+	}
+
+	fingerprint := utils.GenerateFingerprint(ctx)
+	token, apiErr := h.tokenService.ExchangeToken(context.Background(), subjectToken, fingerprint, audience)
+	if apiErr != nil {
+		ctx.JSON(apiErr.Code, gin.H{"error": apiErr.Message, "error_description": apiErr.Details})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"access_token":      token.Token,
+		"issued_token_type": domain.AccessTokenType,
+		"token_type":        "Bearer",
+		"expires_in":        int(time.Until(token.ExpiresAt).Seconds()),
+	})
 }
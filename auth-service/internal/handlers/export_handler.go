@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"auth-service/internal/domain"
+	"auth-service/internal/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ExportHandler struct {
+	exportService    *services.ExportService
+	authEventService *services.AuthEventService
+}
+
+func NewExportHandler(exportService *services.ExportService, authEventService *services.AuthEventService) *ExportHandler {
+	return &ExportHandler{
+		exportService:    exportService,
+		authEventService: authEventService,
+	}
+}
+
+// RequestExport starts building archive with all personal data. Poll GetExport to know when it is ready
+func (h *ExportHandler) RequestExport(ctx *gin.Context) {
+	userID := ctx.GetInt("user_id")
+
+	job, apiErr := h.exportService.RequestExport(userID)
+	if apiErr != nil {
+		ctx.JSON(apiErr.Code, gin.H{"details": apiErr.Details, "error": apiErr.Message})
+		return
+	}
+
+	h.authEventService.Record(userID, domain.AuthEventExportRequested, ctx.ClientIP(), ctx.GetHeader("User-Agent"))
+
+	ctx.JSON(http.StatusAccepted, gin.H{"export": job})
+}
+
+func (h *ExportHandler) GetExport(ctx *gin.Context) {
+	job, apiErr := h.exportService.GetExport(ctx.GetInt("user_id"), ctx.Param("id"))
+	if apiErr != nil {
+		ctx.JSON(apiErr.Code, gin.H{"details": apiErr.Details, "error": apiErr.Message})
+		return
+	}
+
+	response := gin.H{"export": job}
+	if job.Status == domain.ExportReady {
+		// Link works without Authorization header, so it can be opened right in browser
+		response["download_url"] = "/exports/" + job.ID + "/download?token=" + job.DownloadToken
+	}
+
+	ctx.JSON(http.StatusOK, response)
+}
+
+func (h *ExportHandler) Download(ctx *gin.Context) {
+	filePath, apiErr := h.exportService.GetDownload(ctx.Param("id"), ctx.Query("token"))
+	if apiErr != nil {
+		ctx.JSON(apiErr.Code, gin.H{"details": apiErr.Details, "error": apiErr.Message})
+		return
+	}
+
+	ctx.Header("Cache-Control", "no-store")
+	ctx.FileAttachment(filePath, "export.zip")
+}
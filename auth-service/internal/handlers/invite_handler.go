@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"auth-service/internal/domain"
+	"auth-service/internal/services"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type InviteHandler struct {
+	inviteService *services.InviteService
+	userService   *services.UserService
+}
+
+func NewInviteHandler(inviteService *services.InviteService, userService *services.UserService) *InviteHandler {
+	return &InviteHandler{
+		inviteService: inviteService,
+		userService:   userService,
+	}
+}
+
+func (h *InviteHandler) CreateInvite(ctx *gin.Context) {
+	var requestForm struct {
+		Role    domain.UserRole `json:"role"`
+		MaxUses int             `json:"max_uses"`
+		// In seconds, 0 - never expires
+		ExpiresIn int `json:"expires_in"`
+	}
+
+	if err := ctx.ShouldBindJSON(&requestForm); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input data"})
+		return
+	}
+
+	userID := ctx.GetInt("user_id")
+	role, ok := ctx.MustGet("user_role").(domain.UserRole)
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	invite, apiErr := h.inviteService.CreateInvite(
+		userID,
+		role,
+		requestForm.Role,
+		requestForm.MaxUses,
+		time.Duration(requestForm.ExpiresIn)*time.Second,
+	)
+	if apiErr != nil {
+		ctx.JSON(apiErr.Code, gin.H{"details": apiErr.Details, "error": apiErr.Message})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, gin.H{"invite": invite})
+}
+
+func (h *InviteHandler) GetInvites(ctx *gin.Context) {
+	invites, apiErr := h.inviteService.GetInvites(ctx.GetInt("user_id"))
+	if apiErr != nil {
+		ctx.JSON(apiErr.Code, gin.H{"details": apiErr.Details, "error": apiErr.Message})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"invites": invites})
+}
+
+func (h *InviteHandler) RevokeInvite(ctx *gin.Context) {
+	role, ok := ctx.MustGet("user_role").(domain.UserRole)
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	apiErr := h.inviteService.RevokeInvite(ctx.Param("code"), ctx.GetInt("user_id"), role)
+	if apiErr != nil {
+		ctx.JSON(apiErr.Code, gin.H{"details": apiErr.Details, "error": apiErr.Message})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// GetInvitedUsers shows whom user invited
+func (h *InviteHandler) GetInvitedUsers(ctx *gin.Context) {
+	users, apiErr := h.userService.GetInvitedUsers(ctx.GetInt("user_id"))
+	if apiErr != nil {
+		ctx.JSON(apiErr.Code, gin.H{"details": apiErr.Details, "error": apiErr.Message})
+		return
+	}
+
+	response := make([]*domain.UserResponse, 0, len(users))
+	for i := range users {
+		response = append(response, users[i].ToUserResponse())
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"users": response})
+}
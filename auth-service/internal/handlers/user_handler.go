@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"auth-service/internal/domain"
+	"auth-service/internal/services"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type UserHandler struct {
+	userService      *services.UserService
+	deletionService  *services.DeletionService
+	authEventService *services.AuthEventService
+}
+
+func NewUserHandler(userService *services.UserService, deletionService *services.DeletionService, authEventService *services.AuthEventService) *UserHandler {
+	return &UserHandler{
+		userService:      userService,
+		deletionService:  deletionService,
+		authEventService: authEventService,
+	}
+}
+
+func (h *UserHandler) GetMe(ctx *gin.Context) {
+	user, apiErr := h.userService.GetUserByID(ctx.GetInt("user_id"))
+	if apiErr != nil {
+		ctx.JSON(apiErr.Code, gin.H{"details": apiErr.Details, "error": apiErr.Message})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"user": user.ToUserResponse()})
+}
+
+// DeleteMe schedules account deletion. Until grace period is over, it can be cancelled with RestoreMe
+func (h *UserHandler) DeleteMe(ctx *gin.Context) {
+	var requestForm struct {
+		Password string `json:"password"`
+	}
+
+	if err := ctx.ShouldBindJSON(&requestForm); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input data"})
+		return
+	}
+
+	user, apiErr := h.deletionService.RequestDeletion(ctx.GetInt("user_id"), requestForm.Password)
+	if apiErr != nil {
+		ctx.JSON(apiErr.Code, gin.H{"details": apiErr.Details, "error": apiErr.Message})
+		return
+	}
+
+	h.authEventService.Record(user.ID, domain.AuthEventDeletionRequested, ctx.ClientIP(), ctx.GetHeader("User-Agent"))
+
+	ctx.JSON(http.StatusAccepted, gin.H{"delete_after": user.DeleteAfter})
+}
+
+func (h *UserHandler) RestoreMe(ctx *gin.Context) {
+	userID := ctx.GetInt("user_id")
+
+	apiErr := h.deletionService.CancelDeletion(userID)
+	if apiErr != nil {
+		ctx.JSON(apiErr.Code, gin.H{"details": apiErr.Details, "error": apiErr.Message})
+		return
+	}
+
+	h.authEventService.Record(userID, domain.AuthEventDeletionCancelled, ctx.ClientIP(), ctx.GetHeader("User-Agent"))
+
+	ctx.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// SearchUsers is users directory. Finds users by beginning of username
+func (h *UserHandler) SearchUsers(ctx *gin.Context) {
+	role, ok := ctx.MustGet("user_role").(domain.UserRole)
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	limit := 0
+	if limitString := ctx.Query("limit"); limitString != "" {
+		var err error
+		if limit, err = strconv.Atoi(limitString); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input data"})
+			return
+		}
+	}
+
+	users, apiErr := h.userService.SearchUsers(role, ctx.Query("username"), limit)
+	if apiErr != nil {
+		ctx.JSON(apiErr.Code, gin.H{"details": apiErr.Details, "error": apiErr.Message})
+		return
+	}
+
+	response := make([]*domain.UserResponse, 0, len(users))
+	for i := range users {
+		response = append(response, users[i].ToUserResponse())
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"users": response})
+}
@@ -0,0 +1,51 @@
+package middlewares
+
+import (
+	"auth-service/internal/domain"
+	"auth-service/internal/services"
+	"auth-service/internal/utils"
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SessionMiddleware checks session token from header, same way as /auth/validate does
+func SessionMiddleware(tokenService *services.TokenService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		authHeader := c.GetHeader("Authorization")
+		if authHeader == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header is required"})
+			c.Abort()
+			return
+		}
+
+		// Split from "Bearer"
+		tokenParts := strings.Split(authHeader, " ")
+		if len(tokenParts) != 2 || strings.ToLower(tokenParts[0]) != "bearer" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid Authorization header format"})
+			c.Abort()
+			return
+		}
+
+		fingerprint := utils.GenerateFingerprint(c)
+		claims, apiErr := tokenService.ValidateToken(context.Background(), tokenParts[1], fingerprint)
+		if apiErr != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+			c.Abort()
+			return
+		}
+
+		// Tokens made before roles appeared have no role, they are regular users
+		role := domain.UserRole(claims.Role)
+		if role == "" {
+			role = domain.RoleUser
+		}
+
+		// Set user in context, so then we can use it in handlers
+		c.Set("user_id", claims.UserID)
+		c.Set("user_role", role)
+		c.Next()
+	}
+}
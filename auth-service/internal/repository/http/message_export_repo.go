@@ -0,0 +1,74 @@
+package repositories
+
+import (
+	"auth-service/internal/utils"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	logger "auth-service/internal"
+
+	"go.uber.org/zap"
+)
+
+// Message service sets it after the last message, response without it is cut
+const exportCompleteTrailer = "X-Export-Complete"
+
+// HTTPMessageExportRepo gets messages from internal API of message service
+type HTTPMessageExportRepo struct {
+	client         *http.Client
+	messageService string
+	internalKey    string
+}
+
+func NewHTTPMessageExportRepo(messageServiceURL string, internalKey string) *HTTPMessageExportRepo {
+	return &HTTPMessageExportRepo{
+		client:         &http.Client{},
+		messageService: messageServiceURL,
+		internalKey:    internalKey,
+	}
+}
+
+func (repo *HTTPMessageExportRepo) WriteUserMessages(ctx context.Context, userID int, w io.Writer) *utils.APIError {
+	url := fmt.Sprintf("%s/internal/users/%d/messages", repo.messageService, userID)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return utils.NewAPIError(500, "Failed to create messages request", err.Error())
+	}
+	req.Header.Set("X-Internal-Key", repo.internalKey)
+
+	resp, err := repo.client.Do(req)
+	if err != nil {
+		logger.Error("Cannot get messages from message service",
+			zap.Int("User ID", userID),
+			zap.Error(err))
+		return utils.NewAPIError(503, "Message service unavailable", err.Error())
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		logger.Error("Message service refused to give messages",
+			zap.Int("User ID", userID),
+			zap.Int("Status", resp.StatusCode))
+		return utils.NewAPIError(502, "Failed to get messages", fmt.Sprintf("Message service answered %d", resp.StatusCode))
+	}
+
+	// Messages can be a lot, so we don't keep them in memory
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		logger.Error("Cannot copy messages",
+			zap.Int("User ID", userID),
+			zap.Error(err))
+		return utils.NewAPIError(502, "Failed to get messages", err.Error())
+	}
+
+	// Trailers are known only after body is read to the end
+	if resp.Trailer.Get(exportCompleteTrailer) != "true" {
+		logger.Error("Message service didn't finish messages",
+			zap.Int("User ID", userID))
+		return utils.NewAPIError(502, "Failed to get messages", "Export of messages is incomplete")
+	}
+
+	return nil
+}
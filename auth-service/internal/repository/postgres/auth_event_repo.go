@@ -0,0 +1,68 @@
+package repositories
+
+import (
+	"auth-service/internal/domain"
+	"auth-service/internal/utils"
+	"context"
+
+	logger "auth-service/internal"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+type PostgresAuthEventRepo struct {
+	db *pgxpool.Pool
+}
+
+func NewPostgresAuthEventRepo(db *pgxpool.Pool) *PostgresAuthEventRepo {
+	return &PostgresAuthEventRepo{db: db}
+}
+
+func (repo *PostgresAuthEventRepo) RecordEvent(event *domain.AuthEvent) *utils.APIError {
+	query := `INSERT INTO auth_events (user_id, type, ip, user_agent)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at`
+
+	err := repo.db.QueryRow(context.Background(), query,
+		event.UserID,
+		event.Type,
+		event.IP,
+		event.UserAgent).Scan(&event.ID, &event.CreatedAt)
+
+	if err != nil {
+		logger.Error("Cannot record auth event",
+			zap.Int("User ID", event.UserID),
+			zap.String("Type", string(event.Type)),
+			zap.Error(err))
+		return ClassifyDBerror(err)
+	}
+
+	return nil
+}
+
+func (repo *PostgresAuthEventRepo) GetUserEvents(userID int) ([]domain.AuthEvent, *utils.APIError) {
+	query := `SELECT id, user_id, type, ip, user_agent, created_at
+		FROM auth_events WHERE user_id = $1
+		ORDER BY created_at`
+
+	rows, err := repo.db.Query(context.Background(), query, userID)
+	if err != nil {
+		logger.Error("Cannot get auth events",
+			zap.Int("User ID", userID),
+			zap.Error(err))
+		return nil, ClassifyDBerror(err)
+	}
+	defer rows.Close()
+
+	events := []domain.AuthEvent{}
+	for rows.Next() {
+		var event domain.AuthEvent
+		if err := rows.Scan(&event.ID, &event.UserID, &event.Type, &event.IP, &event.UserAgent, &event.CreatedAt); err != nil {
+			return nil, ClassifyDBerror(err)
+		}
+		events = append(events, event)
+	}
+
+	return events, nil
+}
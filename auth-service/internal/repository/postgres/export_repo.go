@@ -0,0 +1,161 @@
+package repositories
+
+import (
+	"auth-service/internal/domain"
+	"auth-service/internal/utils"
+	"context"
+	"time"
+
+	logger "auth-service/internal"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+const exportJobColumns = "id, user_id, status, file_path, download_token, error, created_at, started_at, finished_at, expires_at"
+
+type PostgresExportRepo struct {
+	db *pgxpool.Pool
+}
+
+func NewPostgresExportRepo(db *pgxpool.Pool) *PostgresExportRepo {
+	return &PostgresExportRepo{db: db}
+}
+
+func (repo *PostgresExportRepo) CreateExportJob(job *domain.ExportJob) *utils.APIError {
+	query := `INSERT INTO export_jobs (id, user_id, status) VALUES ($1, $2, $3) RETURNING created_at`
+
+	err := repo.db.QueryRow(context.Background(), query, job.ID, job.UserID, job.Status).Scan(&job.CreatedAt)
+	if err != nil {
+		logger.Error("Cannot create export job",
+			zap.Int("User ID", job.UserID),
+			zap.Error(err))
+		return ClassifyDBerror(err)
+	}
+
+	return nil
+}
+
+func (repo *PostgresExportRepo) GetExportJob(id string) (*domain.ExportJob, *utils.APIError) {
+	query := "SELECT " + exportJobColumns + " FROM export_jobs WHERE id = $1"
+	return repo.getOne(query, id)
+}
+
+func (repo *PostgresExportRepo) GetActiveExportJob(userID int) (*domain.ExportJob, *utils.APIError) {
+	query := "SELECT " + exportJobColumns + " FROM export_jobs WHERE user_id = $1 AND status IN ($2, $3) LIMIT 1"
+	return repo.getOne(query, userID, domain.ExportPending, domain.ExportRunning)
+}
+
+func (repo *PostgresExportRepo) ClaimPendingExportJob(startedAt time.Time) (*domain.ExportJob, *utils.APIError) {
+	// Jobs locked by another worker are skipped, it is claiming them right now
+	query := `UPDATE export_jobs SET status = $1, started_at = $2
+		WHERE id = (
+			SELECT id FROM export_jobs WHERE status = $3
+			ORDER BY created_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + exportJobColumns
+	return repo.getOne(query, domain.ExportRunning, startedAt, domain.ExportPending)
+}
+
+func (repo *PostgresExportRepo) RequeueStaleExportJobs(startedBefore time.Time) (int64, *utils.APIError) {
+	query := `UPDATE export_jobs SET status = $1, started_at = NULL
+		WHERE status = $2 AND (started_at IS NULL OR started_at < $3)`
+
+	tag, err := repo.db.Exec(context.Background(), query, domain.ExportPending, domain.ExportRunning, startedBefore)
+	if err != nil {
+		logger.Error("Cannot requeue stale export jobs",
+			zap.Error(err))
+		return 0, ClassifyDBerror(err)
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+func (repo *PostgresExportRepo) GetExpiredExportJobs(limit int) ([]domain.ExportJob, *utils.APIError) {
+	query := "SELECT " + exportJobColumns + " FROM export_jobs WHERE status = $1 AND expires_at <= NOW() LIMIT $2"
+	return repo.getMany(query, domain.ExportReady, limit)
+}
+
+func (repo *PostgresExportRepo) UpdateExportJob(job *domain.ExportJob) *utils.APIError {
+	query := `UPDATE export_jobs
+		SET status = $1, file_path = $2, download_token = $3, error = $4, started_at = $5, finished_at = $6, expires_at = $7
+		WHERE id = $8`
+
+	_, err := repo.db.Exec(context.Background(), query,
+		job.Status,
+		job.FilePath,
+		job.DownloadToken,
+		job.Error,
+		job.StartedAt,
+		job.FinishedAt,
+		job.ExpiresAt,
+		job.ID)
+
+	if err != nil {
+		logger.Error("Cannot update export job",
+			zap.String("Job ID", job.ID),
+			zap.Error(err))
+		return ClassifyDBerror(err)
+	}
+
+	return nil
+}
+
+func (repo *PostgresExportRepo) getOne(query string, args ...interface{}) (*domain.ExportJob, *utils.APIError) {
+	job, err := scanExportJob(repo.db.QueryRow(context.Background(), query, args...))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, nil
+		}
+		logger.Error("Cannot get export job",
+			zap.Error(err))
+		return nil, ClassifyDBerror(err)
+	}
+
+	return job, nil
+}
+
+func (repo *PostgresExportRepo) getMany(query string, args ...interface{}) ([]domain.ExportJob, *utils.APIError) {
+	rows, err := repo.db.Query(context.Background(), query, args...)
+	if err != nil {
+		logger.Error("Cannot get export jobs",
+			zap.Error(err))
+		return nil, ClassifyDBerror(err)
+	}
+	defer rows.Close()
+
+	jobs := []domain.ExportJob{}
+	for rows.Next() {
+		job, err := scanExportJob(rows)
+		if err != nil {
+			return nil, ClassifyDBerror(err)
+		}
+		jobs = append(jobs, *job)
+	}
+
+	return jobs, nil
+}
+
+func scanExportJob(row pgx.Row) (*domain.ExportJob, error) {
+	var job domain.ExportJob
+	err := row.Scan(
+		&job.ID,
+		&job.UserID,
+		&job.Status,
+		&job.FilePath,
+		&job.DownloadToken,
+		&job.Error,
+		&job.CreatedAt,
+		&job.StartedAt,
+		&job.FinishedAt,
+		&job.ExpiresAt)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &job, nil
+}
@@ -0,0 +1,80 @@
+package repositories
+
+import (
+	"auth-service/internal/domain"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClaimPendingExportJob(t *testing.T) {
+	db := testSchemaDB(t)
+	repo := NewPostgresExportRepo(db)
+
+	tests := []struct {
+		name    string
+		pending int
+		// Taken by another worker already
+		running int
+		workers int
+	}{
+		{name: "nothing to do", running: 2, workers: 3},
+		{name: "one worker", pending: 3, workers: 1},
+		{name: "more workers than jobs", pending: 3, running: 1, workers: 8},
+		{name: "more jobs than workers", pending: 20, workers: 4},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userID := createUser(t, db, fmt.Sprintf("user%d", i))
+			for j := range tt.pending + tt.running {
+				status := domain.ExportPending
+				if j >= tt.pending {
+					status = domain.ExportRunning
+				}
+				job := &domain.ExportJob{ID: fmt.Sprintf("job%d-%d", i, j), UserID: userID, Status: status}
+				if apiErr := repo.CreateExportJob(job); apiErr != nil {
+					t.Fatalf("cannot create job: %v", apiErr.Message)
+				}
+			}
+
+			// Every worker claims until nothing is left, every job must be claimed once
+			var wg sync.WaitGroup
+			var mu sync.Mutex
+			claimed := map[string]int{}
+			for range tt.workers {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for {
+						job, apiErr := repo.ClaimPendingExportJob(time.Now())
+						if apiErr != nil {
+							t.Errorf("cannot claim job: %v", apiErr.Message)
+							return
+						}
+						if job == nil {
+							return
+						}
+						if job.Status != domain.ExportRunning {
+							t.Errorf("claimed job %s has status %s", job.ID, job.Status)
+						}
+						mu.Lock()
+						claimed[job.ID]++
+						mu.Unlock()
+					}
+				}()
+			}
+			wg.Wait()
+
+			if len(claimed) != tt.pending {
+				t.Fatalf("claimed %d jobs, want %d", len(claimed), tt.pending)
+			}
+			for id, times := range claimed {
+				if times != 1 {
+					t.Errorf("job %s claimed %d times", id, times)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package repositories
+
+import (
+	"auth-service/internal/domain"
+	"auth-service/internal/utils"
+	"context"
+
+	logger "auth-service/internal"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+type PostgresInviteRepo struct {
+	db *pgxpool.Pool
+}
+
+func NewPostgresInviteRepo(db *pgxpool.Pool) *PostgresInviteRepo {
+	return &PostgresInviteRepo{db: db}
+}
+
+func (repo *PostgresInviteRepo) CreateInvite(invite *domain.Invite) *utils.APIError {
+	query := `INSERT INTO invites (code, created_by, role, max_uses, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING uses, created_at`
+
+	err := repo.db.QueryRow(context.Background(), query,
+		invite.Code,
+		invite.CreatedBy,
+		invite.Role,
+		invite.MaxUses,
+		invite.ExpiresAt).Scan(&invite.Uses, &invite.CreatedAt)
+
+	if err != nil {
+		logger.Error("Cannot create invite",
+			zap.Int("Creator ID", invite.CreatedBy),
+			zap.Error(err))
+		return ClassifyDBerror(err)
+	}
+
+	return nil
+}
+
+func (repo *PostgresInviteRepo) GetInvite(code string) (*domain.Invite, *utils.APIError) {
+	query := `SELECT code, created_by, role, max_uses, uses, expires_at, revoked_at, created_at
+		FROM invites WHERE code = $1`
+
+	var invite domain.Invite
+	err := repo.db.QueryRow(context.Background(), query, code).Scan(
+		&invite.Code,
+		&invite.CreatedBy,
+		&invite.Role,
+		&invite.MaxUses,
+		&invite.Uses,
+		&invite.ExpiresAt,
+		&invite.RevokedAt,
+		&invite.CreatedAt)
+
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, nil
+		}
+		logger.Error("Cannot get invite",
+			zap.Error(err))
+		return nil, ClassifyDBerror(err)
+	}
+
+	return &invite, nil
+}
+
+func (repo *PostgresInviteRepo) GetInvitesByCreator(creatorID int) ([]domain.Invite, *utils.APIError) {
+	query := `SELECT code, created_by, role, max_uses, uses, expires_at, revoked_at, created_at
+		FROM invites WHERE created_by = $1
+		ORDER BY created_at DESC`
+
+	rows, err := repo.db.Query(context.Background(), query, creatorID)
+	if err != nil {
+		logger.Error("Cannot get invites",
+			zap.Int("Creator ID", creatorID),
+			zap.Error(err))
+		return nil, ClassifyDBerror(err)
+	}
+	defer rows.Close()
+
+	invites := []domain.Invite{}
+	for rows.Next() {
+		var invite domain.Invite
+		if err := rows.Scan(
+			&invite.Code,
+			&invite.CreatedBy,
+			&invite.Role,
+			&invite.MaxUses,
+			&invite.Uses,
+			&invite.ExpiresAt,
+			&invite.RevokedAt,
+			&invite.CreatedAt,
+		); err != nil {
+			return nil, ClassifyDBerror(err)
+		}
+		invites = append(invites, invite)
+	}
+
+	return invites, nil
+}
+
+func (repo *PostgresInviteRepo) RevokeInvite(code string) *utils.APIError {
+	query := "UPDATE invites SET revoked_at = NOW() WHERE code = $1 AND revoked_at IS NULL"
+
+	_, err := repo.db.Exec(context.Background(), query, code)
+	if err != nil {
+		logger.Error("Cannot revoke invite",
+			zap.Error(err))
+		return ClassifyDBerror(err)
+	}
+
+	return nil
+}
@@ -4,29 +4,32 @@ import (
 	"auth-service/internal/domain"
 	"auth-service/internal/utils"
 	"context"
+	"strings"
+	"time"
 
 	logger "auth-service/internal"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"go.uber.org/zap"
 )
 
 type PostgresUserRepo struct {
-	db *pgx.Conn
+	db *pgxpool.Pool
 }
 
-func NewPostgresUserRepo(db *pgx.Conn) *PostgresUserRepo {
+func NewPostgresUserRepo(db *pgxpool.Pool) *PostgresUserRepo {
 	return &PostgresUserRepo{db: db}
 }
 
 // Returns id of new user and service must insert its in already existing user structure
 // Or you can make it returns full user
 func (repo *PostgresUserRepo) CreateUser(user *domain.User) (int, *utils.APIError) {
-	query := "INSERT INTO users (username, password) VALUES ($1, $2) RETURNING ID"
+	query := "INSERT INTO users (username, password, role) VALUES ($1, $2, $3) RETURNING ID"
 
 	// Thanks pgx for doing escaping of special characters for us <3
 	var id int
-	err := repo.db.QueryRow(context.Background(), query, user.Username, user.Password).Scan(&id)
+	err := repo.db.QueryRow(context.Background(), query, user.Username, user.Password, user.Role).Scan(&id)
 	if err != nil {
 		logger.Error("Cannot create user",
 			zap.Error(err))
@@ -36,12 +39,63 @@ func (repo *PostgresUserRepo) CreateUser(user *domain.User) (int, *utils.APIErro
 	return id, nil
 }
 
+func (repo *PostgresUserRepo) CreateUserWithInvite(user *domain.User, inviteCode string) (int, *utils.APIError) {
+	ctx := context.Background()
+
+	tx, err := repo.db.Begin(ctx)
+	if err != nil {
+		logger.Error("Cannot begin transaction",
+			zap.Error(err))
+		return 0, ClassifyDBerror(err)
+	}
+	// Does nothing after commit
+	defer tx.Rollback(ctx)
+
+	// Take one use of invite. Row is locked until commit, so two users can't take the last use
+	redeemQuery := `UPDATE invites SET uses = uses + 1
+		WHERE code = $1
+			AND revoked_at IS NULL
+			AND uses < max_uses
+			AND (expires_at IS NULL OR expires_at > NOW())
+		RETURNING role, created_by`
+
+	var inviterID int
+	err = tx.QueryRow(ctx, redeemQuery, inviteCode).Scan(&user.Role, &inviterID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return 0, utils.NewAPIError(403, "Invalid invite code", "Invite is expired, revoked or used up")
+		}
+		logger.Error("Cannot redeem invite",
+			zap.Error(err))
+		return 0, ClassifyDBerror(err)
+	}
+	user.InvitedBy = &inviterID
+
+	insertQuery := "INSERT INTO users (username, password, role, invited_by, invite_code) VALUES ($1, $2, $3, $4, $5) RETURNING ID"
+
+	var id int
+	err = tx.QueryRow(ctx, insertQuery, user.Username, user.Password, user.Role, user.InvitedBy, inviteCode).Scan(&id)
+	if err != nil {
+		logger.Error("Cannot create user",
+			zap.Error(err))
+		return 0, ClassifyDBerror(err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		logger.Error("Cannot commit user creation",
+			zap.Error(err))
+		return 0, ClassifyDBerror(err)
+	}
+
+	return id, nil
+}
+
 func (repo *PostgresUserRepo) GetUserByID(id int) (*domain.User, *utils.APIError) {
-	query := "SELECT * FROM users WHERE id = $1"
+	query := "SELECT id, username, password, role, invited_by, delete_after, created_at FROM users WHERE id = $1 AND deleted_at IS NULL"
 	row := repo.db.QueryRow(context.Background(), query, id)
 
 	var user domain.User
-	err := row.Scan(&user.ID, &user.Username, &user.CreatedAt)
+	err := row.Scan(&user.ID, &user.Username, &user.Password, &user.Role, &user.InvitedBy, &user.DeleteAfter, &user.CreatedAt)
 
 	if err != nil {
 		if err == pgx.ErrNoRows {
@@ -55,11 +109,11 @@ func (repo *PostgresUserRepo) GetUserByID(id int) (*domain.User, *utils.APIError
 }
 
 func (repo *PostgresUserRepo) GetUserByUsername(username string) (*domain.User, *utils.APIError) {
-	query := "SELECT * FROM users WHERE username = $1"
+	query := "SELECT id, username, password, role, invited_by, delete_after, created_at FROM users WHERE username = $1 AND deleted_at IS NULL"
 	row := repo.db.QueryRow(context.Background(), query, username)
 
 	var user domain.User
-	err := row.Scan(&user.ID, &user.Username, &user.Password, &user.CreatedAt)
+	err := row.Scan(&user.ID, &user.Username, &user.Password, &user.Role, &user.InvitedBy, &user.DeleteAfter, &user.CreatedAt)
 
 	if err != nil {
 		if err == pgx.ErrNoRows {
@@ -71,3 +125,230 @@ func (repo *PostgresUserRepo) GetUserByUsername(username string) (*domain.User,
 	}
 	return &user, nil
 }
+
+func (repo *PostgresUserRepo) UpgradeGuest(id int, username string, password string) *utils.APIError {
+	query := "UPDATE users SET username = $1, password = $2, role = $3 WHERE id = $4 AND role = $5"
+
+	tag, err := repo.db.Exec(context.Background(), query, username, password, domain.RoleUser, id, domain.RoleGuest)
+	if err != nil {
+		logger.Error("Cannot upgrade guest",
+			zap.Int("User ID", id),
+			zap.Error(err))
+		return ClassifyDBerror(err)
+	}
+
+	// Somebody already upgraded this guest
+	if tag.RowsAffected() == 0 {
+		return utils.NewAPIError(409, "User is not a guest", "")
+	}
+
+	return nil
+}
+
+// Guests are not shown in directory
+func (repo *PostgresUserRepo) SearchUsers(usernamePrefix string, limit int) ([]domain.User, *utils.APIError) {
+	query := `SELECT id, username, role, created_at FROM users
+		WHERE username LIKE $1 || '%' AND role <> $2 AND deleted_at IS NULL
+		ORDER BY username
+		LIMIT $3`
+
+	// LIKE has its own special characters, escape them
+	escaper := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+	rows, err := repo.db.Query(context.Background(), query, escaper.Replace(usernamePrefix), domain.RoleGuest, limit)
+	if err != nil {
+		logger.Error("Cannot search users",
+			zap.String("Prefix", usernamePrefix),
+			zap.Error(err))
+		return nil, ClassifyDBerror(err)
+	}
+	defer rows.Close()
+
+	users := []domain.User{}
+	for rows.Next() {
+		var user domain.User
+		if err := rows.Scan(&user.ID, &user.Username, &user.Role, &user.CreatedAt); err != nil {
+			return nil, ClassifyDBerror(err)
+		}
+		users = append(users, user)
+	}
+
+	return users, nil
+}
+
+func (repo *PostgresUserRepo) GetInvitedUsers(inviterID int) ([]domain.User, *utils.APIError) {
+	query := "SELECT id, username, role, invited_by, created_at FROM users WHERE invited_by = $1 AND deleted_at IS NULL ORDER BY created_at"
+
+	rows, err := repo.db.Query(context.Background(), query, inviterID)
+	if err != nil {
+		logger.Error("Cannot get invited users",
+			zap.Int("Inviter ID", inviterID),
+			zap.Error(err))
+		return nil, ClassifyDBerror(err)
+	}
+	defer rows.Close()
+
+	users := []domain.User{}
+	for rows.Next() {
+		var user domain.User
+		if err := rows.Scan(&user.ID, &user.Username, &user.Role, &user.InvitedBy, &user.CreatedAt); err != nil {
+			return nil, ClassifyDBerror(err)
+		}
+		users = append(users, user)
+	}
+
+	return users, nil
+}
+
+func (repo *PostgresUserRepo) ScheduleDeletion(id int, deleteAfter time.Time) *utils.APIError {
+	query := "UPDATE users SET delete_after = $1 WHERE id = $2 AND deleted_at IS NULL"
+
+	_, err := repo.db.Exec(context.Background(), query, deleteAfter, id)
+	if err != nil {
+		logger.Error("Cannot schedule user deletion",
+			zap.Int("User ID", id),
+			zap.Error(err))
+		return ClassifyDBerror(err)
+	}
+
+	return nil
+}
+
+func (repo *PostgresUserRepo) CancelDeletion(id int) *utils.APIError {
+	query := "UPDATE users SET delete_after = NULL WHERE id = $1 AND delete_after IS NOT NULL AND deleted_at IS NULL"
+
+	tag, err := repo.db.Exec(context.Background(), query, id)
+	if err != nil {
+		logger.Error("Cannot cancel user deletion",
+			zap.Int("User ID", id),
+			zap.Error(err))
+		return ClassifyDBerror(err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return utils.NewAPIError(409, "Account is not scheduled for deletion", "")
+	}
+
+	return nil
+}
+
+func (repo *PostgresUserRepo) GetUsersDueForDeletion(limit int) ([]domain.User, *utils.APIError) {
+	query := `SELECT id, username, role, delete_after, created_at FROM users
+		WHERE delete_after <= NOW() AND deleted_at IS NULL
+		ORDER BY delete_after
+		LIMIT $1`
+
+	rows, err := repo.db.Query(context.Background(), query, limit)
+	if err != nil {
+		logger.Error("Cannot get users due for deletion",
+			zap.Error(err))
+		return nil, ClassifyDBerror(err)
+	}
+	defer rows.Close()
+
+	users := []domain.User{}
+	for rows.Next() {
+		var user domain.User
+		if err := rows.Scan(&user.ID, &user.Username, &user.Role, &user.DeleteAfter, &user.CreatedAt); err != nil {
+			return nil, ClassifyDBerror(err)
+		}
+		users = append(users, user)
+	}
+
+	return users, nil
+}
+
+func (repo *PostgresUserRepo) EraseUser(id int) *utils.APIError {
+	ctx := context.Background()
+
+	tx, err := repo.db.Begin(ctx)
+	if err != nil {
+		logger.Error("Cannot begin transaction",
+			zap.Error(err))
+		return ClassifyDBerror(err)
+	}
+	defer tx.Rollback(ctx)
+
+	// Username is freed, so somebody else can take it. Row is claimed only if deletion is still due,
+	// so cancellation which came after user was picked by worker wins
+	eraseQuery := `UPDATE users
+		SET username = NULL, password = '', invite_code = NULL, delete_after = NULL, deleted_at = NOW(),
+			deletion_event_pending = TRUE
+		WHERE id = $1 AND delete_after <= NOW() AND deleted_at IS NULL
+		RETURNING id`
+
+	err = tx.QueryRow(ctx, eraseQuery, id).Scan(&id)
+	if err == pgx.ErrNoRows {
+		return utils.NewAPIError(409, "Account is not due for deletion", "")
+	}
+	if err != nil {
+		logger.Error("Cannot erase user",
+			zap.Int("User ID", id),
+			zap.Error(err))
+		return ClassifyDBerror(err)
+	}
+
+	// Everything else which tells something about user
+	cleanupQueries := []string{
+		"DELETE FROM invites WHERE created_by = $1",
+		"DELETE FROM auth_events WHERE user_id = $1",
+		"DELETE FROM export_jobs WHERE user_id = $1 AND status <> 'ready'",
+		// Ready exports have files, export worker removes them when they expire
+		"UPDATE export_jobs SET expires_at = NOW() WHERE user_id = $1 AND status = 'ready'",
+	}
+
+	for _, query := range cleanupQueries {
+		if _, err := tx.Exec(ctx, query, id); err != nil {
+			logger.Error("Cannot clean up data of erased user",
+				zap.Int("User ID", id),
+				zap.Error(err))
+			return ClassifyDBerror(err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		logger.Error("Cannot commit user erasure",
+			zap.Int("User ID", id),
+			zap.Error(err))
+		return ClassifyDBerror(err)
+	}
+
+	return nil
+}
+
+func (repo *PostgresUserRepo) GetUsersPendingDeletionEvent(limit int) ([]int, *utils.APIError) {
+	query := "SELECT id FROM users WHERE deletion_event_pending ORDER BY id LIMIT $1"
+
+	rows, err := repo.db.Query(context.Background(), query, limit)
+	if err != nil {
+		logger.Error("Cannot get users pending deletion event",
+			zap.Error(err))
+		return nil, ClassifyDBerror(err)
+	}
+	defer rows.Close()
+
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, ClassifyDBerror(err)
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+func (repo *PostgresUserRepo) MarkDeletionEventPublished(id int) *utils.APIError {
+	query := "UPDATE users SET deletion_event_pending = FALSE WHERE id = $1"
+
+	_, err := repo.db.Exec(context.Background(), query, id)
+	if err != nil {
+		logger.Error("Cannot mark deletion event published",
+			zap.Int("User ID", id),
+			zap.Error(err))
+		return ClassifyDBerror(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,78 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestEraseUser(t *testing.T) {
+	db := testSchemaDB(t)
+	repo := NewPostgresUserRepo(db)
+
+	tests := []struct {
+		name string
+		// Interval added to NOW(), empty - deletion is not scheduled
+		deleteAfter string
+		// Workers erasing the same user at once
+		workers  int
+		wantCode int
+	}{
+		{name: "due", deleteAfter: "-1 minute", workers: 1},
+		{name: "not scheduled", workers: 1, wantCode: 409},
+		{name: "grace period is not over", deleteAfter: "1 day", workers: 1, wantCode: 409},
+		{name: "erased by one of workers", deleteAfter: "-1 minute", workers: 8},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			id := createUser(t, db, fmt.Sprintf("user%d", i))
+			if tt.deleteAfter != "" {
+				if _, err := db.Exec(ctx, `UPDATE users SET delete_after = NOW() + $2::interval WHERE id = $1`, id, tt.deleteAfter); err != nil {
+					t.Fatalf("cannot schedule deletion: %v", err)
+				}
+			}
+
+			var wg sync.WaitGroup
+			var mu sync.Mutex
+			codes := map[int]int{}
+			for range tt.workers {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					code := 0
+					if apiErr := repo.EraseUser(id); apiErr != nil {
+						code = apiErr.Code
+					}
+					mu.Lock()
+					codes[code]++
+					mu.Unlock()
+				}()
+			}
+			wg.Wait()
+
+			if tt.wantCode != 0 {
+				if codes[tt.wantCode] != tt.workers {
+					t.Fatalf("got results %v, want all %d", codes, tt.wantCode)
+				}
+				return
+			}
+			// The rest see that user is erased already
+			if codes[0] != 1 || codes[409] != tt.workers-1 {
+				t.Fatalf("got results %v, want one erasure", codes)
+			}
+
+			var username *string
+			var pending bool
+			err := db.QueryRow(ctx, `SELECT username, deletion_event_pending FROM users WHERE id = $1 AND deleted_at IS NOT NULL`, id).Scan(&username, &pending)
+			if err != nil {
+				t.Fatalf("erased user not found: %v", err)
+			}
+			if username != nil || !pending {
+				t.Fatalf("username %v, deletion event pending %v", username, pending)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package repositories
+
+import (
+	"auth-service/internal/utils"
+	"context"
+	"time"
+
+	logger "auth-service/internal"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+type RedisChallengeRepo struct {
+	client *redis.Client
+}
+
+func NewRedisChallengeRepo(client *redis.Client) *RedisChallengeRepo {
+	return &RedisChallengeRepo{client: client}
+}
+
+// Key lives as long as challenge itself, after that challenge is expired anyway
+func (repo *RedisChallengeRepo) MarkChallengeUsed(ctx context.Context, nonce string, ttl time.Duration) (bool, *utils.APIError) {
+	isNew, err := repo.client.SetNX(ctx, "pow:used:"+nonce, 1, ttl).Result()
+	if err != nil {
+		logger.Error("Cannot mark challenge as used",
+			zap.Error(err))
+		return false, utils.NewAPIError(500, "Failed to check challenge", err.Error())
+	}
+
+	return isNew, nil
+}
+
+func (repo *RedisChallengeRepo) GetRegistrationCount(ctx context.Context, clientIP string) (int, *utils.APIError) {
+	count, err := repo.client.Get(ctx, "pow:registrations:"+clientIP).Int()
+	if err != nil {
+		if err == redis.Nil {
+			return 0, nil
+		}
+		logger.Error("Cannot get registration count",
+			zap.Error(err))
+		return 0, utils.NewAPIError(500, "Failed to get registration count", err.Error())
+	}
+
+	return count, nil
+}
+
+// Fixed window counter. Window starts with first registration
+func (repo *RedisChallengeRepo) IncrementRegistrationCount(ctx context.Context, clientIP string, window time.Duration) *utils.APIError {
+	key := "pow:registrations:" + clientIP
+
+	pipe := repo.client.TxPipeline()
+	pipe.Incr(ctx, key)
+	pipe.ExpireNX(ctx, key, window)
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		logger.Error("Cannot increment registration count",
+			zap.Error(err))
+		return utils.NewAPIError(500, "Failed to increment registration count", err.Error())
+	}
+
+	return nil
+}
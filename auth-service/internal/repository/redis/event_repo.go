@@ -0,0 +1,48 @@
+package repositories
+
+import (
+	"auth-service/internal/domain"
+	"auth-service/internal/utils"
+	"context"
+	"time"
+
+	logger "auth-service/internal"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// Other services read this stream with consumer groups, so event is delivered even if consumer was down
+const userEventsStream = "events:users"
+
+type RedisEventPublisher struct {
+	client *redis.Client
+}
+
+func NewRedisEventPublisher(client *redis.Client) *RedisEventPublisher {
+	return &RedisEventPublisher{client: client}
+}
+
+func (repo *RedisEventPublisher) PublishUserEvent(ctx context.Context, event *domain.UserEvent) *utils.APIError {
+	err := repo.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: userEventsStream,
+		// Keep stream from growing forever
+		MaxLen: 100000,
+		Approx: true,
+		Values: map[string]interface{}{
+			"type":        string(event.Type),
+			"user_id":     event.UserID,
+			"occurred_at": event.OccurredAt.Format(time.RFC3339),
+		},
+	}).Err()
+
+	if err != nil {
+		logger.Error("Cannot publish user event",
+			zap.String("Type", string(event.Type)),
+			zap.Int("User ID", event.UserID),
+			zap.Error(err))
+		return utils.NewAPIError(500, "Failed to publish event", err.Error())
+	}
+
+	return nil
+}
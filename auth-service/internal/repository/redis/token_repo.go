@@ -5,6 +5,7 @@ import (
 	"auth-service/internal/utils"
 	"context"
 	"fmt"
+	"strings"
 	"time"
 
 	logger "auth-service/internal"
@@ -62,3 +63,55 @@ func (repo *RedisTokenRepo) IsTokenExists(ctx context.Context, token string) boo
 
 	return true
 }
+
+// Session keys look like user_id:fingerprint_hash, so we can find all sessions of user
+func (repo *RedisTokenRepo) DeleteUserTokens(ctx context.Context, userID int) *utils.APIError {
+	iter := repo.client.Scan(ctx, 0, fmt.Sprintf("%d:*", userID), 100).Iterator()
+	for iter.Next(ctx) {
+		if err := repo.client.Del(ctx, iter.Val()).Err(); err != nil {
+			logger.Error("Cannot delete token",
+				zap.Int("User ID", userID),
+				zap.Error(err))
+			return utils.NewAPIError(500, "Failed to delete tokens", err.Error())
+		}
+	}
+
+	if err := iter.Err(); err != nil {
+		logger.Error("Cannot scan user tokens",
+			zap.Int("User ID", userID),
+			zap.Error(err))
+		return utils.NewAPIError(500, "Failed to delete tokens", err.Error())
+	}
+
+	return nil
+}
+
+func (repo *RedisTokenRepo) GetUserSessions(ctx context.Context, userID int) ([]domain.Session, *utils.APIError) {
+	prefix := fmt.Sprintf("%d:", userID)
+	sessions := []domain.Session{}
+
+	iter := repo.client.Scan(ctx, 0, prefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		ttl, err := repo.client.TTL(ctx, iter.Val()).Result()
+		if err != nil {
+			logger.Error("Cannot get session TTL",
+				zap.Int("User ID", userID),
+				zap.Error(err))
+			return nil, utils.NewAPIError(500, "Failed to get sessions", err.Error())
+		}
+
+		sessions = append(sessions, domain.Session{
+			Fingerprint: strings.TrimPrefix(iter.Val(), prefix),
+			ExpiresAt:   time.Now().Add(ttl),
+		})
+	}
+
+	if err := iter.Err(); err != nil {
+		logger.Error("Cannot scan user sessions",
+			zap.Int("User ID", userID),
+			zap.Error(err))
+		return nil, utils.NewAPIError(500, "Failed to get sessions", err.Error())
+	}
+
+	return sessions, nil
+}
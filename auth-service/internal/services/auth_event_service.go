@@ -0,0 +1,33 @@
+package services
+
+import (
+	"auth-service/internal/domain"
+	"auth-service/internal/utils"
+)
+
+type AuthEventService struct {
+	repo domain.AuthEventRepository
+}
+
+func NewAuthEventService(repo domain.AuthEventRepository) *AuthEventService {
+	return &AuthEventService{repo: repo}
+}
+
+// Record saves event. It is only history, so if it fails, request still goes on (repository logs error)
+func (s *AuthEventService) Record(userID int, eventType domain.AuthEventType, ip string, userAgent string) {
+	s.repo.RecordEvent(&domain.AuthEvent{
+		UserID:    userID,
+		Type:      eventType,
+		IP:        ip,
+		UserAgent: userAgent,
+	})
+}
+
+func (s *AuthEventService) GetUserEvents(userID int) ([]domain.AuthEvent, *utils.APIError) {
+	events, apiErr := s.repo.GetUserEvents(userID)
+	if apiErr != nil {
+		return nil, utils.NewAPIError(500, "Internal server error", "Please try again")
+	}
+
+	return events, nil
+}
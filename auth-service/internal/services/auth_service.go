@@ -14,8 +14,8 @@ func NewAuthService(userService *UserService) *AuthService {
 	return &AuthService{userService: userService}
 }
 
-func (s *AuthService) Register(user *domain.User) (*domain.User, *utils.APIError) {
-	return s.userService.CreateUser(user)
+func (s *AuthService) Register(user *domain.User, inviteCode string) (*domain.User, *utils.APIError) {
+	return s.userService.CreateUser(user, inviteCode)
 }
 
 func (s *AuthService) Login(username, password string) (string, *utils.APIError) {
@@ -28,7 +28,7 @@ func (s *AuthService) Login(username, password string) (string, *utils.APIError)
 		return "", utils.NewAPIError(401, "Invalid credentials", "")
 	}
 
-	token, err := auth.GenerateToken(user.ID)
+	token, err := auth.GenerateToken(user.ID, string(user.Role))
 	if err != nil {
 		return "", utils.NewAPIError(500, "Error generating token", "")
 	}
@@ -0,0 +1,95 @@
+package services
+
+import (
+	"auth-service/internal/auth"
+	"auth-service/internal/domain"
+	"auth-service/internal/utils"
+	"context"
+	"math/bits"
+	"time"
+
+	logger "auth-service/internal"
+
+	"go.uber.org/zap"
+)
+
+const (
+	challengeTTL = 5 * time.Minute
+	// Registrations from one IP are counted in this window
+	registrationWindow = time.Hour
+)
+
+// ChallengeService throttles automated registrations with proof-of-work.
+// The more users were registered from IP recently, the harder puzzle it gets
+type ChallengeService struct {
+	repo           domain.ChallengeRepository
+	baseDifficulty int
+	maxDifficulty  int
+}
+
+func NewChallengeService(repo domain.ChallengeRepository, baseDifficulty int, maxDifficulty int) *ChallengeService {
+	return &ChallengeService{
+		repo:           repo,
+		baseDifficulty: baseDifficulty,
+		maxDifficulty:  maxDifficulty,
+	}
+}
+
+func (s *ChallengeService) CreateChallenge(ctx context.Context, clientIP string) (*domain.ChallengeResponse, *utils.APIError) {
+	registrations, apiErr := s.repo.GetRegistrationCount(ctx, clientIP)
+	if apiErr != nil {
+		return nil, utils.NewAPIError(500, "Internal server error", "Please try again")
+	}
+
+	// One more bit (twice more work) every time registrations count doubles
+	difficulty := s.baseDifficulty + bits.Len(uint(registrations))
+	if difficulty > s.maxDifficulty {
+		difficulty = s.maxDifficulty
+	}
+
+	challengeString, challenge, err := auth.IssueChallenge(clientIP, difficulty, challengeTTL)
+	if err != nil {
+		logger.Error("Cannot issue challenge",
+			zap.Error(err))
+		return nil, utils.NewAPIError(500, "Internal server error", "Please try again")
+	}
+
+	return &domain.ChallengeResponse{
+		Challenge:  challengeString,
+		Difficulty: challenge.Difficulty,
+		Algorithm:  "sha256",
+		ExpiresAt:  challenge.ExpiresAt,
+	}, nil
+}
+
+// VerifyChallenge checks solution and burns challenge, so it can't be used twice
+func (s *ChallengeService) VerifyChallenge(ctx context.Context, challengeString string, solution string, clientIP string) *utils.APIError {
+	if challengeString == "" || solution == "" {
+		return utils.NewAPIError(400, "Challenge is required", "Get one from /auth/challenge")
+	}
+
+	challenge, err := auth.VerifyChallenge(challengeString, clientIP, solution)
+	if err != nil {
+		return utils.NewAPIError(403, "Invalid challenge", err.Error())
+	}
+
+	isNew, apiErr := s.repo.MarkChallengeUsed(ctx, challenge.Nonce, time.Until(challenge.ExpiresAt))
+	if apiErr != nil {
+		return utils.NewAPIError(500, "Internal server error", "Please try again")
+	}
+
+	if !isNew {
+		return utils.NewAPIError(403, "Invalid challenge", "Challenge was already used")
+	}
+
+	return nil
+}
+
+// RecordRegistration makes next challenges for this IP harder
+func (s *ChallengeService) RecordRegistration(ctx context.Context, clientIP string) {
+	if apiErr := s.repo.IncrementRegistrationCount(ctx, clientIP, registrationWindow); apiErr != nil {
+		logger.Warn("Cannot record registration",
+			zap.String("error", apiErr.Message),
+			zap.String("details", apiErr.Details))
+	}
+}
@@ -0,0 +1,168 @@
+package services
+
+import (
+	"auth-service/internal/auth"
+	"auth-service/internal/domain"
+	"auth-service/internal/utils"
+	"context"
+	"time"
+
+	logger "auth-service/internal"
+
+	"go.uber.org/zap"
+)
+
+// DeletionService handles self-service account deletion. Account is not deleted right away,
+// user has grace period to change his mind
+type DeletionService struct {
+	repo        domain.UserRepository
+	gracePeriod time.Duration
+}
+
+func NewDeletionService(repo domain.UserRepository, gracePeriod time.Duration) *DeletionService {
+	return &DeletionService{repo: repo, gracePeriod: gracePeriod}
+}
+
+func (s *DeletionService) RequestDeletion(userID int, password string) (*domain.User, *utils.APIError) {
+	user, apiErr := s.repo.GetUserByID(userID)
+	if apiErr != nil {
+		return nil, utils.NewAPIError(500, "Internal server error", "Please try again")
+	}
+
+	if user == nil {
+		return nil, utils.NewAPIError(404, "User not found", "")
+	}
+
+	// Guests have no password, token is enough for them
+	if user.Role != domain.RoleGuest && !auth.CheckPassword(password, user.Password) {
+		return nil, utils.NewAPIError(401, "Invalid password", "")
+	}
+
+	// Already scheduled, don't move the date
+	if user.DeleteAfter != nil {
+		return user, nil
+	}
+
+	deleteAfter := time.Now().Add(s.gracePeriod)
+	if apiErr := s.repo.ScheduleDeletion(userID, deleteAfter); apiErr != nil {
+		return nil, utils.NewAPIError(500, "Internal server error", "Please try again")
+	}
+
+	user.DeleteAfter = &deleteAfter
+	return user, nil
+}
+
+func (s *DeletionService) CancelDeletion(userID int) *utils.APIError {
+	apiErr := s.repo.CancelDeletion(userID)
+	if apiErr != nil && apiErr.Code != 409 {
+		return utils.NewAPIError(500, "Internal server error", "Please try again")
+	}
+
+	return apiErr
+}
+
+// DeletionWorker deletes accounts which grace period is over
+type DeletionWorker struct {
+	repo      domain.UserRepository
+	tokenRepo domain.TokenRepository
+	events    domain.EventPublisher
+	interval  time.Duration
+}
+
+func NewDeletionWorker(repo domain.UserRepository, tokenRepo domain.TokenRepository, events domain.EventPublisher, interval time.Duration) *DeletionWorker {
+	return &DeletionWorker{
+		repo:      repo,
+		tokenRepo: tokenRepo,
+		events:    events,
+		interval:  interval,
+	}
+}
+
+// Run blocks until context is cancelled, so start it in goroutine
+func (w *DeletionWorker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		w.deleteDueUsers(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (w *DeletionWorker) deleteDueUsers(ctx context.Context) {
+	// Users erased earlier, which event failed to go out
+	pending, apiErr := w.repo.GetUsersPendingDeletionEvent(100)
+	if apiErr != nil {
+		return
+	}
+
+	for _, userID := range pending {
+		if apiErr := w.announceDeletion(ctx, userID); apiErr != nil {
+			logger.Error("Cannot publish deletion of user, will retry later",
+				zap.Int("User ID", userID),
+				zap.String("error", apiErr.Message),
+				zap.String("details", apiErr.Details))
+		}
+	}
+
+	users, apiErr := w.repo.GetUsersDueForDeletion(100)
+	if apiErr != nil {
+		return
+	}
+
+	for _, user := range users {
+		apiErr := w.deleteUser(ctx, user.ID)
+		if apiErr != nil && apiErr.Code == 409 {
+			logger.Info("User deletion was cancelled", zap.Int("User ID", user.ID))
+			continue
+		}
+		if apiErr != nil {
+			logger.Error("Cannot delete user, will retry later",
+				zap.Int("User ID", user.ID),
+				zap.String("error", apiErr.Message),
+				zap.String("details", apiErr.Details))
+			continue
+		}
+		logger.Info("User deleted", zap.Int("User ID", user.ID))
+	}
+}
+
+// Order matters. User is erased first, and only erased users are announced, so user who cancelled
+// deletion in the last moment keeps messages. Announcement is repeated until it succeeds,
+// so message service must handle event more than once
+func (w *DeletionWorker) deleteUser(ctx context.Context, userID int) *utils.APIError {
+	if apiErr := w.repo.EraseUser(userID); apiErr != nil {
+		return apiErr
+	}
+
+	if apiErr := w.announceDeletion(ctx, userID); apiErr != nil {
+		logger.Error("Cannot publish deletion of user, will retry later",
+			zap.Int("User ID", userID),
+			zap.String("error", apiErr.Message),
+			zap.String("details", apiErr.Details))
+	}
+	return nil
+}
+
+// announceDeletion drops sessions of erased user and tells other services about it
+func (w *DeletionWorker) announceDeletion(ctx context.Context, userID int) *utils.APIError {
+	if apiErr := w.tokenRepo.DeleteUserTokens(ctx, userID); apiErr != nil {
+		return apiErr
+	}
+
+	event := &domain.UserEvent{
+		Type:       domain.UserDeletedEvent,
+		UserID:     userID,
+		OccurredAt: time.Now(),
+	}
+	if apiErr := w.events.PublishUserEvent(ctx, event); apiErr != nil {
+		return apiErr
+	}
+
+	return w.repo.MarkDeletionEventPublished(userID)
+}
@@ -0,0 +1,149 @@
+package services
+
+import (
+	"auth-service/internal/domain"
+	"auth-service/internal/utils"
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+type fakeDeletedUser struct {
+	// Grace period is over
+	due bool
+	// User cancelled deletion after worker picked him up
+	cancelled bool
+	erased    bool
+	// Erased, user.deleted is not published yet
+	pending bool
+}
+
+// fakeDeletionRepo has only what deletion worker uses, other methods panic
+type fakeDeletionRepo struct {
+	domain.UserRepository
+	users map[int]*fakeDeletedUser
+}
+
+func (r *fakeDeletionRepo) GetUsersDueForDeletion(limit int) ([]domain.User, *utils.APIError) {
+	var users []domain.User
+	for id, user := range r.users {
+		if user.due && !user.erased {
+			users = append(users, domain.User{ID: id})
+		}
+	}
+	return users, nil
+}
+
+func (r *fakeDeletionRepo) EraseUser(id int) *utils.APIError {
+	user := r.users[id]
+	if user.cancelled || user.erased {
+		return utils.NewAPIError(409, "User is not due for deletion", "")
+	}
+	user.erased = true
+	user.pending = true
+	return nil
+}
+
+func (r *fakeDeletionRepo) GetUsersPendingDeletionEvent(limit int) ([]int, *utils.APIError) {
+	var ids []int
+	for id, user := range r.users {
+		if user.pending {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+func (r *fakeDeletionRepo) MarkDeletionEventPublished(id int) *utils.APIError {
+	r.users[id].pending = false
+	return nil
+}
+
+type fakePublisher struct {
+	fail      bool
+	published []int
+}
+
+func (p *fakePublisher) PublishUserEvent(ctx context.Context, event *domain.UserEvent) *utils.APIError {
+	if p.fail {
+		return utils.NewAPIError(500, "Cannot publish event", "")
+	}
+	p.published = append(p.published, event.UserID)
+	return nil
+}
+
+func TestDeletionWorker(t *testing.T) {
+	tests := []struct {
+		name          string
+		users         map[int]*fakeDeletedUser
+		failPublish   bool
+		wantErased    []int
+		wantPending   []int
+		wantPublished []int
+	}{
+		{
+			name:          "due user",
+			users:         map[int]*fakeDeletedUser{1: {due: true}, 2: {}},
+			wantErased:    []int{1},
+			wantPublished: []int{1},
+		},
+		{
+			name:  "cancelled after pick up",
+			users: map[int]*fakeDeletedUser{1: {due: true, cancelled: true}},
+		},
+		{
+			name:        "publishing fails",
+			users:       map[int]*fakeDeletedUser{1: {due: true}},
+			failPublish: true,
+			wantErased:  []int{1},
+			wantPending: []int{1},
+		},
+		{
+			name:          "pending from previous run",
+			users:         map[int]*fakeDeletedUser{1: {due: true, erased: true, pending: true}, 2: {due: true}},
+			wantErased:    []int{1, 2},
+			wantPublished: []int{1, 2},
+		},
+		{
+			name:        "pending and publishing still fails",
+			users:       map[int]*fakeDeletedUser{1: {due: true, erased: true, pending: true}},
+			failPublish: true,
+			wantErased:  []int{1},
+			wantPending: []int{1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &fakeDeletionRepo{users: tt.users}
+			events := &fakePublisher{fail: tt.failPublish}
+			worker := NewDeletionWorker(repo, &fakeTokenRepo{tokens: map[string]string{}}, events, 0)
+
+			worker.deleteDueUsers(context.Background())
+
+			var erased, pending []int
+			for id, user := range repo.users {
+				if user.erased {
+					erased = append(erased, id)
+				}
+				if user.pending {
+					pending = append(pending, id)
+				}
+			}
+			sort.Ints(erased)
+			sort.Ints(pending)
+			sort.Ints(events.published)
+
+			if !reflect.DeepEqual(erased, tt.wantErased) {
+				t.Errorf("erased %v, want %v", erased, tt.wantErased)
+			}
+			if !reflect.DeepEqual(pending, tt.wantPending) {
+				t.Errorf("pending %v, want %v", pending, tt.wantPending)
+			}
+			if !reflect.DeepEqual(events.published, tt.wantPublished) {
+				t.Errorf("published %v, want %v", events.published, tt.wantPublished)
+			}
+		})
+	}
+}
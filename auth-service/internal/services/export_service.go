@@ -0,0 +1,310 @@
+package services
+
+import (
+	"archive/zip"
+	"auth-service/internal/domain"
+	"auth-service/internal/utils"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	logger "auth-service/internal"
+
+	"go.uber.org/zap"
+)
+
+// Job which is running longer than that belongs to worker which crashed or was restarted
+const exportJobLease = time.Hour
+
+// ExportService handles requests for personal data export. Export itself is built by ExportWorker
+type ExportService struct {
+	repo domain.ExportRepository
+}
+
+func NewExportService(repo domain.ExportRepository) *ExportService {
+	return &ExportService{repo: repo}
+}
+
+// RequestExport creates export job. If user already waits for one, he gets it instead of new
+func (s *ExportService) RequestExport(userID int) (*domain.ExportJob, *utils.APIError) {
+	activeJob, apiErr := s.repo.GetActiveExportJob(userID)
+	if apiErr != nil {
+		return nil, utils.NewAPIError(500, "Internal server error", "Please try again")
+	}
+
+	if activeJob != nil {
+		return activeJob, nil
+	}
+
+	id, err := randomHex(16)
+	if err != nil {
+		logger.Error("Cannot generate export job ID",
+			zap.Error(err))
+		return nil, utils.NewAPIError(500, "Internal server error", "Please try again")
+	}
+
+	job := &domain.ExportJob{
+		ID:     id,
+		UserID: userID,
+		Status: domain.ExportPending,
+	}
+
+	if apiErr := s.repo.CreateExportJob(job); apiErr != nil {
+		return nil, utils.NewAPIError(500, "Internal server error", "Please try again")
+	}
+
+	return job, nil
+}
+
+// GetExport returns job of user. Somebody else's job looks like not existing one
+func (s *ExportService) GetExport(userID int, id string) (*domain.ExportJob, *utils.APIError) {
+	job, apiErr := s.repo.GetExportJob(id)
+	if apiErr != nil {
+		return nil, utils.NewAPIError(500, "Internal server error", "Please try again")
+	}
+
+	if job == nil || job.UserID != userID {
+		return nil, utils.NewAPIError(404, "Export not found", "")
+	}
+
+	return job, nil
+}
+
+// GetDownload checks download link and returns path of archive
+func (s *ExportService) GetDownload(id string, token string) (string, *utils.APIError) {
+	job, apiErr := s.repo.GetExportJob(id)
+	if apiErr != nil {
+		return "", utils.NewAPIError(500, "Internal server error", "Please try again")
+	}
+
+	if job == nil || job.DownloadToken == "" || subtle.ConstantTimeCompare([]byte(job.DownloadToken), []byte(token)) != 1 {
+		return "", utils.NewAPIError(404, "Export not found", "")
+	}
+
+	if job.Status != domain.ExportReady || job.ExpiresAt == nil || job.ExpiresAt.Before(time.Now()) {
+		return "", utils.NewAPIError(410, "Download link expired", "Request new export")
+	}
+
+	return job.FilePath, nil
+}
+
+// ExportWorker builds archives for pending export jobs and removes expired ones
+type ExportWorker struct {
+	exportRepo  domain.ExportRepository
+	userRepo    domain.UserRepository
+	tokenRepo   domain.TokenRepository
+	eventRepo   domain.AuthEventRepository
+	messageRepo domain.MessageExportRepository
+	dir         string
+	ttl         time.Duration
+	interval    time.Duration
+}
+
+func NewExportWorker(
+	exportRepo domain.ExportRepository,
+	userRepo domain.UserRepository,
+	tokenRepo domain.TokenRepository,
+	eventRepo domain.AuthEventRepository,
+	messageRepo domain.MessageExportRepository,
+	dir string,
+	ttl time.Duration,
+	interval time.Duration,
+) *ExportWorker {
+	return &ExportWorker{
+		exportRepo:  exportRepo,
+		userRepo:    userRepo,
+		tokenRepo:   tokenRepo,
+		eventRepo:   eventRepo,
+		messageRepo: messageRepo,
+		dir:         dir,
+		ttl:         ttl,
+		interval:    interval,
+	}
+}
+
+// Run blocks until context is cancelled, so start it in goroutine
+func (w *ExportWorker) Run(ctx context.Context) {
+	if err := os.MkdirAll(w.dir, 0700); err != nil {
+		logger.Error("Cannot create export directory, exports are disabled",
+			zap.String("Directory", w.dir),
+			zap.Error(err))
+		return
+	}
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		w.processPendingJobs(ctx)
+		w.removeExpiredJobs()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (w *ExportWorker) processPendingJobs(ctx context.Context) {
+	// Otherwise user waits for such job forever and can't request new export
+	if requeued, apiErr := w.exportRepo.RequeueStaleExportJobs(time.Now().Add(-exportJobLease)); apiErr == nil && requeued > 0 {
+		logger.Warn("Requeued stale export jobs", zap.Int64("Jobs", requeued))
+	}
+
+	for i := 0; i < 10; i++ {
+		job, apiErr := w.exportRepo.ClaimPendingExportJob(time.Now())
+		if apiErr != nil || job == nil {
+			return
+		}
+
+		filePath := filepath.Join(w.dir, job.ID+".zip")
+		now := time.Now()
+		job.FinishedAt = &now
+
+		if err := w.buildArchive(ctx, job.UserID, filePath); err != nil {
+			logger.Error("Cannot build export",
+				zap.String("Job ID", job.ID),
+				zap.Int("User ID", job.UserID),
+				zap.Error(err))
+			os.Remove(filePath)
+
+			job.Status = domain.ExportFailed
+			job.Error = "Cannot collect your data, please try again later"
+			w.exportRepo.UpdateExportJob(job)
+			continue
+		}
+
+		token, err := randomHex(32)
+		if err != nil {
+			logger.Error("Cannot generate download token",
+				zap.Error(err))
+			os.Remove(filePath)
+			job.Status = domain.ExportFailed
+			w.exportRepo.UpdateExportJob(job)
+			continue
+		}
+
+		expiresAt := time.Now().Add(w.ttl)
+		job.Status = domain.ExportReady
+		job.FilePath = filePath
+		job.DownloadToken = token
+		job.ExpiresAt = &expiresAt
+		w.exportRepo.UpdateExportJob(job)
+
+		logger.Info("Export is ready",
+			zap.String("Job ID", job.ID),
+			zap.Int("User ID", job.UserID))
+	}
+}
+
+func (w *ExportWorker) removeExpiredJobs() {
+	jobs, apiErr := w.exportRepo.GetExpiredExportJobs(100)
+	if apiErr != nil {
+		return
+	}
+
+	for i := range jobs {
+		job := &jobs[i]
+		if err := os.Remove(job.FilePath); err != nil && !os.IsNotExist(err) {
+			logger.Error("Cannot remove expired export",
+				zap.String("Job ID", job.ID),
+				zap.Error(err))
+			continue
+		}
+
+		job.Status = domain.ExportExpired
+		job.FilePath = ""
+		job.DownloadToken = ""
+		w.exportRepo.UpdateExportJob(job)
+	}
+}
+
+// Archive is ZIP with one JSON file for every kind of data
+func (w *ExportWorker) buildArchive(ctx context.Context, userID int, filePath string) error {
+	user, apiErr := w.userRepo.GetUserByID(userID)
+	if apiErr != nil {
+		return apiErr
+	}
+	if user == nil {
+		return os.ErrNotExist
+	}
+
+	sessions, apiErr := w.tokenRepo.GetUserSessions(ctx, userID)
+	if apiErr != nil {
+		return apiErr
+	}
+
+	events, apiErr := w.eventRepo.GetUserEvents(userID)
+	if apiErr != nil {
+		return apiErr
+	}
+
+	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	archive := zip.NewWriter(file)
+
+	// Password hash is personal data too, but it is useless for user and must never leave the server
+	profile := struct {
+		ID          int             `json:"id"`
+		Username    string          `json:"username"`
+		Role        domain.UserRole `json:"role"`
+		InvitedBy   *int            `json:"invited_by,omitempty"`
+		DeleteAfter *time.Time      `json:"delete_after,omitempty"`
+		CreatedAt   time.Time       `json:"created_at"`
+	}{user.ID, user.Username, user.Role, user.InvitedBy, user.DeleteAfter, user.CreatedAt}
+
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"profile.json", profile},
+		{"sessions.json", sessions},
+		{"auth_events.json", events},
+	}
+
+	for _, f := range files {
+		entry, err := archive.Create(f.name)
+		if err != nil {
+			return err
+		}
+
+		encoder := json.NewEncoder(entry)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(f.data); err != nil {
+			return err
+		}
+	}
+
+	messagesEntry, err := archive.Create("messages.json")
+	if err != nil {
+		return err
+	}
+
+	if apiErr := w.messageRepo.WriteUserMessages(ctx, userID, messagesEntry); apiErr != nil {
+		return apiErr
+	}
+
+	if err := archive.Close(); err != nil {
+		return err
+	}
+
+	return file.Close()
+}
+
+func randomHex(size int) (string, error) {
+	randomBytes := make([]byte, size)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(randomBytes), nil
+}
@@ -0,0 +1,123 @@
+package services
+
+import (
+	"auth-service/internal/domain"
+	"auth-service/internal/utils"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	logger "auth-service/internal"
+
+	"go.uber.org/zap"
+)
+
+// Limits for invites of regular users. Admins have no limits
+const (
+	userInviteMaxUses = 5
+	userInviteMaxTTL  = 7 * 24 * time.Hour
+)
+
+type InviteService struct {
+	repo domain.InviteRepository
+}
+
+func NewInviteService(repo domain.InviteRepository) *InviteService {
+	return &InviteService{repo: repo}
+}
+
+// CreateInvite makes new invite code. Only admins can pre-assign role
+// ttl = 0 means invite never expires (admins only)
+func (s *InviteService) CreateInvite(creatorID int, creatorRole domain.UserRole, role domain.UserRole, maxUses int, ttl time.Duration) (*domain.Invite, *utils.APIError) {
+
+	if creatorRole == domain.RoleGuest {
+		return nil, utils.NewAPIError(403, "Guests can't invite users", "Upgrade your account first")
+	}
+
+	if role == "" {
+		role = domain.RoleUser
+	}
+
+	// Inviting guests makes no sense
+	if !role.IsValid() || role == domain.RoleGuest {
+		return nil, utils.NewAPIError(400, "Invalid role", "")
+	}
+
+	if maxUses <= 0 {
+		maxUses = 1
+	}
+
+	if ttl < 0 {
+		return nil, utils.NewAPIError(400, "Invalid expiry", "")
+	}
+
+	if creatorRole != domain.RoleAdmin {
+		if role != domain.RoleUser {
+			return nil, utils.NewAPIError(403, "Only admins can assign roles", "")
+		}
+
+		if maxUses > userInviteMaxUses {
+			return nil, utils.NewAPIError(400, "Too many uses", "Maximum is 5")
+		}
+
+		if ttl == 0 || ttl > userInviteMaxTTL {
+			ttl = userInviteMaxTTL
+		}
+	}
+
+	randomBytes := make([]byte, 8)
+	if _, err := rand.Read(randomBytes); err != nil {
+		logger.Error("Cannot generate invite code",
+			zap.Error(err))
+		return nil, utils.NewAPIError(500, "Internal server error", "Please try again")
+	}
+
+	invite := &domain.Invite{
+		Code:      hex.EncodeToString(randomBytes),
+		CreatedBy: creatorID,
+		Role:      role,
+		MaxUses:   maxUses,
+	}
+
+	if ttl > 0 {
+		expiresAt := time.Now().Add(ttl)
+		invite.ExpiresAt = &expiresAt
+	}
+
+	if apiErr := s.repo.CreateInvite(invite); apiErr != nil {
+		return nil, utils.NewAPIError(500, "Internal server error", "Please try again")
+	}
+
+	return invite, nil
+}
+
+func (s *InviteService) GetInvites(creatorID int) ([]domain.Invite, *utils.APIError) {
+	invites, apiErr := s.repo.GetInvitesByCreator(creatorID)
+	if apiErr != nil {
+		return nil, utils.NewAPIError(500, "Internal server error", "Please try again")
+	}
+
+	return invites, nil
+}
+
+// RevokeInvite stops invite. User can revoke his own invites, admin can revoke any
+func (s *InviteService) RevokeInvite(code string, userID int, role domain.UserRole) *utils.APIError {
+	invite, apiErr := s.repo.GetInvite(code)
+	if apiErr != nil {
+		return utils.NewAPIError(500, "Internal server error", "Please try again")
+	}
+
+	if invite == nil {
+		return utils.NewAPIError(404, "Invite not found", "")
+	}
+
+	if invite.CreatedBy != userID && role != domain.RoleAdmin {
+		return utils.NewAPIError(403, "It is not your invite", "")
+	}
+
+	if apiErr := s.repo.RevokeInvite(code); apiErr != nil {
+		return utils.NewAPIError(500, "Internal server error", "Please try again")
+	}
+
+	return nil
+}
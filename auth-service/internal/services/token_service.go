@@ -13,15 +13,22 @@ import (
 
 type TokenService struct {
 	tokenRepo domain.TokenRepository
+	// Services which can get their own token through token exchange
+	audiences   []string
+	exchangeTTL time.Duration
 }
 
-func NewTokenService(tokenRepo domain.TokenRepository) *TokenService {
-	return &TokenService{tokenRepo: tokenRepo}
+func NewTokenService(tokenRepo domain.TokenRepository, audiences []string, exchangeTTL time.Duration) *TokenService {
+	return &TokenService{
+		tokenRepo:   tokenRepo,
+		audiences:   audiences,
+		exchangeTTL: exchangeTTL,
+	}
 }
 
-func (s *TokenService) CreateToken(ctx context.Context, userID int, fingerprint string) (*domain.Token, *utils.APIError) {
+func (s *TokenService) CreateToken(ctx context.Context, userID int, role domain.UserRole, fingerprint string) (*domain.Token, *utils.APIError) {
 	// Generate new JWT token
-	tokenString, err := auth.GenerateToken(userID)
+	tokenString, err := auth.GenerateToken(userID, string(role))
 	if err != nil {
 		logger.Error("Failed to generate token",
 			zap.Int("User ID", userID),
@@ -32,8 +39,9 @@ func (s *TokenService) CreateToken(ctx context.Context, userID int, fingerprint
 	newToken := &domain.Token{
 		UserID:    userID,
 		Token:     tokenString,
+		Audience:  auth.SessionAudience,
 		IssuedAt:  time.Now(),
-		ExpiresAt: time.Now().Add(12 * time.Hour),
+		ExpiresAt: time.Now().Add(auth.SessionTokenTTL),
 	}
 
 	// Save token in repo
@@ -61,6 +69,11 @@ func (s *TokenService) ValidateToken(ctx context.Context, token string, fingerpr
 		return nil, utils.NewAPIError(403, "Expired token", "")
 	}
 
+	// Exchanged tokens can't be used as session
+	if !claims.VerifyAudience(auth.SessionAudience, true) {
+		return nil, utils.NewAPIError(403, "Invalid token", "")
+	}
+
 	sessionToken, apiErr := s.tokenRepo.GetToken(context.Background(), claims.UserID, fingerprint)
 
 	// Token expired by TTL or not found in redis
@@ -75,3 +88,75 @@ func (s *TokenService) ValidateToken(ctx context.Context, token string, fingerpr
 
 	return claims, nil
 }
+
+// ExchangeToken turns session token into short-lived token for one service (RFC 8693).
+// So if this token leaks from one service, it is useless for others and dies in few minutes
+func (s *TokenService) ExchangeToken(ctx context.Context, subjectToken string, fingerprint string, audience string) (*domain.Token, *utils.APIError) {
+	if !s.isAllowedAudience(audience) {
+		return nil, utils.NewAPIError(400, domain.TokenErrInvalidTarget, "Unknown audience")
+	}
+
+	// Only live session can be exchanged
+	claims, apiErr := s.ValidateToken(ctx, subjectToken, fingerprint)
+	if apiErr != nil {
+		return nil, utils.NewAPIError(400, domain.TokenErrInvalidGrant, "Invalid or expired subject token")
+	}
+
+	tokenString, err := auth.GenerateAudienceToken(claims.UserID, claims.Role, audience, auth.SessionID(subjectToken), s.exchangeTTL)
+	if err != nil {
+		logger.Error("Failed to generate audience token",
+			zap.Int("User ID", claims.UserID),
+			zap.String("Audience", audience),
+			zap.Error(err))
+		return nil, utils.NewAPIError(500, domain.TokenErrServerError, "")
+	}
+
+	return &domain.Token{
+		UserID:    claims.UserID,
+		Token:     tokenString,
+		Audience:  audience,
+		IssuedAt:  time.Now(),
+		ExpiresAt: time.Now().Add(s.exchangeTTL),
+	}, nil
+}
+
+// ValidateAudienceToken checks token made by exchange. Token must be issued for this audience
+// and session it was exchanged from must be still alive, so logout or revoking session kills its tokens too
+func (s *TokenService) ValidateAudienceToken(ctx context.Context, token string, fingerprint string, audience string) (*auth.Claims, *utils.APIError) {
+
+	// Nobody can ask to validate session token as "audience" token
+	if audience == auth.SessionAudience {
+		return nil, utils.NewAPIError(403, "Invalid token", "")
+	}
+
+	claims, err := auth.ValidateToken(token)
+	if err != nil {
+		return nil, utils.NewAPIError(403, "Invalid token", "")
+	}
+
+	// Token for another service
+	if !claims.VerifyAudience(audience, true) {
+		return nil, utils.NewAPIError(403, "Invalid token audience", "")
+	}
+
+	sessionToken, apiErr := s.tokenRepo.GetToken(ctx, claims.UserID, fingerprint)
+	if apiErr != nil {
+		return nil, utils.NewAPIError(403, "Invalid or expired token", "")
+	}
+
+	// Fingerprint has another session now, e.g. user logged out and in again
+	if claims.SessionID == "" || claims.SessionID != auth.SessionID(sessionToken) {
+		return nil, utils.NewAPIError(403, "Invalid or expired token", "")
+	}
+
+	return claims, nil
+}
+
+func (s *TokenService) isAllowedAudience(audience string) bool {
+	for _, allowed := range s.audiences {
+		if audience == allowed {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,142 @@
+package services
+
+import (
+	"auth-service/internal/auth"
+	"auth-service/internal/domain"
+	"auth-service/internal/utils"
+	"context"
+	"testing"
+	"time"
+)
+
+// fakeTokenRepo keeps one session token per fingerprint
+type fakeTokenRepo struct {
+	tokens map[string]string
+}
+
+func (r *fakeTokenRepo) SaveToken(ctx context.Context, userID int, fingerprintHash string, token *domain.Token) *utils.APIError {
+	r.tokens[fingerprintHash] = token.Token
+	return nil
+}
+
+func (r *fakeTokenRepo) GetToken(ctx context.Context, userID int, fingerprintHash string) (string, *utils.APIError) {
+	token, ok := r.tokens[fingerprintHash]
+	if !ok {
+		return "", utils.NewAPIError(404, "Token not found", "")
+	}
+	return token, nil
+}
+
+func (r *fakeTokenRepo) IsTokenExists(ctx context.Context, token string) bool {
+	for _, saved := range r.tokens {
+		if saved == token {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *fakeTokenRepo) DeleteUserTokens(ctx context.Context, userID int) *utils.APIError {
+	r.tokens = map[string]string{}
+	return nil
+}
+
+func (r *fakeTokenRepo) GetUserSessions(ctx context.Context, userID int) ([]domain.Session, *utils.APIError) {
+	return nil, nil
+}
+
+func TestValidateAudienceToken(t *testing.T) {
+	const fingerprint = "fingerprint"
+
+	tests := []struct {
+		name string
+		// Changes sessions after exchange
+		after    func(repo *fakeTokenRepo, service *TokenService)
+		audience string
+		token    func(exchanged string) string
+		wantErr  bool
+	}{
+		{
+			name:     "live session",
+			audience: "message-service",
+		},
+		{
+			name:     "another audience",
+			audience: "other-service",
+			wantErr:  true,
+		},
+		{
+			name: "logout",
+			after: func(repo *fakeTokenRepo, service *TokenService) {
+				repo.DeleteUserTokens(context.Background(), 1)
+			},
+			audience: "message-service",
+			wantErr:  true,
+		},
+		{
+			name: "new session on the same fingerprint",
+			after: func(repo *fakeTokenRepo, service *TokenService) {
+				// Tokens issued in the same second are equal, new one must differ
+				repo.tokens[fingerprint] += "x"
+			},
+			audience: "message-service",
+			wantErr:  true,
+		},
+		{
+			name:     "token without session",
+			audience: "message-service",
+			token: func(exchanged string) string {
+				token, _ := auth.GenerateAudienceToken(1, "user", "message-service", "", time.Minute)
+				return token
+			},
+			wantErr: true,
+		},
+		{
+			name:     "session token",
+			audience: auth.SessionAudience,
+			token: func(exchanged string) string {
+				token, _ := auth.GenerateToken(1, "user")
+				return token
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &fakeTokenRepo{tokens: map[string]string{}}
+			service := NewTokenService(repo, []string{"message-service", "other-service"}, time.Minute)
+
+			session, apiErr := service.CreateToken(context.Background(), 1, domain.RoleUser, fingerprint)
+			if apiErr != nil {
+				t.Fatalf("CreateToken: %v", apiErr.Message)
+			}
+			exchanged, apiErr := service.ExchangeToken(context.Background(), session.Token, fingerprint, "message-service")
+			if apiErr != nil {
+				t.Fatalf("ExchangeToken: %v", apiErr.Message)
+			}
+
+			if tt.after != nil {
+				tt.after(repo, service)
+			}
+			token := exchanged.Token
+			if tt.token != nil {
+				token = tt.token(token)
+			}
+
+			claims, apiErr := service.ValidateAudienceToken(context.Background(), token, fingerprint, tt.audience)
+			if tt.wantErr {
+				if apiErr == nil {
+					t.Fatalf("expected error, got claims of user %d", claims.UserID)
+				}
+				return
+			}
+			if apiErr != nil {
+				t.Fatalf("unexpected error: %s", apiErr.Message)
+			}
+			if claims.UserID != 1 || claims.SessionID != auth.SessionID(session.Token) {
+				t.Fatalf("wrong claims: %+v", claims)
+			}
+		})
+	}
+}
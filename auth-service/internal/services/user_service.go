@@ -4,6 +4,8 @@ import (
 	"auth-service/internal/auth"
 	"auth-service/internal/domain"
 	"auth-service/internal/utils"
+	"crypto/rand"
+	"encoding/hex"
 
 	logger "auth-service/internal"
 
@@ -11,14 +13,25 @@ import (
 )
 
 type UserService struct {
-	repo domain.UserRepository
+	repo             domain.UserRepository
+	registrationMode domain.RegistrationMode
 }
 
-func NewUserService(repo domain.UserRepository) *UserService {
-	return &UserService{repo: repo}
+func NewUserService(repo domain.UserRepository, registrationMode domain.RegistrationMode) *UserService {
+	return &UserService{repo: repo, registrationMode: registrationMode}
 }
 
-func (s *UserService) CreateUser(user *domain.User) (*domain.User, *utils.APIError) {
+// CreateUser registers new user. Invite code is required in invite-only mode and optional in open mode
+func (s *UserService) CreateUser(user *domain.User, inviteCode string) (*domain.User, *utils.APIError) {
+
+	switch s.registrationMode {
+	case domain.RegistrationClosed:
+		return nil, utils.NewAPIError(403, "Registration is closed", "")
+	case domain.RegistrationInviteOnly:
+		if inviteCode == "" {
+			return nil, utils.NewAPIError(403, "Invite code is required", "")
+		}
+	}
 
 	foundUser, apiErr := s.repo.GetUserByUsername(user.Username)
 
@@ -45,8 +58,22 @@ func (s *UserService) CreateUser(user *domain.User) (*domain.User, *utils.APIErr
 
 	user.Password = hashedPassword
 
-	newUserId, apiErr := s.repo.CreateUser(user)
+	if user.Role == "" {
+		user.Role = domain.RoleUser
+	}
+
+	var newUserId int
+	if inviteCode != "" {
+		newUserId, apiErr = s.repo.CreateUserWithInvite(user, inviteCode)
+	} else {
+		newUserId, apiErr = s.repo.CreateUser(user)
+	}
+
 	if apiErr != nil {
+		// Bad invite, user must know about it
+		if apiErr.Code == 403 {
+			return nil, apiErr
+		}
 		logger.Error("Cannot create user",
 			zap.String("error", apiErr.Message),
 			zap.String("details", apiErr.Details))
@@ -95,3 +122,118 @@ func (s *UserService) GetUserByID(id int) (*domain.User, *utils.APIError) {
 
 	return foundUser, nil
 }
+
+// CreateGuest makes anonymous user with generated handle and without password
+func (s *UserService) CreateGuest() (*domain.User, *utils.APIError) {
+	// Guests would be a way around invites
+	if s.registrationMode != domain.RegistrationOpen {
+		return nil, utils.NewAPIError(403, "Guest registration is disabled", "")
+	}
+
+	// Handle can collide with existing one, so try few times
+	for attempt := 0; attempt < 5; attempt++ {
+		randomBytes := make([]byte, 4)
+		if _, err := rand.Read(randomBytes); err != nil {
+			logger.Error("Cannot generate guest handle",
+				zap.Error(err))
+			return nil, utils.NewAPIError(500, "Internal server error", "Please try again")
+		}
+
+		guest := &domain.User{
+			Username: "guest_" + hex.EncodeToString(randomBytes),
+			Role:     domain.RoleGuest,
+		}
+
+		newUserId, apiErr := s.repo.CreateUser(guest)
+		if apiErr != nil {
+			if apiErr.Code == 409 {
+				continue
+			}
+			logger.Error("Cannot create guest",
+				zap.String("error", apiErr.Message),
+				zap.String("details", apiErr.Details))
+			return nil, utils.NewAPIError(500, "Internal server error while registering your user", "Please try again")
+		}
+
+		guest.ID = newUserId
+		return guest, nil
+	}
+
+	return nil, utils.NewAPIError(500, "Internal server error while registering your user", "Please try again")
+}
+
+// UpgradeGuest attaches username and password to guest. User ID is the same, so all messages are kept
+func (s *UserService) UpgradeGuest(id int, username string, password string) (*domain.User, *utils.APIError) {
+	if username == "" || password == "" {
+		return nil, utils.NewAPIError(400, "Username and password are required", "")
+	}
+
+	user, apiErr := s.GetUserByID(id)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+
+	if user.Role != domain.RoleGuest {
+		return nil, utils.NewAPIError(409, "User is not a guest", "")
+	}
+
+	foundUser, apiErr := s.repo.GetUserByUsername(username)
+	if apiErr != nil {
+		logger.Error("Cannot upgrade guest. Unexpectable DB error",
+			zap.String("error", apiErr.Message),
+			zap.String("details", apiErr.Details))
+		return nil, utils.NewAPIError(500, "Internal server error", "Database error")
+	}
+
+	if foundUser != nil {
+		return nil, utils.NewAPIError(409, "User already exists", "")
+	}
+
+	hashedPassword, err := auth.HashPassword(password)
+	if err != nil {
+		logger.Error("Cannot hash user password",
+			zap.Error(err))
+		return nil, utils.NewAPIError(500, "Internal server error while upgrading your user", "Please try again")
+	}
+
+	if apiErr := s.repo.UpgradeGuest(id, username, hashedPassword); apiErr != nil {
+		return nil, apiErr
+	}
+
+	user.Username = username
+	user.Password = hashedPassword
+	user.Role = domain.RoleUser
+
+	return user, nil
+}
+
+func (s *UserService) SearchUsers(role domain.UserRole, usernamePrefix string, limit int) ([]domain.User, *utils.APIError) {
+	if !role.CanListDirectory() {
+		return nil, utils.NewAPIError(403, "Guests can't list users", "Upgrade your account first")
+	}
+
+	if len(usernamePrefix) == 0 {
+		return nil, utils.NewAPIError(400, "Username cannot be empty", "")
+	}
+
+	if limit <= 0 || limit > 50 {
+		limit = 50
+	}
+
+	users, apiErr := s.repo.SearchUsers(usernamePrefix, limit)
+	if apiErr != nil {
+		return nil, utils.NewAPIError(500, "Internal server error", "Please try again")
+	}
+
+	return users, nil
+}
+
+// GetInvitedUsers returns users who registered with invites of this user
+func (s *UserService) GetInvitedUsers(inviterID int) ([]domain.User, *utils.APIError) {
+	users, apiErr := s.repo.GetInvitedUsers(inviterID)
+	if apiErr != nil {
+		return nil, utils.NewAPIError(500, "Internal server error", "Please try again")
+	}
+
+	return users, nil
+}
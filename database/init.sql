@@ -1,17 +1,289 @@
 -- Create user tablef
 CREATE TABLE IF NOT EXISTS users (
     id SERIAL PRIMARY KEY,
-    username VARCHAR(50) UNIQUE NOT NULL,
+    -- NULL after account is deleted
+    username VARCHAR(50) UNIQUE,
     password TEXT NOT NULL,
+    -- user, guest
+    role VARCHAR(20) DEFAULT 'user' NOT NULL,
+    -- Who invited this user and with which code
+    invited_by INT REFERENCES users(id) ON DELETE SET NULL,
+    invite_code VARCHAR(32),
+    -- Account deletion: user asked to delete account, and when it was actually erased
+    delete_after TIMESTAMP without time zone,
+    deleted_at TIMESTAMP without time zone,
+    -- Erased, but user.deleted event is not published yet
+    deletion_event_pending BOOLEAN DEFAULT FALSE NOT NULL,
     created_at TIMESTAMP without time zone DEFAULT NOW() NOT NULL
 );
 
+CREATE TABLE IF NOT EXISTS invites (
+    code VARCHAR(32) PRIMARY KEY,
+    created_by INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    -- Role which user gets after registration
+    role VARCHAR(20) DEFAULT 'user' NOT NULL,
+    max_uses INT DEFAULT 1 NOT NULL,
+    uses INT DEFAULT 0 NOT NULL,
+    expires_at TIMESTAMP without time zone,
+    revoked_at TIMESTAMP without time zone,
+    created_at TIMESTAMP without time zone DEFAULT NOW() NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS invites_created_by_idx ON invites (created_by);
+CREATE INDEX IF NOT EXISTS users_invited_by_idx ON users (invited_by);
+CREATE INDEX IF NOT EXISTS users_delete_after_idx ON users (delete_after) WHERE delete_after IS NOT NULL;
+CREATE INDEX IF NOT EXISTS users_deletion_event_pending_idx ON users (id) WHERE deletion_event_pending;
+
+CREATE TABLE IF NOT EXISTS auth_events (
+    id BIGSERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    -- register, login, guest_upgrade, ...
+    type VARCHAR(50) NOT NULL,
+    ip VARCHAR(64) DEFAULT '' NOT NULL,
+    user_agent TEXT DEFAULT '' NOT NULL,
+    created_at TIMESTAMP without time zone DEFAULT NOW() NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS auth_events_user_id_idx ON auth_events (user_id, created_at);
+
+CREATE TABLE IF NOT EXISTS export_jobs (
+    id VARCHAR(32) PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    -- pending, running, ready, failed, expired
+    status VARCHAR(20) DEFAULT 'pending' NOT NULL,
+    file_path TEXT DEFAULT '' NOT NULL,
+    download_token TEXT DEFAULT '' NOT NULL,
+    error TEXT DEFAULT '' NOT NULL,
+    created_at TIMESTAMP without time zone DEFAULT NOW() NOT NULL,
+    -- When worker took job. Running job older than lease is taken again
+    started_at TIMESTAMP without time zone,
+    finished_at TIMESTAMP without time zone,
+    expires_at TIMESTAMP without time zone
+);
+
+CREATE INDEX IF NOT EXISTS export_jobs_user_id_idx ON export_jobs (user_id);
+CREATE INDEX IF NOT EXISTS export_jobs_status_idx ON export_jobs (status);
+
+-- Conversation has stable ID, messages and inbox lines point to it
+CREATE TABLE IF NOT EXISTS conversations (
+    id SERIAL PRIMARY KEY,
+    -- direct, group, channel
+    type VARCHAR(20) DEFAULT 'direct' NOT NULL,
+    -- Direct conversation: smaller user ID << 32 | bigger user ID, so two users have only one
+    direct_key BIGINT UNIQUE,
+    title VARCHAR(100),
+    avatar_url TEXT,
+    -- Owner of group
+    created_by INT REFERENCES users(id) ON DELETE SET NULL,
+    -- New members of group see messages sent before they joined
+    history_visible BOOLEAN DEFAULT FALSE NOT NULL,
+    -- Channels only. Subscribers have no inbox rows, their inbox line is built from here
+    message_count BIGINT DEFAULT 0 NOT NULL,
+    last_message_id INT,
+    last_sender_id INT,
+    last_preview TEXT,
+    last_message_at TIMESTAMP,
+    -- Channels only. Members with member role, updated when they subscribe and unsubscribe
+    subscriber_count BIGINT DEFAULT 0 NOT NULL,
+    created_at TIMESTAMP DEFAULT (NOW() AT TIME ZONE 'UTC') NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS conversation_participants (
+    conversation_id INT NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    -- owner, admin, member. Only for groups
+    role VARCHAR(20) DEFAULT 'member' NOT NULL,
+    joined_at TIMESTAMP DEFAULT (NOW() AT TIME ZONE 'UTC') NOT NULL,
+    -- Read position: everything up to this message is read
+    last_read_id INT,
+    last_read_at TIMESTAMP,
+    -- Read position in channel, unread count is message_count of channel minus it
+    last_read_seq BIGINT DEFAULT 0 NOT NULL,
+    PRIMARY KEY (conversation_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS conversation_participants_user_idx ON conversation_participants (user_id);
+
+-- Banned users can't join group again
+CREATE TABLE IF NOT EXISTS conversation_bans (
+    conversation_id INT NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    banned_by INT REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP DEFAULT (NOW() AT TIME ZONE 'UTC') NOT NULL,
+    PRIMARY KEY (conversation_id, user_id)
+);
+
+-- Links to join group
+CREATE TABLE IF NOT EXISTS conversation_invites (
+    code VARCHAR(32) PRIMARY KEY,
+    conversation_id INT NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+    created_by INT REFERENCES users(id) ON DELETE SET NULL,
+    -- 0 - no limit
+    max_uses INT DEFAULT 0 NOT NULL,
+    uses INT DEFAULT 0 NOT NULL,
+    expires_at TIMESTAMP,
+    revoked_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT (NOW() AT TIME ZONE 'UTC') NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS conversation_invites_conversation_idx ON conversation_invites (conversation_id);
+
 CREATE TABLE messages (
     id SERIAL,
     sender_id INT REFERENCES users(id) ON DELETE CASCADE,
+    -- NULL in groups, there members have read position instead of message status
     recipient_id INT REFERENCES users(id) ON DELETE CASCADE,
     content TEXT NOT NULL,
-    timestamp TIMESTAMP DEFAULT NOW(),
+    -- Always UTC, partitions are split by UTC days
+    timestamp TIMESTAMP DEFAULT (NOW() AT TIME ZONE 'UTC'),
+    -- sent, delivered, watched. Only moves forward
     status VARCHAR(20) DEFAULT 'sent',
-    PRIMARY KEY (id, timestamp)
-) PARTITION BY RANGE (timestamp);
\ No newline at end of file
+    delivered_at TIMESTAMP,
+    read_at TIMESTAMP,
+    -- text, system. Content of system message is JSON of event
+    type VARCHAR(20) DEFAULT 'text' NOT NULL,
+    -- Deleted message stays as tombstone without content
+    deleted_at TIMESTAMP,
+    deleted_by INT,
+    -- Purge job cleared the rest of tombstone, the row itself stays
+    purged_at TIMESTAMP,
+    -- Number of message in channel, NULL in other conversations
+    seq BIGINT,
+    -- When content was changed the last time, old versions are in message_edits
+    edited_at TIMESTAMP,
+    -- No foreign key: detached and archived partitions keep messages of deleted conversations.
+    -- Range partitions can be split into hash partitions by it
+    conversation_id INT NOT NULL,
+    PRIMARY KEY (id, timestamp, conversation_id)
+) PARTITION BY RANGE (timestamp);
+
+-- Daily partitions are created by partition manager of message service.
+-- If it is late, messages go here and are moved to the right partition later
+CREATE TABLE IF NOT EXISTS messages_default PARTITION OF messages DEFAULT;
+
+-- Conversation reads go through parent table, index is created on every partition
+CREATE INDEX IF NOT EXISTS messages_conversation_idx ON messages (conversation_id, timestamp DESC, id DESC);
+
+-- Purge job finds tombstones without scanning live messages and purged ones
+CREATE INDEX IF NOT EXISTS messages_unpurged_deleted_idx ON messages (deleted_at) WHERE deleted_at IS NOT NULL AND purged_at IS NULL;
+
+-- Previous versions of edited messages
+CREATE TABLE IF NOT EXISTS message_edits (
+    id SERIAL PRIMARY KEY,
+    -- No foreign key to partitioned messages, message is found by ID and timestamp
+    message_id INT NOT NULL,
+    message_timestamp TIMESTAMP NOT NULL,
+    conversation_id INT NOT NULL,
+    sender_id INT REFERENCES users(id) ON DELETE CASCADE,
+    -- Content before edit
+    content TEXT NOT NULL,
+    -- When this version was replaced
+    edited_at TIMESTAMP DEFAULT (NOW() AT TIME ZONE 'UTC') NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS message_edits_message_idx ON message_edits (message_id, message_timestamp);
+
+-- Delete for me: message is hidden only for one user
+CREATE TABLE IF NOT EXISTS message_hides (
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    -- No foreign key to partitioned messages, message is found by ID and timestamp
+    message_id INT NOT NULL,
+    message_timestamp TIMESTAMP NOT NULL,
+    conversation_id INT NOT NULL,
+    hidden_at TIMESTAMP DEFAULT (NOW() AT TIME ZONE 'UTC') NOT NULL,
+    PRIMARY KEY (user_id, message_id)
+);
+
+CREATE INDEX IF NOT EXISTS message_hides_message_idx ON message_hides (message_id, message_timestamp);
+
+-- Which time range of messages lives in which archive file
+CREATE TABLE IF NOT EXISTS archive_index (
+    id SERIAL PRIMARY KEY,
+    -- Detached partition, it is dropped after export
+    table_name VARCHAR(128) UNIQUE NOT NULL,
+    range_start TIMESTAMP NOT NULL,
+    range_end TIMESTAMP NOT NULL,
+    -- detached, archived
+    status VARCHAR(20) DEFAULT 'detached' NOT NULL,
+    format VARCHAR(20) DEFAULT 'jsonl.gz' NOT NULL,
+    -- file:///path or s3://bucket/key
+    location TEXT DEFAULT '' NOT NULL,
+    message_count BIGINT DEFAULT 0 NOT NULL,
+    created_at TIMESTAMP DEFAULT (NOW() AT TIME ZONE 'UTC') NOT NULL,
+    archived_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS archive_index_range_idx ON archive_index (range_start, range_end);
+
+-- Archived partition is split by conversation, so reading one conversation doesn't unpack whole partition
+CREATE TABLE IF NOT EXISTS archive_files (
+    id SERIAL PRIMARY KEY,
+    archive_id INT NOT NULL REFERENCES archive_index(id) ON DELETE CASCADE,
+    -- NULL for archives made before split, such file has messages of all conversations
+    conversation_id INT,
+    -- Key in archive store, file is rewritten under it when user is erased
+    key TEXT NOT NULL,
+    location TEXT NOT NULL,
+    message_count BIGINT DEFAULT 0 NOT NULL,
+    -- Senders and recipients of messages in file, NULL if not known yet
+    user_ids INT[]
+);
+
+CREATE INDEX IF NOT EXISTS archive_files_conversation_idx ON archive_files (archive_id, conversation_id);
+CREATE INDEX IF NOT EXISTS archive_files_users_idx ON archive_files USING GIN (user_ids);
+
+-- Edits and deletions of archived messages, they are applied when archive is read.
+-- Purge job writes deletions into archive files and removes their rows
+CREATE TABLE IF NOT EXISTS archived_message_changes (
+    -- No foreign key, message lives in archive file
+    message_id INT NOT NULL,
+    message_timestamp TIMESTAMP NOT NULL,
+    conversation_id INT NOT NULL,
+    sender_id INT,
+    recipient_id INT,
+    -- Current content, empty after deletion
+    content TEXT NOT NULL,
+    edited_at TIMESTAMP,
+    deleted_at TIMESTAMP,
+    deleted_by INT,
+    PRIMARY KEY (message_id, message_timestamp)
+);
+
+CREATE INDEX IF NOT EXISTS archived_message_changes_deleted_idx ON archived_message_changes (deleted_at) WHERE deleted_at IS NOT NULL;
+
+-- Inbox: one line per user and conversation, updated together with messages
+CREATE TABLE IF NOT EXISTS conversation_summaries (
+    -- Owner of inbox line and the other side of direct conversation. Every participant has a line
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    conversation_id INT NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+    peer_id INT REFERENCES users(id) ON DELETE CASCADE,
+    last_message_id INT NOT NULL,
+    last_sender_id INT NOT NULL,
+    last_preview TEXT NOT NULL,
+    last_message_at TIMESTAMP NOT NULL,
+    unread_count INT DEFAULT 0 NOT NULL,
+    PRIMARY KEY (user_id, conversation_id)
+);
+
+CREATE INDEX IF NOT EXISTS conversation_summaries_recent_idx ON conversation_summaries (user_id, last_message_at DESC, conversation_id DESC);
+
+-- Sync log: every user has his own numbering of events, offline clients read it from the last number they saw
+CREATE TABLE IF NOT EXISTS user_sync_state (
+    user_id INT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    last_seq BIGINT DEFAULT 0 NOT NULL,
+    -- Entries up to this number are deleted by compaction
+    compacted_seq BIGINT DEFAULT 0 NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS user_sync_log (
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    seq BIGINT NOT NULL,
+    type VARCHAR(50) NOT NULL,
+    payload JSONB NOT NULL,
+    created_at TIMESTAMP DEFAULT (NOW() AT TIME ZONE 'UTC') NOT NULL,
+    PRIMARY KEY (user_id, seq)
+);
+
+CREATE INDEX IF NOT EXISTS user_sync_log_created_idx ON user_sync_log (created_at);
+-- Content of deleted message is cleared in entries which carry it
+CREATE INDEX IF NOT EXISTS user_sync_log_message_idx ON user_sync_log ((payload #>> '{message,id}'));
@@ -0,0 +1,5 @@
+-- init.sql runs only on empty database, so existing databases need migrations from this folder.
+-- Run them in order: psql -U postgres -d messenger_db -f 001_user_roles.sql
+
+-- Guest accounts
+ALTER TABLE users ADD COLUMN IF NOT EXISTS role VARCHAR(20) DEFAULT 'user' NOT NULL;
@@ -0,0 +1,17 @@
+-- Invite-gated registration
+ALTER TABLE users ADD COLUMN IF NOT EXISTS invited_by INT REFERENCES users(id) ON DELETE SET NULL;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS invite_code VARCHAR(32);
+
+CREATE TABLE IF NOT EXISTS invites (
+    code VARCHAR(32) PRIMARY KEY,
+    created_by INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role VARCHAR(20) DEFAULT 'user' NOT NULL,
+    max_uses INT DEFAULT 1 NOT NULL,
+    uses INT DEFAULT 0 NOT NULL,
+    expires_at TIMESTAMP without time zone,
+    revoked_at TIMESTAMP without time zone,
+    created_at TIMESTAMP without time zone DEFAULT NOW() NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS invites_created_by_idx ON invites (created_by);
+CREATE INDEX IF NOT EXISTS users_invited_by_idx ON users (invited_by);
@@ -0,0 +1,6 @@
+-- Account deletion
+ALTER TABLE users ALTER COLUMN username DROP NOT NULL;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS delete_after TIMESTAMP without time zone;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP without time zone;
+
+CREATE INDEX IF NOT EXISTS users_delete_after_idx ON users (delete_after) WHERE delete_after IS NOT NULL;
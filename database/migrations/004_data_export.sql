@@ -0,0 +1,28 @@
+-- Personal data export
+CREATE TABLE IF NOT EXISTS auth_events (
+    id BIGSERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    -- register, login, guest_upgrade, ...
+    type VARCHAR(50) NOT NULL,
+    ip VARCHAR(64) DEFAULT '' NOT NULL,
+    user_agent TEXT DEFAULT '' NOT NULL,
+    created_at TIMESTAMP without time zone DEFAULT NOW() NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS auth_events_user_id_idx ON auth_events (user_id, created_at);
+
+CREATE TABLE IF NOT EXISTS export_jobs (
+    id VARCHAR(32) PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    -- pending, running, ready, failed, expired
+    status VARCHAR(20) DEFAULT 'pending' NOT NULL,
+    file_path TEXT DEFAULT '' NOT NULL,
+    download_token TEXT DEFAULT '' NOT NULL,
+    error TEXT DEFAULT '' NOT NULL,
+    created_at TIMESTAMP without time zone DEFAULT NOW() NOT NULL,
+    finished_at TIMESTAMP without time zone,
+    expires_at TIMESTAMP without time zone
+);
+
+CREATE INDEX IF NOT EXISTS export_jobs_user_id_idx ON export_jobs (user_id);
+CREATE INDEX IF NOT EXISTS export_jobs_status_idx ON export_jobs (status);
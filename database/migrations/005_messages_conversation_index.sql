@@ -0,0 +1,3 @@
+-- Conversation history across partitions
+-- Conversation reads go through parent table, index is created on every partition
+CREATE INDEX IF NOT EXISTS messages_conversation_idx ON messages (sender_id, recipient_id, timestamp DESC);
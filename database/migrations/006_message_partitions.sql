@@ -0,0 +1,4 @@
+-- Partition manager
+ALTER TABLE messages ALTER COLUMN timestamp SET DEFAULT (NOW() AT TIME ZONE 'UTC');
+
+CREATE TABLE IF NOT EXISTS messages_default PARTITION OF messages DEFAULT;
@@ -0,0 +1,13 @@
+-- Configurable partitioning. Rewrites the whole table, run it when nobody writes messages
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS conversation_key BIGINT;
+UPDATE messages
+SET conversation_key = (LEAST(sender_id, recipient_id)::BIGINT << 32) | GREATEST(sender_id, recipient_id)
+WHERE conversation_key IS NULL;
+ALTER TABLE messages ALTER COLUMN conversation_key SET NOT NULL;
+
+-- Unique keys of partitioned table must contain columns of all partition keys
+ALTER TABLE messages DROP CONSTRAINT messages_pkey;
+ALTER TABLE messages ADD PRIMARY KEY (id, timestamp, conversation_key);
+
+DROP INDEX IF EXISTS messages_conversation_idx;
+CREATE INDEX IF NOT EXISTS messages_conversation_idx ON messages (conversation_key, timestamp DESC, id DESC);
@@ -0,0 +1,18 @@
+-- Cold archive of old message partitions
+CREATE TABLE IF NOT EXISTS archive_index (
+    id SERIAL PRIMARY KEY,
+    -- Detached partition, it is dropped after export
+    table_name VARCHAR(128) UNIQUE NOT NULL,
+    range_start TIMESTAMP NOT NULL,
+    range_end TIMESTAMP NOT NULL,
+    -- detached, archived
+    status VARCHAR(20) DEFAULT 'detached' NOT NULL,
+    format VARCHAR(20) DEFAULT 'jsonl.gz' NOT NULL,
+    -- file:///path or s3://bucket/key
+    location TEXT DEFAULT '' NOT NULL,
+    message_count BIGINT DEFAULT 0 NOT NULL,
+    created_at TIMESTAMP DEFAULT (NOW() AT TIME ZONE 'UTC') NOT NULL,
+    archived_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS archive_index_range_idx ON archive_index (range_start, range_end);
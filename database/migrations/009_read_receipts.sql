@@ -0,0 +1,6 @@
+-- Delivered status and read receipts
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS delivered_at TIMESTAMP;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS read_at TIMESTAMP;
+
+-- Watched messages were surely delivered, time is unknown
+UPDATE messages SET delivered_at = timestamp, read_at = timestamp WHERE status = 'watched' AND read_at IS NULL;
@@ -0,0 +1,34 @@
+-- Conversation inbox
+CREATE TABLE IF NOT EXISTS conversation_summaries (
+    -- Owner of inbox line and the other side of conversation. Every conversation has two lines
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    peer_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    last_message_id INT NOT NULL,
+    last_sender_id INT NOT NULL,
+    last_preview TEXT NOT NULL,
+    last_message_at TIMESTAMP NOT NULL,
+    unread_count INT DEFAULT 0 NOT NULL,
+    PRIMARY KEY (user_id, peer_id)
+);
+
+CREATE INDEX IF NOT EXISTS conversation_summaries_recent_idx ON conversation_summaries (user_id, last_message_at DESC, peer_id DESC);
+
+-- Build inbox from existing messages, it scans all partitions once
+INSERT INTO conversation_summaries (user_id, peer_id, last_message_id, last_sender_id, last_preview, last_message_at, unread_count)
+SELECT u.user_id, u.peer_id, last.id, last.sender_id, LEFT(last.content, 100), last.timestamp,
+    (SELECT COUNT(*) FROM messages m
+        WHERE m.recipient_id = u.user_id AND m.sender_id = u.peer_id AND m.status <> 'watched')
+FROM (
+    SELECT DISTINCT sender_id AS user_id, recipient_id AS peer_id FROM messages
+    UNION
+    SELECT DISTINCT recipient_id, sender_id FROM messages
+) u
+CROSS JOIN LATERAL (
+    SELECT id, sender_id, content, timestamp FROM messages m
+    WHERE (m.sender_id = u.user_id AND m.recipient_id = u.peer_id)
+        OR (m.sender_id = u.peer_id AND m.recipient_id = u.user_id)
+    ORDER BY timestamp DESC, id DESC
+    LIMIT 1
+) last
+WHERE u.user_id IS NOT NULL AND u.peer_id IS NOT NULL
+ON CONFLICT (user_id, peer_id) DO NOTHING;
@@ -0,0 +1,83 @@
+-- Conversations with stable IDs. Rewrites the whole messages table, run it when nobody writes messages.
+-- Hash buckets are made by conversation_key, repartition with -hash-buckets 0 before this migration
+CREATE TABLE IF NOT EXISTS conversations (
+    id SERIAL PRIMARY KEY,
+    type VARCHAR(20) DEFAULT 'direct' NOT NULL,
+    direct_key BIGINT UNIQUE,
+    created_at TIMESTAMP DEFAULT (NOW() AT TIME ZONE 'UTC') NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS conversation_participants (
+    conversation_id INT NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    joined_at TIMESTAMP DEFAULT (NOW() AT TIME ZONE 'UTC') NOT NULL,
+    PRIMARY KEY (conversation_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS conversation_participants_user_idx ON conversation_participants (user_id);
+
+-- One direct conversation for every pair of users which already talked, created when they did.
+-- Pairs where one side was deleted get it too, the other side still sees the history
+INSERT INTO conversations (type, direct_key, created_at)
+SELECT 'direct', conversation_key, MIN(timestamp) FROM messages
+WHERE conversation_key IS NOT NULL
+GROUP BY conversation_key
+ON CONFLICT (direct_key) DO NOTHING;
+
+-- Detached partitions are not changed with parent, but archiver reads conversation_id from them
+DO $$
+DECLARE
+    detached RECORD;
+BEGIN
+    FOR detached IN SELECT table_name FROM archive_index WHERE status = 'detached' LOOP
+        EXECUTE format('INSERT INTO conversations (type, direct_key, created_at)
+            SELECT ''direct'', conversation_key, MIN(timestamp) FROM %I GROUP BY conversation_key
+            ON CONFLICT (direct_key) DO NOTHING', detached.table_name);
+        EXECUTE format('ALTER TABLE %I ADD COLUMN IF NOT EXISTS conversation_id INT', detached.table_name);
+        EXECUTE format('UPDATE %I m SET conversation_id = c.id FROM conversations c WHERE c.direct_key = m.conversation_key', detached.table_name);
+    END LOOP;
+END $$;
+
+-- Both users are packed into direct key
+INSERT INTO conversation_participants (conversation_id, user_id, joined_at)
+SELECT c.id, u.id, c.created_at
+FROM conversations c
+JOIN users u ON u.id IN ((c.direct_key >> 32)::INT, (c.direct_key & 4294967295)::INT)
+WHERE c.type = 'direct'
+ON CONFLICT DO NOTHING;
+
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS conversation_id INT;
+UPDATE messages m SET conversation_id = c.id
+FROM conversations c
+WHERE c.direct_key = m.conversation_key AND m.conversation_id IS NULL;
+
+-- Messages without key can't be put into any conversation. They are kept aside instead of being lost,
+-- so they can be checked and moved back by hand
+CREATE TABLE IF NOT EXISTS orphaned_messages (LIKE messages);
+INSERT INTO orphaned_messages SELECT * FROM messages WHERE conversation_id IS NULL;
+DELETE FROM messages WHERE conversation_id IS NULL;
+ALTER TABLE messages ALTER COLUMN conversation_id SET NOT NULL;
+
+ALTER TABLE messages DROP CONSTRAINT messages_pkey;
+ALTER TABLE messages ADD PRIMARY KEY (id, timestamp, conversation_id);
+
+DROP INDEX IF EXISTS messages_conversation_idx;
+CREATE INDEX IF NOT EXISTS messages_conversation_idx ON messages (conversation_id, timestamp DESC, id DESC);
+
+ALTER TABLE messages DROP COLUMN conversation_key;
+
+-- Inbox lines point to conversation now
+ALTER TABLE conversation_summaries ADD COLUMN IF NOT EXISTS conversation_id INT REFERENCES conversations(id) ON DELETE CASCADE;
+UPDATE conversation_summaries s SET conversation_id = c.id
+FROM conversations c
+WHERE c.direct_key = (LEAST(s.user_id, s.peer_id)::BIGINT << 32) | GREATEST(s.user_id, s.peer_id);
+
+DELETE FROM conversation_summaries WHERE conversation_id IS NULL;
+ALTER TABLE conversation_summaries ALTER COLUMN conversation_id SET NOT NULL;
+ALTER TABLE conversation_summaries ALTER COLUMN peer_id DROP NOT NULL;
+
+ALTER TABLE conversation_summaries DROP CONSTRAINT conversation_summaries_pkey;
+ALTER TABLE conversation_summaries ADD PRIMARY KEY (user_id, conversation_id);
+
+DROP INDEX IF EXISTS conversation_summaries_recent_idx;
+CREATE INDEX IF NOT EXISTS conversation_summaries_recent_idx ON conversation_summaries (user_id, last_message_at DESC, conversation_id DESC);
@@ -0,0 +1,8 @@
+-- Group conversations
+ALTER TABLE conversations ADD COLUMN IF NOT EXISTS title VARCHAR(100);
+ALTER TABLE conversations ADD COLUMN IF NOT EXISTS created_by INT REFERENCES users(id) ON DELETE SET NULL;
+ALTER TABLE conversations ADD COLUMN IF NOT EXISTS history_visible BOOLEAN DEFAULT FALSE NOT NULL;
+
+-- Read position of every member
+ALTER TABLE conversation_participants ADD COLUMN IF NOT EXISTS last_read_id INT;
+ALTER TABLE conversation_participants ADD COLUMN IF NOT EXISTS last_read_at TIMESTAMP;
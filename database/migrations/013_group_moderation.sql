@@ -0,0 +1,33 @@
+-- Roles, bans, invite links and system messages in groups
+ALTER TABLE conversations ADD COLUMN IF NOT EXISTS avatar_url TEXT;
+
+ALTER TABLE conversation_participants ADD COLUMN IF NOT EXISTS role VARCHAR(20) DEFAULT 'member' NOT NULL;
+UPDATE conversation_participants p SET role = 'owner'
+FROM conversations c
+WHERE c.id = p.conversation_id AND c.type = 'group' AND c.created_by = p.user_id;
+
+CREATE TABLE IF NOT EXISTS conversation_bans (
+    conversation_id INT NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    banned_by INT REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP DEFAULT (NOW() AT TIME ZONE 'UTC') NOT NULL,
+    PRIMARY KEY (conversation_id, user_id)
+);
+
+CREATE TABLE IF NOT EXISTS conversation_invites (
+    code VARCHAR(32) PRIMARY KEY,
+    conversation_id INT NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+    created_by INT REFERENCES users(id) ON DELETE SET NULL,
+    max_uses INT DEFAULT 0 NOT NULL,
+    uses INT DEFAULT 0 NOT NULL,
+    expires_at TIMESTAMP,
+    revoked_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT (NOW() AT TIME ZONE 'UTC') NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS conversation_invites_conversation_idx ON conversation_invites (conversation_id);
+
+-- Adding columns with constant default doesn't rewrite partitions
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS type VARCHAR(20) DEFAULT 'text' NOT NULL;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS deleted_by INT;
@@ -0,0 +1,10 @@
+-- Broadcast channels: one message row for all subscribers, read position is a number of message
+ALTER TABLE conversations ADD COLUMN IF NOT EXISTS message_count BIGINT DEFAULT 0 NOT NULL;
+ALTER TABLE conversations ADD COLUMN IF NOT EXISTS last_message_id INT;
+ALTER TABLE conversations ADD COLUMN IF NOT EXISTS last_sender_id INT;
+ALTER TABLE conversations ADD COLUMN IF NOT EXISTS last_preview TEXT;
+ALTER TABLE conversations ADD COLUMN IF NOT EXISTS last_message_at TIMESTAMP;
+
+ALTER TABLE conversation_participants ADD COLUMN IF NOT EXISTS last_read_seq BIGINT DEFAULT 0 NOT NULL;
+
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS seq BIGINT;
@@ -0,0 +1,18 @@
+-- Sync log: every user has his own numbering of events, offline clients read it from the last number they saw
+CREATE TABLE IF NOT EXISTS user_sync_state (
+    user_id INT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    last_seq BIGINT DEFAULT 0 NOT NULL,
+    -- Entries up to this number are deleted by compaction
+    compacted_seq BIGINT DEFAULT 0 NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS user_sync_log (
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    seq BIGINT NOT NULL,
+    type VARCHAR(50) NOT NULL,
+    payload JSONB NOT NULL,
+    created_at TIMESTAMP DEFAULT (NOW() AT TIME ZONE 'UTC') NOT NULL,
+    PRIMARY KEY (user_id, seq)
+);
+
+CREATE INDEX IF NOT EXISTS user_sync_log_created_idx ON user_sync_log (created_at);
@@ -0,0 +1,17 @@
+-- Message editing: current content stays in messages, previous versions go to message_edits
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS edited_at TIMESTAMP;
+
+CREATE TABLE IF NOT EXISTS message_edits (
+    id SERIAL PRIMARY KEY,
+    -- No foreign key to partitioned messages, message is found by ID and timestamp
+    message_id INT NOT NULL,
+    message_timestamp TIMESTAMP NOT NULL,
+    conversation_id INT NOT NULL,
+    sender_id INT REFERENCES users(id) ON DELETE CASCADE,
+    -- Content before edit
+    content TEXT NOT NULL,
+    -- When this version was replaced
+    edited_at TIMESTAMP DEFAULT (NOW() AT TIME ZONE 'UTC') NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS message_edits_message_idx ON message_edits (message_id, message_timestamp);
@@ -0,0 +1,15 @@
+-- Delete for me: message is hidden only for one user
+CREATE TABLE IF NOT EXISTS message_hides (
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    -- No foreign key to partitioned messages, message is found by ID and timestamp
+    message_id INT NOT NULL,
+    message_timestamp TIMESTAMP NOT NULL,
+    conversation_id INT NOT NULL,
+    hidden_at TIMESTAMP DEFAULT (NOW() AT TIME ZONE 'UTC') NOT NULL,
+    PRIMARY KEY (user_id, message_id)
+);
+
+CREATE INDEX IF NOT EXISTS message_hides_message_idx ON message_hides (message_id, message_timestamp);
+
+-- Purge job finds tombstones without scanning live messages
+CREATE INDEX IF NOT EXISTS messages_deleted_idx ON messages (deleted_at) WHERE deleted_at IS NOT NULL;
@@ -0,0 +1,2 @@
+-- Running export job of crashed worker goes back to queue when its lease is over
+ALTER TABLE export_jobs ADD COLUMN IF NOT EXISTS started_at TIMESTAMP without time zone;
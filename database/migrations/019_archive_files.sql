@@ -0,0 +1,22 @@
+-- Archived partition is split by conversation, so reading one conversation doesn't unpack whole partition
+CREATE TABLE IF NOT EXISTS archive_files (
+    id SERIAL PRIMARY KEY,
+    archive_id INT NOT NULL REFERENCES archive_index(id) ON DELETE CASCADE,
+    -- NULL for archives made before split, such file has messages of all conversations
+    conversation_id INT,
+    -- Key in archive store, file is rewritten under it when user is erased
+    key TEXT NOT NULL,
+    location TEXT NOT NULL,
+    message_count BIGINT DEFAULT 0 NOT NULL,
+    -- Senders and recipients of messages in file, NULL if not known yet
+    user_ids INT[]
+);
+
+CREATE INDEX IF NOT EXISTS archive_files_conversation_idx ON archive_files (archive_id, conversation_id);
+CREATE INDEX IF NOT EXISTS archive_files_users_idx ON archive_files USING GIN (user_ids);
+
+-- Archives made before keep their single file
+INSERT INTO archive_files (archive_id, key, location, message_count)
+SELECT a.id, regexp_replace(a.table_name, '^archived_', '') || '.jsonl.gz', a.location, a.message_count
+FROM archive_index a
+WHERE a.status = 'archived' AND NOT EXISTS (SELECT 1 FROM archive_files f WHERE f.archive_id = a.id);
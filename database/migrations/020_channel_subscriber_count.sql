@@ -0,0 +1,7 @@
+-- Subscribers of channel are counted on subscribe and unsubscribe, not on every read
+ALTER TABLE conversations ADD COLUMN IF NOT EXISTS subscriber_count BIGINT DEFAULT 0 NOT NULL;
+
+UPDATE conversations c SET subscriber_count = (
+    SELECT COUNT(*) FROM conversation_participants p WHERE p.conversation_id = c.id AND p.role = 'member'
+)
+WHERE c.type = 'channel';
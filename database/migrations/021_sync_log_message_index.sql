@@ -0,0 +1,9 @@
+-- Content of deleted message is cleared in sync log too, entries are found by ID of their message
+CREATE INDEX IF NOT EXISTS user_sync_log_message_idx ON user_sync_log ((payload #>> '{message,id}'));
+
+-- Entries written before that still have content of messages which are deleted already
+UPDATE user_sync_log l SET payload = jsonb_set(l.payload, '{message,content}', '""')
+FROM messages m
+WHERE m.deleted_at IS NOT NULL
+    AND l.payload #>> '{message,id}' = m.id::text
+    AND l.payload #>> '{message,content}' <> '';
@@ -0,0 +1,5 @@
+-- Erased user whose user.deleted event is not published yet. Event goes out after erasure is committed,
+-- so if publishing fails, deletion worker finds the user here and tries again
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deletion_event_pending BOOLEAN DEFAULT FALSE NOT NULL;
+
+CREATE INDEX IF NOT EXISTS users_deletion_event_pending_idx ON users (id) WHERE deletion_event_pending;
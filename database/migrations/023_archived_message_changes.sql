@@ -0,0 +1,18 @@
+-- Archive files are not rewritten on every edit or deletion. Changes of archived messages are kept here
+-- and applied when archive is read, purge job writes deletions into files and removes their rows
+CREATE TABLE IF NOT EXISTS archived_message_changes (
+    -- No foreign key, message lives in archive file
+    message_id INT NOT NULL,
+    message_timestamp TIMESTAMP NOT NULL,
+    conversation_id INT NOT NULL,
+    sender_id INT,
+    recipient_id INT,
+    -- Current content, empty after deletion
+    content TEXT NOT NULL,
+    edited_at TIMESTAMP,
+    deleted_at TIMESTAMP,
+    deleted_by INT,
+    PRIMARY KEY (message_id, message_timestamp)
+);
+
+CREATE INDEX IF NOT EXISTS archived_message_changes_deleted_idx ON archived_message_changes (deleted_at) WHERE deleted_at IS NOT NULL;
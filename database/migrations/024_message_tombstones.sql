@@ -0,0 +1,6 @@
+-- Purge job keeps tombstones of deleted messages, so history has no holes and sync clients keep their positions.
+-- It clears what is left of them and marks them purged, so they are not picked up again
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS purged_at TIMESTAMP;
+
+DROP INDEX IF EXISTS messages_deleted_idx;
+CREATE INDEX IF NOT EXISTS messages_unpurged_deleted_idx ON messages (deleted_at) WHERE deleted_at IS NOT NULL AND purged_at IS NULL;
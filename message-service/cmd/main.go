@@ -4,20 +4,40 @@ import (
 	"context"
 	"fmt"
 	logger "message-service/internal"
+	"message-service/internal/domain"
 	"message-service/internal/handlers"
 	"message-service/internal/middlewares"
+	archiveRepos "message-service/internal/repository/archive"
 	repositories "message-service/internal/repository/postgres"
+	redisRepos "message-service/internal/repository/redis"
 	"message-service/internal/services"
 	"os"
+	"path/filepath"
+	"strconv"
+	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/redis/go-redis/v9"
 	"go.uber.org/zap"
 )
 
+// Tokens for message service must be issued with this audience
+const serviceAudience = "message-service"
+
 var (
-	messageHandler *handlers.MessageHandler
+	messageHandler      *handlers.MessageHandler
+	conversationHandler *handlers.ConversationHandler
+	groupHandler        *handlers.GroupHandler
+	internalHandler     *handlers.InternalHandler
+	realtimeHandler     *handlers.RealtimeHandler
+	syncHandler         *handlers.SyncHandler
+	presenceHandler     *handlers.PresenceHandler
+
+	// Middleware of realtime routes redeems tickets
+	ticketService *services.TicketService
 )
 
 func main() {
@@ -35,25 +55,180 @@ func main() {
 	dbPort := os.Getenv("DB_PORT")
 	dbConnString := fmt.Sprintf("postgres://%s:%s@%s:%s/%s", dbUser, dbPassword, dbHost, dbPort, dbName)
 
-	db, err := pgx.Connect(context.Background(), dbConnString)
+	// Handlers and background workers share pool, every query and transaction takes its own connection
+	db, err := pgxpool.New(context.Background(), dbConnString)
 	if err != nil {
 		logger.Fatal("Cannot open DB connection", zap.Error(err))
 	}
-	defer db.Close(context.Background())
+	defer db.Close()
+
+	// Partition manager holds advisory lock, which belongs to connection, so it gets its own one
+	partitionDB, err := pgx.Connect(context.Background(), dbConnString)
+	if err != nil {
+		logger.Fatal("Cannot open DB connection for partition manager", zap.Error(err))
+	}
+	defer partitionDB.Close(context.Background())
+
+	// Open Redis connection. We read events of auth service from it, and instances send real-time events to each other through it
+	redisPort := os.Getenv("REDIS_PORT")
+	redisDbId := os.Getenv("REDIS_DB_ID")
+	redisConnString := fmt.Sprintf("redis://default:@redis:%s/%s", redisPort, redisDbId)
+	opt, _ := redis.ParseURL(redisConnString)
+
+	client := redis.NewClient(opt)
+
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		logger.Fatal("Cannot open redis connection", zap.Error(err))
+	}
 
 	// Initialize repositories
 	messageRepository := repositories.NewPostgresMessageRepo(db)
+	userEventRepository, err := redisRepos.NewRedisUserEventRepo(context.Background(), client)
+	if err != nil {
+		logger.Fatal("Cannot create user events consumer group", zap.Error(err))
+	}
 	logger.Info("Initialized repositories")
 
+	guestDailyLimit := 20
+	if limit, err := strconv.Atoi(os.Getenv("GUEST_DAILY_MESSAGE_LIMIT")); err == nil {
+		guestDailyLimit = limit
+	}
+
+	// What to do with messages of deleted users: erase or anonymize
+	erasurePolicy := domain.ErasurePolicy(os.Getenv("MESSAGE_ERASURE_POLICY"))
+	if erasurePolicy == "" {
+		erasurePolicy = domain.ErasurePolicyErase
+	}
+	if !erasurePolicy.IsValid() {
+		logger.Fatal("Invalid MESSAGE_ERASURE_POLICY", zap.String("policy", string(erasurePolicy)))
+	}
+
+	// Partitions of messages table
+	partitionScheme := domain.PartitionScheme{Granularity: domain.PartitionGranularity(os.Getenv("PARTITION_GRANULARITY"))}
+	if partitionScheme.Granularity == "" {
+		partitionScheme.Granularity = domain.Daily
+	}
+	if !partitionScheme.Granularity.IsValid() {
+		logger.Fatal("Invalid PARTITION_GRANULARITY", zap.String("granularity", string(partitionScheme.Granularity)))
+	}
+	if buckets, err := strconv.Atoi(os.Getenv("PARTITION_HASH_BUCKETS")); err == nil && buckets > 0 {
+		partitionScheme.HashBuckets = buckets
+	}
+
+	precreateDays := 7
+	if days, err := strconv.Atoi(os.Getenv("PARTITION_PRECREATE_DAYS")); err == nil {
+		precreateDays = days
+	}
+
+	// 0 keeps messages forever
+	retentionDays := 0
+	if days, err := strconv.Atoi(os.Getenv("MESSAGE_RETENTION_DAYS")); err == nil {
+		retentionDays = days
+	}
+
+	// 0 lets edit messages any time
+	editWindowHours := 48
+	if hours, err := strconv.Atoi(os.Getenv("MESSAGE_EDIT_WINDOW_HOURS")); err == nil {
+		editWindowHours = hours
+	}
+
+	// 0 lets delete messages for everyone any time
+	deleteWindowHours := 48
+	if hours, err := strconv.Atoi(os.Getenv("MESSAGE_DELETE_WINDOW_HOURS")); err == nil {
+		deleteWindowHours = hours
+	}
+
+	// 0 never purges tombstones of deleted messages
+	deletedRetentionDays := 30
+	if days, err := strconv.Atoi(os.Getenv("DELETED_MESSAGE_RETENTION_DAYS")); err == nil {
+		deletedRetentionDays = days
+	}
+
+	// 0 keeps sync log forever
+	syncRetentionDays := 30
+	if days, err := strconv.Atoi(os.Getenv("SYNC_LOG_RETENTION_DAYS")); err == nil {
+		syncRetentionDays = days
+	}
+
+	retentionAction := domain.RetentionAction(os.Getenv("PARTITION_RETENTION_ACTION"))
+	if retentionAction == "" {
+		retentionAction = domain.RetentionDetach
+	}
+	if !retentionAction.IsValid() {
+		logger.Fatal("Invalid PARTITION_RETENTION_ACTION", zap.String("action", string(retentionAction)))
+	}
+
+	// Where archived partitions are kept: S3-compatible bucket if it is configured, local directory otherwise
+	var archiveStore domain.ArchiveStore
+	if bucket := os.Getenv("ARCHIVE_S3_BUCKET"); bucket != "" {
+		archiveStore, err = archiveRepos.NewS3ArchiveStore(
+			os.Getenv("ARCHIVE_S3_ENDPOINT"),
+			bucket,
+			os.Getenv("ARCHIVE_S3_REGION"),
+			os.Getenv("ARCHIVE_S3_ACCESS_KEY"),
+			os.Getenv("ARCHIVE_S3_SECRET_KEY"),
+		)
+	} else {
+		archiveDir := os.Getenv("ARCHIVE_DIR")
+		if archiveDir == "" {
+			archiveDir = filepath.Join(os.TempDir(), "message-archive")
+		}
+		archiveStore, err = archiveRepos.NewLocalArchiveStore(archiveDir)
+	}
+	if err != nil {
+		logger.Fatal("Cannot open archive store", zap.Error(err))
+	}
+
 	// Initialize services
-	messageService := services.NewMessageService(messageRepository)
+	archiveService := services.NewArchiveService(repositories.NewPostgresArchiveIndexRepo(db), archiveStore)
+	conversationRepository := repositories.NewPostgresConversationRepo(db)
+	// Presence and typing live only in Redis
+	presenceRepository := redisRepos.NewRedisPresenceRepo(client)
+	realtimeService := services.NewRealtimeService(
+		conversationRepository,
+		redisRepos.NewRedisRealtimeRepo(context.Background(), client),
+		presenceRepository,
+	)
+	presenceService := services.NewPresenceService(presenceRepository, conversationRepository)
+	ticketService = services.NewTicketService(redisRepos.NewRedisTicketRepo(client))
+	syncService := services.NewSyncService(repositories.NewPostgresSyncRepo(db), time.Duration(syncRetentionDays)*24*time.Hour, time.Hour)
+	messageService := services.NewMessageService(messageRepository, conversationRepository, archiveService, realtimeService, guestDailyLimit,
+		time.Duration(editWindowHours)*time.Hour, time.Duration(deleteWindowHours)*time.Hour)
+	conversationService := services.NewConversationService(conversationRepository, messageRepository, realtimeService)
+	groupService := services.NewGroupService(conversationRepository, repositories.NewPostgresGroupRepo(db), messageRepository, realtimeService)
 	logger.Info("Initialized services")
 
+	// Start background workers. Hostname is unique for every container, so it is good consumer name
+	consumerName, _ := os.Hostname()
+	userEventService := services.NewUserEventService(
+		userEventRepository,
+		messageRepository,
+		archiveService,
+		erasurePolicy,
+		consumerName,
+	)
+	go userEventService.Run(context.Background())
+	partitionRepository := repositories.NewPostgresPartitionRepo(partitionDB)
+	archiver := services.NewArchiver(partitionRepository, repositories.NewPostgresArchiveIndexRepo(db), archiveStore)
+	partitionManager := services.NewPartitionManager(partitionRepository, partitionScheme, precreateDays, retentionDays, retentionAction, archiver, time.Hour)
+	go partitionManager.Run(context.Background())
+	go realtimeService.Run(context.Background())
+	go syncService.Run(context.Background())
+	purgeService := services.NewPurgeService(messageRepository, archiveService, time.Duration(deletedRetentionDays)*24*time.Hour, time.Hour)
+	go purgeService.Run(context.Background())
+	logger.Info("Started workers")
+
 	// Initialize middlewares
 	logger.Info("Initialized middlewares")
 
 	// Initialize handlers
 	messageHandler = handlers.NewMessageHandler(messageService)
+	conversationHandler = handlers.NewConversationHandler(conversationService)
+	groupHandler = handlers.NewGroupHandler(groupService)
+	internalHandler = handlers.NewInternalHandler(messageService)
+	realtimeHandler = handlers.NewRealtimeHandler(realtimeService, presenceService, ticketService)
+	presenceHandler = handlers.NewPresenceHandler(presenceService)
+	syncHandler = handlers.NewSyncHandler(syncService)
 	logger.Info("Initialized handlers")
 
 	service_address := fmt.Sprintf("0.0.0.0:%s", os.Getenv("SERVICE_PORT"))
@@ -70,11 +245,59 @@ func InitRouter() *gin.Engine {
 
 	// End-points with auth only
 	protected := router.Group("/")
-	protected.Use(middlewares.TokenValidationMiddleware(os.Getenv("AUTH_SERVICE_ADDR")))
+	protected.Use(middlewares.TokenValidationMiddleware(os.Getenv("AUTH_SERVICE_ADDR"), serviceAudience))
 
 	protected.POST("/sendMessage", messageHandler.SendMessage)
 	protected.GET("/getConversation", messageHandler.GetConversationMessages)
 	protected.POST("/updateMessageStatus", messageHandler.UpdateMessageStatus)
+	protected.POST("/markConversationRead", messageHandler.MarkConversationRead)
+	protected.GET("/conversations", conversationHandler.GetConversations)
+	protected.POST("/conversations", conversationHandler.CreateConversation)
+	protected.GET("/conversations/:id", conversationHandler.GetConversation)
+	protected.GET("/conversations/:id/messages", messageHandler.GetMessages)
+	protected.POST("/conversations/:id/messages", messageHandler.SendConversationMessage)
+	protected.POST("/conversations/:id/read", messageHandler.MarkRead)
+	protected.POST("/groups", conversationHandler.CreateGroup)
+	protected.POST("/conversations/:id/members", conversationHandler.AddMembers)
+	protected.DELETE("/conversations/:id/members/:user_id", conversationHandler.RemoveMember)
+	protected.POST("/conversations/:id/leave", conversationHandler.Leave)
+	protected.PATCH("/conversations/:id", groupHandler.UpdateGroup)
+	protected.PUT("/conversations/:id/members/:user_id/role", groupHandler.SetRole)
+	protected.DELETE("/conversations/:id/messages/:message_id", messageHandler.DeleteMessage)
+	protected.GET("/conversations/:id/bans", groupHandler.GetBans)
+	protected.POST("/conversations/:id/bans", groupHandler.Ban)
+	protected.DELETE("/conversations/:id/bans/:user_id", groupHandler.Unban)
+	protected.GET("/conversations/:id/invites", groupHandler.GetInvites)
+	protected.POST("/conversations/:id/invites", groupHandler.CreateInvite)
+	protected.DELETE("/conversations/:id/invites/:code", groupHandler.RevokeInvite)
+	protected.POST("/groups/join/:code", groupHandler.JoinByInvite)
+	protected.POST("/channels", conversationHandler.CreateChannel)
+	protected.POST("/channels/:id/subscribe", conversationHandler.Subscribe)
+	protected.POST("/channels/:id/unsubscribe", conversationHandler.Unsubscribe)
+	protected.GET("/sync", syncHandler.Sync)
+	protected.PATCH("/messages/:id", messageHandler.EditMessage)
+	protected.GET("/messages/:id/edits", messageHandler.GetMessageEdits)
+	protected.POST("/conversations/:id/typing", realtimeHandler.Typing)
+	protected.GET("/presence", presenceHandler.GetPresence)
+	protected.GET("/presence/settings", presenceHandler.GetSettings)
+	protected.PUT("/presence/settings", presenceHandler.UpdateSettings)
+	protected.POST("/realtime/ticket", realtimeHandler.Ticket)
+
+	// Browsers can't set Authorization header for WebSocket, they pass one-time ticket in query
+	router.GET("/ws",
+		middlewares.TicketMiddleware(ticketService, middlewares.TokenValidationMiddleware(os.Getenv("AUTH_SERVICE_ADDR"), serviceAudience)),
+		realtimeHandler.WebSocket)
+	// Fallbacks for clients behind proxies which break WebSockets. EventSource can't set headers too
+	events := router.Group("/events")
+	events.Use(middlewares.TicketMiddleware(ticketService, middlewares.TokenValidationMiddleware(os.Getenv("AUTH_SERVICE_ADDR"), serviceAudience)))
+	events.GET("", realtimeHandler.Events)
+	events.GET("/poll", realtimeHandler.PollEvents)
+
+	// API for other services
+	internal := router.Group("/internal")
+	internal.Use(middlewares.InternalAuthMiddleware(os.Getenv("INTERNAL_API_KEY")))
+
+	internal.GET("/users/:id/messages", internalHandler.ExportUserMessages)
 
 	return router
 }
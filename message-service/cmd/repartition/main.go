@@ -0,0 +1,59 @@
+// Repartition moves existing messages to partitions of new scheme (granularity and hash buckets),
+// while message service keeps working. Set PARTITION_GRANULARITY and PARTITION_HASH_BUCKETS of
+// message service to the same values afterwards, otherwise partition manager creates partitions of old scheme.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	logger "message-service/internal"
+	"message-service/internal/domain"
+	repositories "message-service/internal/repository/postgres"
+	"message-service/internal/services"
+	"os"
+	"strconv"
+
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+)
+
+func main() {
+	logger.InitLogger()
+	defer logger.Sync()
+
+	// Flags win, env of message service is default
+	defaultBuckets, _ := strconv.Atoi(os.Getenv("PARTITION_HASH_BUCKETS"))
+	defaultGranularity := os.Getenv("PARTITION_GRANULARITY")
+	if defaultGranularity == "" {
+		defaultGranularity = string(domain.Daily)
+	}
+
+	granularity := flag.String("granularity", defaultGranularity, "daily, weekly or monthly")
+	buckets := flag.Int("hash-buckets", defaultBuckets, "hash sub-partitions by conversation, 0 to disable")
+	dryRun := flag.Bool("dry-run", false, "only print what would be done")
+	flag.Parse()
+
+	scheme := domain.PartitionScheme{Granularity: domain.PartitionGranularity(*granularity), HashBuckets: *buckets}
+	if !scheme.Granularity.IsValid() || scheme.HashBuckets < 0 {
+		logger.Fatal("Invalid partition scheme",
+			zap.String("granularity", *granularity),
+			zap.Int("hash buckets", *buckets))
+	}
+
+	dbConnString := fmt.Sprintf("postgres://%s:%s@%s:%s/%s",
+		os.Getenv("DB_USER"), os.Getenv("DB_PASSWORD"), os.Getenv("DB_HOST"), os.Getenv("DB_PORT"), os.Getenv("DB_NAME"))
+
+	db, err := pgx.Connect(context.Background(), dbConnString)
+	if err != nil {
+		logger.Fatal("Cannot open DB connection", zap.Error(err))
+	}
+	defer db.Close(context.Background())
+
+	repartitioner := services.NewRepartitioner(repositories.NewPostgresPartitionRepo(db), scheme, *dryRun)
+	if apiErr := repartitioner.Run(context.Background()); apiErr != nil {
+		logger.Fatal("Re-partitioning failed", zap.String("error", apiErr.Message), zap.String("details", apiErr.Details))
+	}
+
+	logger.Info("Re-partitioning finished")
+}
@@ -0,0 +1,107 @@
+package domain
+
+import (
+	"context"
+	"io"
+	"message-service/internal/utils"
+	"time"
+)
+
+type ArchiveStatus string
+
+const (
+	// Partition is detached from messages table, but still lives in database
+	ArchiveDetached ArchiveStatus = "detached"
+	// Partition is exported to archive store and dropped
+	ArchiveArchived ArchiveStatus = "archived"
+)
+
+// Archives are gzipped JSON lines, one message per line
+const ArchiveFormatJSONLGzip = "jsonl.gz"
+
+type (
+	ArchiveIndexRepository interface {
+		// Detached partitions which are not exported yet, oldest first
+		GetPendingArchives(ctx context.Context, limit int) ([]ArchiveEntry, *utils.APIError)
+		// Saves files which partition was split into
+		MarkArchived(ctx context.Context, id int, files []ArchiveFile) *utils.APIError
+		// Archived ranges which have common time with [start, end), ordered by start. Zero time means no bound
+		GetArchivesInRange(ctx context.Context, start, end time.Time) ([]ArchiveEntry, *utils.APIError)
+		// Files of archive which can have messages of conversation, 0 means all files
+		GetArchiveFiles(ctx context.Context, archiveID int, conversationID int) ([]ArchiveFile, *utils.APIError)
+		// Files which can have messages sent by user or to user, or messages of given conversations. Oldest first
+		GetUserArchiveFiles(ctx context.Context, userID int, conversationIDs []int) ([]ArchiveFile, *utils.APIError)
+		// Saves file which was rewritten under the same key
+		UpdateArchiveFile(ctx context.Context, file *ArchiveFile) *utils.APIError
+		// Edits and deletions of archived messages by message ID
+		GetArchivedMessageChanges(ctx context.Context, messageIDs []int) (map[int]ArchivedMessageChange, *utils.APIError)
+		// Archived messages deleted before before, oldest first
+		GetDeletedArchivedMessages(ctx context.Context, before time.Time, limit int) ([]ArchivedMessageChange, *utils.APIError)
+		// Forgets changes which were written into archive files, and hides of these messages
+		DeleteArchivedMessageChanges(ctx context.Context, changes []ArchivedMessageChange) *utils.APIError
+	}
+
+	// ArchiveStore keeps archive files: local directory or S3-compatible bucket
+	ArchiveStore interface {
+		// Put saves file under key and returns location for Get
+		Put(ctx context.Context, key string, file io.ReadSeeker, size int64) (string, *utils.APIError)
+		Get(ctx context.Context, location string) (io.ReadCloser, *utils.APIError)
+	}
+
+	// ArchiveEntry says which time range lives in which file
+	ArchiveEntry struct {
+		ID int
+		// Name of detached partition table
+		TableName  string
+		RangeStart time.Time
+		RangeEnd   time.Time
+		Status     ArchiveStatus
+		Format     string
+		// Set only for archives made before they were split by conversation, files have own locations now
+		Location   string
+		Messages   int64
+		CreatedAt  time.Time
+		ArchivedAt *time.Time
+	}
+
+	// ArchiveFile keeps messages of one conversation from archived partition
+	ArchiveFile struct {
+		ID        int
+		ArchiveID int
+		// Nil for archives made before split, such file has messages of all conversations
+		ConversationID *int
+		// Key in store, file is rewritten under it when user is erased
+		Key      string
+		Location string
+		Messages int64
+		// Senders and recipients of messages in file, nil if not known
+		UserIDs []int
+	}
+
+	// ArchivedMessageChange is current state of archived message which was edited or deleted.
+	// Archive files are not rewritten for every change, it is applied when archive is read
+	ArchivedMessageChange struct {
+		MessageID      int
+		Timestamp      time.Time
+		ConversationID int
+		Content        string
+		EditedAt       *time.Time
+		DeletedAt      *time.Time
+		DeletedBy      *int
+	}
+)
+
+// Apply puts change into message read from archive
+func (c *ArchivedMessageChange) Apply(msg *Message) {
+	if msg.MessageID != c.MessageID || !msg.Timestamp.Equal(c.Timestamp) {
+		return
+	}
+	msg.Content = c.Content
+	if c.EditedAt != nil {
+		msg.EditedAt = c.EditedAt
+	}
+	if c.DeletedAt != nil {
+		msg.DeletedAt = c.DeletedAt
+		msg.DeletedBy = c.DeletedBy
+	}
+}
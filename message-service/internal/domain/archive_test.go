@@ -0,0 +1,76 @@
+package domain
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestArchivedMessageChangeApply(t *testing.T) {
+	sent := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	edited := sent.Add(time.Hour)
+	deleted := sent.Add(2 * time.Hour)
+	moderator := 3
+
+	archived := func() Message {
+		return Message{MessageID: 1, ConversationID: 5, SenderID: 2, Content: "original", Timestamp: sent}
+	}
+
+	tests := []struct {
+		name   string
+		change ArchivedMessageChange
+		want   func(msg *Message)
+	}{
+		{
+			name:   "edit",
+			change: ArchivedMessageChange{MessageID: 1, Timestamp: sent, Content: "edited", EditedAt: &edited},
+			want: func(msg *Message) {
+				msg.Content = "edited"
+				msg.EditedAt = &edited
+			},
+		},
+		{
+			name:   "deletion",
+			change: ArchivedMessageChange{MessageID: 1, Timestamp: sent, DeletedAt: &deleted, DeletedBy: &moderator},
+			want: func(msg *Message) {
+				msg.Content = ""
+				msg.DeletedAt = &deleted
+				msg.DeletedBy = &moderator
+			},
+		},
+		{
+			name:   "deletion after edit",
+			change: ArchivedMessageChange{MessageID: 1, Timestamp: sent, EditedAt: &edited, DeletedAt: &deleted, DeletedBy: &moderator},
+			want: func(msg *Message) {
+				msg.Content = ""
+				msg.EditedAt = &edited
+				msg.DeletedAt = &deleted
+				msg.DeletedBy = &moderator
+			},
+		},
+		{
+			name:   "other message",
+			change: ArchivedMessageChange{MessageID: 2, Timestamp: sent, Content: "edited", EditedAt: &edited},
+			want:   func(msg *Message) {},
+		},
+		{
+			// Message is found by ID and timestamp, like in messages table
+			name:   "same ID, other time",
+			change: ArchivedMessageChange{MessageID: 1, Timestamp: sent.Add(time.Second), Content: "edited", EditedAt: &edited},
+			want:   func(msg *Message) {},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := archived()
+			tt.change.Apply(&got)
+
+			want := archived()
+			tt.want(&want)
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("got %+v, want %+v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package domain
+
+import (
+	"context"
+	"message-service/internal/utils"
+	"time"
+)
+
+type ConversationType string
+
+const (
+	// Conversation of two users
+	DirectConversation ConversationType = "direct"
+	// Conversation with title and any number of members
+	GroupConversation ConversationType = "group"
+	// Only publishers (owner and admins) post, any number of subscribers read
+	ChannelConversation ConversationType = "channel"
+)
+
+type (
+	ConversationRepository interface {
+		// Returns direct conversation of two users, it is created if they didn't talk before
+		GetOrCreateDirectConversation(ctx context.Context, user1ID, user2ID int) (*Conversation, *utils.APIError)
+		// Returns nil if users didn't talk before
+		GetDirectConversation(ctx context.Context, user1ID, user2ID int) (*Conversation, *utils.APIError)
+		// Returns nil if there is no such conversation. Channels can have thousands of subscribers,
+		// so only publishers and user himself are in participants, others are counted
+		GetConversation(ctx context.Context, conversationID, userID int) (*Conversation, *utils.APIError)
+		// Conversations of user, most recent first. Cursor is optional
+		GetConversations(ctx context.Context, userID int, cursor *ConversationCursor, limit int) ([]ConversationSummary, *utils.APIError)
+		// Creates group or channel, creator has to be in memberIDs. He becomes owner
+		CreateConversation(ctx context.Context, conversation *Conversation, memberIDs []int) (*Conversation, *utils.APIError)
+		// Users who are already members or banned are skipped. Returns how many were added
+		AddParticipants(ctx context.Context, conversationID int, userIDs []int) (int64, *utils.APIError)
+		// Removes user with his inbox line. Group left without members is deleted with its messages.
+		// Returns false if he wasn't a member
+		RemoveParticipant(ctx context.Context, conversationID, userID int) (bool, *utils.APIError)
+		// IDs of all members, also of all channel subscribers
+		GetParticipantIDs(ctx context.Context, conversationID int) ([]int, *utils.APIError)
+		// Any member, also plain subscriber of channel. Returns nil if user is not a member
+		GetParticipant(ctx context.Context, conversationID, userID int) (*Participant, *utils.APIError)
+		// Which of users have direct conversation or group with user. Channels don't count
+		GetConversationPeers(ctx context.Context, userID int, userIDs []int) (map[int]bool, *utils.APIError)
+		// Groups of user with time from which he can read their messages, zero time means all history
+		GetUserGroups(ctx context.Context, userID int) (map[int]time.Time, *utils.APIError)
+	}
+
+	Conversation struct {
+		ID   int              `json:"id"`
+		Type ConversationType `json:"type"`
+		// Only for groups
+		Title     string `json:"title,omitempty"`
+		AvatarURL string `json:"avatar_url,omitempty"`
+		CreatedBy int    `json:"created_by,omitempty"`
+		// New members of group see messages sent before they joined
+		HistoryVisible bool `json:"history_visible"`
+		// Only for channels
+		SubscriberCount int `json:"subscriber_count,omitempty"`
+		// ConversationKey of two users, only for direct conversation
+		DirectKey    *int64        `json:"-"`
+		Participants []Participant `json:"participants"`
+		CreatedAt    time.Time     `json:"created_at"`
+	}
+
+	Participant struct {
+		UserID int `json:"user_id"`
+		// Only for groups
+		Role     ConversationRole `json:"role,omitempty"`
+		JoinedAt time.Time        `json:"joined_at"`
+		// The last message which user read, nil if nothing
+		LastReadID *int       `json:"last_read_id,omitempty"`
+		LastReadAt *time.Time `json:"last_read_at,omitempty"`
+	}
+
+	// ConversationSummary is a line in inbox of user. Table is updated together with messages,
+	// so inbox doesn't have to look into partitions
+	ConversationSummary struct {
+		ConversationID int              `json:"conversation_id"`
+		Type           ConversationType `json:"type"`
+		Title          string           `json:"title,omitempty"`
+		// The other user of direct conversation
+		PeerID      int         `json:"peer_id,omitempty"`
+		LastMessage LastMessage `json:"last_message"`
+		UnreadCount int         `json:"unread_count"`
+	}
+
+	LastMessage struct {
+		MessageID int       `json:"id"`
+		SenderID  int       `json:"sender_id"`
+		Preview   string    `json:"preview"`
+		Timestamp time.Time `json:"timestamp"`
+	}
+
+	// Position in inbox for keyset pagination
+	ConversationCursor struct {
+		LastMessageAt  time.Time
+		ConversationID int
+	}
+)
+
+// Participant returns membership of user, nil if he is not in conversation
+func (c *Conversation) Participant(userID int) *Participant {
+	for i := range c.Participants {
+		if c.Participants[i].UserID == userID {
+			return &c.Participants[i]
+		}
+	}
+	return nil
+}
+
+// Role returns role of user in group, empty if he is not a member
+func (c *Conversation) Role(userID int) ConversationRole {
+	if participant := c.Participant(userID); participant != nil {
+		return participant.Role
+	}
+	return ""
+}
+
+// HasParticipant says if user is in conversation
+func (c *Conversation) HasParticipant(userID int) bool {
+	return c.Participant(userID) != nil
+}
+
+// Peer returns the other user of direct conversation, 0 for groups
+func (c *Conversation) Peer(userID int) int {
+	if c.Type != DirectConversation {
+		return 0
+	}
+	for _, participant := range c.Participants {
+		if participant.UserID != userID {
+			return participant.UserID
+		}
+	}
+	return 0
+}
+
+// VisibleSince returns time from which user can read messages, zero time means all history
+func (c *Conversation) VisibleSince(userID int) time.Time {
+	participant := c.Participant(userID)
+	if c.Type != GroupConversation || c.HistoryVisible || participant == nil {
+		return time.Time{}
+	}
+	return participant.JoinedAt
+}
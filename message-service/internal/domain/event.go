@@ -0,0 +1,48 @@
+package domain
+
+import (
+	"context"
+	"message-service/internal/utils"
+	"time"
+)
+
+type EventType string
+
+const (
+	// Published by auth service when account is erased
+	UserDeletedEvent EventType = "user.deleted"
+)
+
+// ErasurePolicy says what happens with messages of deleted user
+type ErasurePolicy string
+
+const (
+	// Delete all messages which user sent or received
+	ErasurePolicyErase ErasurePolicy = "erase"
+	// Keep messages. Auth service erases personal data of user, so author becomes anonymous
+	ErasurePolicyAnonymize ErasurePolicy = "anonymize"
+)
+
+func (p ErasurePolicy) IsValid() bool {
+	switch p {
+	case ErasurePolicyErase, ErasurePolicyAnonymize:
+		return true
+	default:
+		return false
+	}
+}
+
+type (
+	UserEventRepository interface {
+		// Pending returns events which were read by this consumer before, but not acknowledged (e.g. we crashed)
+		ReadUserEvents(ctx context.Context, consumer string, pending bool) ([]UserEvent, *utils.APIError)
+		AckUserEvent(ctx context.Context, eventID string) *utils.APIError
+	}
+
+	UserEvent struct {
+		ID         string    `json:"id"`
+		Type       EventType `json:"type"`
+		UserID     int       `json:"user_id"`
+		OccurredAt time.Time `json:"occurred_at"`
+	}
+)
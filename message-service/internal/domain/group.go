@@ -0,0 +1,119 @@
+package domain
+
+import (
+	"context"
+	"message-service/internal/utils"
+	"time"
+)
+
+// ConversationRole is role of member in group
+type ConversationRole string
+
+const (
+	// Creator of group, there is only one
+	ConversationOwner ConversationRole = "owner"
+	// Can manage members, messages and invites
+	ConversationAdmin  ConversationRole = "admin"
+	ConversationMember ConversationRole = "member"
+)
+
+func (r ConversationRole) IsValid() bool {
+	switch r {
+	case ConversationOwner, ConversationAdmin, ConversationMember:
+		return true
+	default:
+		return false
+	}
+}
+
+func (r ConversationRole) rank() int {
+	switch r {
+	case ConversationOwner:
+		return 2
+	case ConversationAdmin:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// CanModerate says if member with role r can manage group
+func (r ConversationRole) CanModerate() bool {
+	return r.rank() > 0
+}
+
+// Outranks says if member with role r can remove, ban or change role of member with role other
+func (r ConversationRole) Outranks(other ConversationRole) bool {
+	return r.rank() > other.rank()
+}
+
+// Actions of system messages
+const (
+	ActionTitleChanged     = "title_changed"
+	ActionAvatarChanged    = "avatar_changed"
+	ActionMembersAdded     = "members_added"
+	ActionMemberRemoved    = "member_removed"
+	ActionMemberLeft       = "member_left"
+	ActionMemberJoined     = "member_joined"
+	ActionMemberBanned     = "member_banned"
+	ActionMemberUnbanned   = "member_unbanned"
+	ActionRoleChanged      = "role_changed"
+	ActionOwnershipChanged = "ownership_transferred"
+	ActionMessageDeleted   = "message_deleted"
+	ActionInviteCreated    = "invite_created"
+	ActionInviteRevoked    = "invite_revoked"
+)
+
+type (
+	GroupRepository interface {
+		// Changes title and avatar of group
+		UpdateGroup(ctx context.Context, group *Conversation) *utils.APIError
+		// Returns false if user is not a member
+		SetParticipantRole(ctx context.Context, conversationID, userID int, role ConversationRole) (bool, *utils.APIError)
+		// New owner gets owner role, old one becomes admin
+		TransferOwnership(ctx context.Context, conversationID, fromID, toID int) *utils.APIError
+		// Removes user from group and doesn't let him back
+		Ban(ctx context.Context, ban *Ban) *utils.APIError
+		// Returns false if user wasn't banned
+		Unban(ctx context.Context, conversationID, userID int) (bool, *utils.APIError)
+		GetBans(ctx context.Context, conversationID int) ([]Ban, *utils.APIError)
+		IsBanned(ctx context.Context, conversationID, userID int) (bool, *utils.APIError)
+		CreateInvite(ctx context.Context, invite *GroupInvite) *utils.APIError
+		GetInvites(ctx context.Context, conversationID int) ([]GroupInvite, *utils.APIError)
+		// Returns false if there is no such active invite in group
+		RevokeInvite(ctx context.Context, conversationID int, code string) (bool, *utils.APIError)
+		// Takes one use of invite and adds user to group. Returns group ID and false if user was a member already
+		JoinByInvite(ctx context.Context, code string, userID int) (int, bool, *utils.APIError)
+	}
+
+	Ban struct {
+		ConversationID int       `json:"conversation_id"`
+		UserID         int       `json:"user_id"`
+		BannedBy       int       `json:"banned_by"`
+		CreatedAt      time.Time `json:"created_at"`
+	}
+
+	// GroupInvite is a link to join group
+	GroupInvite struct {
+		Code           string `json:"code"`
+		ConversationID int    `json:"conversation_id"`
+		CreatedBy      int    `json:"created_by"`
+		// 0 - no limit
+		MaxUses   int        `json:"max_uses"`
+		Uses      int        `json:"uses"`
+		ExpiresAt *time.Time `json:"expires_at"`
+		RevokedAt *time.Time `json:"revoked_at,omitempty"`
+		CreatedAt time.Time  `json:"created_at"`
+	}
+
+	// SystemEvent is content of system message, clients render it themselves
+	SystemEvent struct {
+		Action string `json:"action"`
+		// Who was added, removed or changed
+		UserIDs   []int            `json:"user_ids,omitempty"`
+		Title     string           `json:"title,omitempty"`
+		AvatarURL string           `json:"avatar_url,omitempty"`
+		Role      ConversationRole `json:"role,omitempty"`
+		MessageID int              `json:"message_id,omitempty"`
+	}
+)
@@ -8,33 +8,154 @@ import (
 
 type MessageStatus string
 
+type MessageType string
+
 const (
-	Sent    MessageStatus = "sent"
+	TextMessage MessageType = "text"
+	// Made by server when group is changed, content is JSON of SystemEvent
+	SystemMessage MessageType = "system"
+)
+
+const (
+	Sent MessageStatus = "sent"
+	// Recipient's client got message
+	Delivered MessageStatus = "delivered"
+	// Recipient read message
 	Watched MessageStatus = "watched"
 )
 
 func (s MessageStatus) IsValid() bool {
 	switch s {
-	case Sent, Watched:
+	case Sent, Delivered, Watched:
 		return true
 	default:
 		return false
 	}
 }
 
+// Status only moves forward: sent -> delivered -> watched
+func (s MessageStatus) rank() int {
+	switch s {
+	case Sent:
+		return 0
+	case Delivered:
+		return 1
+	case Watched:
+		return 2
+	default:
+		return -1
+	}
+}
+
+// CanMoveTo says if message with status s can get status next
+func (s MessageStatus) CanMoveTo(next MessageStatus) bool {
+	return next.IsValid() && next.rank() > s.rank()
+}
+
+// ConversationKey is the same for both directions of conversation between two users.
+// It makes sure that a pair of users has only one direct conversation
+func ConversationKey(user1ID, user2ID int) int64 {
+	if user1ID > user2ID {
+		user1ID, user2ID = user2ID, user1ID
+	}
+	return int64(user1ID)<<32 | int64(user2ID)
+}
+
 type (
+	// Methods which change messages take SyncRecord of their event, it is saved in the same transaction.
+	// Nil record means event doesn't go to sync log
 	MessageRepository interface {
-		SendMessage(ctx context.Context, message *Message) (*Message, *utils.APIError)
-		GetMessagesBetweenUsers(ctx context.Context, user1ID, user2ID int) (*[]Message, *utils.APIError)
-		UpdateMessageStatus(ctx context.Context, messageID int, status string) *utils.APIError
-		GetMessageByID(ctx context.Context, messageID int) (*Message, *utils.APIError)
+		// Fails with 429 if sender has sent dailyLimit messages since beginning of current day, 0 means no limit
+		SendMessage(ctx context.Context, message *Message, dailyLimit int, record *SyncRecord) (*Message, *utils.APIError)
+		// Posts to channel. Subscribers have no inbox lines, channel itself remembers the last message
+		SendChannelMessage(ctx context.Context, message *Message, dailyLimit int) (*Message, *utils.APIError)
+		GetConversationMessages(ctx context.Context, conversationID int, filter ConversationFilter) (*[]Message, *utils.APIError)
+		// Changes status only if it is still from, so two requests can't move it backward. Returns false if nothing changed
+		UpdateMessageStatus(ctx context.Context, messageID int, timestamp time.Time, from, to MessageStatus, record *SyncRecord) (bool, *utils.APIError)
+		// Marks sent messages of recipient as delivered. Returns delivery time of messages which were changed,
+		// only their records (by message ID) are saved
+		MarkDelivered(ctx context.Context, recipientID int, messages []Message, records map[int]*SyncRecord) (map[int]time.Time, *utils.APIError)
+		// Marks all messages which reader got in conversation up to message (included) as read and moves his read position. Returns how many
+		MarkConversationRead(ctx context.Context, readerID, conversationID int, upTo *Message, record *SyncRecord) (int64, *utils.APIError)
+		// Timestamp is optional, with it Postgres looks only in one partition. Returns nil if there is no such message
+		GetMessageByID(ctx context.Context, messageID int, timestamp time.Time) (*Message, *utils.APIError)
+		// Saves current content to edit history and replaces it. Returns nil if there is no such message or it is deleted.
+		// Event of record gets the edited message
+		EditMessage(ctx context.Context, messageID int, timestamp time.Time, content string, record *SyncRecord) (*Message, *utils.APIError)
+		// Previous versions of message, oldest first
+		GetMessageEdits(ctx context.Context, messageID int, timestamp time.Time) ([]MessageEdit, *utils.APIError)
+		// Clears content of message and its edit history and marks it deleted. Returns false if there is no such message or it is deleted already
+		DeleteMessage(ctx context.Context, messageID int, timestamp time.Time, deletedBy int, record *SyncRecord) (bool, *utils.APIError)
+		// Like EditMessage and DeleteMessage, but for message read from archive. Change is saved next to archive,
+		// see ArchivedMessageChange
+		EditArchivedMessage(ctx context.Context, message *Message, content string, record *SyncRecord) (*Message, *utils.APIError)
+		DeleteArchivedMessage(ctx context.Context, message *Message, deletedBy int, record *SyncRecord) (bool, *utils.APIError)
+		// Hides message from user only, others still see it
+		HideMessage(ctx context.Context, userID int, message *Message, record *SyncRecord) *utils.APIError
+		// Which of messages user has hidden
+		GetHiddenMessageIDs(ctx context.Context, userID int, messageIDs []int) (map[int]bool, *utils.APIError)
+		// Clears tombstones of messages deleted before before down to ID, time and deletion time. Returns how many
+		PurgeDeletedMessages(ctx context.Context, before time.Time) (int64, *utils.APIError)
+		// Deletes all messages which user sent or received, returns how many
+		DeleteUserMessages(ctx context.Context, userID int) (int64, *utils.APIError)
+		// Calls fn for every message user sent or received, oldest first. Messages of user come with edit history.
+		// Stops if fn returns error
+		ForEachUserMessage(ctx context.Context, userID int, fn func(*Message) error) *utils.APIError
+	}
+	// Which part of conversation to read. Zero time means there is no bound
+	ConversationFilter struct {
+		After  time.Time
+		Before time.Time
+		Limit  int
+		// Read only messages after (or before) this one
+		Cursor *MessageCursor
+	}
+	// Position in conversation for keyset pagination. Timestamp and ID together are unique,
+	// so new messages don't shift pages like OFFSET does
+	MessageCursor struct {
+		Timestamp time.Time
+		ID        int
+		// Newer messages than cursor, otherwise older
+		Newer bool
+	}
+	// One page of conversation, newest messages first
+	ConversationPage struct {
+		Messages []Message `json:"messages"`
+		// Older messages
+		NextCursor string `json:"next_cursor,omitempty"`
+		// Newer messages
+		PrevCursor string `json:"prev_cursor,omitempty"`
 	}
 	Message struct {
-		MessageID   int       `json:"id"`
-		RecipientID int       `json:"recipient_id"`
+		MessageID      int `json:"id"`
+		ConversationID int `json:"conversation_id"`
+		// Only for direct conversation
+		RecipientID int       `json:"recipient_id,omitempty"`
 		SenderID    int       `json:"sender_id"`
 		Content     string    `json:"content"`
 		Timestamp   time.Time `json:"timestamp"`
 		Status      string    `json:"status"`
+		// When recipient got message and when read it
+		DeliveredAt *time.Time  `json:"delivered_at,omitempty"`
+		ReadAt      *time.Time  `json:"read_at,omitempty"`
+		Type        MessageType `json:"type"`
+		// Message stays in conversation as tombstone without content
+		DeletedAt *time.Time `json:"deleted_at,omitempty"`
+		DeletedBy *int       `json:"deleted_by,omitempty"`
+		// Number of message in channel, unread messages of subscriber are counted by it
+		Seq *int64 `json:"seq,omitempty"`
+		// When content was changed the last time
+		EditedAt *time.Time `json:"edited_at,omitempty"`
+		// Reader deleted it for himself, he gets placeholder without content
+		Hidden bool `json:"hidden,omitempty"`
+		// Previous versions, only personal data export of sender has them
+		Edits []MessageEdit `json:"edits,omitempty"`
+	}
+	// Previous version of message
+	MessageEdit struct {
+		ID      int    `json:"id"`
+		Content string `json:"content"`
+		// When this version was replaced
+		EditedAt time.Time `json:"edited_at"`
 	}
 )
@@ -0,0 +1,32 @@
+package domain
+
+import "testing"
+
+func TestMessageStatusCanMoveTo(t *testing.T) {
+	tests := []struct {
+		from MessageStatus
+		to   MessageStatus
+		want bool
+	}{
+		{from: Sent, to: Sent, want: false},
+		{from: Sent, to: Delivered, want: true},
+		{from: Sent, to: Watched, want: true},
+		{from: Delivered, to: Sent, want: false},
+		{from: Delivered, to: Delivered, want: false},
+		{from: Delivered, to: Watched, want: true},
+		{from: Watched, to: Sent, want: false},
+		{from: Watched, to: Delivered, want: false},
+		{from: Watched, to: Watched, want: false},
+		{from: Sent, to: "read", want: false},
+		{from: Sent, to: "", want: false},
+		{from: "unknown", to: "unknown", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.from)+"->"+string(tt.to), func(t *testing.T) {
+			if got := tt.from.CanMoveTo(tt.to); got != tt.want {
+				t.Fatalf("CanMoveTo = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+	"message-service/internal/utils"
+	"time"
+)
+
+// RetentionAction says what happens with partitions older than retention
+type RetentionAction string
+
+const (
+	// Detach partition from messages table, but keep it in database (for backup or manual archive)
+	RetentionDetach RetentionAction = "detach"
+	// Drop partition with all its messages
+	RetentionDrop RetentionAction = "drop"
+	// Detach partition, export it to archive store and drop it. Messages are still readable from archive
+	RetentionArchive RetentionAction = "archive"
+)
+
+func (a RetentionAction) IsValid() bool {
+	switch a {
+	case RetentionDetach, RetentionDrop, RetentionArchive:
+		return true
+	default:
+		return false
+	}
+}
+
+// PartitionGranularity is how much time one range partition of messages table covers
+type PartitionGranularity string
+
+const (
+	Daily   PartitionGranularity = "daily"
+	Weekly  PartitionGranularity = "weekly"
+	Monthly PartitionGranularity = "monthly"
+)
+
+func (g PartitionGranularity) IsValid() bool {
+	switch g {
+	case Daily, Weekly, Monthly:
+		return true
+	default:
+		return false
+	}
+}
+
+// PeriodStart returns beginning of period which contains t. Everything is in UTC
+func (g PartitionGranularity) PeriodStart(t time.Time) time.Time {
+	day := time.Date(t.UTC().Year(), t.UTC().Month(), t.UTC().Day(), 0, 0, 0, 0, time.UTC)
+
+	switch g {
+	case Weekly:
+		// Weeks start on Monday, like ISO weeks
+		offset := (int(day.Weekday()) + 6) % 7
+		return day.AddDate(0, 0, -offset)
+	case Monthly:
+		return time.Date(day.Year(), day.Month(), 1, 0, 0, 0, 0, time.UTC)
+	default:
+		return day
+	}
+}
+
+// NextPeriod returns beginning of period after the one which starts at start
+func (g PartitionGranularity) NextPeriod(start time.Time) time.Time {
+	switch g {
+	case Weekly:
+		return start.AddDate(0, 0, 7)
+	case Monthly:
+		return start.AddDate(0, 1, 0)
+	default:
+		return start.AddDate(0, 0, 1)
+	}
+}
+
+// PartitionName returns name of partition for period which starts at start.
+// messages_2024_01_02 for days, messages_2024_w01 for weeks and messages_2024_01 for months
+func (g PartitionGranularity) PartitionName(start time.Time) string {
+	switch g {
+	case Weekly:
+		year, week := start.ISOWeek()
+		return fmt.Sprintf("messages_%04d_w%02d", year, week)
+	case Monthly:
+		return "messages_" + start.Format("2006_01")
+	default:
+		return "messages_" + start.Format("2006_01_02")
+	}
+}
+
+// PartitionScheme is how messages table must be partitioned
+type PartitionScheme struct {
+	Granularity PartitionGranularity
+	// Range partitions are split into this many hash partitions by conversation, 0 means no split
+	HashBuckets int
+}
+
+// Partition returns partition for period which starts at start
+func (s PartitionScheme) Partition(start time.Time) Partition {
+	return Partition{
+		Name:        s.Granularity.PartitionName(start),
+		Start:       start,
+		End:         s.Granularity.NextPeriod(start),
+		HashBuckets: s.HashBuckets,
+	}
+}
+
+type (
+	PartitionRepository interface {
+		// Advisory lock, so only one instance manages partitions at a time. Returns false if lock is taken
+		TryLock(ctx context.Context) (bool, *utils.APIError)
+		Unlock(ctx context.Context) *utils.APIError
+		// Range partitions of messages table ordered by start, default partition is not included
+		ListPartitions(ctx context.Context) ([]Partition, *utils.APIError)
+		// Creates partition. Messages of its range which got into default partition are moved to it
+		CreatePartition(ctx context.Context, partition *Partition) *utils.APIError
+		CreateDefaultPartition(ctx context.Context) *utils.APIError
+		// Detaches partition and adds it to archive index, so it can be archived later
+		DetachPartition(ctx context.Context, partition *Partition) *utils.APIError
+		DropPartition(ctx context.Context, partition *Partition) *utils.APIError
+		// Calls fn for every message of detached partition grouped by conversation, stops if fn returns error
+		ForEachDetachedMessage(ctx context.Context, table string, fn func(*Message) error) *utils.APIError
+		DropDetachedPartition(ctx context.Context, table string) *utils.APIError
+
+		// Online re-partitioning. New partition is built as separate staging table while old ones still work,
+		// then they are swapped in one short transaction
+		CreateStagingPartition(ctx context.Context, partition *Partition) *utils.APIError
+		// Copies messages of partition range to its staging table, returns how many
+		CopyToStaging(ctx context.Context, partition *Partition) (int64, *utils.APIError)
+		// Keys of messages changed after start are recorded, so staging tables can catch up without full scan
+		StartChangeTracking(ctx context.Context) *utils.APIError
+		StopChangeTracking(ctx context.Context) *utils.APIError
+		// Applies up to limit recorded changes to staging tables, returns how many were applied
+		ApplyChanges(ctx context.Context, partitions []Partition, limit int) (int64, *utils.APIError)
+		// Replaces old partitions with staging tables of new ones. Changes left since last ApplyChanges are applied
+		// and tracking is stopped
+		SwapPartitions(ctx context.Context, partitions []Partition, old []Partition) *utils.APIError
+	}
+
+	Partition struct {
+		Name string
+		// Range of timestamps, End is not included
+		Start time.Time
+		End   time.Time
+		// Number of hash sub-partitions, 0 if partition is not split
+		HashBuckets int
+	}
+)
+
+// Overlaps says if partition has common timestamps with range [start, end)
+func (p *Partition) Overlaps(start, end time.Time) bool {
+	return p.Start.Before(end) && start.Before(p.End)
+}
@@ -0,0 +1,49 @@
+package domain
+
+import (
+	"context"
+	"message-service/internal/utils"
+	"time"
+)
+
+// LastSeenVisibility says who sees when user was online
+type LastSeenVisibility string
+
+const (
+	LastSeenEverybody LastSeenVisibility = "everybody"
+	LastSeenNobody    LastSeenVisibility = "nobody"
+)
+
+type (
+	// PresenceRepository keeps who is online and who is typing. It lives only in Redis,
+	// everything expires by itself
+	PresenceRepository interface {
+		// Session of user counts as online until expiresAt. User is seen now
+		SetSession(ctx context.Context, userID int, sessionID string, expiresAt time.Time) *utils.APIError
+		GetPresence(ctx context.Context, userIDs []int) ([]Presence, *utils.APIError)
+		GetLastSeenVisibility(ctx context.Context, userID int) (LastSeenVisibility, *utils.APIError)
+		SetLastSeenVisibility(ctx context.Context, userID int, visibility LastSeenVisibility) *utils.APIError
+		// Returns false if user already started typing in conversation less than ttl ago
+		StartTyping(ctx context.Context, conversationID, userID int, ttl time.Duration) (bool, *utils.APIError)
+		// Cached members of conversation who see typing. Returns false if they are not cached
+		GetTypingMembers(ctx context.Context, conversationID int) ([]int, bool, *utils.APIError)
+		SetTypingMembers(ctx context.Context, conversationID int, userIDs []int, ttl time.Duration) *utils.APIError
+		ForgetTypingMembers(ctx context.Context, conversationID int) *utils.APIError
+	}
+
+	Presence struct {
+		UserID int  `json:"user_id"`
+		Online bool `json:"online"`
+		// Not set if user was never seen or hides it
+		LastSeen   *time.Time         `json:"last_seen,omitempty"`
+		Visibility LastSeenVisibility `json:"-"`
+	}
+
+	PresenceSettings struct {
+		LastSeen LastSeenVisibility `json:"last_seen"`
+	}
+)
+
+func (v LastSeenVisibility) IsValid() bool {
+	return v == LastSeenEverybody || v == LastSeenNobody
+}
@@ -0,0 +1,92 @@
+package domain
+
+import (
+	"context"
+	"message-service/internal/utils"
+	"time"
+)
+
+// RealtimeEventType is type of event which is pushed to connected clients
+type RealtimeEventType string
+
+const (
+	MessageCreatedEvent RealtimeEventType = "message.created"
+	// Message became delivered or watched
+	MessageStatusEvent  RealtimeEventType = "message.status"
+	MessageDeletedEvent RealtimeEventType = "message.deleted"
+	// User deleted message for himself, only his devices get it
+	MessageHiddenEvent RealtimeEventType = "message.hidden"
+	// Event has message with new content
+	MessageEditedEvent RealtimeEventType = "message.edited"
+	// Member moved his read position
+	ConversationReadEvent RealtimeEventType = "conversation.read"
+	TypingEvent           RealtimeEventType = "typing"
+	// Client missed events which we don't have anymore, it must reload what it shows
+	ResyncEvent RealtimeEventType = "resync"
+)
+
+type (
+	// EventPublisher delivers events to users who are online. Events are not stored in database,
+	// so publishing never fails the action which caused it. Sync log gets them with the action, see SyncRecord
+	EventPublisher interface {
+		Publish(ctx context.Context, userIDs []int, event *RealtimeEvent)
+		// Sends event to all members of conversation, including subscribers of channel
+		PublishToConversation(ctx context.Context, conversation *Conversation, event *RealtimeEvent)
+		// Members of conversation changed, cached ones must not be used
+		ForgetMembers(ctx context.Context, conversationID int)
+	}
+
+	// RealtimeEventRepository keeps recent events of every user and tells instances about new ones,
+	// so user gets events wherever he is connected
+	RealtimeEventRepository interface {
+		// Appends event to events of every user and notifies instances which watch them.
+		// Ephemeral events are only sent to instances
+		Publish(ctx context.Context, userIDs []int, event *RealtimeEvent) *utils.APIError
+		// Events of user from fromID to toID, both included, oldest first. Empty toID means up to the newest one
+		GetEvents(ctx context.Context, userID int, fromID, toID string) ([]RealtimeEvent, *utils.APIError)
+		// Removes stored events of users which carry message, so its content is gone when message is deleted
+		DeleteMessageEvents(ctx context.Context, userIDs []int, messageID int) *utils.APIError
+		// Events of user after afterID, oldest first
+		GetEventsAfter(ctx context.Context, userID int, afterID string, limit int) ([]RealtimeEvent, *utils.APIError)
+		// ID of the newest event of user, "0-0" if there are none
+		GetLastEventID(ctx context.Context, userID int) (string, *utils.APIError)
+		// Starts/stops notifications about users
+		Watch(ctx context.Context, userID int) *utils.APIError
+		Unwatch(ctx context.Context, userID int) *utils.APIError
+		// Listen calls handle for notifications until context is cancelled. Event is nil when user has new stored events,
+		// handle also gets nil after reconnect to Redis, notifications could be lost then
+		Listen(ctx context.Context, handle func(userID int, event *RealtimeEvent))
+	}
+
+	RealtimeEvent struct {
+		// Set on delivery, client sends ID of the last event it got to resume after reconnect
+		ID             string            `json:"id,omitempty"`
+		Type           RealtimeEventType `json:"type"`
+		ConversationID int               `json:"conversation_id,omitempty"`
+		// Who did it: reader, typing user
+		UserID    int           `json:"user_id,omitempty"`
+		Message   *Message      `json:"message,omitempty"`
+		MessageID int           `json:"message_id,omitempty"`
+		Status    MessageStatus `json:"status,omitempty"`
+		CreatedAt time.Time     `json:"created_at"`
+		// Client stops showing event after that, e.g. typing which wasn't repeated
+		ExpiresAt *time.Time `json:"expires_at,omitempty"`
+		// Ephemeral events (typing) are not kept for resume
+		Ephemeral bool `json:"-"`
+	}
+
+	// RealtimeTicketRepository keeps one-time tickets, browsers open WebSocket and EventSource with them
+	// instead of putting token into URL
+	RealtimeTicketRepository interface {
+		SaveTicket(ctx context.Context, id string, ticket *RealtimeTicket, ttl time.Duration) *utils.APIError
+		// Returns ticket and deletes it, nil if there is no such ticket
+		TakeTicket(ctx context.Context, id string) (*RealtimeTicket, *utils.APIError)
+	}
+
+	// RealtimeTicket remembers who got ticket. Connection opened with it is closed when token expires
+	RealtimeTicket struct {
+		UserID         int       `json:"user_id"`
+		Role           UserRole  `json:"role"`
+		TokenExpiresAt time.Time `json:"token_expires_at"`
+	}
+)
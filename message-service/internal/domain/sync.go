@@ -0,0 +1,48 @@
+package domain
+
+import (
+	"context"
+	"message-service/internal/utils"
+	"time"
+)
+
+type (
+	// SyncRepository is durable log of events of every user. Client which was offline reads it
+	// from the last number it has seen, numbers of user only grow. Entries are written by repositories
+	// in transaction of change which caused them, see SyncRecord
+	SyncRepository interface {
+		// Entries of user with numbers after since, oldest first
+		GetEntries(ctx context.Context, userID int, since int64, limit int) ([]SyncEntry, *utils.APIError)
+		GetState(ctx context.Context, userID int) (*SyncState, *utils.APIError)
+		// Deletes entries older than before. Returns how many were deleted
+		Compact(ctx context.Context, before time.Time) (int64, *utils.APIError)
+	}
+
+	// SyncRecord is event which goes to sync log of users together with change which caused it,
+	// so log has it if and only if change is saved. Every user gets it with their next number
+	SyncRecord struct {
+		UserIDs []int
+		Event   *RealtimeEvent
+	}
+
+	SyncEntry struct {
+		Seq   int64         `json:"seq"`
+		Event RealtimeEvent `json:"event"`
+	}
+
+	SyncState struct {
+		// The newest number of user
+		LastSeq int64
+		// Entries up to this number are deleted
+		CompactedSeq int64
+	}
+
+	SyncPage struct {
+		Entries []SyncEntry `json:"entries"`
+		// Pass it as since to the next request
+		NextSeq int64 `json:"next_seq"`
+		HasMore bool  `json:"has_more"`
+		// Entries which client needs are deleted, it must reload everything and continue from NextSeq
+		Resync bool `json:"resync"`
+	}
+)
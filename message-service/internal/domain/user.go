@@ -5,6 +5,14 @@ import (
 	"time"
 )
 
+type UserRole string
+
+const (
+	RoleUser UserRole = "user"
+	// Guests have daily limit of messages
+	RoleGuest UserRole = "guest"
+)
+
 type (
 	UserRepository interface {
 		CreateUser(user *User) (*User, *utils.APIError)
@@ -0,0 +1,313 @@
+package handlers
+
+import (
+	"message-service/internal/services"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ConversationHandler struct {
+	conversationService *services.ConversationService
+}
+
+func NewConversationHandler(conversationService *services.ConversationService) *ConversationHandler {
+	return &ConversationHandler{conversationService: conversationService}
+}
+
+func (h *ConversationHandler) GetConversations(ctx *gin.Context) {
+
+	userIDString, exists := ctx.Get("user_id")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized request"})
+		return
+	}
+
+	userID, ok := userIDString.(int)
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	var limit int
+	if limitString := ctx.Query("limit"); limitString != "" {
+		var err error
+		if limit, err = strconv.Atoi(limitString); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input data", "details": "limit must be a number"})
+			return
+		}
+	}
+
+	conversations, nextCursor, apiErr := h.conversationService.GetConversations(ctx.Request.Context(), userID, ctx.Query("cursor"), limit)
+	if apiErr != nil {
+		ctx.JSON(apiErr.Code, gin.H{"details": apiErr.Details, "error": apiErr.Message})
+		return
+	}
+
+	response := gin.H{"conversations": conversations}
+	if nextCursor != "" {
+		response["next_cursor"] = nextCursor
+	}
+	ctx.JSON(http.StatusOK, response)
+}
+
+// CreateConversation returns direct conversation with peer, it is created if needed
+func (h *ConversationHandler) CreateConversation(ctx *gin.Context) {
+
+	var requestForm struct {
+		PeerID int `json:"peer_id"`
+	}
+
+	if err := ctx.ShouldBindJSON(&requestForm); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input data"})
+		return
+	}
+
+	userIDString, exists := ctx.Get("user_id")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized request"})
+		return
+	}
+
+	userID, ok := userIDString.(int)
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	conversation, apiErr := h.conversationService.CreateDirectConversation(ctx.Request.Context(), userID, requestForm.PeerID)
+	if apiErr != nil {
+		ctx.JSON(apiErr.Code, gin.H{"details": apiErr.Details, "error": apiErr.Message})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"conversation": conversation})
+}
+
+func (h *ConversationHandler) GetConversation(ctx *gin.Context) {
+
+	userIDString, exists := ctx.Get("user_id")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized request"})
+		return
+	}
+
+	userID, ok := userIDString.(int)
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	conversationID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil || conversationID <= 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input data", "details": "Invalid conversation ID"})
+		return
+	}
+
+	conversation, apiErr := h.conversationService.GetConversation(ctx.Request.Context(), userID, conversationID)
+	if apiErr != nil {
+		ctx.JSON(apiErr.Code, gin.H{"details": apiErr.Details, "error": apiErr.Message})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"conversation": conversation})
+}
+
+func (h *ConversationHandler) CreateGroup(ctx *gin.Context) {
+
+	var requestForm struct {
+		Title     string `json:"title"`
+		MemberIDs []int  `json:"member_ids"`
+		// New members see messages sent before they joined
+		HistoryVisible bool `json:"history_visible"`
+	}
+
+	if err := ctx.ShouldBindJSON(&requestForm); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input data"})
+		return
+	}
+
+	userIDString, exists := ctx.Get("user_id")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized request"})
+		return
+	}
+
+	userID, ok := userIDString.(int)
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	group, apiErr := h.conversationService.CreateGroup(ctx.Request.Context(), userID, requestForm.Title, requestForm.MemberIDs, requestForm.HistoryVisible)
+	if apiErr != nil {
+		ctx.JSON(apiErr.Code, gin.H{"details": apiErr.Details, "error": apiErr.Message})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, gin.H{"conversation": group})
+}
+
+func (h *ConversationHandler) AddMembers(ctx *gin.Context) {
+
+	var requestForm struct {
+		UserIDs []int `json:"user_ids"`
+	}
+
+	if err := ctx.ShouldBindJSON(&requestForm); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input data"})
+		return
+	}
+
+	userIDString, exists := ctx.Get("user_id")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized request"})
+		return
+	}
+
+	userID, ok := userIDString.(int)
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	conversationID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil || conversationID <= 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input data", "details": "Invalid conversation ID"})
+		return
+	}
+
+	added, apiErr := h.conversationService.AddMembers(ctx.Request.Context(), userID, conversationID, requestForm.UserIDs)
+	if apiErr != nil {
+		ctx.JSON(apiErr.Code, gin.H{"details": apiErr.Details, "error": apiErr.Message})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"added": added})
+}
+
+func (h *ConversationHandler) RemoveMember(ctx *gin.Context) {
+
+	userIDString, exists := ctx.Get("user_id")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized request"})
+		return
+	}
+
+	userID, ok := userIDString.(int)
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	conversationID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil || conversationID <= 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input data", "details": "Invalid conversation ID"})
+		return
+	}
+
+	memberID, err := strconv.Atoi(ctx.Param("user_id"))
+	if err != nil || memberID <= 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input data", "details": "Invalid user ID"})
+		return
+	}
+
+	if apiErr := h.conversationService.RemoveMember(ctx.Request.Context(), userID, conversationID, memberID); apiErr != nil {
+		ctx.JSON(apiErr.Code, gin.H{"details": apiErr.Details, "error": apiErr.Message})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+func (h *ConversationHandler) Leave(ctx *gin.Context) {
+
+	userIDString, exists := ctx.Get("user_id")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized request"})
+		return
+	}
+
+	userID, ok := userIDString.(int)
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	conversationID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil || conversationID <= 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input data", "details": "Invalid conversation ID"})
+		return
+	}
+
+	if apiErr := h.conversationService.Leave(ctx.Request.Context(), userID, conversationID); apiErr != nil {
+		ctx.JSON(apiErr.Code, gin.H{"details": apiErr.Details, "error": apiErr.Message})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+func (h *ConversationHandler) CreateChannel(ctx *gin.Context) {
+
+	var requestForm struct {
+		Title string `json:"title"`
+	}
+
+	if err := ctx.ShouldBindJSON(&requestForm); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input data"})
+		return
+	}
+
+	userIDString, exists := ctx.Get("user_id")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized request"})
+		return
+	}
+
+	userID, ok := userIDString.(int)
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	channel, apiErr := h.conversationService.CreateChannel(ctx.Request.Context(), userID, requestForm.Title)
+	if apiErr != nil {
+		ctx.JSON(apiErr.Code, gin.H{"details": apiErr.Details, "error": apiErr.Message})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, gin.H{"conversation": channel})
+}
+
+func (h *ConversationHandler) Subscribe(ctx *gin.Context) {
+
+	userID, channelID, ok := userAndConversation(ctx)
+	if !ok {
+		return
+	}
+
+	channel, apiErr := h.conversationService.Subscribe(ctx.Request.Context(), userID, channelID)
+	if apiErr != nil {
+		ctx.JSON(apiErr.Code, gin.H{"details": apiErr.Details, "error": apiErr.Message})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"conversation": channel})
+}
+
+func (h *ConversationHandler) Unsubscribe(ctx *gin.Context) {
+
+	userID, channelID, ok := userAndConversation(ctx)
+	if !ok {
+		return
+	}
+
+	if apiErr := h.conversationService.Unsubscribe(ctx.Request.Context(), userID, channelID); apiErr != nil {
+		ctx.JSON(apiErr.Code, gin.H{"details": apiErr.Details, "error": apiErr.Message})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
@@ -0,0 +1,248 @@
+package handlers
+
+import (
+	"message-service/internal/domain"
+	"message-service/internal/services"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type GroupHandler struct {
+	groupService *services.GroupService
+}
+
+func NewGroupHandler(groupService *services.GroupService) *GroupHandler {
+	return &GroupHandler{groupService: groupService}
+}
+
+func (h *GroupHandler) UpdateGroup(ctx *gin.Context) {
+
+	// Only fields which are set are changed
+	var requestForm struct {
+		Title     *string `json:"title"`
+		AvatarURL *string `json:"avatar_url"`
+	}
+
+	if err := ctx.ShouldBindJSON(&requestForm); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input data"})
+		return
+	}
+
+	userID, conversationID, ok := userAndConversation(ctx)
+	if !ok {
+		return
+	}
+
+	group, apiErr := h.groupService.UpdateGroup(ctx.Request.Context(), userID, conversationID, requestForm.Title, requestForm.AvatarURL)
+	if apiErr != nil {
+		ctx.JSON(apiErr.Code, gin.H{"details": apiErr.Details, "error": apiErr.Message})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"conversation": group})
+}
+
+func (h *GroupHandler) SetRole(ctx *gin.Context) {
+
+	var requestForm struct {
+		Role domain.ConversationRole `json:"role"`
+	}
+
+	if err := ctx.ShouldBindJSON(&requestForm); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input data"})
+		return
+	}
+
+	userID, conversationID, ok := userAndConversation(ctx)
+	if !ok {
+		return
+	}
+
+	memberID, err := strconv.Atoi(ctx.Param("user_id"))
+	if err != nil || memberID <= 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input data", "details": "Invalid user ID"})
+		return
+	}
+
+	if apiErr := h.groupService.SetRole(ctx.Request.Context(), userID, conversationID, memberID, requestForm.Role); apiErr != nil {
+		ctx.JSON(apiErr.Code, gin.H{"details": apiErr.Details, "error": apiErr.Message})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+func (h *GroupHandler) Ban(ctx *gin.Context) {
+
+	var requestForm struct {
+		UserID int `json:"user_id"`
+	}
+
+	if err := ctx.ShouldBindJSON(&requestForm); err != nil || requestForm.UserID <= 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input data"})
+		return
+	}
+
+	userID, conversationID, ok := userAndConversation(ctx)
+	if !ok {
+		return
+	}
+
+	if apiErr := h.groupService.Ban(ctx.Request.Context(), userID, conversationID, requestForm.UserID); apiErr != nil {
+		ctx.JSON(apiErr.Code, gin.H{"details": apiErr.Details, "error": apiErr.Message})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+func (h *GroupHandler) Unban(ctx *gin.Context) {
+
+	userID, conversationID, ok := userAndConversation(ctx)
+	if !ok {
+		return
+	}
+
+	targetID, err := strconv.Atoi(ctx.Param("user_id"))
+	if err != nil || targetID <= 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input data", "details": "Invalid user ID"})
+		return
+	}
+
+	if apiErr := h.groupService.Unban(ctx.Request.Context(), userID, conversationID, targetID); apiErr != nil {
+		ctx.JSON(apiErr.Code, gin.H{"details": apiErr.Details, "error": apiErr.Message})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+func (h *GroupHandler) GetBans(ctx *gin.Context) {
+
+	userID, conversationID, ok := userAndConversation(ctx)
+	if !ok {
+		return
+	}
+
+	bans, apiErr := h.groupService.GetBans(ctx.Request.Context(), userID, conversationID)
+	if apiErr != nil {
+		ctx.JSON(apiErr.Code, gin.H{"details": apiErr.Details, "error": apiErr.Message})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"bans": bans})
+}
+
+func (h *GroupHandler) CreateInvite(ctx *gin.Context) {
+
+	var requestForm struct {
+		// 0 - no limit
+		MaxUses int `json:"max_uses"`
+		// In seconds, 0 - never expires
+		ExpiresIn int `json:"expires_in"`
+	}
+
+	if err := ctx.ShouldBindJSON(&requestForm); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input data"})
+		return
+	}
+
+	userID, conversationID, ok := userAndConversation(ctx)
+	if !ok {
+		return
+	}
+
+	invite, apiErr := h.groupService.CreateInvite(
+		ctx.Request.Context(),
+		userID,
+		conversationID,
+		requestForm.MaxUses,
+		time.Duration(requestForm.ExpiresIn)*time.Second,
+	)
+	if apiErr != nil {
+		ctx.JSON(apiErr.Code, gin.H{"details": apiErr.Details, "error": apiErr.Message})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, gin.H{"invite": invite})
+}
+
+func (h *GroupHandler) GetInvites(ctx *gin.Context) {
+
+	userID, conversationID, ok := userAndConversation(ctx)
+	if !ok {
+		return
+	}
+
+	invites, apiErr := h.groupService.GetInvites(ctx.Request.Context(), userID, conversationID)
+	if apiErr != nil {
+		ctx.JSON(apiErr.Code, gin.H{"details": apiErr.Details, "error": apiErr.Message})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"invites": invites})
+}
+
+func (h *GroupHandler) RevokeInvite(ctx *gin.Context) {
+
+	userID, conversationID, ok := userAndConversation(ctx)
+	if !ok {
+		return
+	}
+
+	if apiErr := h.groupService.RevokeInvite(ctx.Request.Context(), userID, conversationID, ctx.Param("code")); apiErr != nil {
+		ctx.JSON(apiErr.Code, gin.H{"details": apiErr.Details, "error": apiErr.Message})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+func (h *GroupHandler) JoinByInvite(ctx *gin.Context) {
+
+	userIDString, exists := ctx.Get("user_id")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized request"})
+		return
+	}
+
+	userID, ok := userIDString.(int)
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	group, apiErr := h.groupService.JoinByInvite(ctx.Request.Context(), userID, ctx.Param("code"))
+	if apiErr != nil {
+		ctx.JSON(apiErr.Code, gin.H{"details": apiErr.Details, "error": apiErr.Message})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"conversation": group})
+}
+
+// userAndConversation reads user from context and conversation ID from path. It responds itself if something is wrong
+func userAndConversation(ctx *gin.Context) (int, int, bool) {
+	userIDString, exists := ctx.Get("user_id")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized request"})
+		return 0, 0, false
+	}
+
+	userID, ok := userIDString.(int)
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return 0, 0, false
+	}
+
+	conversationID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil || conversationID <= 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input data", "details": "Invalid conversation ID"})
+		return 0, 0, false
+	}
+
+	return userID, conversationID, true
+}
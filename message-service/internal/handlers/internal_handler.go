@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"encoding/json"
+	"message-service/internal/domain"
+	"message-service/internal/services"
+	"net/http"
+	"strconv"
+
+	logger "message-service/internal"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// Trailer which is sent only after the last message. Without it reader must treat export as broken
+const exportCompleteTrailer = "X-Export-Complete"
+
+// InternalHandler serves API for other services
+type InternalHandler struct {
+	messageService *services.MessageService
+}
+
+func NewInternalHandler(messageService *services.MessageService) *InternalHandler {
+	return &InternalHandler{messageService: messageService}
+}
+
+// ExportUserMessages streams JSON array of all messages of user. Used by data export of auth service
+func (h *InternalHandler) ExportUserMessages(ctx *gin.Context) {
+	userID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input data"})
+		return
+	}
+
+	ctx.Header("Content-Type", "application/json")
+	// Status is sent before we know if all messages can be read, trailer says that they were
+	ctx.Header("Trailer", exportCompleteTrailer)
+	ctx.Status(http.StatusOK)
+
+	// Array is written by hand, so we don't have to keep all messages in memory
+	encoder := json.NewEncoder(ctx.Writer)
+	separator := "["
+
+	apiErr := h.messageService.ExportUserMessages(ctx.Request.Context(), userID, func(message *domain.Message) error {
+		if _, err := ctx.Writer.WriteString(separator); err != nil {
+			return err
+		}
+		separator = ","
+		return encoder.Encode(message)
+	})
+
+	// Headers are already sent, response goes without trailer and reader drops it
+	if apiErr != nil {
+		logger.Error("Cannot export user messages",
+			zap.Int("User ID", userID),
+			zap.String("error", apiErr.Message))
+		ctx.Abort()
+		return
+	}
+
+	if separator == "[" {
+		ctx.Writer.WriteString("[")
+	}
+	ctx.Writer.WriteString("]")
+	ctx.Writer.Header().Set(exportCompleteTrailer, "true")
+}
@@ -3,8 +3,10 @@ package handlers
 import (
 	"message-service/internal/domain"
 	"message-service/internal/services"
+	"message-service/internal/utils"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -43,10 +45,14 @@ func (h *MessageHandler) SendMessage(ctx *gin.Context) {
 		return
 	}
 
+	senderRole, _ := ctx.Get("user_role")
+	role, _ := senderRole.(domain.UserRole)
+
 	message, apiErr := h.messageService.CreateMessage(
 		ctx.Request.Context(),
 		requestForm.RecipientID,
 		senderID,
+		role,
 		requestForm.Content,
 	)
 	if apiErr != nil {
@@ -84,17 +90,104 @@ func (h *MessageHandler) GetConversationMessages(ctx *gin.Context) {
 		return
 	}
 
-	messages, apiErr := h.messageService.GetConversationMessages(
+	filter, around, ok := parseConversationQuery(ctx)
+	if !ok {
+		return
+	}
+
+	page, apiErr := h.messageService.GetDirectConversationMessages(
 		ctx.Request.Context(),
 		user1ID,
 		recipientID,
+		filter,
+		ctx.Query("cursor"),
+		around,
 	)
 	if apiErr != nil {
 		ctx.JSON(apiErr.Code, gin.H{"details": apiErr.Details, "error": apiErr.Message})
 		return
 	}
 
-	ctx.JSON(http.StatusOK, gin.H{"messages": messages})
+	ctx.JSON(http.StatusOK, page)
+}
+
+// GetMessages returns page of conversation with ID from path
+func (h *MessageHandler) GetMessages(ctx *gin.Context) {
+
+	userIDString, exists := ctx.Get("user_id")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized request"})
+		return
+	}
+
+	userID, ok := userIDString.(int)
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	conversationID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil || conversationID <= 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input data", "details": "Invalid conversation ID"})
+		return
+	}
+
+	filter, around, ok := parseConversationQuery(ctx)
+	if !ok {
+		return
+	}
+
+	page, apiErr := h.messageService.GetConversationMessages(
+		ctx.Request.Context(),
+		userID,
+		conversationID,
+		filter,
+		ctx.Query("cursor"),
+		around,
+	)
+	if apiErr != nil {
+		ctx.JSON(apiErr.Code, gin.H{"details": apiErr.Details, "error": apiErr.Message})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, page)
+}
+
+// parseConversationQuery reads filter of conversation page from query. It responds itself if something is wrong
+func parseConversationQuery(ctx *gin.Context) (domain.ConversationFilter, int, bool) {
+	var err error
+
+	// Time bounds in RFC 3339, for example 2024-01-02T15:04:05Z
+	var filter domain.ConversationFilter
+	if after := ctx.Query("after"); after != "" {
+		if filter.After, err = time.Parse(time.RFC3339, after); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input data", "details": "after must be RFC 3339 time"})
+			return filter, 0, false
+		}
+	}
+	if before := ctx.Query("before"); before != "" {
+		if filter.Before, err = time.Parse(time.RFC3339, before); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input data", "details": "before must be RFC 3339 time"})
+			return filter, 0, false
+		}
+	}
+	if limit := ctx.Query("limit"); limit != "" {
+		if filter.Limit, err = strconv.Atoi(limit); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input data", "details": "limit must be a number"})
+			return filter, 0, false
+		}
+	}
+
+	// Jump to message: window of messages around this one
+	var around int
+	if aroundString := ctx.Query("around"); aroundString != "" {
+		if around, err = strconv.Atoi(aroundString); err != nil || around <= 0 {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input data", "details": "around must be message ID"})
+			return filter, 0, false
+		}
+	}
+
+	return filter, around, true
 }
 
 func (h *MessageHandler) UpdateMessageStatus(ctx *gin.Context) {
@@ -102,6 +195,88 @@ func (h *MessageHandler) UpdateMessageStatus(ctx *gin.Context) {
 	var requestForm struct {
 		MessageID int                  `json:"message_id"`
 		Status    domain.MessageStatus `json:"status"`
+		// Optional, timestamp of message makes search faster
+		Timestamp time.Time `json:"timestamp"`
+	}
+
+	if err := ctx.ShouldBindJSON(&requestForm); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input data"})
+		return
+	}
+
+	if !requestForm.Status.IsValid() || requestForm.MessageID <= 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input data"})
+		return
+	}
+
+	userIDString, exists := ctx.Get("user_id")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized request"})
+		return
+	}
+
+	userID, ok := userIDString.(int)
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	apiErr := h.messageService.UpdateMessageStatus(
+		ctx.Request.Context(),
+		userID,
+		requestForm.MessageID,
+		requestForm.Timestamp,
+		requestForm.Status,
+	)
+
+	if apiErr != nil {
+		ctx.JSON(apiErr.Code, gin.H{"details": apiErr.Details, "error": apiErr.Message})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+func (h *MessageHandler) MarkConversationRead(ctx *gin.Context) {
+
+	var requestForm struct {
+		// Other side of conversation
+		PeerID int `json:"peer_id"`
+		// Everything up to this message (included) is read
+		MessageID int `json:"message_id"`
+	}
+
+	if err := ctx.ShouldBindJSON(&requestForm); err != nil || requestForm.PeerID <= 0 || requestForm.MessageID <= 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input data"})
+		return
+	}
+
+	userIDString, exists := ctx.Get("user_id")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized request"})
+		return
+	}
+
+	userID, ok := userIDString.(int)
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	updated, apiErr := h.messageService.MarkDirectConversationRead(ctx.Request.Context(), userID, requestForm.PeerID, requestForm.MessageID)
+	if apiErr != nil {
+		ctx.JSON(apiErr.Code, gin.H{"details": apiErr.Details, "error": apiErr.Message})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"updated": updated})
+}
+
+// SendConversationMessage sends message to conversation with ID from path
+func (h *MessageHandler) SendConversationMessage(ctx *gin.Context) {
+
+	var requestForm struct {
+		Content string `json:"content"`
 	}
 
 	if err := ctx.ShouldBindJSON(&requestForm); err != nil {
@@ -109,12 +284,109 @@ func (h *MessageHandler) UpdateMessageStatus(ctx *gin.Context) {
 		return
 	}
 
-	if !requestForm.Status.IsValid() {
+	conversationID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil || conversationID <= 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input data", "details": "Invalid conversation ID"})
+		return
+	}
+
+	senderIDString, exists := ctx.Get("user_id")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found in context"})
+		return
+	}
+
+	senderID, ok := senderIDString.(int)
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid User ID type"})
+		return
+	}
+
+	senderRole, _ := ctx.Get("user_role")
+	role, _ := senderRole.(domain.UserRole)
+
+	message, apiErr := h.messageService.SendConversationMessage(ctx.Request.Context(), conversationID, senderID, role, requestForm.Content)
+	if apiErr != nil {
+		ctx.JSON(apiErr.Code, gin.H{"details": apiErr.Details, "error": apiErr.Message})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, gin.H{"message": message})
+}
+
+// MarkRead marks messages of conversation with ID from path as read
+func (h *MessageHandler) MarkRead(ctx *gin.Context) {
+
+	var requestForm struct {
+		// Everything up to this message (included) is read
+		MessageID int `json:"message_id"`
+	}
+
+	if err := ctx.ShouldBindJSON(&requestForm); err != nil || requestForm.MessageID <= 0 {
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input data"})
 		return
 	}
 
-	apiErr := h.messageService.UpdateMessageStatus(ctx.Request.Context(), requestForm.MessageID, requestForm.Status)
+	conversationID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil || conversationID <= 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input data", "details": "Invalid conversation ID"})
+		return
+	}
+
+	userIDString, exists := ctx.Get("user_id")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized request"})
+		return
+	}
+
+	userID, ok := userIDString.(int)
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	updated, apiErr := h.messageService.MarkConversationRead(ctx.Request.Context(), userID, conversationID, requestForm.MessageID)
+	if apiErr != nil {
+		ctx.JSON(apiErr.Code, gin.H{"details": apiErr.Details, "error": apiErr.Message})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"updated": updated})
+}
+
+// DeleteMessage deletes message of conversation, timestamp of message in query is optional
+func (h *MessageHandler) DeleteMessage(ctx *gin.Context) {
+
+	userID, conversationID, ok := userAndConversation(ctx)
+	if !ok {
+		return
+	}
+
+	messageID, err := strconv.Atoi(ctx.Param("message_id"))
+	if err != nil || messageID <= 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input data", "details": "Invalid message ID"})
+		return
+	}
+
+	var timestamp time.Time
+	if timestampString := ctx.Query("timestamp"); timestampString != "" {
+		if timestamp, err = time.Parse(time.RFC3339Nano, timestampString); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input data", "details": "timestamp must be RFC 3339 time"})
+			return
+		}
+	}
+
+	// for=me hides message only for user, for=everyone (default) leaves tombstone
+	var apiErr *utils.APIError
+	switch ctx.DefaultQuery("for", "everyone") {
+	case "everyone":
+		apiErr = h.messageService.DeleteMessage(ctx.Request.Context(), userID, conversationID, messageID, timestamp)
+	case "me":
+		apiErr = h.messageService.HideMessage(ctx.Request.Context(), userID, conversationID, messageID, timestamp)
+	default:
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input data", "details": "for must be me or everyone"})
+		return
+	}
 
 	if apiErr != nil {
 		ctx.JSON(apiErr.Code, gin.H{"details": apiErr.Details, "error": apiErr.Message})
@@ -123,3 +395,82 @@ func (h *MessageHandler) UpdateMessageStatus(ctx *gin.Context) {
 
 	ctx.JSON(http.StatusOK, gin.H{"status": "ok"})
 }
+
+// EditMessage replaces content of message, only sender can do it
+func (h *MessageHandler) EditMessage(ctx *gin.Context) {
+
+	var requestForm struct {
+		Content string `json:"content"`
+		// Optional, with it Postgres looks only in one partition
+		Timestamp time.Time `json:"timestamp"`
+	}
+
+	if err := ctx.ShouldBindJSON(&requestForm); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input data"})
+		return
+	}
+
+	messageID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil || messageID <= 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input data", "details": "Invalid message ID"})
+		return
+	}
+
+	userIDString, exists := ctx.Get("user_id")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized request"})
+		return
+	}
+
+	userID, ok := userIDString.(int)
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	message, apiErr := h.messageService.EditMessage(ctx.Request.Context(), userID, messageID, requestForm.Timestamp, requestForm.Content)
+	if apiErr != nil {
+		ctx.JSON(apiErr.Code, gin.H{"details": apiErr.Details, "error": apiErr.Message})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": message})
+}
+
+// GetMessageEdits returns message with its previous versions. timestamp query is optional
+func (h *MessageHandler) GetMessageEdits(ctx *gin.Context) {
+
+	messageID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil || messageID <= 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input data", "details": "Invalid message ID"})
+		return
+	}
+
+	var timestamp time.Time
+	if timestampString := ctx.Query("timestamp"); timestampString != "" {
+		if timestamp, err = time.Parse(time.RFC3339Nano, timestampString); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input data", "details": "timestamp must be RFC 3339 time"})
+			return
+		}
+	}
+
+	userIDString, exists := ctx.Get("user_id")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized request"})
+		return
+	}
+
+	userID, ok := userIDString.(int)
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	message, edits, apiErr := h.messageService.GetMessageEdits(ctx.Request.Context(), userID, messageID, timestamp)
+	if apiErr != nil {
+		ctx.JSON(apiErr.Code, gin.H{"details": apiErr.Details, "error": apiErr.Message})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": message, "edits": edits})
+}
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"message-service/internal/domain"
+	"message-service/internal/services"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type PresenceHandler struct {
+	presenceService *services.PresenceService
+}
+
+func NewPresenceHandler(presenceService *services.PresenceService) *PresenceHandler {
+	return &PresenceHandler{presenceService: presenceService}
+}
+
+// GetPresence takes comma separated user_ids: /presence?user_ids=1,2,3
+func (h *PresenceHandler) GetPresence(ctx *gin.Context) {
+
+	userIDString, exists := ctx.Get("user_id")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized request"})
+		return
+	}
+
+	userID, ok := userIDString.(int)
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	var userIDs []int
+	for _, part := range strings.Split(ctx.Query("user_ids"), ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		id, err := strconv.Atoi(part)
+		if err != nil || id <= 0 {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input data", "details": "Invalid user ID"})
+			return
+		}
+		userIDs = append(userIDs, id)
+	}
+
+	presences, apiErr := h.presenceService.GetPresence(ctx.Request.Context(), userID, userIDs)
+	if apiErr != nil {
+		ctx.JSON(apiErr.Code, gin.H{"details": apiErr.Details, "error": apiErr.Message})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"presence": presences})
+}
+
+func (h *PresenceHandler) GetSettings(ctx *gin.Context) {
+
+	userIDString, exists := ctx.Get("user_id")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized request"})
+		return
+	}
+
+	userID, ok := userIDString.(int)
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	settings, apiErr := h.presenceService.GetSettings(ctx.Request.Context(), userID)
+	if apiErr != nil {
+		ctx.JSON(apiErr.Code, gin.H{"details": apiErr.Details, "error": apiErr.Message})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, settings)
+}
+
+func (h *PresenceHandler) UpdateSettings(ctx *gin.Context) {
+
+	userIDString, exists := ctx.Get("user_id")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized request"})
+		return
+	}
+
+	userID, ok := userIDString.(int)
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	var settings domain.PresenceSettings
+	if err := ctx.ShouldBindJSON(&settings); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input data"})
+		return
+	}
+
+	if apiErr := h.presenceService.UpdateSettings(ctx.Request.Context(), userID, &settings); apiErr != nil {
+		ctx.JSON(apiErr.Code, gin.H{"details": apiErr.Details, "error": apiErr.Message})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, settings)
+}
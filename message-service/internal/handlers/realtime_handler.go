@@ -0,0 +1,401 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"message-service/internal/domain"
+	"message-service/internal/services"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// Client must answer our ping in this time, otherwise it is gone
+	pongWait   = 60 * time.Second
+	pingPeriod = pongWait * 9 / 10
+	writeWait  = 10 * time.Second
+	// Clients send only small commands
+	maxClientMessageSize = 4096
+	// Long poll returns empty list after that, proxies usually close idle requests after a minute
+	pollTimeout = 25 * time.Second
+	// How long EventSource of browser waits before reconnect, in milliseconds
+	sseRetry = 3000
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	// Token is checked by middleware and cookies are not used, so any origin is fine
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// clientMessage is a command which client sends over WebSocket
+type clientMessage struct {
+	Type           domain.RealtimeEventType `json:"type"`
+	ConversationID int                      `json:"conversation_id"`
+}
+
+type RealtimeHandler struct {
+	realtimeService *services.RealtimeService
+	presenceService *services.PresenceService
+	ticketService   *services.TicketService
+}
+
+func NewRealtimeHandler(realtimeService *services.RealtimeService, presenceService *services.PresenceService, ticketService *services.TicketService) *RealtimeHandler {
+	return &RealtimeHandler{realtimeService: realtimeService, presenceService: presenceService, ticketService: ticketService}
+}
+
+// Ticket gives one-time ticket for /ws and /events, browsers pass it in ticket query instead of token
+func (h *RealtimeHandler) Ticket(ctx *gin.Context) {
+
+	userIDString, exists := ctx.Get("user_id")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized request"})
+		return
+	}
+
+	userID, ok := userIDString.(int)
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	role, _ := ctx.Get("user_role")
+	userRole, _ := role.(domain.UserRole)
+	expiresAt, _ := ctx.Get("token_expires_at")
+	tokenExpiresAt, _ := expiresAt.(time.Time)
+
+	ticket, apiErr := h.ticketService.IssueTicket(ctx.Request.Context(), userID, userRole, tokenExpiresAt)
+	if apiErr != nil {
+		ctx.JSON(apiErr.Code, gin.H{"details": apiErr.Details, "error": apiErr.Message})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"ticket": ticket, "expires_in": int(services.RealtimeTicketTTL.Seconds())})
+}
+
+// WebSocket pushes events of user until he disconnects. Client resumes with Last-Event-ID header or last_event_id query
+func (h *RealtimeHandler) WebSocket(ctx *gin.Context) {
+
+	userIDString, exists := ctx.Get("user_id")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized request"})
+		return
+	}
+
+	userID, ok := userIDString.(int)
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	connection, missed, apiErr := h.realtimeService.Connect(ctx.Request.Context(), userID, lastEventID(ctx))
+	if apiErr != nil {
+		ctx.JSON(apiErr.Code, gin.H{"details": apiErr.Details, "error": apiErr.Message})
+		return
+	}
+	defer h.realtimeService.Disconnect(connection)
+
+	// Upgrader responds itself if request is wrong
+	ws, err := upgrader.Upgrade(ctx.Writer, ctx.Request, nil)
+	if err != nil {
+		return
+	}
+	defer ws.Close()
+
+	h.presenceService.Heartbeat(ctx.Request.Context(), connection)
+	// Request context is cancelled already when client is gone
+	defer h.presenceService.Leave(context.Background(), connection)
+
+	expired, stopExpiry := tokenExpiry(ctx)
+	defer stopExpiry()
+
+	done := make(chan struct{})
+	go h.readLoop(ctx.Request.Context(), ws, connection, done)
+
+	h.writeLoop(ws, connection, missed, done, expired)
+}
+
+// readLoop reads commands of client and answers to pings. done is closed when client is gone
+func (h *RealtimeHandler) readLoop(ctx context.Context, ws *websocket.Conn, connection *services.Connection, done chan struct{}) {
+	defer close(done)
+
+	ws.SetReadLimit(maxClientMessageSize)
+	ws.SetReadDeadline(time.Now().Add(pongWait))
+	// Pongs are heartbeats of presence
+	ws.SetPongHandler(func(string) error {
+		h.presenceService.Heartbeat(ctx, connection)
+		return ws.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	for {
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		// Any message shows that client is alive
+		ws.SetReadDeadline(time.Now().Add(pongWait))
+
+		var message clientMessage
+		if err := json.Unmarshal(data, &message); err != nil {
+			continue
+		}
+
+		// Typing is best effort, client doesn't wait for answer
+		if message.Type == domain.TypingEvent && message.ConversationID > 0 {
+			h.realtimeService.SendTyping(ctx, connection.UserID, message.ConversationID)
+		}
+	}
+}
+
+// writeLoop is the only writer of ws. It sends missed events first, then new ones and pings until client is gone
+// or his token expires
+func (h *RealtimeHandler) writeLoop(ws *websocket.Conn, connection *services.Connection, missed []domain.RealtimeEvent, done chan struct{}, expired <-chan time.Time) {
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+
+	for i := range missed {
+		if err := writeEvent(ws, &missed[i]); err != nil {
+			return
+		}
+	}
+
+	for {
+		select {
+		case event, ok := <-connection.Events:
+			// Hub dropped us, client reads too slowly
+			if !ok {
+				closeMessage := websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "Too slow, reconnect with last event ID")
+				ws.WriteControl(websocket.CloseMessage, closeMessage, time.Now().Add(writeWait))
+				return
+			}
+			if err := writeEvent(ws, &event); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if err := ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait)); err != nil {
+				return
+			}
+		case <-expired:
+			closeMessage := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "Token expired, reconnect with new ticket")
+			ws.WriteControl(websocket.CloseMessage, closeMessage, time.Now().Add(writeWait))
+			return
+		case <-done:
+			return
+		}
+	}
+}
+
+func writeEvent(ws *websocket.Conn, event *domain.RealtimeEvent) error {
+	ws.SetWriteDeadline(time.Now().Add(writeWait))
+	return ws.WriteJSON(event)
+}
+
+// Events is Server-Sent Events stream with the same events as WebSocket, for clients behind proxies which break WebSockets.
+// EventSource of browser sends Last-Event-ID itself when it reconnects
+func (h *RealtimeHandler) Events(ctx *gin.Context) {
+
+	userIDString, exists := ctx.Get("user_id")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized request"})
+		return
+	}
+
+	userID, ok := userIDString.(int)
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	connection, missed, apiErr := h.realtimeService.Connect(ctx.Request.Context(), userID, lastEventID(ctx))
+	if apiErr != nil {
+		ctx.JSON(apiErr.Code, gin.H{"details": apiErr.Details, "error": apiErr.Message})
+		return
+	}
+	defer h.realtimeService.Disconnect(connection)
+
+	h.presenceService.Heartbeat(ctx.Request.Context(), connection)
+	defer h.presenceService.Leave(context.Background(), connection)
+
+	ctx.Header("Content-Type", "text/event-stream")
+	ctx.Header("Cache-Control", "no-cache")
+	ctx.Header("Connection", "keep-alive")
+	// Nginx must not buffer the stream
+	ctx.Header("X-Accel-Buffering", "no")
+	ctx.Status(http.StatusOK)
+
+	fmt.Fprintf(ctx.Writer, "retry: %d\n\n", sseRetry)
+	for i := range missed {
+		if err := writeSSEvent(ctx.Writer, &missed[i]); err != nil {
+			return
+		}
+	}
+	ctx.Writer.Flush()
+
+	// Comments keep connection alive, so proxies don't close it as idle
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+
+	// Stream ends with token, EventSource reconnects and fails until client gets new ticket
+	expired, stopExpiry := tokenExpiry(ctx)
+	defer stopExpiry()
+
+	for {
+		select {
+		case event, ok := <-connection.Events:
+			// Hub dropped us, client reconnects with Last-Event-ID
+			if !ok {
+				return
+			}
+			if err := writeSSEvent(ctx.Writer, &event); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if _, err := fmt.Fprint(ctx.Writer, ": ping\n\n"); err != nil {
+				return
+			}
+			// Client can't answer over SSE, connection which is still open is alive
+			h.presenceService.Heartbeat(ctx.Request.Context(), connection)
+		case <-expired:
+			return
+		case <-ctx.Request.Context().Done():
+			return
+		}
+		ctx.Writer.Flush()
+	}
+}
+
+// PollEvents is long polling: it returns missed events at once or waits for new ones up to pollTimeout.
+// Client passes last_event_id of previous response to the next request
+func (h *RealtimeHandler) PollEvents(ctx *gin.Context) {
+
+	userIDString, exists := ctx.Get("user_id")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized request"})
+		return
+	}
+
+	userID, ok := userIDString.(int)
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	lastID := lastEventID(ctx)
+	connection, events, apiErr := h.realtimeService.Connect(ctx.Request.Context(), userID, lastID)
+	if apiErr != nil {
+		ctx.JSON(apiErr.Code, gin.H{"details": apiErr.Details, "error": apiErr.Message})
+		return
+	}
+	defer h.realtimeService.Disconnect(connection)
+
+	// Next poll comes before grace period of Leave ends, so user stays online between them
+	h.presenceService.Heartbeat(ctx.Request.Context(), connection)
+	defer h.presenceService.Leave(context.Background(), connection)
+
+	// Fresh client and client after resync continue from position where this poll started,
+	// so events which come before the next poll are not lost
+	if lastID == "" || (len(events) > 0 && events[0].Type == domain.ResyncEvent) {
+		lastID = connection.StartID
+	}
+
+	if len(events) == 0 {
+		timer := time.NewTimer(pollTimeout)
+		defer timer.Stop()
+
+		select {
+		case event, ok := <-connection.Events:
+			if ok {
+				events = append(events, event)
+			}
+		case <-timer.C:
+		case <-ctx.Request.Context().Done():
+			return
+		}
+
+		// Take what came together with the first event
+		for {
+			event, ok := nextEvent(connection)
+			if !ok {
+				break
+			}
+			events = append(events, event)
+		}
+	}
+
+	// Ephemeral events have no ID, client keeps the previous one
+	for i := range events {
+		if events[i].ID != "" {
+			lastID = events[i].ID
+		}
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"events": events, "last_event_id": lastID})
+}
+
+// Typing is for clients without WebSocket, others send it over /ws
+func (h *RealtimeHandler) Typing(ctx *gin.Context) {
+
+	userID, conversationID, ok := userAndConversation(ctx)
+	if !ok {
+		return
+	}
+
+	if apiErr := h.realtimeService.SendTyping(ctx.Request.Context(), userID, conversationID); apiErr != nil {
+		ctx.JSON(apiErr.Code, gin.H{"details": apiErr.Details, "error": apiErr.Message})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// lastEventID is from Last-Event-ID header, or from query where client can't set headers
+func lastEventID(ctx *gin.Context) string {
+	if id := ctx.GetHeader("Last-Event-ID"); id != "" {
+		return id
+	}
+	return ctx.Query("last_event_id")
+}
+
+// tokenExpiry fires when token of request expires. It never fires if expiry is unknown
+func tokenExpiry(ctx *gin.Context) (<-chan time.Time, func()) {
+	value, exists := ctx.Get("token_expires_at")
+	expiresAt, ok := value.(time.Time)
+	if !exists || !ok {
+		return nil, func() {}
+	}
+
+	timer := time.NewTimer(time.Until(expiresAt))
+	return timer.C, func() { timer.Stop() }
+}
+
+// nextEvent returns event which is waiting already, it doesn't block
+func nextEvent(connection *services.Connection) (domain.RealtimeEvent, bool) {
+	select {
+	case event, ok := <-connection.Events:
+		return event, ok
+	default:
+		return domain.RealtimeEvent{}, false
+	}
+}
+
+func writeSSEvent(w io.Writer, event *domain.RealtimeEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	// Event without ID doesn't change Last-Event-ID of EventSource
+	if event.ID != "" {
+		if _, err := fmt.Fprintf(w, "id: %s\n", event.ID); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+	return err
+}
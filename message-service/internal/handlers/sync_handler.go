@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"message-service/internal/services"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SyncHandler struct {
+	syncService *services.SyncService
+}
+
+func NewSyncHandler(syncService *services.SyncService) *SyncHandler {
+	return &SyncHandler{syncService: syncService}
+}
+
+// Sync returns what happened to user after sequence number since (0 - from the beginning)
+func (h *SyncHandler) Sync(ctx *gin.Context) {
+
+	userIDString, exists := ctx.Get("user_id")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized request"})
+		return
+	}
+
+	userID, ok := userIDString.(int)
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	var since int64
+	if sinceString := ctx.Query("since"); sinceString != "" {
+		var err error
+		if since, err = strconv.ParseInt(sinceString, 10, 64); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input data", "details": "since must be a number"})
+			return
+		}
+	}
+
+	var limit int
+	if limitString := ctx.Query("limit"); limitString != "" {
+		var err error
+		if limit, err = strconv.Atoi(limitString); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input data", "details": "limit must be a number"})
+			return
+		}
+	}
+
+	page, apiErr := h.syncService.GetSync(ctx.Request.Context(), userID, since, limit)
+	if apiErr != nil {
+		ctx.JSON(apiErr.Code, gin.H{"details": apiErr.Details, "error": apiErr.Message})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, page)
+}
@@ -4,8 +4,11 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"message-service/internal/domain"
+	"message-service/internal/utils"
 	"net"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
 
@@ -14,11 +17,20 @@ import (
 
 type TokenValidationResponse struct {
 	UserID int    `json:"user_id"`
+	Role   string `json:"role"`
 	Status string `json:"valid"`
+	// Unix time, long connections are closed then
+	ExpiresAt int64 `json:"expires_at"`
 }
 
-// TokenValidationMiddleware checks token from header by sendind request to auth service
-func TokenValidationMiddleware(validationServiceURL string) gin.HandlerFunc {
+// TicketRedeemer checks one-time tickets of realtime connections
+type TicketRedeemer interface {
+	RedeemTicket(ctx context.Context, id string) (*domain.RealtimeTicket, *utils.APIError)
+}
+
+// TokenValidationMiddleware checks token from header by sendind request to auth service.
+// Token must be issued for our audience (see token exchange in auth service), session tokens are rejected
+func TokenValidationMiddleware(validationServiceURL string, audience string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Get token from Authorization header
 		authHeader := c.GetHeader("Authorization")
@@ -44,7 +56,7 @@ func TokenValidationMiddleware(validationServiceURL string) gin.HandlerFunc {
 		// Prepare HTTP request
 
 		client := &http.Client{}
-		req, err := http.NewRequestWithContext(ctx, "GET", validationServiceURL+"/auth/validate", nil)
+		req, err := http.NewRequestWithContext(ctx, "GET", validationServiceURL+"/auth/validate?audience="+url.QueryEscape(audience), nil)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create validation request"})
 			c.Abort()
@@ -98,8 +110,44 @@ func TokenValidationMiddleware(validationServiceURL string) gin.HandlerFunc {
 			return
 		}
 
-		// Set user id in context, so then we can use it in handlers
+		// Set user id and role in context, so then we can use it in handlers
 		c.Set("user_id", validationResponse.UserID)
+		c.Set("user_role", domain.UserRole(validationResponse.Role))
+		if validationResponse.ExpiresAt > 0 {
+			c.Set("token_expires_at", time.Unix(validationResponse.ExpiresAt, 0))
+		}
+		c.Next()
+	}
+}
+
+// TicketMiddleware lets browsers open WebSocket and EventSource, which can't set headers, with one-time ticket
+// in ticket query. Token itself never goes to URL, so it doesn't end up in access logs.
+// Requests with Authorization header are checked by tokenValidation
+func TicketMiddleware(tickets TicketRedeemer, tokenValidation gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Query("ticket")
+		if c.GetHeader("Authorization") != "" || id == "" {
+			tokenValidation(c)
+			return
+		}
+
+		ticket, apiErr := tickets.RedeemTicket(c.Request.Context(), id)
+		if apiErr != nil {
+			c.JSON(apiErr.Code, gin.H{"details": apiErr.Details, "error": apiErr.Message})
+			c.Abort()
+			return
+		}
+		if ticket == nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid ticket"})
+			c.Abort()
+			return
+		}
+
+		c.Set("user_id", ticket.UserID)
+		c.Set("user_role", ticket.Role)
+		if !ticket.TokenExpiresAt.IsZero() {
+			c.Set("token_expires_at", ticket.TokenExpiresAt)
+		}
 		c.Next()
 	}
 }
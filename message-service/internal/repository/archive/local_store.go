@@ -0,0 +1,74 @@
+package repositories
+
+import (
+	"context"
+	"io"
+	"message-service/internal/utils"
+	"os"
+	"path/filepath"
+	"strings"
+
+	logger "message-service/internal"
+
+	"go.uber.org/zap"
+)
+
+const localLocationPrefix = "file://"
+
+// LocalArchiveStore keeps archive files in local directory (or mounted volume)
+type LocalArchiveStore struct {
+	dir string
+}
+
+func NewLocalArchiveStore(dir string) (*LocalArchiveStore, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+	return &LocalArchiveStore{dir: dir}, nil
+}
+
+func (s *LocalArchiveStore) Put(ctx context.Context, key string, file io.ReadSeeker, size int64) (string, *utils.APIError) {
+	path := filepath.Join(s.dir, filepath.Base(key))
+
+	// Written under temporary name, so half-written file never looks like archive
+	tmp, err := os.CreateTemp(s.dir, filepath.Base(key)+".*.tmp")
+	if err != nil {
+		logger.Error("Cannot create archive file", zap.String("Key", key), zap.Error(err))
+		return "", utils.NewAPIError(500, "Cannot save archive", err.Error())
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, file); err != nil {
+		tmp.Close()
+		logger.Error("Cannot write archive file", zap.String("Key", key), zap.Error(err))
+		return "", utils.NewAPIError(500, "Cannot save archive", err.Error())
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return "", utils.NewAPIError(500, "Cannot save archive", err.Error())
+	}
+	if err := tmp.Close(); err != nil {
+		return "", utils.NewAPIError(500, "Cannot save archive", err.Error())
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		logger.Error("Cannot move archive file", zap.String("Key", key), zap.Error(err))
+		return "", utils.NewAPIError(500, "Cannot save archive", err.Error())
+	}
+
+	return localLocationPrefix + path, nil
+}
+
+func (s *LocalArchiveStore) Get(ctx context.Context, location string) (io.ReadCloser, *utils.APIError) {
+	if !strings.HasPrefix(location, localLocationPrefix) {
+		return nil, utils.NewAPIError(500, "Archive is kept in another store", location)
+	}
+
+	file, err := os.Open(strings.TrimPrefix(location, localLocationPrefix))
+	if err != nil {
+		logger.Error("Cannot open archive file", zap.String("Location", location), zap.Error(err))
+		return nil, utils.NewAPIError(500, "Cannot read archive", err.Error())
+	}
+
+	return file, nil
+}
@@ -0,0 +1,175 @@
+package repositories
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"message-service/internal/utils"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	logger "message-service/internal"
+
+	"go.uber.org/zap"
+)
+
+const s3LocationPrefix = "s3://"
+
+// S3ArchiveStore keeps archive files in S3-compatible bucket (AWS, MinIO, ...).
+// Requests are signed with AWS Signature Version 4, we need only two calls, so no SDK
+type S3ArchiveStore struct {
+	client    *http.Client
+	endpoint  *url.URL
+	bucket    string
+	region    string
+	accessKey string
+	secretKey string
+}
+
+func NewS3ArchiveStore(endpoint, bucket, region, accessKey, secretKey string) (*S3ArchiveStore, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint %q", endpoint)
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	return &S3ArchiveStore{
+		client:    &http.Client{},
+		endpoint:  u,
+		bucket:    bucket,
+		region:    region,
+		accessKey: accessKey,
+		secretKey: secretKey,
+	}, nil
+}
+
+func (s *S3ArchiveStore) Put(ctx context.Context, key string, file io.ReadSeeker, size int64) (string, *utils.APIError) {
+	// Signature needs hash of the whole body, so file is read twice
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", utils.NewAPIError(500, "Cannot read archive", err.Error())
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", utils.NewAPIError(500, "Cannot read archive", err.Error())
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.objectURL(key), io.NopCloser(file))
+	if err != nil {
+		return "", utils.NewAPIError(500, "Failed to create archive request", err.Error())
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/gzip")
+	s.sign(req, hex.EncodeToString(hash.Sum(nil)), time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		logger.Error("Cannot upload archive", zap.String("Key", key), zap.Error(err))
+		return "", utils.NewAPIError(503, "Archive store unavailable", err.Error())
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		logger.Error("Archive store refused upload",
+			zap.String("Key", key),
+			zap.Int("Status", resp.StatusCode),
+			zap.String("Body", string(body)))
+		return "", utils.NewAPIError(502, "Cannot save archive", fmt.Sprintf("Archive store answered %d", resp.StatusCode))
+	}
+
+	return s3LocationPrefix + s.bucket + "/" + key, nil
+}
+
+func (s *S3ArchiveStore) Get(ctx context.Context, location string) (io.ReadCloser, *utils.APIError) {
+	key, ok := strings.CutPrefix(location, s3LocationPrefix+s.bucket+"/")
+	if !ok {
+		return nil, utils.NewAPIError(500, "Archive is kept in another store", location)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.objectURL(key), nil)
+	if err != nil {
+		return nil, utils.NewAPIError(500, "Failed to create archive request", err.Error())
+	}
+	s.sign(req, emptyPayloadHash, time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		logger.Error("Cannot download archive", zap.String("Location", location), zap.Error(err))
+		return nil, utils.NewAPIError(503, "Archive store unavailable", err.Error())
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		logger.Error("Archive store refused download",
+			zap.String("Location", location),
+			zap.Int("Status", resp.StatusCode))
+		return nil, utils.NewAPIError(502, "Cannot read archive", fmt.Sprintf("Archive store answered %d", resp.StatusCode))
+	}
+
+	return resp.Body, nil
+}
+
+// Path-style URL (endpoint/bucket/key), works with MinIO and AWS
+func (s *S3ArchiveStore) objectURL(key string) string {
+	u := *s.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.bucket + "/" + key
+	return u.String()
+}
+
+// sha256 of empty string
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// sign adds AWS Signature Version 4 to request.
+// See https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-header-based-auth.html
+func (s *S3ArchiveStore) sign(req *http.Request, payloadHash string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("Host", req.URL.Host)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalHeaders := "host:" + req.URL.Host + "\n" +
+		"x-amz-content-sha256:" + payloadHash + "\n" +
+		"x-amz-date:" + amzDate + "\n"
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), date)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.accessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
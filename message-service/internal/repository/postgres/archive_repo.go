@@ -0,0 +1,287 @@
+package repositories
+
+import (
+	"context"
+	"message-service/internal/domain"
+	"message-service/internal/utils"
+	"strconv"
+	"time"
+
+	logger "message-service/internal"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+const (
+	archiveEntryColumns = "id, table_name, range_start, range_end, status, format, location, message_count, created_at, archived_at"
+	archiveFileColumns  = "id, archive_id, conversation_id, key, location, message_count, user_ids"
+	// Columns of domain.ArchivedMessageChange in order of queryChanges
+	archivedChangeColumns = "message_id, message_timestamp, conversation_id, content, edited_at, deleted_at, deleted_by"
+)
+
+type PostgresArchiveIndexRepo struct {
+	db *pgxpool.Pool
+}
+
+func NewPostgresArchiveIndexRepo(db *pgxpool.Pool) *PostgresArchiveIndexRepo {
+	return &PostgresArchiveIndexRepo{db: db}
+}
+
+func (r *PostgresArchiveIndexRepo) GetPendingArchives(ctx context.Context, limit int) ([]domain.ArchiveEntry, *utils.APIError) {
+	query := "SELECT " + archiveEntryColumns + " FROM archive_index WHERE status = $1 ORDER BY range_start LIMIT $2"
+	return r.queryEntries(ctx, query, domain.ArchiveDetached, limit)
+}
+
+func (r *PostgresArchiveIndexRepo) MarkArchived(ctx context.Context, id int, files []domain.ArchiveFile) *utils.APIError {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		logger.Error("Cannot begin transaction", zap.Error(err))
+		return ClassifyDBerror(err)
+	}
+	defer tx.Rollback(ctx)
+
+	var messages int64
+	for _, file := range files {
+		messages += file.Messages
+		_, err = tx.Exec(ctx, `INSERT INTO archive_files (archive_id, conversation_id, key, location, message_count, user_ids)
+			VALUES ($1, $2, $3, $4, $5, $6)`,
+			id, file.ConversationID, file.Key, file.Location, file.Messages, file.UserIDs)
+		if err != nil {
+			break
+		}
+	}
+
+	if err == nil {
+		_, err = tx.Exec(ctx, `UPDATE archive_index
+			SET status = $1, message_count = $2, archived_at = NOW() AT TIME ZONE 'UTC'
+			WHERE id = $3`, domain.ArchiveArchived, messages, id)
+	}
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+
+	if err != nil {
+		logger.Error("Cannot mark partition as archived",
+			zap.Int("Archive ID", id),
+			zap.Error(err))
+		return ClassifyDBerror(err)
+	}
+	return nil
+}
+
+func (r *PostgresArchiveIndexRepo) GetArchivesInRange(ctx context.Context, start, end time.Time) ([]domain.ArchiveEntry, *utils.APIError) {
+	query := "SELECT " + archiveEntryColumns + " FROM archive_index WHERE status = $1"
+	args := []any{domain.ArchiveArchived}
+
+	if !start.IsZero() {
+		args = append(args, start)
+		query += " AND range_end > $" + strconv.Itoa(len(args))
+	}
+	if !end.IsZero() {
+		args = append(args, end)
+		query += " AND range_start < $" + strconv.Itoa(len(args))
+	}
+	query += " ORDER BY range_start"
+
+	return r.queryEntries(ctx, query, args...)
+}
+
+func (r *PostgresArchiveIndexRepo) GetArchiveFiles(ctx context.Context, archiveID int, conversationID int) ([]domain.ArchiveFile, *utils.APIError) {
+	query := "SELECT " + archiveFileColumns + ` FROM archive_files
+		WHERE archive_id = $1 AND ($2 = 0 OR conversation_id = $2 OR conversation_id IS NULL)
+		ORDER BY id`
+	return r.queryFiles(ctx, query, archiveID, conversationID)
+}
+
+func (r *PostgresArchiveIndexRepo) GetUserArchiveFiles(ctx context.Context, userID int, conversationIDs []int) ([]domain.ArchiveFile, *utils.APIError) {
+	query := `SELECT f.id, f.archive_id, f.conversation_id, f.key, f.location, f.message_count, f.user_ids FROM archive_files f
+		JOIN archive_index a ON a.id = f.archive_id
+		WHERE f.user_ids @> ARRAY[$1::int] OR f.user_ids IS NULL OR f.conversation_id = ANY($2::int[])
+		ORDER BY a.range_start, f.id`
+	return r.queryFiles(ctx, query, userID, conversationIDs)
+}
+
+func (r *PostgresArchiveIndexRepo) UpdateArchiveFile(ctx context.Context, file *domain.ArchiveFile) *utils.APIError {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		logger.Error("Cannot begin transaction", zap.Error(err))
+		return ClassifyDBerror(err)
+	}
+	defer tx.Rollback(ctx)
+
+	// Count of partition goes down by messages which were removed from file
+	var old int64
+	err = tx.QueryRow(ctx, "SELECT message_count FROM archive_files WHERE id = $1 FOR UPDATE", file.ID).Scan(&old)
+	if err == nil {
+		_, err = tx.Exec(ctx, "UPDATE archive_files SET location = $1, message_count = $2, user_ids = $3 WHERE id = $4",
+			file.Location, file.Messages, file.UserIDs, file.ID)
+	}
+	if err == nil {
+		_, err = tx.Exec(ctx, "UPDATE archive_index SET message_count = message_count - $1 WHERE id = $2",
+			old-file.Messages, file.ArchiveID)
+	}
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+
+	if err != nil {
+		logger.Error("Cannot update archive file",
+			zap.Int("Archive ID", file.ArchiveID),
+			zap.String("Key", file.Key),
+			zap.Error(err))
+		return ClassifyDBerror(err)
+	}
+	return nil
+}
+
+func (r *PostgresArchiveIndexRepo) GetArchivedMessageChanges(ctx context.Context, messageIDs []int) (map[int]domain.ArchivedMessageChange, *utils.APIError) {
+	changes := make(map[int]domain.ArchivedMessageChange)
+	if len(messageIDs) == 0 {
+		return changes, nil
+	}
+
+	list, apiErr := r.queryChanges(ctx, "SELECT "+archivedChangeColumns+" FROM archived_message_changes WHERE message_id = ANY($1)", messageIDs)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+
+	for _, change := range list {
+		changes[change.MessageID] = change
+	}
+	return changes, nil
+}
+
+func (r *PostgresArchiveIndexRepo) GetDeletedArchivedMessages(ctx context.Context, before time.Time, limit int) ([]domain.ArchivedMessageChange, *utils.APIError) {
+	query := "SELECT " + archivedChangeColumns + ` FROM archived_message_changes
+		WHERE deleted_at IS NOT NULL AND deleted_at < $1
+		ORDER BY deleted_at
+		LIMIT $2`
+	return r.queryChanges(ctx, query, before, limit)
+}
+
+func (r *PostgresArchiveIndexRepo) DeleteArchivedMessageChanges(ctx context.Context, changes []domain.ArchivedMessageChange) *utils.APIError {
+	ids := make([]int, 0, len(changes))
+	timestamps := make([]time.Time, 0, len(changes))
+	for _, change := range changes {
+		ids = append(ids, change.MessageID)
+		timestamps = append(timestamps, change.Timestamp)
+	}
+
+	// Tombstone in file is all what is left, hides of it go too, as they do for purged messages in Postgres
+	_, err := r.db.Exec(ctx, `WITH removed AS (
+			DELETE FROM archived_message_changes c
+			USING unnest($1::int[], $2::timestamp[]) AS d(message_id, message_timestamp)
+			WHERE c.message_id = d.message_id AND c.message_timestamp = d.message_timestamp
+			RETURNING c.message_id, c.message_timestamp
+		)
+		DELETE FROM message_hides h USING removed r
+		WHERE h.message_id = r.message_id AND h.message_timestamp = r.message_timestamp`, ids, timestamps)
+	if err != nil {
+		logger.Error("Cannot delete changes of archived messages", zap.Error(err))
+		return ClassifyDBerror(err)
+	}
+	return nil
+}
+
+func (r *PostgresArchiveIndexRepo) queryChanges(ctx context.Context, query string, args ...any) ([]domain.ArchivedMessageChange, *utils.APIError) {
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		logger.Error("Cannot get changes of archived messages", zap.Error(err))
+		return nil, ClassifyDBerror(err)
+	}
+	defer rows.Close()
+
+	var changes []domain.ArchivedMessageChange
+	for rows.Next() {
+		var change domain.ArchivedMessageChange
+		if err := rows.Scan(
+			&change.MessageID,
+			&change.Timestamp,
+			&change.ConversationID,
+			&change.Content,
+			&change.EditedAt,
+			&change.DeletedAt,
+			&change.DeletedBy,
+		); err != nil {
+			return nil, ClassifyDBerror(err)
+		}
+		changes = append(changes, change)
+	}
+
+	if err := rows.Err(); err != nil {
+		logger.Error("Cannot read changes of archived messages", zap.Error(err))
+		return nil, ClassifyDBerror(err)
+	}
+
+	return changes, nil
+}
+
+func (r *PostgresArchiveIndexRepo) queryFiles(ctx context.Context, query string, args ...any) ([]domain.ArchiveFile, *utils.APIError) {
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		logger.Error("Cannot get archive files", zap.Error(err))
+		return nil, ClassifyDBerror(err)
+	}
+	defer rows.Close()
+
+	var files []domain.ArchiveFile
+	for rows.Next() {
+		var file domain.ArchiveFile
+		if err := rows.Scan(
+			&file.ID,
+			&file.ArchiveID,
+			&file.ConversationID,
+			&file.Key,
+			&file.Location,
+			&file.Messages,
+			&file.UserIDs,
+		); err != nil {
+			return nil, ClassifyDBerror(err)
+		}
+		files = append(files, file)
+	}
+
+	if err := rows.Err(); err != nil {
+		logger.Error("Cannot read archive files", zap.Error(err))
+		return nil, ClassifyDBerror(err)
+	}
+
+	return files, nil
+}
+
+func (r *PostgresArchiveIndexRepo) queryEntries(ctx context.Context, query string, args ...any) ([]domain.ArchiveEntry, *utils.APIError) {
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		logger.Error("Cannot get archive index", zap.Error(err))
+		return nil, ClassifyDBerror(err)
+	}
+	defer rows.Close()
+
+	var entries []domain.ArchiveEntry
+	for rows.Next() {
+		var entry domain.ArchiveEntry
+		if err := rows.Scan(
+			&entry.ID,
+			&entry.TableName,
+			&entry.RangeStart,
+			&entry.RangeEnd,
+			&entry.Status,
+			&entry.Format,
+			&entry.Location,
+			&entry.Messages,
+			&entry.CreatedAt,
+			&entry.ArchivedAt,
+		); err != nil {
+			return nil, ClassifyDBerror(err)
+		}
+		entries = append(entries, entry)
+	}
+
+	if err := rows.Err(); err != nil {
+		logger.Error("Cannot read archive index", zap.Error(err))
+		return nil, ClassifyDBerror(err)
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,594 @@
+package repositories
+
+import (
+	"context"
+	"message-service/internal/domain"
+	"message-service/internal/utils"
+	"time"
+
+	logger "message-service/internal"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+// How many characters of last message are shown in inbox
+const previewLength = 100
+
+type PostgresConversationRepo struct {
+	db *pgxpool.Pool
+}
+
+func NewPostgresConversationRepo(db *pgxpool.Pool) *PostgresConversationRepo {
+	return &PostgresConversationRepo{db: db}
+}
+
+func (r *PostgresConversationRepo) GetOrCreateDirectConversation(ctx context.Context, user1ID, user2ID int) (*domain.Conversation, *utils.APIError) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		logger.Error("Cannot begin transaction", zap.Error(err))
+		return nil, ClassifyDBerror(err)
+	}
+	defer tx.Rollback(ctx)
+
+	key := domain.ConversationKey(user1ID, user2ID)
+	conversation := &domain.Conversation{Type: domain.DirectConversation, DirectKey: &key}
+
+	// Direct key is unique, so two first messages at once still make one conversation
+	query := `INSERT INTO conversations (type, direct_key) VALUES ($1, $2)
+		ON CONFLICT (direct_key) DO NOTHING
+		RETURNING id, created_at`
+
+	err = tx.QueryRow(ctx, query, string(domain.DirectConversation), key).Scan(&conversation.ID, &conversation.CreatedAt)
+	if err == pgx.ErrNoRows {
+		// Somebody created it already
+		tx.Rollback(ctx)
+		conversation, apiErr := r.GetDirectConversation(ctx, user1ID, user2ID)
+		if apiErr == nil && conversation == nil {
+			// And deleted it right away
+			return nil, utils.NewAPIError(409, "Conversation was changed", "Please try again")
+		}
+		return conversation, apiErr
+	}
+
+	if err == nil {
+		_, err = tx.Exec(ctx, `INSERT INTO conversation_participants (conversation_id, user_id, joined_at) VALUES ($1, $2, $4), ($1, $3, $4)`,
+			conversation.ID, user1ID, user2ID, conversation.CreatedAt)
+	}
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+
+	if err != nil {
+		logger.Error("Cannot create conversation",
+			zap.Int("User 1", user1ID),
+			zap.Int("User 2", user2ID),
+			zap.Error(err))
+		// User not found error
+		if pgErr, ok := err.(*pgconn.PgError); ok && pgErr.Code == "23503" {
+			return nil, utils.NewAPIError(404, "User does not exist", "")
+		}
+		return nil, ClassifyDBerror(err)
+	}
+
+	conversation.Participants = []domain.Participant{
+		{UserID: user1ID, JoinedAt: conversation.CreatedAt},
+		{UserID: user2ID, JoinedAt: conversation.CreatedAt},
+	}
+	return conversation, nil
+}
+
+func (r *PostgresConversationRepo) GetDirectConversation(ctx context.Context, user1ID, user2ID int) (*domain.Conversation, *utils.APIError) {
+	return r.getConversation(ctx, "c.direct_key = $1", domain.ConversationKey(user1ID, user2ID), user1ID)
+}
+
+func (r *PostgresConversationRepo) GetConversation(ctx context.Context, conversationID, userID int) (*domain.Conversation, *utils.APIError) {
+	return r.getConversation(ctx, "c.id = $1", conversationID, userID)
+}
+
+func (r *PostgresConversationRepo) getConversation(ctx context.Context, condition string, arg any, userID int) (*domain.Conversation, *utils.APIError) {
+	query := `SELECT c.id, c.type, COALESCE(c.title, ''), COALESCE(c.avatar_url, ''), COALESCE(c.created_by, 0), c.history_visible, c.direct_key,
+			c.subscriber_count, c.created_at
+		FROM conversations c
+		WHERE ` + condition
+
+	var conversation domain.Conversation
+	var conversationType string
+	err := r.db.QueryRow(ctx, query, arg).Scan(
+		&conversation.ID,
+		&conversationType,
+		&conversation.Title,
+		&conversation.AvatarURL,
+		&conversation.CreatedBy,
+		&conversation.HistoryVisible,
+		&conversation.DirectKey,
+		&conversation.SubscriberCount,
+		&conversation.CreatedAt,
+	)
+
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, nil
+		}
+		logger.Error("Cannot get conversation", zap.Any("Conversation", arg), zap.Error(err))
+		return nil, ClassifyDBerror(err)
+	}
+	conversation.Type = domain.ConversationType(conversationType)
+
+	// Subscribers of channel are not loaded, except user himself
+	participantsQuery := `SELECT user_id, role, joined_at, last_read_id, last_read_at
+		FROM conversation_participants
+		WHERE conversation_id = $1 AND ($2 <> 'channel' OR role <> 'member' OR user_id = $3)
+		ORDER BY joined_at, user_id`
+
+	rows, err := r.db.Query(ctx, participantsQuery, conversation.ID, conversationType, userID)
+	if err != nil {
+		logger.Error("Cannot get participants", zap.Int("Conversation ID", conversation.ID), zap.Error(err))
+		return nil, ClassifyDBerror(err)
+	}
+	defer rows.Close()
+
+	conversation.Participants = []domain.Participant{}
+	for rows.Next() {
+		var participant domain.Participant
+		var role string
+		if err := rows.Scan(&participant.UserID, &role, &participant.JoinedAt, &participant.LastReadID, &participant.LastReadAt); err != nil {
+			return nil, ClassifyDBerror(err)
+		}
+		if conversation.Type != domain.DirectConversation {
+			participant.Role = domain.ConversationRole(role)
+		}
+		conversation.Participants = append(conversation.Participants, participant)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, ClassifyDBerror(err)
+	}
+
+	return &conversation, nil
+}
+
+func (r *PostgresConversationRepo) CreateConversation(ctx context.Context, group *domain.Conversation, memberIDs []int) (*domain.Conversation, *utils.APIError) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		logger.Error("Cannot begin transaction", zap.Error(err))
+		return nil, ClassifyDBerror(err)
+	}
+	defer tx.Rollback(ctx)
+
+	query := `INSERT INTO conversations (type, title, avatar_url, created_by, history_visible) VALUES ($1, $2, NULLIF($3, ''), $4, $5)
+		RETURNING id, created_at`
+
+	err = tx.QueryRow(ctx, query, string(group.Type), group.Title, group.AvatarURL, group.CreatedBy, group.HistoryVisible).
+		Scan(&group.ID, &group.CreatedAt)
+
+	if err == nil {
+		_, err = tx.Exec(ctx, `INSERT INTO conversation_participants (conversation_id, user_id, joined_at, role)
+			SELECT $1, m, $3, CASE WHEN m = $4 THEN 'owner' ELSE 'member' END
+			FROM unnest($2::int[]) m`, group.ID, memberIDs, group.CreatedAt, group.CreatedBy)
+	}
+	// Everybody except creator is a member
+	if err == nil {
+		err = changeSubscriberCount(ctx, tx, group.ID, int64(len(memberIDs)-1))
+	}
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+
+	if err != nil {
+		logger.Error("Cannot create conversation",
+			zap.String("Type", string(group.Type)),
+			zap.Int("Creator ID", group.CreatedBy),
+			zap.Error(err))
+		// User not found error
+		if pgErr, ok := err.(*pgconn.PgError); ok && pgErr.Code == "23503" {
+			return nil, utils.NewAPIError(404, "User does not exist", "")
+		}
+		return nil, ClassifyDBerror(err)
+	}
+
+	group.Participants = make([]domain.Participant, 0, len(memberIDs))
+	for _, memberID := range memberIDs {
+		role := domain.ConversationMember
+		if memberID == group.CreatedBy {
+			role = domain.ConversationOwner
+		}
+		group.Participants = append(group.Participants, domain.Participant{UserID: memberID, Role: role, JoinedAt: group.CreatedAt})
+	}
+	return group, nil
+}
+
+func (r *PostgresConversationRepo) AddParticipants(ctx context.Context, conversationID int, userIDs []int) (int64, *utils.APIError) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		logger.Error("Cannot begin transaction", zap.Error(err))
+		return 0, ClassifyDBerror(err)
+	}
+	defer tx.Rollback(ctx)
+
+	// New channel subscribers start with everything read
+	query := `INSERT INTO conversation_participants (conversation_id, user_id, last_read_seq)
+		SELECT $1, m, (SELECT message_count FROM conversations WHERE id = $1) FROM unnest($2::int[]) m
+		WHERE NOT EXISTS (SELECT 1 FROM conversation_bans b WHERE b.conversation_id = $1 AND b.user_id = m)
+		ON CONFLICT (conversation_id, user_id) DO NOTHING`
+
+	tag, err := tx.Exec(ctx, query, conversationID, userIDs)
+	if err == nil {
+		err = changeSubscriberCount(ctx, tx, conversationID, tag.RowsAffected())
+	}
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+
+	if err != nil {
+		logger.Error("Cannot add participants",
+			zap.Int("Conversation ID", conversationID),
+			zap.Error(err))
+		// User not found error
+		if pgErr, ok := err.(*pgconn.PgError); ok && pgErr.Code == "23503" {
+			return 0, utils.NewAPIError(404, "User does not exist", "")
+		}
+		return 0, ClassifyDBerror(err)
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+func (r *PostgresConversationRepo) RemoveParticipant(ctx context.Context, conversationID, userID int) (bool, *utils.APIError) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		logger.Error("Cannot begin transaction", zap.Error(err))
+		return false, ClassifyDBerror(err)
+	}
+	defer tx.Rollback(ctx)
+
+	var role string
+	err = tx.QueryRow(ctx, `DELETE FROM conversation_participants WHERE conversation_id = $1 AND user_id = $2 RETURNING role`,
+		conversationID, userID).Scan(&role)
+	removed := err == nil
+	if err == pgx.ErrNoRows {
+		err = nil
+	}
+	// Conversation disappears from his inbox
+	if err == nil && removed {
+		_, err = tx.Exec(ctx, `DELETE FROM conversation_summaries WHERE conversation_id = $1 AND user_id = $2`, conversationID, userID)
+	}
+	if err == nil && removed {
+		err = changeSubscriberCount(ctx, tx, conversationID, subscriberDelta(role, ""))
+	}
+	// Nobody can read group without members, it goes away with its messages. Summaries, bans and invites
+	// are removed by foreign keys, messages don't have one
+	var emptied pgconn.CommandTag
+	if err == nil && removed {
+		emptied, err = tx.Exec(ctx, `DELETE FROM conversations c
+			WHERE c.id = $1 AND c.type = 'group'
+				AND NOT EXISTS (SELECT 1 FROM conversation_participants p WHERE p.conversation_id = c.id)`, conversationID)
+	}
+	if err == nil && emptied.RowsAffected() > 0 {
+		_, err = tx.Exec(ctx, `WITH deleted AS (
+				DELETE FROM messages WHERE conversation_id = $1 RETURNING id, timestamp
+			), edits AS (
+				DELETE FROM message_edits e USING deleted d WHERE e.message_id = d.id AND e.message_timestamp = d.timestamp
+			)
+			DELETE FROM message_hides h USING deleted d WHERE h.message_id = d.id AND h.message_timestamp = d.timestamp`,
+			conversationID)
+	}
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+
+	if err != nil {
+		logger.Error("Cannot remove participant",
+			zap.Int("Conversation ID", conversationID),
+			zap.Int("User ID", userID),
+			zap.Error(err))
+		return false, ClassifyDBerror(err)
+	}
+
+	return removed, nil
+}
+
+func (r *PostgresConversationRepo) GetParticipant(ctx context.Context, conversationID, userID int) (*domain.Participant, *utils.APIError) {
+	query := `SELECT p.user_id, p.role, p.joined_at, p.last_read_id, p.last_read_at, c.type
+		FROM conversation_participants p
+		JOIN conversations c ON c.id = p.conversation_id
+		WHERE p.conversation_id = $1 AND p.user_id = $2`
+
+	var participant domain.Participant
+	var role, conversationType string
+	err := r.db.QueryRow(ctx, query, conversationID, userID).Scan(
+		&participant.UserID, &role, &participant.JoinedAt, &participant.LastReadID, &participant.LastReadAt, &conversationType)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, nil
+		}
+		logger.Error("Cannot get participant",
+			zap.Int("Conversation ID", conversationID),
+			zap.Int("User ID", userID),
+			zap.Error(err))
+		return nil, ClassifyDBerror(err)
+	}
+	if domain.ConversationType(conversationType) != domain.DirectConversation {
+		participant.Role = domain.ConversationRole(role)
+	}
+
+	return &participant, nil
+}
+
+func (r *PostgresConversationRepo) GetConversationPeers(ctx context.Context, userID int, userIDs []int) (map[int]bool, *utils.APIError) {
+	peers := make(map[int]bool)
+	if len(userIDs) == 0 {
+		return peers, nil
+	}
+
+	query := `SELECT DISTINCT other.user_id
+		FROM conversation_participants me
+		JOIN conversations c ON c.id = me.conversation_id
+		JOIN conversation_participants other ON other.conversation_id = me.conversation_id
+		WHERE me.user_id = $1 AND c.type <> 'channel' AND other.user_id = ANY($2)`
+
+	rows, err := r.db.Query(ctx, query, userID, userIDs)
+	if err != nil {
+		logger.Error("Cannot get conversation peers",
+			zap.Int("User ID", userID),
+			zap.Error(err))
+		return nil, ClassifyDBerror(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var peerID int
+		if err := rows.Scan(&peerID); err != nil {
+			return nil, ClassifyDBerror(err)
+		}
+		peers[peerID] = true
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, ClassifyDBerror(err)
+	}
+
+	return peers, nil
+}
+
+func (r *PostgresConversationRepo) GetParticipantIDs(ctx context.Context, conversationID int) ([]int, *utils.APIError) {
+	rows, err := r.db.Query(ctx, `SELECT user_id FROM conversation_participants WHERE conversation_id = $1`, conversationID)
+	if err != nil {
+		logger.Error("Cannot get participants",
+			zap.Int("Conversation ID", conversationID),
+			zap.Error(err))
+		return nil, ClassifyDBerror(err)
+	}
+	defer rows.Close()
+
+	userIDs := []int{}
+	for rows.Next() {
+		var userID int
+		if err := rows.Scan(&userID); err != nil {
+			return nil, ClassifyDBerror(err)
+		}
+		userIDs = append(userIDs, userID)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, ClassifyDBerror(err)
+	}
+
+	return userIDs, nil
+}
+
+func (r *PostgresConversationRepo) GetUserGroups(ctx context.Context, userID int) (map[int]time.Time, *utils.APIError) {
+	query := `SELECT p.conversation_id, CASE WHEN c.history_visible THEN NULL ELSE p.joined_at END
+		FROM conversation_participants p
+		JOIN conversations c ON c.id = p.conversation_id
+		WHERE p.user_id = $1 AND c.type = 'group'`
+
+	rows, err := r.db.Query(ctx, query, userID)
+	if err != nil {
+		logger.Error("Cannot get groups of user",
+			zap.Int("User ID", userID),
+			zap.Error(err))
+		return nil, ClassifyDBerror(err)
+	}
+	defer rows.Close()
+
+	groups := make(map[int]time.Time)
+	for rows.Next() {
+		var conversationID int
+		var visibleSince *time.Time
+		if err := rows.Scan(&conversationID, &visibleSince); err != nil {
+			return nil, ClassifyDBerror(err)
+		}
+		groups[conversationID] = time.Time{}
+		if visibleSince != nil {
+			groups[conversationID] = *visibleSince
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, ClassifyDBerror(err)
+	}
+
+	return groups, nil
+}
+
+func (r *PostgresConversationRepo) GetConversations(ctx context.Context, userID int, cursor *domain.ConversationCursor, limit int) ([]domain.ConversationSummary, *utils.APIError) {
+	// Channels have no inbox lines, their last message is kept in conversations table
+	// and unread messages are counted by read position of subscriber
+	query := `SELECT conversation_id, type, title, peer_id, last_message_id, last_sender_id, last_preview, last_message_at, unread_count
+		FROM (
+			SELECT s.conversation_id, c.type, COALESCE(c.title, '') AS title, COALESCE(s.peer_id, 0) AS peer_id,
+				s.last_message_id, s.last_sender_id, s.last_preview, s.last_message_at, s.unread_count
+			FROM conversation_summaries s
+			JOIN conversations c ON c.id = s.conversation_id
+			WHERE s.user_id = $1
+			UNION ALL
+			SELECT c.id, c.type, COALESCE(c.title, ''), 0,
+				c.last_message_id, c.last_sender_id, c.last_preview, c.last_message_at,
+				GREATEST(c.message_count - p.last_read_seq, 0)::INT
+			FROM conversation_participants p
+			JOIN conversations c ON c.id = p.conversation_id
+			WHERE p.user_id = $1 AND c.type = 'channel' AND c.last_message_at IS NOT NULL
+		) inbox`
+	args := []any{userID, limit}
+
+	if cursor != nil {
+		query += ` WHERE (last_message_at, conversation_id) < ($3, $4)`
+		args = append(args, cursor.LastMessageAt, cursor.ConversationID)
+	}
+	query += ` ORDER BY last_message_at DESC, conversation_id DESC LIMIT $2`
+
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		logger.Error("Cannot get conversations",
+			zap.Int("User ID", userID),
+			zap.Error(err))
+		return nil, ClassifyDBerror(err)
+	}
+	defer rows.Close()
+
+	conversations := []domain.ConversationSummary{}
+	for rows.Next() {
+		var summary domain.ConversationSummary
+		var conversationType string
+		if err := rows.Scan(
+			&summary.ConversationID,
+			&conversationType,
+			&summary.Title,
+			&summary.PeerID,
+			&summary.LastMessage.MessageID,
+			&summary.LastMessage.SenderID,
+			&summary.LastMessage.Preview,
+			&summary.LastMessage.Timestamp,
+			&summary.UnreadCount,
+		); err != nil {
+			return nil, ClassifyDBerror(err)
+		}
+		summary.Type = domain.ConversationType(conversationType)
+		conversations = append(conversations, summary)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, ClassifyDBerror(err)
+	}
+
+	return conversations, nil
+}
+
+// Two messages can be sent at once, and the older one may commit later. It must not become the last one
+const newerThanSummary = `(EXCLUDED.last_message_at, EXCLUDED.last_message_id) > (conversation_summaries.last_message_at, conversation_summaries.last_message_id)`
+
+// updateSummariesOnSend puts new message on top of inbox of every participant, everybody except sender gets one more unread
+func updateSummariesOnSend(ctx context.Context, tx pgx.Tx, message *domain.Message) error {
+	// Peer is set only in direct conversation, where recipient is known
+	query := `INSERT INTO conversation_summaries
+			(user_id, conversation_id, peer_id, last_message_id, last_sender_id, last_preview, last_message_at, unread_count)
+		SELECT p.user_id, p.conversation_id,
+			CASE WHEN $2::int IS NULL THEN NULL WHEN p.user_id = $1 THEN $2 ELSE $1 END,
+			$3, $1, LEFT($4, $6), $5,
+			CASE WHEN p.user_id = $1 THEN 0 ELSE 1 END
+		FROM conversation_participants p
+		WHERE p.conversation_id = $7
+		ON CONFLICT (user_id, conversation_id) DO UPDATE
+		SET last_message_id = CASE WHEN ` + newerThanSummary + ` THEN EXCLUDED.last_message_id ELSE conversation_summaries.last_message_id END,
+			last_sender_id = CASE WHEN ` + newerThanSummary + ` THEN EXCLUDED.last_sender_id ELSE conversation_summaries.last_sender_id END,
+			last_preview = CASE WHEN ` + newerThanSummary + ` THEN EXCLUDED.last_preview ELSE conversation_summaries.last_preview END,
+			last_message_at = GREATEST(EXCLUDED.last_message_at, conversation_summaries.last_message_at),
+			unread_count = conversation_summaries.unread_count + EXCLUDED.unread_count`
+
+	_, err := tx.Exec(ctx, query, message.SenderID, nullableID(message.RecipientID), message.MessageID, message.Content, message.Timestamp, previewLength, message.ConversationID)
+	return err
+}
+
+// decrementUnread is called when user read count messages of conversation
+func decrementUnread(ctx context.Context, tx pgx.Tx, userID, conversationID int, count int64) error {
+	query := `UPDATE conversation_summaries SET unread_count = GREATEST(unread_count - $3, 0)
+		WHERE user_id = $1 AND conversation_id = $2`
+
+	_, err := tx.Exec(ctx, query, userID, conversationID, count)
+	return err
+}
+
+// decrementGroupUnread is called when read position of group member moved from (fromAt, fromID) to upTo.
+// Only messages between two positions are counted, so partitions outside of them are not touched.
+// Returns how many messages became read
+func decrementGroupUnread(ctx context.Context, tx pgx.Tx, userID, conversationID int, fromAt time.Time, fromID int, upTo *domain.Message) (int64, error) {
+	// Deleted messages left counters when they were deleted
+	query := `SELECT COUNT(*) FROM messages
+		WHERE conversation_id = $2 AND sender_id <> $1 AND deleted_at IS NULL
+			AND timestamp >= $3 AND timestamp <= $5
+			AND (timestamp, id) > ($3, $4) AND (timestamp, id) <= ($5, $6)`
+
+	var read int64
+	if err := tx.QueryRow(ctx, query, userID, conversationID, fromAt, fromID, upTo.Timestamp, upTo.MessageID).Scan(&read); err != nil {
+		return 0, err
+	}
+	if read == 0 {
+		return 0, nil
+	}
+
+	return read, decrementUnread(ctx, tx, userID, conversationID, read)
+}
+
+// decrementUnreadOnDelete takes deleted message out of unread counters of those who have not read it yet.
+// Channels count unread messages by numbers, deleted message keeps its number there
+func decrementUnreadOnDelete(ctx context.Context, tx pgx.Tx, message *domain.Message) error {
+	if message.Seq != nil {
+		return nil
+	}
+	if message.RecipientID != 0 {
+		if message.Status == string(domain.Watched) {
+			return nil
+		}
+		return decrementUnread(ctx, tx, message.RecipientID, message.ConversationID, 1)
+	}
+
+	// Member counted it if he joined before it and his read position is behind it. Rows are locked like
+	// in MarkConversationRead, so a read at the same time either moves position first or doesn't count it
+	query := `UPDATE conversation_summaries s SET unread_count = GREATEST(s.unread_count - 1, 0)
+		FROM (
+			SELECT user_id FROM conversation_participants
+			WHERE conversation_id = $1 AND user_id <> $2
+				AND (joined_at, 0) < ($3, $4)
+				AND (last_read_at IS NULL OR (last_read_at, last_read_id) < ($3, $4))
+			FOR UPDATE
+		) p
+		WHERE s.user_id = p.user_id AND s.conversation_id = $1`
+
+	_, err := tx.Exec(ctx, query, message.ConversationID, message.SenderID, message.Timestamp, message.MessageID)
+	return err
+}
+
+// changeSubscriberCount keeps number of channel members with member role, so it is not counted on every read.
+// Other conversations are not touched
+func changeSubscriberCount(ctx context.Context, tx pgx.Tx, conversationID int, delta int64) error {
+	if delta == 0 {
+		return nil
+	}
+
+	_, err := tx.Exec(ctx, `UPDATE conversations SET subscriber_count = subscriber_count + $2 WHERE id = $1 AND type = 'channel'`,
+		conversationID, delta)
+	return err
+}
+
+// subscriberDelta says how subscriber count changes when role of participant changes. Empty role means not a participant
+func subscriberDelta(oldRole, newRole string) int64 {
+	var delta int64
+	if oldRole == string(domain.ConversationMember) {
+		delta--
+	}
+	if newRole == string(domain.ConversationMember) {
+		delta++
+	}
+	return delta
+}
+
+// NULL instead of zero ID
+func nullableID(id int) any {
+	if id == 0 {
+		return nil
+	}
+	return id
+}
@@ -0,0 +1,367 @@
+package repositories
+
+import (
+	"context"
+	"message-service/internal/domain"
+	"message-service/internal/utils"
+
+	logger "message-service/internal"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+type PostgresGroupRepo struct {
+	db *pgxpool.Pool
+}
+
+func NewPostgresGroupRepo(db *pgxpool.Pool) *PostgresGroupRepo {
+	return &PostgresGroupRepo{db: db}
+}
+
+func (r *PostgresGroupRepo) UpdateGroup(ctx context.Context, group *domain.Conversation) *utils.APIError {
+	query := `UPDATE conversations SET title = $2, avatar_url = NULLIF($3, '') WHERE id = $1 AND type <> 'direct'`
+
+	if _, err := r.db.Exec(ctx, query, group.ID, group.Title, group.AvatarURL); err != nil {
+		logger.Error("Cannot update group",
+			zap.Int("Conversation ID", group.ID),
+			zap.Error(err))
+		return ClassifyDBerror(err)
+	}
+
+	return nil
+}
+
+func (r *PostgresGroupRepo) SetParticipantRole(ctx context.Context, conversationID, userID int, role domain.ConversationRole) (bool, *utils.APIError) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		logger.Error("Cannot begin transaction", zap.Error(err))
+		return false, ClassifyDBerror(err)
+	}
+	defer tx.Rollback(ctx)
+
+	var oldRole string
+	err = tx.QueryRow(ctx, `SELECT role FROM conversation_participants WHERE conversation_id = $1 AND user_id = $2 FOR UPDATE`,
+		conversationID, userID).Scan(&oldRole)
+	if err == pgx.ErrNoRows {
+		return false, nil
+	}
+	if err == nil {
+		_, err = tx.Exec(ctx, `UPDATE conversation_participants SET role = $3 WHERE conversation_id = $1 AND user_id = $2`,
+			conversationID, userID, string(role))
+	}
+	// Channel admins are not counted as subscribers
+	if err == nil {
+		err = changeSubscriberCount(ctx, tx, conversationID, subscriberDelta(oldRole, string(role)))
+	}
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+
+	if err != nil {
+		logger.Error("Cannot set role",
+			zap.Int("Conversation ID", conversationID),
+			zap.Int("User ID", userID),
+			zap.Error(err))
+		return false, ClassifyDBerror(err)
+	}
+
+	return true, nil
+}
+
+func (r *PostgresGroupRepo) TransferOwnership(ctx context.Context, conversationID, fromID, toID int) *utils.APIError {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		logger.Error("Cannot begin transaction", zap.Error(err))
+		return ClassifyDBerror(err)
+	}
+	defer tx.Rollback(ctx)
+
+	// New owner may have been a subscriber of channel
+	var oldRole string
+	err = tx.QueryRow(ctx, `SELECT role FROM conversation_participants WHERE conversation_id = $1 AND user_id = $2 FOR UPDATE`,
+		conversationID, toID).Scan(&oldRole)
+	if err == pgx.ErrNoRows {
+		return utils.NewAPIError(404, "Member not found", "")
+	}
+
+	// Both rows in one statement, so group always has one owner
+	query := `UPDATE conversation_participants
+		SET role = CASE WHEN user_id = $3 THEN 'owner' ELSE 'admin' END
+		WHERE conversation_id = $1 AND user_id IN ($2, $3)`
+
+	var tag pgconn.CommandTag
+	if err == nil {
+		tag, err = tx.Exec(ctx, query, conversationID, fromID, toID)
+	}
+	if err == nil && tag.RowsAffected() != 2 {
+		return utils.NewAPIError(404, "Member not found", "")
+	}
+	if err == nil {
+		err = changeSubscriberCount(ctx, tx, conversationID, subscriberDelta(oldRole, string(domain.ConversationOwner)))
+	}
+	if err == nil {
+		_, err = tx.Exec(ctx, `UPDATE conversations SET created_by = $2 WHERE id = $1`, conversationID, toID)
+	}
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+
+	if err != nil {
+		logger.Error("Cannot transfer ownership",
+			zap.Int("Conversation ID", conversationID),
+			zap.Int("From ID", fromID),
+			zap.Int("To ID", toID),
+			zap.Error(err))
+		return ClassifyDBerror(err)
+	}
+
+	return nil
+}
+
+func (r *PostgresGroupRepo) Ban(ctx context.Context, ban *domain.Ban) *utils.APIError {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		logger.Error("Cannot begin transaction", zap.Error(err))
+		return ClassifyDBerror(err)
+	}
+	defer tx.Rollback(ctx)
+
+	query := `INSERT INTO conversation_bans (conversation_id, user_id, banned_by) VALUES ($1, $2, $3)
+		ON CONFLICT (conversation_id, user_id) DO UPDATE SET banned_by = EXCLUDED.banned_by
+		RETURNING created_at`
+
+	err = tx.QueryRow(ctx, query, ban.ConversationID, ban.UserID, ban.BannedBy).Scan(&ban.CreatedAt)
+	// Users who are not members can be banned too, they have no role
+	var role string
+	if err == nil {
+		err = tx.QueryRow(ctx, `DELETE FROM conversation_participants WHERE conversation_id = $1 AND user_id = $2 RETURNING role`,
+			ban.ConversationID, ban.UserID).Scan(&role)
+		if err == pgx.ErrNoRows {
+			err = nil
+		}
+	}
+	if err == nil {
+		err = changeSubscriberCount(ctx, tx, ban.ConversationID, subscriberDelta(role, ""))
+	}
+	if err == nil {
+		_, err = tx.Exec(ctx, `DELETE FROM conversation_summaries WHERE conversation_id = $1 AND user_id = $2`, ban.ConversationID, ban.UserID)
+	}
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+
+	if err != nil {
+		logger.Error("Cannot ban user",
+			zap.Int("Conversation ID", ban.ConversationID),
+			zap.Int("User ID", ban.UserID),
+			zap.Error(err))
+		// User not found error
+		if pgErr, ok := err.(*pgconn.PgError); ok && pgErr.Code == "23503" {
+			return utils.NewAPIError(404, "User does not exist", "")
+		}
+		return ClassifyDBerror(err)
+	}
+
+	return nil
+}
+
+func (r *PostgresGroupRepo) Unban(ctx context.Context, conversationID, userID int) (bool, *utils.APIError) {
+	tag, err := r.db.Exec(ctx, `DELETE FROM conversation_bans WHERE conversation_id = $1 AND user_id = $2`, conversationID, userID)
+	if err != nil {
+		logger.Error("Cannot unban user",
+			zap.Int("Conversation ID", conversationID),
+			zap.Int("User ID", userID),
+			zap.Error(err))
+		return false, ClassifyDBerror(err)
+	}
+
+	return tag.RowsAffected() > 0, nil
+}
+
+func (r *PostgresGroupRepo) GetBans(ctx context.Context, conversationID int) ([]domain.Ban, *utils.APIError) {
+	query := `SELECT conversation_id, user_id, COALESCE(banned_by, 0), created_at
+		FROM conversation_bans
+		WHERE conversation_id = $1
+		ORDER BY created_at DESC`
+
+	rows, err := r.db.Query(ctx, query, conversationID)
+	if err != nil {
+		logger.Error("Cannot get bans",
+			zap.Int("Conversation ID", conversationID),
+			zap.Error(err))
+		return nil, ClassifyDBerror(err)
+	}
+	defer rows.Close()
+
+	bans := []domain.Ban{}
+	for rows.Next() {
+		var ban domain.Ban
+		if err := rows.Scan(&ban.ConversationID, &ban.UserID, &ban.BannedBy, &ban.CreatedAt); err != nil {
+			return nil, ClassifyDBerror(err)
+		}
+		bans = append(bans, ban)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, ClassifyDBerror(err)
+	}
+
+	return bans, nil
+}
+
+func (r *PostgresGroupRepo) IsBanned(ctx context.Context, conversationID, userID int) (bool, *utils.APIError) {
+	query := `SELECT EXISTS (SELECT 1 FROM conversation_bans WHERE conversation_id = $1 AND user_id = $2)`
+
+	var banned bool
+	if err := r.db.QueryRow(ctx, query, conversationID, userID).Scan(&banned); err != nil {
+		logger.Error("Cannot check ban",
+			zap.Int("Conversation ID", conversationID),
+			zap.Int("User ID", userID),
+			zap.Error(err))
+		return false, ClassifyDBerror(err)
+	}
+
+	return banned, nil
+}
+
+func (r *PostgresGroupRepo) CreateInvite(ctx context.Context, invite *domain.GroupInvite) *utils.APIError {
+	query := `INSERT INTO conversation_invites (code, conversation_id, created_by, max_uses, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING uses, created_at`
+
+	err := r.db.QueryRow(ctx, query, invite.Code, invite.ConversationID, invite.CreatedBy, invite.MaxUses, invite.ExpiresAt).
+		Scan(&invite.Uses, &invite.CreatedAt)
+	if err != nil {
+		logger.Error("Cannot create group invite",
+			zap.Int("Conversation ID", invite.ConversationID),
+			zap.Error(err))
+		return ClassifyDBerror(err)
+	}
+
+	return nil
+}
+
+func (r *PostgresGroupRepo) GetInvites(ctx context.Context, conversationID int) ([]domain.GroupInvite, *utils.APIError) {
+	query := `SELECT code, conversation_id, COALESCE(created_by, 0), max_uses, uses, expires_at, revoked_at, created_at
+		FROM conversation_invites
+		WHERE conversation_id = $1
+		ORDER BY created_at DESC`
+
+	rows, err := r.db.Query(ctx, query, conversationID)
+	if err != nil {
+		logger.Error("Cannot get group invites",
+			zap.Int("Conversation ID", conversationID),
+			zap.Error(err))
+		return nil, ClassifyDBerror(err)
+	}
+	defer rows.Close()
+
+	invites := []domain.GroupInvite{}
+	for rows.Next() {
+		var invite domain.GroupInvite
+		if err := rows.Scan(
+			&invite.Code,
+			&invite.ConversationID,
+			&invite.CreatedBy,
+			&invite.MaxUses,
+			&invite.Uses,
+			&invite.ExpiresAt,
+			&invite.RevokedAt,
+			&invite.CreatedAt,
+		); err != nil {
+			return nil, ClassifyDBerror(err)
+		}
+		invites = append(invites, invite)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, ClassifyDBerror(err)
+	}
+
+	return invites, nil
+}
+
+func (r *PostgresGroupRepo) RevokeInvite(ctx context.Context, conversationID int, code string) (bool, *utils.APIError) {
+	query := `UPDATE conversation_invites SET revoked_at = NOW() AT TIME ZONE 'UTC'
+		WHERE code = $1 AND conversation_id = $2 AND revoked_at IS NULL`
+
+	tag, err := r.db.Exec(ctx, query, code, conversationID)
+	if err != nil {
+		logger.Error("Cannot revoke group invite",
+			zap.Int("Conversation ID", conversationID),
+			zap.Error(err))
+		return false, ClassifyDBerror(err)
+	}
+
+	return tag.RowsAffected() > 0, nil
+}
+
+func (r *PostgresGroupRepo) JoinByInvite(ctx context.Context, code string, userID int) (int, bool, *utils.APIError) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		logger.Error("Cannot begin transaction", zap.Error(err))
+		return 0, false, ClassifyDBerror(err)
+	}
+	defer tx.Rollback(ctx)
+
+	var conversationID int
+	var banned, member bool
+	checkQuery := `SELECT i.conversation_id,
+			EXISTS (SELECT 1 FROM conversation_bans b WHERE b.conversation_id = i.conversation_id AND b.user_id = $2),
+			EXISTS (SELECT 1 FROM conversation_participants p WHERE p.conversation_id = i.conversation_id AND p.user_id = $2)
+		FROM conversation_invites i
+		WHERE i.code = $1`
+
+	err = tx.QueryRow(ctx, checkQuery, code, userID).Scan(&conversationID, &banned, &member)
+	if err == pgx.ErrNoRows {
+		return 0, false, utils.NewAPIError(403, "Invalid invite code", "Invite is expired, revoked or used up")
+	}
+	if err != nil {
+		logger.Error("Cannot check group invite", zap.Error(err))
+		return 0, false, ClassifyDBerror(err)
+	}
+
+	if banned {
+		return 0, false, utils.NewAPIError(403, "You are banned in this group", "")
+	}
+	// Members don't spend uses of invite
+	if member {
+		return conversationID, false, nil
+	}
+
+	// Take one use of invite. Row is locked until commit, so two users can't take the last use
+	redeemQuery := `UPDATE conversation_invites SET uses = uses + 1
+		WHERE code = $1
+			AND revoked_at IS NULL
+			AND (max_uses = 0 OR uses < max_uses)
+			AND (expires_at IS NULL OR expires_at > NOW() AT TIME ZONE 'UTC')`
+
+	tag, err := tx.Exec(ctx, redeemQuery, code)
+	if err == nil && tag.RowsAffected() == 0 {
+		return 0, false, utils.NewAPIError(403, "Invalid invite code", "Invite is expired, revoked or used up")
+	}
+	if err == nil {
+		tag, err = tx.Exec(ctx, `INSERT INTO conversation_participants (conversation_id, user_id, last_read_seq)
+			SELECT $1, $2, message_count FROM conversations WHERE id = $1
+			ON CONFLICT (conversation_id, user_id) DO NOTHING`, conversationID, userID)
+	}
+	if err == nil {
+		err = changeSubscriberCount(ctx, tx, conversationID, tag.RowsAffected())
+	}
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+
+	if err != nil {
+		logger.Error("Cannot join group",
+			zap.Int("Conversation ID", conversationID),
+			zap.Int("User ID", userID),
+			zap.Error(err))
+		return 0, false, ClassifyDBerror(err)
+	}
+
+	return conversationID, true, nil
+}
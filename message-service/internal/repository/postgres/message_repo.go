@@ -2,71 +2,126 @@ package repositories
 
 import (
 	"context"
-	"database/sql"
+	"errors"
 	"message-service/internal/domain"
 	"message-service/internal/utils"
+	"strconv"
 	"time"
 
 	logger "message-service/internal"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"go.uber.org/zap"
 )
 
+// First key of advisory lock which serializes sends of user with daily limit
+const dailyLimitLock = 1
+
+var errDailyLimit = errors.New("daily message limit is reached")
+
+// Columns of domain.Message in order of scanMessage. Group messages have no recipient
+const messageColumns = "id, conversation_id, sender_id, COALESCE(recipient_id, 0), content, timestamp, status, delivered_at, read_at, type, deleted_at, deleted_by, seq, edited_at"
+
+func scanMessage(row pgx.Row, msg *domain.Message) error {
+	return row.Scan(
+		&msg.MessageID,
+		&msg.ConversationID,
+		&msg.SenderID,
+		&msg.RecipientID,
+		&msg.Content,
+		&msg.Timestamp,
+		&msg.Status,
+		&msg.DeliveredAt,
+		&msg.ReadAt,
+		&msg.Type,
+		&msg.DeletedAt,
+		&msg.DeletedBy,
+		&msg.Seq,
+		&msg.EditedAt,
+	)
+}
+
 type PostgresMessageRepo struct {
-	db *pgx.Conn
+	db *pgxpool.Pool
 }
 
-func NewPostgresMessageRepo(db *pgx.Conn) *PostgresMessageRepo {
+func NewPostgresMessageRepo(db *pgxpool.Pool) *PostgresMessageRepo {
 	return &PostgresMessageRepo{db: db}
 }
 
-func (r *PostgresMessageRepo) SendMessage(ctx context.Context, message *domain.Message) (*domain.Message, *utils.APIError) {
-	// Read about formatting here https://www.geeksforgeeks.org/time-formatting-in-golang/
-	today := time.Now()
-	query := "INSERT INTO messages_" + today.Format("2006_01_02") + ` (recipient_id, sender_id, content)
-		VALUES ($1, $2, $3)
+// checkDailyLimit fails with errDailyLimit if sender has sent limit messages today. Lock of sender is held
+// until commit, so two sends at once can't both take the last free message
+func checkDailyLimit(ctx context.Context, tx pgx.Tx, senderID, limit int) error {
+	if limit <= 0 {
+		return nil
+	}
+
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock($1::int, $2::int)`, dailyLimitLock, senderID); err != nil {
+		return err
+	}
+
+	// Query parent table, Postgres will look only in today's partition. Timestamps are in UTC
+	var count int
+	err := tx.QueryRow(ctx, `SELECT COUNT(*) FROM messages
+		WHERE sender_id = $1 AND timestamp >= date_trunc('day', NOW() AT TIME ZONE 'UTC')`, senderID).Scan(&count)
+	if err == nil && count >= limit {
+		return errDailyLimit
+	}
+	return err
+}
+
+func (r *PostgresMessageRepo) SendMessage(ctx context.Context, message *domain.Message, dailyLimit int, record *domain.SyncRecord) (*domain.Message, *utils.APIError) {
+	// Message and conversation summaries are changed together, otherwise inbox can lie
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		logger.Error("Cannot begin transaction", zap.Error(err))
+		return nil, ClassifyDBerror(err)
+	}
+	defer tx.Rollback(ctx)
+
+	// Postgres puts row into right partition itself. Partitions are made by partition manager
+	if message.Type == "" {
+		message.Type = domain.TextMessage
+	}
+
+	query := `INSERT INTO messages (recipient_id, sender_id, content, conversation_id, type)
+		VALUES ($1, $2, $3, $4, $5)
 		RETURNING id, timestamp, status`
 
-	err := r.db.QueryRow(
-		ctx,
-		query,
-		message.RecipientID,
-		message.SenderID,
-		message.Content).Scan(&message.MessageID, &message.Timestamp, &message.Status)
+	err = checkDailyLimit(ctx, tx, message.SenderID, dailyLimit)
+	if err == errDailyLimit {
+		return nil, utils.NewAPIError(429, "Daily message limit is reached", "")
+	}
+	if err == nil {
+		err = tx.QueryRow(
+			ctx,
+			query,
+			nullableID(message.RecipientID),
+			message.SenderID,
+			message.Content,
+			message.ConversationID,
+			string(message.Type)).Scan(&message.MessageID, &message.Timestamp, &message.Status)
+	}
+	if err == nil {
+		err = updateSummariesOnSend(ctx, tx, message)
+	}
+	// Event has the same message, it is filled now
+	if err == nil {
+		err = appendSyncLog(ctx, tx, record)
+	}
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
 
 	if err != nil {
 		logger.Error("Cannot send message",
 			zap.Int("From id", message.SenderID),
 			zap.Int("To id", message.RecipientID),
 			zap.Error(err))
-		// This error says that we dont have actual partition in db
-		if pgErr, ok := err.(*pgconn.PgError); ok && pgErr.Code == "42P01" {
-			apiErr := r.CreatePartition(ctx)
-
-			if apiErr != nil {
-				return nil, apiErr
-			}
-
-			// Retry query
-			retryErr := r.db.QueryRow(
-				ctx,
-				query,
-				message.RecipientID,
-				message.SenderID,
-				message.Content).Scan(&message.MessageID, &message.Timestamp, &message.Status)
-
-			if retryErr != nil {
-				logger.Error("Cannot send message",
-					zap.Int("From id", message.SenderID),
-					zap.Int("To id", message.RecipientID),
-					zap.Error(retryErr))
-				return nil, ClassifyDBerror(retryErr)
-			}
-			return message, nil
-			// User not found error
-		} else if pgErr, ok := err.(*pgconn.PgError); ok && pgErr.Code == "23503" {
+		// User not found error
+		if pgErr, ok := err.(*pgconn.PgError); ok && pgErr.Code == "23503" {
 			return nil, utils.NewAPIError(404, "User does not exist", "")
 		}
 		return nil, ClassifyDBerror(err)
@@ -75,36 +130,105 @@ func (r *PostgresMessageRepo) SendMessage(ctx context.Context, message *domain.M
 	return message, nil
 }
 
-func (r *PostgresMessageRepo) CreatePartition(ctx context.Context) *utils.APIError {
-	today := time.Now()
-	partitionQuery := `CREATE TABLE IF NOT EXISTS messages_` + today.Format("2006_01_02") + ` PARTITION OF messages
-				FOR VALUES FROM ('` + today.Format("2006-01-02") + `') TO ('` + today.Add(24*time.Hour).Format("2006-01-02") + `');`
+func (r *PostgresMessageRepo) SendChannelMessage(ctx context.Context, message *domain.Message, dailyLimit int) (*domain.Message, *utils.APIError) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		logger.Error("Cannot begin transaction", zap.Error(err))
+		return nil, ClassifyDBerror(err)
+	}
+	defer tx.Rollback(ctx)
 
-	_, createErr := r.db.Exec(ctx, partitionQuery)
-	if createErr != nil {
-		logger.Error("Cannot create new partition messages_"+today.Format("2006_01_02"),
-			zap.Error(createErr))
-		return utils.NewAPIError(500, "Internal server error", "Please report about this")
+	if message.Type == "" {
+		message.Type = domain.TextMessage
 	}
 
-	logger.Info("Created new partition messages_" + today.Format("2006_01_02"))
+	err = checkDailyLimit(ctx, tx, message.SenderID, dailyLimit)
+	if err == errDailyLimit {
+		return nil, utils.NewAPIError(429, "Daily message limit is reached", "")
+	}
 
-	return nil
+	// Row of channel stays locked until commit, so messages get numbers in order of sending
+	// and the last message is always the newest one
+	var seq int64
+	if err == nil {
+		err = tx.QueryRow(ctx, `UPDATE conversations SET message_count = message_count + 1
+			WHERE id = $1 AND type = 'channel'
+			RETURNING message_count`, message.ConversationID).Scan(&seq)
+	}
+	if err == pgx.ErrNoRows {
+		return nil, utils.NewAPIError(404, "Channel not found", "")
+	}
+
+	if err == nil {
+		message.Seq = &seq
+		err = tx.QueryRow(ctx, `INSERT INTO messages (sender_id, content, conversation_id, type, seq)
+			VALUES ($1, $2, $3, $4, $5)
+			RETURNING id, timestamp, status`,
+			message.SenderID,
+			message.Content,
+			message.ConversationID,
+			string(message.Type),
+			seq).Scan(&message.MessageID, &message.Timestamp, &message.Status)
+	}
+	if err == nil {
+		_, err = tx.Exec(ctx, `UPDATE conversations
+			SET last_message_id = $2, last_sender_id = $3, last_preview = LEFT($4, $5), last_message_at = $6
+			WHERE id = $1`,
+			message.ConversationID, message.MessageID, message.SenderID, message.Content, previewLength, message.Timestamp)
+	}
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+
+	if err != nil {
+		logger.Error("Cannot send channel message",
+			zap.Int("From id", message.SenderID),
+			zap.Int("Channel ID", message.ConversationID),
+			zap.Error(err))
+		return nil, ClassifyDBerror(err)
+	}
+
+	return message, nil
 }
-func (r *PostgresMessageRepo) GetMessagesBetweenUsers(ctx context.Context, user1ID, user2ID int) (*[]domain.Message, *utils.APIError) {
-	today := time.Now()
-	query := `SELECT id, sender_id, recipient_id, content, timestamp, status FROM ` + "messages_" + today.Format("2006_01_02") +
-		` WHERE 
-			(sender_id = $1 AND recipient_id = $2)
-			OR (sender_id = $2 AND recipient_id = $1)
-		ORDER BY 
-			timestamp DESC;`
 
-	rows, err := r.db.Query(ctx, query, user1ID, user2ID)
+func (r *PostgresMessageRepo) GetConversationMessages(ctx context.Context, conversationID int, filter domain.ConversationFilter) (*[]domain.Message, *utils.APIError) {
+	// Parent table with time bounds, so Postgres skips partitions which are out of range.
+	// Conversation ID also skips hash partitions of other conversations
+	query := `SELECT ` + messageColumns + ` FROM messages
+		WHERE conversation_id = $1`
+	args := []any{conversationID}
+
+	if !filter.After.IsZero() {
+		args = append(args, filter.After)
+		query += " AND timestamp > $" + strconv.Itoa(len(args))
+	}
+	if !filter.Before.IsZero() {
+		args = append(args, filter.Before)
+		query += " AND timestamp < $" + strconv.Itoa(len(args))
+	}
+
+	// Newer messages are read in ascending order, otherwise LIMIT cuts wrong end
+	order := "DESC"
+	if filter.Cursor != nil {
+		args = append(args, filter.Cursor.Timestamp, filter.Cursor.ID)
+		if filter.Cursor.Newer {
+			query += " AND (timestamp, id) > ($" + strconv.Itoa(len(args)-1) + ", $" + strconv.Itoa(len(args)) + ")"
+			order = "ASC"
+		} else {
+			query += " AND (timestamp, id) < ($" + strconv.Itoa(len(args)-1) + ", $" + strconv.Itoa(len(args)) + ")"
+		}
+	}
+
+	args = append(args, filter.Limit)
+	query += `
+		ORDER BY
+			timestamp ` + order + `, id ` + order + `
+		LIMIT $` + strconv.Itoa(len(args))
+
+	rows, err := r.db.Query(ctx, query, args...)
 	if err != nil {
 		logger.Error("Cannot get conversation",
-			zap.Int("User 1", user1ID),
-			zap.Int("User 2", user2ID),
+			zap.Int("Conversation ID", conversationID),
 			zap.Error(err))
 		return nil, ClassifyDBerror(err)
 	}
@@ -113,14 +237,7 @@ func (r *PostgresMessageRepo) GetMessagesBetweenUsers(ctx context.Context, user1
 	var messages []domain.Message
 	for rows.Next() {
 		var msg domain.Message
-		if err := rows.Scan(
-			&msg.MessageID,
-			&msg.SenderID,
-			&msg.RecipientID,
-			&msg.Content,
-			&msg.Timestamp,
-			&msg.Status,
-		); err != nil {
+		if err := scanMessage(rows, &msg); err != nil {
 			return nil, ClassifyDBerror(err)
 		}
 		messages = append(messages, msg)
@@ -128,37 +245,218 @@ func (r *PostgresMessageRepo) GetMessagesBetweenUsers(ctx context.Context, user1
 
 	return &messages, nil
 }
-func (r *PostgresMessageRepo) UpdateMessageStatus(ctx context.Context, messageID int, status string) *utils.APIError {
-	today := time.Now()
-	query := "UPDATE messages_" + today.Format("2006_01_02") + ` SET status = $1 WHERE id = $2`
-	_, err := r.db.Exec(ctx, query, status, messageID)
+func (r *PostgresMessageRepo) UpdateMessageStatus(ctx context.Context, messageID int, timestamp time.Time, from, to domain.MessageStatus, record *domain.SyncRecord) (bool, *utils.APIError) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		logger.Error("Cannot begin transaction", zap.Error(err))
+		return false, ClassifyDBerror(err)
+	}
+	defer tx.Rollback(ctx)
+
+	// Timestamp is a part of partition key, so only one partition is touched.
+	// Read message is delivered too, even if client skipped that step
+	query := `UPDATE messages
+		SET status = $1,
+			delivered_at = CASE WHEN $1 IN ('delivered', 'watched') THEN COALESCE(delivered_at, NOW() AT TIME ZONE 'UTC') ELSE delivered_at END,
+			read_at = CASE WHEN $1 = 'watched' THEN COALESCE(read_at, NOW() AT TIME ZONE 'UTC') ELSE read_at END
+		WHERE id = $2 AND timestamp = $3 AND status = $4
+		RETURNING recipient_id, conversation_id, deleted_at IS NOT NULL`
+
+	var recipientID, conversationID int
+	var deleted bool
+	err = tx.QueryRow(ctx, query, string(to), messageID, timestamp, string(from)).Scan(&recipientID, &conversationID, &deleted)
+	if err == pgx.ErrNoRows {
+		return false, nil
+	}
+
+	// Status only moves forward, so message becomes read only once. Deleted one left counter already
+	if err == nil && to == domain.Watched && !deleted {
+		err = decrementUnread(ctx, tx, recipientID, conversationID, 1)
+	}
+	if err == nil {
+		err = appendSyncLog(ctx, tx, record)
+	}
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+
 	if err != nil {
 		logger.Error("Cannot update message status",
 			zap.Int("Message ID", messageID),
-			zap.String("Status", status),
+			zap.String("Status", string(to)),
 			zap.Error(err))
-		return ClassifyDBerror(err)
+		return false, ClassifyDBerror(err)
 	}
-	return nil
+	return true, nil
 }
 
-func (r *PostgresMessageRepo) GetMessageByID(ctx context.Context, messageID int) (*domain.Message, *utils.APIError) {
+func (r *PostgresMessageRepo) MarkDelivered(ctx context.Context, recipientID int, messages []domain.Message, records map[int]*domain.SyncRecord) (map[int]time.Time, *utils.APIError) {
+	delivered := make(map[int]time.Time)
+	if len(messages) == 0 {
+		return delivered, nil
+	}
+
+	ids := make([]int, 0, len(messages))
+	timestamps := make([]time.Time, 0, len(messages))
+	for _, msg := range messages {
+		ids = append(ids, msg.MessageID)
+		timestamps = append(timestamps, msg.Timestamp)
+	}
+
+	// One statement for the whole page. Status check keeps it from moving back
+	query := `UPDATE messages SET status = 'delivered', delivered_at = NOW() AT TIME ZONE 'UTC'
+		WHERE recipient_id = $1 AND status = 'sent'
+			AND (id, timestamp) IN (SELECT * FROM unnest($2::int[], $3::timestamp[]))
+		RETURNING id, delivered_at`
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		logger.Error("Cannot begin transaction", zap.Error(err))
+		return nil, ClassifyDBerror(err)
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, query, recipientID, ids, timestamps)
+	if err == nil {
+		for rows.Next() {
+			var id int
+			var deliveredAt time.Time
+			if err = rows.Scan(&id, &deliveredAt); err != nil {
+				break
+			}
+			delivered[id] = deliveredAt
+		}
+		rows.Close()
+		if err == nil {
+			err = rows.Err()
+		}
+	}
+
+	// Only messages which became delivered now have events
+	if err == nil {
+		var changed []*domain.SyncRecord
+		for _, msg := range messages {
+			if _, ok := delivered[msg.MessageID]; ok {
+				changed = append(changed, records[msg.MessageID])
+			}
+		}
+		err = appendSyncLog(ctx, tx, changed...)
+	}
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+
+	if err != nil {
+		logger.Error("Cannot mark messages as delivered",
+			zap.Int("Recipient ID", recipientID),
+			zap.Error(err))
+		return nil, ClassifyDBerror(err)
+	}
+
+	return delivered, nil
+}
+
+func (r *PostgresMessageRepo) MarkConversationRead(ctx context.Context, readerID, conversationID int, upTo *domain.Message, record *domain.SyncRecord) (int64, *utils.APIError) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		logger.Error("Cannot begin transaction", zap.Error(err))
+		return 0, ClassifyDBerror(err)
+	}
+	defer tx.Rollback(ctx)
+
+	// Upper time bound skips newer partitions, older ones have to be checked
+	query := `UPDATE messages
+		SET status = 'watched',
+			delivered_at = COALESCE(delivered_at, NOW() AT TIME ZONE 'UTC'),
+			read_at = NOW() AT TIME ZONE 'UTC'
+		WHERE conversation_id = $1 AND recipient_id = $2 AND status <> 'watched' AND deleted_at IS NULL
+			AND timestamp <= $3 AND (timestamp, id) <= ($3, $4)`
+
+	tag, err := tx.Exec(ctx, query, conversationID, readerID, upTo.Timestamp, upTo.MessageID)
+	read := tag.RowsAffected()
+	if err == nil && read > 0 {
+		err = decrementUnread(ctx, tx, readerID, conversationID, read)
+	}
+
+	// Group messages have no recipient and status. Unread counter of member goes down by messages
+	// between his old and new read positions, members count only messages sent after they joined
+	group := upTo.RecipientID == 0 && upTo.Seq == nil
+	var lastReadID *int
+	var lastReadAt *time.Time
+	var joinedAt time.Time
+	if err == nil && group {
+		err = tx.QueryRow(ctx, `SELECT last_read_id, last_read_at, joined_at FROM conversation_participants
+			WHERE conversation_id = $1 AND user_id = $2 FOR UPDATE`, conversationID, readerID).Scan(&lastReadID, &lastReadAt, &joinedAt)
+		if err == pgx.ErrNoRows {
+			err = nil
+			group = false
+		}
+	}
+
+	// Read position of member only moves forward. Channel messages have numbers, so subscriber remembers
+	// how many he read, and unread messages are counted without looking into messages
+	var readSeq int64
+	if err == nil && upTo.Seq != nil {
+		err = tx.QueryRow(ctx, `SELECT last_read_seq FROM conversation_participants
+			WHERE conversation_id = $1 AND user_id = $2 FOR UPDATE`, conversationID, readerID).Scan(&readSeq)
+		if err == nil && *upTo.Seq > readSeq {
+			read = *upTo.Seq - readSeq
+		}
+	}
+
+	var moved pgconn.CommandTag
+	if err == nil {
+		moved, err = tx.Exec(ctx, `UPDATE conversation_participants
+			SET last_read_id = $3, last_read_at = $4, last_read_seq = GREATEST(last_read_seq, COALESCE($5, 0))
+			WHERE conversation_id = $1 AND user_id = $2
+				AND (last_read_at IS NULL OR (last_read_at, last_read_id) < ($4, $3))`,
+			conversationID, readerID, upTo.MessageID, upTo.Timestamp, upTo.Seq)
+	}
+
+	if err == nil && group && moved.RowsAffected() > 0 {
+		fromAt, fromID := joinedAt, 0
+		if lastReadAt != nil && lastReadID != nil && lastReadAt.After(joinedAt) {
+			fromAt, fromID = *lastReadAt, *lastReadID
+		}
+		read, err = decrementGroupUnread(ctx, tx, readerID, conversationID, fromAt, fromID, upTo)
+	}
+	if err == nil {
+		err = appendSyncLog(ctx, tx, record)
+	}
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+
+	if err != nil {
+		logger.Error("Cannot mark conversation as read",
+			zap.Int("Reader ID", readerID),
+			zap.Int("Conversation ID", conversationID),
+			zap.Error(err))
+		return 0, ClassifyDBerror(err)
+	}
+
+	return read, nil
+}
+
+func (r *PostgresMessageRepo) GetMessageByID(ctx context.Context, messageID int, timestamp time.Time) (*domain.Message, *utils.APIError) {
 	query := `
-		SELECT message_id, recipient_id, sender_id, content, timestamp, status
+		SELECT ` + messageColumns + `
 		FROM messages
-		WHERE message_id = $1
+		WHERE id = $1
 	`
+	args := []any{messageID}
+
+	// Without timestamp all partitions are searched
+	if !timestamp.IsZero() {
+		query += " AND timestamp = $2"
+		args = append(args, timestamp)
+	}
+
 	var msg domain.Message
-	err := r.db.QueryRow(ctx, query, messageID).Scan(
-		&msg.MessageID,
-		&msg.RecipientID,
-		&msg.SenderID,
-		&msg.Content,
-		&msg.Timestamp,
-		&msg.Status)
+	err := scanMessage(r.db.QueryRow(ctx, query, args...), &msg)
 
 	if err != nil {
-		if err == sql.ErrNoRows {
+		if err == pgx.ErrNoRows {
 			return nil, nil
 		}
 		logger.Error("Cannot get message by ID",
@@ -169,3 +467,498 @@ func (r *PostgresMessageRepo) GetMessageByID(ctx context.Context, messageID int)
 
 	return &msg, nil
 }
+
+func (r *PostgresMessageRepo) EditMessage(ctx context.Context, messageID int, timestamp time.Time, content string, record *domain.SyncRecord) (*domain.Message, *utils.APIError) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		logger.Error("Cannot begin transaction", zap.Error(err))
+		return nil, ClassifyDBerror(err)
+	}
+	defer tx.Rollback(ctx)
+
+	// Row stays locked until commit, so two edits at once can't save the same old version
+	var oldContent string
+	var conversationID, senderID int
+	err = tx.QueryRow(ctx, `SELECT content, conversation_id, sender_id FROM messages
+		WHERE id = $1 AND timestamp = $2 AND deleted_at IS NULL
+		FOR UPDATE`, messageID, timestamp).Scan(&oldContent, &conversationID, &senderID)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+
+	if err == nil {
+		_, err = tx.Exec(ctx, `INSERT INTO message_edits (message_id, message_timestamp, conversation_id, sender_id, content)
+			VALUES ($1, $2, $3, $4, $5)`, messageID, timestamp, conversationID, senderID, oldContent)
+	}
+
+	var msg domain.Message
+	if err == nil {
+		err = scanMessage(tx.QueryRow(ctx, `UPDATE messages SET content = $3, edited_at = NOW() AT TIME ZONE 'UTC'
+			WHERE id = $1 AND timestamp = $2
+			RETURNING `+messageColumns, messageID, timestamp, content), &msg)
+	}
+
+	// Inbox shows new text if it is the last message
+	if err == nil {
+		_, err = tx.Exec(ctx, `UPDATE conversation_summaries SET last_preview = LEFT($3, $4)
+			WHERE conversation_id = $1 AND last_message_id = $2`, conversationID, messageID, content, previewLength)
+	}
+	if err == nil {
+		_, err = tx.Exec(ctx, `UPDATE conversations SET last_preview = LEFT($3, $4)
+			WHERE id = $1 AND last_message_id = $2`, conversationID, messageID, content, previewLength)
+	}
+	if err == nil && record != nil {
+		record.Event.Message = &msg
+		err = appendSyncLog(ctx, tx, record)
+	}
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+
+	if err != nil {
+		logger.Error("Cannot edit message",
+			zap.Int("Message ID", messageID),
+			zap.Error(err))
+		return nil, ClassifyDBerror(err)
+	}
+
+	return &msg, nil
+}
+
+func (r *PostgresMessageRepo) GetMessageEdits(ctx context.Context, messageID int, timestamp time.Time) ([]domain.MessageEdit, *utils.APIError) {
+	query := `SELECT id, content, edited_at FROM message_edits
+		WHERE message_id = $1 AND message_timestamp = $2
+		ORDER BY edited_at, id`
+
+	rows, err := r.db.Query(ctx, query, messageID, timestamp)
+	if err != nil {
+		logger.Error("Cannot get message edits",
+			zap.Int("Message ID", messageID),
+			zap.Error(err))
+		return nil, ClassifyDBerror(err)
+	}
+	defer rows.Close()
+
+	edits := []domain.MessageEdit{}
+	for rows.Next() {
+		var edit domain.MessageEdit
+		if err := rows.Scan(&edit.ID, &edit.Content, &edit.EditedAt); err != nil {
+			return nil, ClassifyDBerror(err)
+		}
+		edits = append(edits, edit)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, ClassifyDBerror(err)
+	}
+
+	return edits, nil
+}
+
+func (r *PostgresMessageRepo) DeleteMessage(ctx context.Context, messageID int, timestamp time.Time, deletedBy int, record *domain.SyncRecord) (bool, *utils.APIError) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		logger.Error("Cannot begin transaction", zap.Error(err))
+		return false, ClassifyDBerror(err)
+	}
+	defer tx.Rollback(ctx)
+
+	// Timestamp is a part of partition key, so only one partition is touched
+	query := `UPDATE messages SET content = '', deleted_at = NOW() AT TIME ZONE 'UTC', deleted_by = $3
+		WHERE id = $1 AND timestamp = $2 AND deleted_at IS NULL
+		RETURNING ` + messageColumns
+
+	var deleted domain.Message
+	err = scanMessage(tx.QueryRow(ctx, query, messageID, timestamp, deletedBy), &deleted)
+	if err == pgx.ErrNoRows {
+		return false, nil
+	}
+	conversationID := deleted.ConversationID
+
+	// Row is locked now, so read of recipient either made it watched already or will skip it
+	if err == nil {
+		err = decrementUnreadOnDelete(ctx, tx, &deleted)
+	}
+
+	// Old versions are deleted text too, and so are copies of it in sync log
+	if err == nil {
+		_, err = tx.Exec(ctx, `DELETE FROM message_edits WHERE message_id = $1 AND message_timestamp = $2`, messageID, timestamp)
+	}
+	if err == nil {
+		err = scrubSyncLog(ctx, tx, messageID)
+	}
+	// Inbox must not show deleted text
+	if err == nil {
+		_, err = tx.Exec(ctx, `UPDATE conversation_summaries SET last_preview = ''
+			WHERE conversation_id = $1 AND last_message_id = $2`, conversationID, messageID)
+	}
+	if err == nil {
+		_, err = tx.Exec(ctx, `UPDATE conversations SET last_preview = ''
+			WHERE id = $1 AND last_message_id = $2`, conversationID, messageID)
+	}
+	if err == nil {
+		err = appendSyncLog(ctx, tx, record)
+	}
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+
+	if err != nil {
+		logger.Error("Cannot delete message",
+			zap.Int("Message ID", messageID),
+			zap.Error(err))
+		return false, ClassifyDBerror(err)
+	}
+
+	return true, nil
+}
+
+func (r *PostgresMessageRepo) EditArchivedMessage(ctx context.Context, message *domain.Message, content string, record *domain.SyncRecord) (*domain.Message, *utils.APIError) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		logger.Error("Cannot begin transaction", zap.Error(err))
+		return nil, ClassifyDBerror(err)
+	}
+	defer tx.Rollback(ctx)
+
+	// The first change starts from content of archive. Then the row is locked until commit,
+	// so two edits at once can't save the same old version
+	err = insertArchivedChange(ctx, tx, message)
+
+	var oldContent string
+	if err == nil {
+		err = tx.QueryRow(ctx, `SELECT content FROM archived_message_changes
+			WHERE message_id = $1 AND message_timestamp = $2 AND deleted_at IS NULL
+			FOR UPDATE`, message.MessageID, message.Timestamp).Scan(&oldContent)
+	}
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+
+	if err == nil {
+		_, err = tx.Exec(ctx, `INSERT INTO message_edits (message_id, message_timestamp, conversation_id, sender_id, content)
+			VALUES ($1, $2, $3, $4, $5)`, message.MessageID, message.Timestamp, message.ConversationID, message.SenderID, oldContent)
+	}
+
+	msg := *message
+	msg.Content = content
+	if err == nil {
+		err = tx.QueryRow(ctx, `UPDATE archived_message_changes SET content = $3, edited_at = NOW() AT TIME ZONE 'UTC'
+			WHERE message_id = $1 AND message_timestamp = $2
+			RETURNING edited_at`, message.MessageID, message.Timestamp, content).Scan(&msg.EditedAt)
+	}
+
+	// Quiet conversation can have archived message as the last one
+	if err == nil {
+		_, err = tx.Exec(ctx, `UPDATE conversation_summaries SET last_preview = LEFT($3, $4)
+			WHERE conversation_id = $1 AND last_message_id = $2`, message.ConversationID, message.MessageID, content, previewLength)
+	}
+	if err == nil {
+		_, err = tx.Exec(ctx, `UPDATE conversations SET last_preview = LEFT($3, $4)
+			WHERE id = $1 AND last_message_id = $2`, message.ConversationID, message.MessageID, content, previewLength)
+	}
+	if err == nil && record != nil {
+		record.Event.Message = &msg
+		err = appendSyncLog(ctx, tx, record)
+	}
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+
+	if err != nil {
+		logger.Error("Cannot edit archived message",
+			zap.Int("Message ID", message.MessageID),
+			zap.Error(err))
+		return nil, ClassifyDBerror(err)
+	}
+
+	return &msg, nil
+}
+
+func (r *PostgresMessageRepo) DeleteArchivedMessage(ctx context.Context, message *domain.Message, deletedBy int, record *domain.SyncRecord) (bool, *utils.APIError) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		logger.Error("Cannot begin transaction", zap.Error(err))
+		return false, ClassifyDBerror(err)
+	}
+	defer tx.Rollback(ctx)
+
+	err = insertArchivedChange(ctx, tx, message)
+
+	var deleted bool
+	if err == nil {
+		tag, execErr := tx.Exec(ctx, `UPDATE archived_message_changes
+			SET content = '', deleted_at = NOW() AT TIME ZONE 'UTC', deleted_by = $3
+			WHERE message_id = $1 AND message_timestamp = $2 AND deleted_at IS NULL`, message.MessageID, message.Timestamp, deletedBy)
+		err = execErr
+		deleted = err == nil && tag.RowsAffected() > 0
+	}
+	if err == nil && !deleted {
+		return false, nil
+	}
+
+	// Reads don't touch archived messages, so unread ones are still counted
+	if err == nil {
+		err = decrementUnreadOnDelete(ctx, tx, message)
+	}
+	if err == nil {
+		_, err = tx.Exec(ctx, `DELETE FROM message_edits WHERE message_id = $1 AND message_timestamp = $2`, message.MessageID, message.Timestamp)
+	}
+	if err == nil {
+		err = scrubSyncLog(ctx, tx, message.MessageID)
+	}
+	if err == nil {
+		_, err = tx.Exec(ctx, `UPDATE conversation_summaries SET last_preview = ''
+			WHERE conversation_id = $1 AND last_message_id = $2`, message.ConversationID, message.MessageID)
+	}
+	if err == nil {
+		_, err = tx.Exec(ctx, `UPDATE conversations SET last_preview = ''
+			WHERE id = $1 AND last_message_id = $2`, message.ConversationID, message.MessageID)
+	}
+	if err == nil {
+		err = appendSyncLog(ctx, tx, record)
+	}
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+
+	if err != nil {
+		logger.Error("Cannot delete archived message",
+			zap.Int("Message ID", message.MessageID),
+			zap.Error(err))
+		return false, ClassifyDBerror(err)
+	}
+
+	return true, nil
+}
+
+// insertArchivedChange makes row of archived message change with content from archive, if there is none yet
+func insertArchivedChange(ctx context.Context, tx pgx.Tx, message *domain.Message) error {
+	_, err := tx.Exec(ctx, `INSERT INTO archived_message_changes
+			(message_id, message_timestamp, conversation_id, sender_id, recipient_id, content)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (message_id, message_timestamp) DO NOTHING`,
+		message.MessageID, message.Timestamp, message.ConversationID, message.SenderID, nullableID(message.RecipientID), message.Content)
+	return err
+}
+
+func (r *PostgresMessageRepo) HideMessage(ctx context.Context, userID int, message *domain.Message, record *domain.SyncRecord) *utils.APIError {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		logger.Error("Cannot begin transaction", zap.Error(err))
+		return ClassifyDBerror(err)
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `INSERT INTO message_hides (user_id, message_id, message_timestamp, conversation_id)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id, message_id) DO NOTHING`, userID, message.MessageID, message.Timestamp, message.ConversationID)
+
+	// Inbox of user must not show hidden text, others keep it
+	if err == nil {
+		_, err = tx.Exec(ctx, `UPDATE conversation_summaries SET last_preview = ''
+			WHERE user_id = $1 AND conversation_id = $2 AND last_message_id = $3`, userID, message.ConversationID, message.MessageID)
+	}
+	if err == nil {
+		err = appendSyncLog(ctx, tx, record)
+	}
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+
+	if err != nil {
+		logger.Error("Cannot hide message",
+			zap.Int("User ID", userID),
+			zap.Int("Message ID", message.MessageID),
+			zap.Error(err))
+		return ClassifyDBerror(err)
+	}
+
+	return nil
+}
+
+func (r *PostgresMessageRepo) GetHiddenMessageIDs(ctx context.Context, userID int, messageIDs []int) (map[int]bool, *utils.APIError) {
+	hidden := make(map[int]bool)
+	if len(messageIDs) == 0 {
+		return hidden, nil
+	}
+
+	rows, err := r.db.Query(ctx, `SELECT message_id FROM message_hides WHERE user_id = $1 AND message_id = ANY($2)`, userID, messageIDs)
+	if err != nil {
+		logger.Error("Cannot get hidden messages",
+			zap.Int("User ID", userID),
+			zap.Error(err))
+		return nil, ClassifyDBerror(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var messageID int
+		if err := rows.Scan(&messageID); err != nil {
+			return nil, ClassifyDBerror(err)
+		}
+		hidden[messageID] = true
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, ClassifyDBerror(err)
+	}
+
+	return hidden, nil
+}
+
+func (r *PostgresMessageRepo) PurgeDeletedMessages(ctx context.Context, before time.Time) (int64, *utils.APIError) {
+	// Partial index on deleted_at of every partition, so live and purged messages are not scanned.
+	// Row stays, so history has no holes and clients keep positions they synced up to
+	query := `WITH purged AS (
+			UPDATE messages
+			SET content = '', deleted_by = NULL, edited_at = NULL, purged_at = NOW() AT TIME ZONE 'UTC'
+			WHERE deleted_at IS NOT NULL AND deleted_at < $1 AND purged_at IS NULL
+			RETURNING id, timestamp
+		), hides AS (
+			DELETE FROM message_hides h USING purged p WHERE h.message_id = p.id AND h.message_timestamp = p.timestamp
+		)
+		SELECT COUNT(*) FROM purged`
+
+	var purged int64
+	if err := r.db.QueryRow(ctx, query, before).Scan(&purged); err != nil {
+		logger.Error("Cannot purge deleted messages", zap.Error(err))
+		return 0, ClassifyDBerror(err)
+	}
+
+	return purged, nil
+}
+
+func (r *PostgresMessageRepo) DeleteUserMessages(ctx context.Context, userID int) (int64, *utils.APIError) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		logger.Error("Cannot begin transaction", zap.Error(err))
+		return 0, ClassifyDBerror(err)
+	}
+	defer tx.Rollback(ctx)
+
+	// All partitions, so it is slow. But accounts are not deleted every second.
+	// Previous versions of deleted messages go with them, sync log of others keeps no content of them
+	var deleted int64
+	err = tx.QueryRow(ctx, `WITH deleted AS (
+			DELETE FROM messages WHERE sender_id = $1 OR recipient_id = $1 RETURNING id, timestamp
+		), edits AS (
+			DELETE FROM message_edits e USING deleted d WHERE e.message_id = d.id AND e.message_timestamp = d.timestamp
+		), scrubbed AS (
+			UPDATE user_sync_log l SET payload = jsonb_set(l.payload, '{message,content}', '""')
+			FROM deleted d
+			WHERE l.payload #>> '{message,id}' = d.id::text AND l.payload #>> '{message,content}' <> ''
+		)
+		SELECT COUNT(*) FROM deleted`, userID).Scan(&deleted)
+	// Archived messages are removed from files by archive service, their changes and history are here
+	if err == nil {
+		_, err = tx.Exec(ctx, `DELETE FROM archived_message_changes WHERE sender_id = $1 OR recipient_id = $1`, userID)
+	}
+	if err == nil {
+		_, err = tx.Exec(ctx, `DELETE FROM message_edits WHERE sender_id = $1`, userID)
+	}
+	if err == nil {
+		_, err = tx.Exec(ctx, `DELETE FROM conversation_summaries WHERE user_id = $1 OR peer_id = $1`, userID)
+	}
+	// Direct conversations make no sense without one side, participants go with them
+	if err == nil {
+		_, err = tx.Exec(ctx, `DELETE FROM conversations
+			WHERE type = 'direct' AND id IN (SELECT conversation_id FROM conversation_participants WHERE user_id = $1)`, userID)
+	}
+	// Channels he was subscribed to lose a subscriber
+	if err == nil {
+		_, err = tx.Exec(ctx, `WITH removed AS (
+				DELETE FROM conversation_participants WHERE user_id = $1 RETURNING conversation_id, role
+			)
+			UPDATE conversations c SET subscriber_count = c.subscriber_count - 1
+			FROM removed r
+			WHERE c.id = r.conversation_id AND c.type = 'channel' AND r.role = 'member'`, userID)
+	}
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+
+	if err != nil {
+		logger.Error("Cannot delete user messages",
+			zap.Int("User ID", userID),
+			zap.Error(err))
+		return 0, ClassifyDBerror(err)
+	}
+
+	return deleted, nil
+}
+
+func (r *PostgresMessageRepo) ForEachUserMessage(ctx context.Context, userID int, fn func(*domain.Message) error) *utils.APIError {
+	// Parent table, so all daily partitions are included. Group messages have no recipient,
+	// they belong to user while he is a member and can read them. Previous versions are personal data of sender only
+	query := `SELECT ` + messageColumns + `,
+			ARRAY(SELECT e.id FROM message_edits e
+				WHERE e.message_id = m.id AND e.message_timestamp = m.timestamp AND m.sender_id = $1 ORDER BY e.edited_at, e.id),
+			ARRAY(SELECT e.content FROM message_edits e
+				WHERE e.message_id = m.id AND e.message_timestamp = m.timestamp AND m.sender_id = $1 ORDER BY e.edited_at, e.id),
+			ARRAY(SELECT e.edited_at FROM message_edits e
+				WHERE e.message_id = m.id AND e.message_timestamp = m.timestamp AND m.sender_id = $1 ORDER BY e.edited_at, e.id)
+		FROM messages m
+		WHERE m.sender_id = $1 OR m.recipient_id = $1
+			OR EXISTS (
+				SELECT 1 FROM conversation_participants p
+				JOIN conversations c ON c.id = p.conversation_id
+				WHERE p.conversation_id = m.conversation_id AND p.user_id = $1 AND c.type = 'group'
+					AND (c.history_visible OR m.timestamp >= p.joined_at)
+			)
+		ORDER BY m.timestamp, m.id`
+
+	rows, err := r.db.Query(ctx, query, userID)
+	if err != nil {
+		logger.Error("Cannot get user messages",
+			zap.Int("User ID", userID),
+			zap.Error(err))
+		return ClassifyDBerror(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var msg domain.Message
+		var editIDs []int
+		var editContents []string
+		var editedAt []time.Time
+		err := rows.Scan(
+			&msg.MessageID,
+			&msg.ConversationID,
+			&msg.SenderID,
+			&msg.RecipientID,
+			&msg.Content,
+			&msg.Timestamp,
+			&msg.Status,
+			&msg.DeliveredAt,
+			&msg.ReadAt,
+			&msg.Type,
+			&msg.DeletedAt,
+			&msg.DeletedBy,
+			&msg.Seq,
+			&msg.EditedAt,
+			&editIDs,
+			&editContents,
+			&editedAt,
+		)
+		if err != nil {
+			return ClassifyDBerror(err)
+		}
+		for i := range editIDs {
+			msg.Edits = append(msg.Edits, domain.MessageEdit{ID: editIDs[i], Content: editContents[i], EditedAt: editedAt[i]})
+		}
+
+		if err := fn(&msg); err != nil {
+			return utils.NewAPIError(500, "Cannot process message", err.Error())
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		logger.Error("Cannot read user messages",
+			zap.Int("User ID", userID),
+			zap.Error(err))
+		return ClassifyDBerror(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,211 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"message-service/internal/domain"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSendMessageDailyLimit(t *testing.T) {
+	db := testSchemaDB(t)
+	repo := NewPostgresMessageRepo(db)
+	peer := createUser(t, db, "peer")
+
+	tests := []struct {
+		name  string
+		limit int
+		// Sent at once, limit must hold anyway
+		sends    int
+		wantSent int
+	}{
+		{name: "no limit", limit: 0, sends: 5, wantSent: 5},
+		{name: "under limit", limit: 10, sends: 4, wantSent: 4},
+		{name: "exactly limit", limit: 3, sends: 3, wantSent: 3},
+		{name: "over limit", limit: 3, sends: 12, wantSent: 3},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sender := createUser(t, db, fmt.Sprintf("guest%d", i))
+			conversationID := createConversation(t, db, "direct", sender, peer)
+
+			var wg sync.WaitGroup
+			var mu sync.Mutex
+			sent, limited := 0, 0
+			for range tt.sends {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					message := &domain.Message{SenderID: sender, RecipientID: peer, ConversationID: conversationID, Content: "hi"}
+					_, apiErr := repo.SendMessage(context.Background(), message, tt.limit, nil)
+
+					mu.Lock()
+					defer mu.Unlock()
+					switch {
+					case apiErr == nil:
+						sent++
+					case apiErr.Code == 429:
+						limited++
+					default:
+						t.Errorf("unexpected error: %v", apiErr.Message)
+					}
+				}()
+			}
+			wg.Wait()
+
+			if sent != tt.wantSent {
+				t.Errorf("sent %d messages, want %d", sent, tt.wantSent)
+			}
+			if limited != tt.sends-tt.wantSent {
+				t.Errorf("%d sends hit limit, want %d", limited, tt.sends-tt.wantSent)
+			}
+		})
+	}
+}
+
+func TestDeleteMessageUnread(t *testing.T) {
+	ctx := context.Background()
+	db := testSchemaDB(t)
+	repo := NewPostgresMessageRepo(db)
+
+	tests := []struct {
+		name             string
+		conversationType string
+		// Members after sender, each read up to message with this index, -1 if nothing
+		readUpTo []int
+		deleted  int
+		// Unread counters of members after sender
+		wantUnread []int
+	}{
+		{name: "direct, not read", conversationType: "direct", readUpTo: []int{-1}, deleted: 0, wantUnread: []int{1}},
+		{name: "direct, read", conversationType: "direct", readUpTo: []int{0}, deleted: 0, wantUnread: []int{1}},
+		{name: "direct, all read", conversationType: "direct", readUpTo: []int{1}, deleted: 1, wantUnread: []int{0}},
+		{name: "group, read by one", conversationType: "group", readUpTo: []int{-1, 0}, deleted: 0, wantUnread: []int{1, 1}},
+		{name: "group, last message", conversationType: "group", readUpTo: []int{-1, 0}, deleted: 1, wantUnread: []int{1, 0}},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sender := createUser(t, db, fmt.Sprintf("sender%d", i))
+			userIDs := []int{sender}
+			for j := range tt.readUpTo {
+				userIDs = append(userIDs, createUser(t, db, fmt.Sprintf("member%d_%d", i, j)))
+			}
+			conversationID := createConversation(t, db, tt.conversationType, userIDs...)
+
+			var messages []*domain.Message
+			for range 2 {
+				message := &domain.Message{SenderID: sender, ConversationID: conversationID, Content: "hi"}
+				if tt.conversationType == "direct" {
+					message.RecipientID = userIDs[1]
+				}
+				sent, apiErr := repo.SendMessage(ctx, message, 0, nil)
+				if apiErr != nil {
+					t.Fatalf("cannot send message: %v", apiErr.Message)
+				}
+				messages = append(messages, sent)
+			}
+
+			for j, upTo := range tt.readUpTo {
+				if upTo < 0 {
+					continue
+				}
+				if _, apiErr := repo.MarkConversationRead(ctx, userIDs[j+1], conversationID, messages[upTo], nil); apiErr != nil {
+					t.Fatalf("cannot mark read: %v", apiErr.Message)
+				}
+			}
+
+			deleted := messages[tt.deleted]
+			if ok, apiErr := repo.DeleteMessage(ctx, deleted.MessageID, deleted.Timestamp, sender, nil); apiErr != nil || !ok {
+				t.Fatalf("cannot delete message: %v", apiErr)
+			}
+
+			for j, want := range tt.wantUnread {
+				var unread int
+				err := db.QueryRow(ctx, `SELECT unread_count FROM conversation_summaries WHERE user_id = $1 AND conversation_id = $2`,
+					userIDs[j+1], conversationID).Scan(&unread)
+				if err != nil {
+					t.Fatalf("cannot read unread count: %v", err)
+				}
+				if unread != want {
+					t.Errorf("member %d has %d unread, want %d", j, unread, want)
+				}
+			}
+		})
+	}
+}
+
+func TestPurgeDeletedMessages(t *testing.T) {
+	ctx := context.Background()
+	db := testSchemaDB(t)
+	repo := NewPostgresMessageRepo(db)
+	sender := createUser(t, db, "sender")
+	recipient := createUser(t, db, "recipient")
+	conversationID := createConversation(t, db, "direct", sender, recipient)
+
+	var messages []*domain.Message
+	for _, content := range []string{"kept", "deleted", "hidden and deleted"} {
+		message := &domain.Message{SenderID: sender, RecipientID: recipient, ConversationID: conversationID, Content: content}
+		sent, apiErr := repo.SendMessage(ctx, message, 0, nil)
+		if apiErr != nil {
+			t.Fatalf("cannot send message: %v", apiErr.Message)
+		}
+		messages = append(messages, sent)
+	}
+	if apiErr := repo.HideMessage(ctx, recipient, messages[2], nil); apiErr != nil {
+		t.Fatalf("cannot hide message: %v", apiErr.Message)
+	}
+	for _, message := range messages[1:] {
+		if _, apiErr := repo.DeleteMessage(ctx, message.MessageID, message.Timestamp, sender, nil); apiErr != nil {
+			t.Fatalf("cannot delete message: %v", apiErr.Message)
+		}
+	}
+
+	// The second run finds nothing, purged messages stay purged
+	for run, want := range []int64{2, 0} {
+		purged, apiErr := repo.PurgeDeletedMessages(ctx, time.Now().UTC().Add(time.Hour))
+		if apiErr != nil {
+			t.Fatalf("cannot purge: %v", apiErr.Message)
+		}
+		if purged != want {
+			t.Errorf("run %d purged %d messages, want %d", run, purged, want)
+		}
+	}
+
+	tests := []struct {
+		name        string
+		message     *domain.Message
+		wantContent string
+		wantPurged  bool
+	}{
+		{name: "live message", message: messages[0], wantContent: "kept"},
+		{name: "deleted message", message: messages[1], wantPurged: true},
+		{name: "hidden message", message: messages[2], wantPurged: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var content string
+			var deleted, purged bool
+			err := db.QueryRow(ctx, `SELECT content, deleted_at IS NOT NULL, purged_at IS NOT NULL FROM messages WHERE id = $1`,
+				tt.message.MessageID).Scan(&content, &deleted, &purged)
+			if err != nil {
+				t.Fatalf("message row is gone: %v", err)
+			}
+			if content != tt.wantContent || deleted != tt.wantPurged || purged != tt.wantPurged {
+				t.Errorf("content %q, deleted %v, purged %v; want %q, purged %v", content, deleted, purged, tt.wantContent, tt.wantPurged)
+			}
+		})
+	}
+
+	var hides int
+	if err := db.QueryRow(ctx, `SELECT COUNT(*) FROM message_hides`).Scan(&hides); err != nil {
+		t.Fatalf("cannot count hides: %v", err)
+	}
+	if hides != 0 {
+		t.Errorf("%d hides of purged messages left", hides)
+	}
+}
@@ -0,0 +1,172 @@
+package repositories
+
+import (
+	"context"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const migrationsDir = "../../../../database/migrations"
+
+// migrate runs migrations with numbers from first to last, in order
+func migrate(t *testing.T, db *pgxpool.Pool, first, last string) {
+	t.Helper()
+
+	files, err := filepath.Glob(filepath.Join(migrationsDir, "*.sql"))
+	if err != nil {
+		t.Fatalf("cannot list migrations: %v", err)
+	}
+	sort.Strings(files)
+
+	for _, file := range files {
+		number := filepath.Base(file)[:3]
+		if number >= first && number <= last {
+			execFile(t, db, file)
+		}
+	}
+}
+
+func TestConversationsMigration(t *testing.T) {
+	ctx := context.Background()
+	db := testDB(t)
+	execFile(t, db, "testdata/baseline.sql")
+	migrate(t, db, "001", "006")
+
+	// Messages written before conversations existed. Partition of the last day is detached later, like retention does
+	day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	if _, err := db.Exec(ctx, `CREATE TABLE messages_2023_12_31 PARTITION OF messages
+		FOR VALUES FROM ('2023-12-31 00:00:00') TO ('2024-01-01 00:00:00')`); err != nil {
+		t.Fatalf("cannot create partition: %v", err)
+	}
+
+	users := map[string]int{}
+	for _, name := range []string{"alice", "bob", "carol", "dave"} {
+		users[name] = createUser(t, db, name)
+	}
+
+	type sent struct {
+		from, to string
+		at       time.Time
+		status   string
+	}
+	messages := []sent{
+		{from: "alice", to: "bob", at: day.Add(time.Hour), status: "watched"},
+		{from: "bob", to: "alice", at: day.Add(2 * time.Hour), status: "sent"},
+		{from: "alice", to: "bob", at: day.Add(3 * time.Hour), status: "sent"},
+		{from: "carol", to: "alice", at: day.Add(4 * time.Hour), status: "sent"},
+		// Only in detached partition
+		{from: "dave", to: "bob", at: day.Add(-time.Hour), status: "sent"},
+	}
+	for _, msg := range messages {
+		if _, err := db.Exec(ctx, `INSERT INTO messages (sender_id, recipient_id, content, timestamp, status) VALUES ($1, $2, 'hi', $3, $4)`,
+			users[msg.from], users[msg.to], msg.at, msg.status); err != nil {
+			t.Fatalf("cannot insert message: %v", err)
+		}
+	}
+
+	migrate(t, db, "007", "010")
+	for _, query := range []string{
+		`ALTER TABLE messages DETACH PARTITION messages_2023_12_31`,
+		`ALTER TABLE messages_2023_12_31 RENAME TO archived_messages_2023_12_31`,
+		`INSERT INTO archive_index (table_name, range_start, range_end) VALUES ('archived_messages_2023_12_31', '2023-12-31', '2024-01-01')`,
+	} {
+		if _, err := db.Exec(ctx, query); err != nil {
+			t.Fatalf("cannot detach partition: %v", err)
+		}
+	}
+
+	var summaries int
+	if err := db.QueryRow(ctx, `SELECT COUNT(*) FROM conversation_summaries`).Scan(&summaries); err != nil {
+		t.Fatalf("cannot count summaries: %v", err)
+	}
+
+	migrate(t, db, "011", "011")
+
+	tests := []struct {
+		name       string
+		user, peer string
+		table      string
+		messages   int
+		createdAt  time.Time
+		// Unread messages in inbox of user
+		unread int
+	}{
+		{name: "both sides wrote", user: "bob", peer: "alice", table: "messages", messages: 3, createdAt: day.Add(time.Hour), unread: 1},
+		{name: "one side wrote", user: "alice", peer: "carol", table: "messages", messages: 1, createdAt: day.Add(4 * time.Hour), unread: 1},
+		{name: "only in detached partition", user: "bob", peer: "dave", table: "archived_messages_2023_12_31", messages: 1, createdAt: day.Add(-time.Hour), unread: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user, peer := users[tt.user], users[tt.peer]
+			key := int64(min(user, peer))<<32 | int64(max(user, peer))
+
+			var conversationID int
+			var conversationType string
+			var createdAt time.Time
+			err := db.QueryRow(ctx, `SELECT id, type, created_at FROM conversations WHERE direct_key = $1`, key).Scan(&conversationID, &conversationType, &createdAt)
+			if err != nil {
+				t.Fatalf("conversation is not created: %v", err)
+			}
+			if conversationType != "direct" || !createdAt.Equal(tt.createdAt) {
+				t.Fatalf("conversation %s created at %v, want direct created at %v", conversationType, createdAt, tt.createdAt)
+			}
+
+			var participants []int
+			rows, err := db.Query(ctx, `SELECT user_id FROM conversation_participants WHERE conversation_id = $1 ORDER BY user_id`, conversationID)
+			if err != nil {
+				t.Fatalf("cannot read participants: %v", err)
+			}
+			for rows.Next() {
+				var id int
+				if err := rows.Scan(&id); err != nil {
+					t.Fatalf("cannot read participant: %v", err)
+				}
+				participants = append(participants, id)
+			}
+			rows.Close()
+			if len(participants) != 2 || participants[0] != min(user, peer) || participants[1] != max(user, peer) {
+				t.Fatalf("participants %v, want %d and %d", participants, user, peer)
+			}
+
+			var inConversation int
+			err = db.QueryRow(ctx, `SELECT COUNT(*) FROM `+tt.table+` WHERE conversation_id = $1
+				AND ((sender_id = $2 AND recipient_id = $3) OR (sender_id = $3 AND recipient_id = $2))`,
+				conversationID, user, peer).Scan(&inConversation)
+			if err != nil {
+				t.Fatalf("cannot count messages: %v", err)
+			}
+			if inConversation != tt.messages {
+				t.Fatalf("%d messages in conversation, want %d", inConversation, tt.messages)
+			}
+
+			var unread int
+			var peerID *int
+			err = db.QueryRow(ctx, `SELECT unread_count, peer_id FROM conversation_summaries WHERE user_id = $1 AND conversation_id = $2`,
+				user, conversationID).Scan(&unread, &peerID)
+			if err != nil {
+				t.Fatalf("inbox line is lost: %v", err)
+			}
+			if unread != tt.unread || peerID == nil || *peerID != peer {
+				t.Fatalf("inbox line has %d unread and peer %v, want %d and %d", unread, peerID, tt.unread, peer)
+			}
+		})
+	}
+
+	// Nothing is lost on the way
+	var left, orphaned, summariesAfter int
+	err := db.QueryRow(ctx, `SELECT
+			(SELECT COUNT(*) FROM messages WHERE conversation_id IS NULL),
+			(SELECT COUNT(*) FROM orphaned_messages),
+			(SELECT COUNT(*) FROM conversation_summaries)`).Scan(&left, &orphaned, &summariesAfter)
+	if err != nil {
+		t.Fatalf("cannot count leftovers: %v", err)
+	}
+	if left != 0 || orphaned != 0 || summariesAfter != summaries {
+		t.Fatalf("%d messages without conversation, %d orphaned, %d of %d inbox lines left", left, orphaned, summariesAfter, summaries)
+	}
+}
@@ -0,0 +1,625 @@
+package repositories
+
+import (
+	"context"
+	"message-service/internal/domain"
+	"message-service/internal/utils"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	logger "message-service/internal"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultPartition = "messages_default"
+	// Name of detached partition, so it doesn't look like a live one
+	archivedPartitionPrefix = "archived_"
+	stagingSuffix           = "_staging"
+	partitionBoundsLayout   = "2006-01-02 15:04:05"
+	// Random number, the same for all instances
+	partitionLockKey = 734120981
+	// Keys of messages changed while staging tables are filled, written by trigger on messages
+	changesTable   = "repartition_changes"
+	changesTrigger = "repartition_track"
+)
+
+// FOR VALUES FROM ('2024-01-01 00:00:00') TO ('2024-01-02 00:00:00')
+var partitionBoundsRegexp = regexp.MustCompile(`FROM \('([^']+)'\) TO \('([^']+)'\)`)
+
+// PostgresPartitionRepo manages range partitions of messages table.
+// Advisory lock belongs to connection, so give it its own one
+type PostgresPartitionRepo struct {
+	db *pgx.Conn
+}
+
+func NewPostgresPartitionRepo(db *pgx.Conn) *PostgresPartitionRepo {
+	return &PostgresPartitionRepo{db: db}
+}
+
+func (r *PostgresPartitionRepo) TryLock(ctx context.Context) (bool, *utils.APIError) {
+	var locked bool
+	if err := r.db.QueryRow(ctx, "SELECT pg_try_advisory_lock($1)", partitionLockKey).Scan(&locked); err != nil {
+		logger.Error("Cannot take partition lock", zap.Error(err))
+		return false, ClassifyDBerror(err)
+	}
+	return locked, nil
+}
+
+func (r *PostgresPartitionRepo) Unlock(ctx context.Context) *utils.APIError {
+	if _, err := r.db.Exec(ctx, "SELECT pg_advisory_unlock($1)", partitionLockKey); err != nil {
+		logger.Error("Cannot release partition lock", zap.Error(err))
+		return ClassifyDBerror(err)
+	}
+	return nil
+}
+
+func (r *PostgresPartitionRepo) ListPartitions(ctx context.Context) ([]domain.Partition, *utils.APIError) {
+	// Bounds are taken from catalog, so partitions of any granularity are found, whatever their names are
+	query := `SELECT c.relname, pg_get_expr(c.relpartbound, c.oid),
+			(SELECT COUNT(*) FROM pg_inherits s WHERE s.inhparent = c.oid)
+		FROM pg_inherits i
+		JOIN pg_class c ON c.oid = i.inhrelid
+		WHERE i.inhparent = 'messages'::regclass`
+
+	rows, err := r.db.Query(ctx, query)
+	if err != nil {
+		logger.Error("Cannot list partitions", zap.Error(err))
+		return nil, ClassifyDBerror(err)
+	}
+	defer rows.Close()
+
+	var partitions []domain.Partition
+	for rows.Next() {
+		var name, bounds string
+		var buckets int
+		if err := rows.Scan(&name, &bounds, &buckets); err != nil {
+			return nil, ClassifyDBerror(err)
+		}
+
+		// Default partition has no range
+		match := partitionBoundsRegexp.FindStringSubmatch(bounds)
+		if match == nil {
+			continue
+		}
+		start, err := time.Parse(partitionBoundsLayout, match[1])
+		if err != nil {
+			continue
+		}
+		end, err := time.Parse(partitionBoundsLayout, match[2])
+		if err != nil {
+			continue
+		}
+
+		partitions = append(partitions, domain.Partition{Name: name, Start: start, End: end, HashBuckets: buckets})
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, ClassifyDBerror(err)
+	}
+
+	sortPartitions(partitions)
+	return partitions, nil
+}
+
+func (r *PostgresPartitionRepo) CreatePartition(ctx context.Context, partition *domain.Partition) *utils.APIError {
+	table := pgx.Identifier{partition.Name}.Sanitize()
+
+	// Postgres refuses to attach partition if default one has rows for the same range,
+	// so table is created separately, rows are moved and only then it is attached
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		logger.Error("Cannot begin transaction", zap.Error(err))
+		return ClassifyDBerror(err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := createPartitionTable(ctx, tx, partition.Name, partition.HashBuckets); err != nil {
+		logger.Error("Cannot create partition "+partition.Name, zap.Error(err))
+		return ClassifyDBerror(err)
+	}
+
+	moved, err := tx.Exec(ctx, `WITH moved AS (
+			DELETE FROM `+defaultPartition+` WHERE timestamp >= $1 AND timestamp < $2 RETURNING *
+		)
+		INSERT INTO `+table+` SELECT * FROM moved`, partition.Start, partition.End)
+	if err != nil {
+		logger.Error("Cannot move messages from default partition to "+partition.Name, zap.Error(err))
+		return ClassifyDBerror(err)
+	}
+
+	if _, err := tx.Exec(ctx, attachPartitionQuery(partition)); err != nil {
+		logger.Error("Cannot attach partition "+partition.Name, zap.Error(err))
+		return ClassifyDBerror(err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		logger.Error("Cannot commit partition "+partition.Name, zap.Error(err))
+		return ClassifyDBerror(err)
+	}
+
+	logger.Info("Created new partition "+partition.Name, zap.Int64("Moved from default", moved.RowsAffected()))
+	return nil
+}
+
+func (r *PostgresPartitionRepo) CreateDefaultPartition(ctx context.Context) *utils.APIError {
+	query := "CREATE TABLE IF NOT EXISTS " + defaultPartition + " PARTITION OF messages DEFAULT"
+	if _, err := r.db.Exec(ctx, query); err != nil {
+		logger.Error("Cannot create default partition", zap.Error(err))
+		return ClassifyDBerror(err)
+	}
+	return nil
+}
+
+func (r *PostgresPartitionRepo) DetachPartition(ctx context.Context, partition *domain.Partition) *utils.APIError {
+	table := pgx.Identifier{partition.Name}.Sanitize()
+	archived := pgx.Identifier{archivedPartitionPrefix + partition.Name}.Sanitize()
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		logger.Error("Cannot begin transaction", zap.Error(err))
+		return ClassifyDBerror(err)
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, "ALTER TABLE messages DETACH PARTITION "+table); err != nil {
+		logger.Error("Cannot detach partition "+partition.Name, zap.Error(err))
+		return ClassifyDBerror(err)
+	}
+
+	if _, err := tx.Exec(ctx, "ALTER TABLE "+table+" RENAME TO "+archived); err != nil {
+		logger.Error("Cannot rename detached partition "+partition.Name, zap.Error(err))
+		return ClassifyDBerror(err)
+	}
+
+	indexQuery := `INSERT INTO archive_index (table_name, range_start, range_end, status)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (table_name) DO UPDATE
+		SET range_start = EXCLUDED.range_start, range_end = EXCLUDED.range_end, status = EXCLUDED.status`
+	if _, err := tx.Exec(ctx, indexQuery, archivedPartitionPrefix+partition.Name, partition.Start, partition.End, domain.ArchiveDetached); err != nil {
+		logger.Error("Cannot add partition "+partition.Name+" to archive index", zap.Error(err))
+		return ClassifyDBerror(err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		logger.Error("Cannot commit detach of partition "+partition.Name, zap.Error(err))
+		return ClassifyDBerror(err)
+	}
+
+	logger.Info("Detached partition " + partition.Name)
+	return nil
+}
+
+func (r *PostgresPartitionRepo) DropPartition(ctx context.Context, partition *domain.Partition) *utils.APIError {
+	if _, err := r.db.Exec(ctx, "DROP TABLE "+pgx.Identifier{partition.Name}.Sanitize()); err != nil {
+		logger.Error("Cannot drop partition "+partition.Name, zap.Error(err))
+		return ClassifyDBerror(err)
+	}
+
+	logger.Info("Dropped partition " + partition.Name)
+	return nil
+}
+
+func (r *PostgresPartitionRepo) ForEachDetachedMessage(ctx context.Context, table string, fn func(*domain.Message) error) *utils.APIError {
+	query := "SELECT " + messageColumns + " FROM " + pgx.Identifier{table}.Sanitize() +
+		" ORDER BY conversation_id, timestamp, id"
+
+	rows, err := r.db.Query(ctx, query)
+	if err != nil {
+		logger.Error("Cannot read detached partition "+table, zap.Error(err))
+		return ClassifyDBerror(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var msg domain.Message
+		if err := scanMessage(rows, &msg); err != nil {
+			return ClassifyDBerror(err)
+		}
+
+		if err := fn(&msg); err != nil {
+			return utils.NewAPIError(500, "Cannot process message", err.Error())
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		logger.Error("Cannot read detached partition "+table, zap.Error(err))
+		return ClassifyDBerror(err)
+	}
+
+	return nil
+}
+
+func (r *PostgresPartitionRepo) DropDetachedPartition(ctx context.Context, table string) *utils.APIError {
+	// Never drop live partition by mistake
+	if !strings.HasPrefix(table, archivedPartitionPrefix) {
+		return utils.NewAPIError(400, "Not a detached partition", table)
+	}
+
+	if _, err := r.db.Exec(ctx, "DROP TABLE IF EXISTS "+pgx.Identifier{table}.Sanitize()); err != nil {
+		logger.Error("Cannot drop detached partition "+table, zap.Error(err))
+		return ClassifyDBerror(err)
+	}
+
+	logger.Info("Dropped detached partition " + table)
+	return nil
+}
+
+func (r *PostgresPartitionRepo) CreateStagingPartition(ctx context.Context, partition *domain.Partition) *utils.APIError {
+	staging := partition.Name + stagingSuffix
+	table := pgx.Identifier{staging}.Sanitize()
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		logger.Error("Cannot begin transaction", zap.Error(err))
+		return ClassifyDBerror(err)
+	}
+	defer tx.Rollback(ctx)
+
+	// Leftover of interrupted run
+	if _, err := tx.Exec(ctx, "DROP TABLE IF EXISTS "+table); err != nil {
+		logger.Error("Cannot drop old staging table "+staging, zap.Error(err))
+		return ClassifyDBerror(err)
+	}
+
+	if err := createPartitionTable(ctx, tx, staging, partition.HashBuckets); err != nil {
+		logger.Error("Cannot create staging table "+staging, zap.Error(err))
+		return ClassifyDBerror(err)
+	}
+
+	// With this constraint Postgres doesn't scan table on attach, so swap is fast
+	boundsQuery := "ALTER TABLE " + table + " ADD CONSTRAINT " + pgx.Identifier{staging + "_bounds"}.Sanitize() +
+		" CHECK (timestamp IS NOT NULL AND timestamp >= '" + partition.Start.Format(partitionBoundsLayout) +
+		"' AND timestamp < '" + partition.End.Format(partitionBoundsLayout) + "')"
+	if _, err := tx.Exec(ctx, boundsQuery); err != nil {
+		logger.Error("Cannot add bounds to staging table "+staging, zap.Error(err))
+		return ClassifyDBerror(err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		logger.Error("Cannot commit staging table "+staging, zap.Error(err))
+		return ClassifyDBerror(err)
+	}
+
+	return nil
+}
+
+func (r *PostgresPartitionRepo) CopyToStaging(ctx context.Context, partition *domain.Partition) (int64, *utils.APIError) {
+	// Reading doesn't block writers, so it can take as long as it needs
+	query := "INSERT INTO " + pgx.Identifier{partition.Name + stagingSuffix}.Sanitize() +
+		" SELECT * FROM messages WHERE timestamp >= $1 AND timestamp < $2"
+
+	tag, err := r.db.Exec(ctx, query, partition.Start, partition.End)
+	if err != nil {
+		logger.Error("Cannot copy messages to staging table of "+partition.Name, zap.Error(err))
+		return 0, ClassifyDBerror(err)
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+func (r *PostgresPartitionRepo) StartChangeTracking(ctx context.Context) *utils.APIError {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		logger.Error("Cannot begin transaction", zap.Error(err))
+		return ClassifyDBerror(err)
+	}
+	defer tx.Rollback(ctx)
+
+	// Only re-partitioning reads it, and it starts from scratch after crash, so WAL is not needed
+	queries := []string{
+		"DROP TRIGGER IF EXISTS " + changesTrigger + " ON messages",
+		"DROP TABLE IF EXISTS " + changesTable,
+		"CREATE UNLOGGED TABLE " + changesTable + ` (
+			id BIGSERIAL PRIMARY KEY,
+			message_id INT NOT NULL,
+			message_timestamp TIMESTAMP NOT NULL
+		)`,
+		`CREATE OR REPLACE FUNCTION ` + changesTrigger + `() RETURNS trigger AS $$
+		BEGIN
+			IF TG_OP <> 'INSERT' THEN
+				INSERT INTO ` + changesTable + ` (message_id, message_timestamp) VALUES (OLD.id, OLD.timestamp);
+			END IF;
+			IF TG_OP <> 'DELETE' THEN
+				INSERT INTO ` + changesTable + ` (message_id, message_timestamp) VALUES (NEW.id, NEW.timestamp);
+			END IF;
+			RETURN NULL;
+		END
+		$$ LANGUAGE plpgsql`,
+		// Trigger of partitioned table works on all its partitions
+		"CREATE TRIGGER " + changesTrigger + " AFTER INSERT OR UPDATE OR DELETE ON messages FOR EACH ROW EXECUTE FUNCTION " + changesTrigger + "()",
+	}
+	for _, query := range queries {
+		if _, err := tx.Exec(ctx, query); err != nil {
+			logger.Error("Cannot start tracking changes of messages", zap.Error(err))
+			return ClassifyDBerror(err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		logger.Error("Cannot commit change tracking", zap.Error(err))
+		return ClassifyDBerror(err)
+	}
+
+	return nil
+}
+
+func (r *PostgresPartitionRepo) StopChangeTracking(ctx context.Context) *utils.APIError {
+	if err := dropChangeTracking(ctx, r.db); err != nil {
+		logger.Error("Cannot stop tracking changes of messages", zap.Error(err))
+		return ClassifyDBerror(err)
+	}
+
+	return nil
+}
+
+func (r *PostgresPartitionRepo) ApplyChanges(ctx context.Context, partitions []domain.Partition, limit int) (int64, *utils.APIError) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		logger.Error("Cannot begin transaction", zap.Error(err))
+		return 0, ClassifyDBerror(err)
+	}
+	defer tx.Rollback(ctx)
+
+	applied, err := applyChanges(ctx, tx, partitions, limit)
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+
+	if err != nil {
+		logger.Error("Cannot apply changes to staging tables", zap.Error(err))
+		return 0, ClassifyDBerror(err)
+	}
+
+	return applied, nil
+}
+
+func (r *PostgresPartitionRepo) SwapPartitions(ctx context.Context, partitions []domain.Partition, old []domain.Partition) *utils.APIError {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		logger.Error("Cannot begin transaction", zap.Error(err))
+		return ClassifyDBerror(err)
+	}
+	defer tx.Rollback(ctx)
+
+	// Don't wait in queue behind long queries, everybody else would wait behind us. Caller can retry
+	if _, err := tx.Exec(ctx, "SET LOCAL lock_timeout = '5s'"); err != nil {
+		return ClassifyDBerror(err)
+	}
+	if _, err := tx.Exec(ctx, "LOCK TABLE messages IN ACCESS EXCLUSIVE MODE"); err != nil {
+		logger.Warn("Cannot lock messages table for partition swap", zap.Error(err))
+		return utils.NewAPIError(503, "Messages table is busy", "Try again later")
+	}
+
+	// Only changes which came after the last ApplyChanges are left, so it is quick
+	for {
+		applied, err := applyChanges(ctx, tx, partitions, changesBatch)
+		if err != nil {
+			logger.Error("Cannot apply changes to staging tables", zap.Error(err))
+			return ClassifyDBerror(err)
+		}
+		if applied < changesBatch {
+			break
+		}
+	}
+
+	// Under lock nobody changes messages anymore
+	if err := dropChangeTracking(ctx, tx); err != nil {
+		logger.Error("Cannot stop tracking changes of messages", zap.Error(err))
+		return ClassifyDBerror(err)
+	}
+
+	for _, partition := range old {
+		table := pgx.Identifier{partition.Name}.Sanitize()
+		if _, err := tx.Exec(ctx, "ALTER TABLE messages DETACH PARTITION "+table); err != nil {
+			logger.Error("Cannot detach partition "+partition.Name, zap.Error(err))
+			return ClassifyDBerror(err)
+		}
+		if _, err := tx.Exec(ctx, "DROP TABLE "+table); err != nil {
+			logger.Error("Cannot drop partition "+partition.Name, zap.Error(err))
+			return ClassifyDBerror(err)
+		}
+	}
+
+	for _, partition := range partitions {
+		// These rows are already copied
+		if _, err := tx.Exec(ctx, "DELETE FROM "+defaultPartition+" WHERE timestamp >= $1 AND timestamp < $2", partition.Start, partition.End); err != nil {
+			logger.Error("Cannot clean default partition", zap.Error(err))
+			return ClassifyDBerror(err)
+		}
+
+		if err := renameStaging(ctx, tx, &partition); err != nil {
+			logger.Error("Cannot rename staging table of "+partition.Name, zap.Error(err))
+			return ClassifyDBerror(err)
+		}
+
+		if _, err := tx.Exec(ctx, attachPartitionQuery(&partition)); err != nil {
+			logger.Error("Cannot attach partition "+partition.Name, zap.Error(err))
+			return ClassifyDBerror(err)
+		}
+
+		// Partition bounds do the same job now
+		dropBoundsQuery := "ALTER TABLE " + pgx.Identifier{partition.Name}.Sanitize() +
+			" DROP CONSTRAINT " + pgx.Identifier{partition.Name + stagingSuffix + "_bounds"}.Sanitize()
+		if _, err := tx.Exec(ctx, dropBoundsQuery); err != nil {
+			logger.Error("Cannot drop bounds of partition "+partition.Name, zap.Error(err))
+			return ClassifyDBerror(err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		logger.Error("Cannot commit partition swap", zap.Error(err))
+		return ClassifyDBerror(err)
+	}
+
+	return nil
+}
+
+// How many tracked changes are applied in one transaction
+const changesBatch = 10000
+
+// applyChanges takes up to limit tracked changes and copies current state of these messages to staging tables.
+// Messages which were deleted are deleted from staging too. Returns how many changes were taken
+func applyChanges(ctx context.Context, tx pgx.Tx, partitions []domain.Partition, limit int) (int64, error) {
+	rows, err := tx.Query(ctx, `WITH batch AS (
+			DELETE FROM `+changesTable+` WHERE id IN (SELECT id FROM `+changesTable+` ORDER BY id LIMIT $1)
+			RETURNING message_id, message_timestamp
+		)
+		SELECT message_id, message_timestamp FROM batch`, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	type messageKey struct {
+		id        int
+		timestamp time.Time
+	}
+	var taken int64
+	var ids []int
+	var timestamps []time.Time
+	seen := make(map[messageKey]bool)
+	for rows.Next() {
+		var key messageKey
+		if err := rows.Scan(&key.id, &key.timestamp); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		taken++
+		// Message changed several times is copied once
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		ids = append(ids, key.id)
+		timestamps = append(timestamps, key.timestamp)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	for _, partition := range partitions {
+		staging := pgx.Identifier{partition.Name + stagingSuffix}.Sanitize()
+
+		deleteQuery := `DELETE FROM ` + staging + ` s USING unnest($1::int[], $2::timestamp[]) AS k(id, timestamp)
+			WHERE s.id = k.id AND s.timestamp = k.timestamp`
+		if _, err := tx.Exec(ctx, deleteQuery, ids, timestamps); err != nil {
+			return 0, err
+		}
+
+		insertQuery := `INSERT INTO ` + staging + ` SELECT m.* FROM messages m
+			JOIN unnest($1::int[], $2::timestamp[]) AS k(id, timestamp) ON m.id = k.id AND m.timestamp = k.timestamp
+			WHERE m.timestamp >= $3 AND m.timestamp < $4`
+		if _, err := tx.Exec(ctx, insertQuery, ids, timestamps, partition.Start, partition.End); err != nil {
+			return 0, err
+		}
+	}
+
+	return taken, nil
+}
+
+// Trigger and its table go together. Function stays, it is replaced on next start
+func dropChangeTracking(ctx context.Context, db interface {
+	Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)
+}) error {
+	if _, err := db.Exec(ctx, "DROP TRIGGER IF EXISTS "+changesTrigger+" ON messages"); err != nil {
+		return err
+	}
+	_, err := db.Exec(ctx, "DROP TABLE IF EXISTS "+changesTable)
+	return err
+}
+
+// createPartitionTable creates table which looks like messages, with all its indexes,
+// and splits it into hash partitions by conversation if buckets > 0
+func createPartitionTable(ctx context.Context, tx pgx.Tx, name string, buckets int) error {
+	query := "CREATE TABLE " + pgx.Identifier{name}.Sanitize() +
+		" (LIKE messages INCLUDING DEFAULTS INCLUDING CONSTRAINTS INCLUDING INDEXES)"
+	if buckets > 0 {
+		query += " PARTITION BY HASH (conversation_id)"
+	}
+
+	if _, err := tx.Exec(ctx, query); err != nil {
+		return err
+	}
+
+	for i := 0; i < buckets; i++ {
+		bucketQuery := "CREATE TABLE " + pgx.Identifier{bucketName(name, i)}.Sanitize() +
+			" PARTITION OF " + pgx.Identifier{name}.Sanitize() +
+			" FOR VALUES WITH (MODULUS " + strconv.Itoa(buckets) + ", REMAINDER " + strconv.Itoa(i) + ")"
+		if _, err := tx.Exec(ctx, bucketQuery); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// renameStaging gives staging table, its hash partitions and indexes names of the real partition
+func renameStaging(ctx context.Context, tx pgx.Tx, partition *domain.Partition) error {
+	staging := partition.Name + stagingSuffix
+
+	tables := []string{partition.Name}
+	renames := [][2]string{{staging, partition.Name}}
+	for i := 0; i < partition.HashBuckets; i++ {
+		tables = append(tables, bucketName(partition.Name, i))
+		renames = append(renames, [2]string{bucketName(staging, i), bucketName(partition.Name, i)})
+	}
+
+	for _, rename := range renames {
+		query := "ALTER TABLE " + pgx.Identifier{rename[0]}.Sanitize() + " RENAME TO " + pgx.Identifier{rename[1]}.Sanitize()
+		if _, err := tx.Exec(ctx, query); err != nil {
+			return err
+		}
+	}
+
+	// Otherwise next re-partitioning can't create staging table with the same index names
+	rows, err := tx.Query(ctx, `SELECT i.relname FROM pg_index x
+		JOIN pg_class i ON i.oid = x.indexrelid
+		JOIN pg_class t ON t.oid = x.indrelid
+		WHERE t.relname = ANY($1)`, tables)
+	if err != nil {
+		return err
+	}
+	indexes, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return err
+	}
+
+	for _, index := range indexes {
+		if !strings.Contains(index, stagingSuffix) {
+			continue
+		}
+		query := "ALTER INDEX " + pgx.Identifier{index}.Sanitize() +
+			" RENAME TO " + pgx.Identifier{strings.Replace(index, stagingSuffix, "", 1)}.Sanitize()
+		if _, err := tx.Exec(ctx, query); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func attachPartitionQuery(partition *domain.Partition) string {
+	return "ALTER TABLE messages ATTACH PARTITION " + pgx.Identifier{partition.Name}.Sanitize() +
+		" FOR VALUES FROM ('" + partition.Start.Format(partitionBoundsLayout) +
+		"') TO ('" + partition.End.Format(partitionBoundsLayout) + "')"
+}
+
+func bucketName(table string, bucket int) string {
+	return table + "_h" + strconv.Itoa(bucket)
+}
+
+func sortPartitions(partitions []domain.Partition) {
+	sort.Slice(partitions, func(i, j int) bool {
+		return partitions[i].Start.Before(partitions[j].Start)
+	})
+}
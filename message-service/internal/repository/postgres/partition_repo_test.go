@@ -0,0 +1,136 @@
+package repositories
+
+import (
+	"context"
+	"message-service/internal/domain"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSwapPartitions(t *testing.T) {
+	// Monday, so the week starts with it
+	day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	daily := domain.PartitionScheme{Granularity: domain.Daily}
+
+	tests := []struct {
+		name    string
+		buckets int
+		// Repartitioner catches up before swap, otherwise everything is applied under lock
+		applyBeforeSwap bool
+	}{
+		{name: "daily to weekly", applyBeforeSwap: true},
+		{name: "daily to weekly with hash buckets", buckets: 4, applyBeforeSwap: true},
+		{name: "changes applied only under lock"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			db := testSchemaDB(t)
+			repo := NewPostgresPartitionRepo(testConn(t, db))
+
+			sender, peer := createUser(t, db, "sender"), createUser(t, db, "peer")
+			conversationID := createConversation(t, db, "direct", sender, peer)
+			insert := func(timestamp time.Time, content string) int {
+				t.Helper()
+				var id int
+				err := db.QueryRow(ctx, `INSERT INTO messages (sender_id, recipient_id, conversation_id, content, timestamp)
+					VALUES ($1, $2, $3, $4, $5) RETURNING id`, sender, peer, conversationID, content, timestamp).Scan(&id)
+				if err != nil {
+					t.Fatalf("cannot insert message: %v", err)
+				}
+				return id
+			}
+
+			old := []domain.Partition{daily.Partition(day), daily.Partition(day.AddDate(0, 0, 1))}
+			for _, partition := range old {
+				if apiErr := repo.CreatePartition(ctx, &partition); apiErr != nil {
+					t.Fatalf("cannot create partition: %v", apiErr.Message)
+				}
+			}
+
+			// Third day has no partition, so its message is in default one. Next week stays there after swap
+			want := map[int]string{
+				insert(day.Add(time.Hour), "kept"):                        "kept",
+				insert(day.AddDate(0, 0, 2).Add(time.Hour), "in default"): "in default",
+				insert(day.AddDate(0, 0, 8), "next week"):                 "next week",
+			}
+			edited := insert(day.AddDate(0, 0, 1).Add(time.Hour), "before edit")
+			deleted := insert(day.Add(2*time.Hour), "deleted")
+
+			weekly := domain.PartitionScheme{Granularity: domain.Weekly, HashBuckets: tt.buckets}.Partition(day)
+			if apiErr := repo.StartChangeTracking(ctx); apiErr != nil {
+				t.Fatalf("StartChangeTracking: %v", apiErr.Message)
+			}
+			if apiErr := repo.CreateStagingPartition(ctx, &weekly); apiErr != nil {
+				t.Fatalf("CreateStagingPartition: %v", apiErr.Message)
+			}
+			if _, apiErr := repo.CopyToStaging(ctx, &weekly); apiErr != nil {
+				t.Fatalf("CopyToStaging: %v", apiErr.Message)
+			}
+
+			// Made after copy, only tracking knows about them
+			if _, err := db.Exec(ctx, `UPDATE messages SET content = 'after edit' WHERE id = $1`, edited); err != nil {
+				t.Fatalf("cannot edit message: %v", err)
+			}
+			want[edited] = "after edit"
+			if _, err := db.Exec(ctx, `DELETE FROM messages WHERE id = $1`, deleted); err != nil {
+				t.Fatalf("cannot delete message: %v", err)
+			}
+			want[insert(day.AddDate(0, 0, 3), "added")] = "added"
+
+			if tt.applyBeforeSwap {
+				if _, apiErr := repo.ApplyChanges(ctx, []domain.Partition{weekly}, 2); apiErr != nil {
+					t.Fatalf("ApplyChanges: %v", apiErr.Message)
+				}
+			}
+			if apiErr := repo.SwapPartitions(ctx, []domain.Partition{weekly}, old); apiErr != nil {
+				t.Fatalf("SwapPartitions: %v", apiErr.Message)
+			}
+
+			partitions, apiErr := repo.ListPartitions(ctx)
+			if apiErr != nil {
+				t.Fatalf("ListPartitions: %v", apiErr.Message)
+			}
+			if len(partitions) != 1 || partitions[0].Name != weekly.Name || partitions[0].HashBuckets != tt.buckets ||
+				!partitions[0].Start.Equal(weekly.Start) || !partitions[0].End.Equal(weekly.End) {
+				t.Fatalf("partitions after swap: %+v, want %+v", partitions, weekly)
+			}
+
+			rows, err := db.Query(ctx, `SELECT id, content FROM messages`)
+			if err != nil {
+				t.Fatalf("cannot read messages: %v", err)
+			}
+			got := map[int]string{}
+			for rows.Next() {
+				var id int
+				var content string
+				if err := rows.Scan(&id, &content); err != nil {
+					t.Fatalf("cannot scan message: %v", err)
+				}
+				got[id] = content
+			}
+			rows.Close()
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("messages after swap: %v, want %v", got, want)
+			}
+
+			var inDefault int
+			if err := db.QueryRow(ctx, `SELECT COUNT(*) FROM `+defaultPartition).Scan(&inDefault); err != nil {
+				t.Fatalf("cannot count default partition: %v", err)
+			}
+			if inDefault != 1 {
+				t.Fatalf("%d messages left in default partition, want only the one of next week", inDefault)
+			}
+
+			var tracking bool
+			if err := db.QueryRow(ctx, `SELECT to_regclass($1) IS NOT NULL`, changesTable).Scan(&tracking); err != nil {
+				t.Fatalf("cannot check change tracking: %v", err)
+			}
+			if tracking {
+				t.Fatalf("change tracking is left after swap")
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package repositories
+
+import (
+	"context"
+	"encoding/json"
+	"message-service/internal/domain"
+	"message-service/internal/utils"
+	"strconv"
+	"time"
+
+	logger "message-service/internal"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+type PostgresSyncRepo struct {
+	db *pgxpool.Pool
+}
+
+func NewPostgresSyncRepo(db *pgxpool.Pool) *PostgresSyncRepo {
+	return &PostgresSyncRepo{db: db}
+}
+
+// appendSyncLog saves records in transaction of change which caused them. Users get entries with their next
+// numbers, all records go in one statement, so counters of users are locked once and in order of IDs
+func appendSyncLog(ctx context.Context, tx pgx.Tx, records ...*domain.SyncRecord) error {
+	var userIDs []int
+	var types, payloads []string
+	for _, record := range records {
+		if record == nil {
+			continue
+		}
+
+		payload, err := json.Marshal(record.Event)
+		if err != nil {
+			return err
+		}
+		// Every user gets event once, e.g. when sender is recipient too
+		seen := make(map[int]bool, len(record.UserIDs))
+		for _, userID := range record.UserIDs {
+			if seen[userID] {
+				continue
+			}
+			seen[userID] = true
+			userIDs = append(userIDs, userID)
+			types = append(types, string(record.Event.Type))
+			payloads = append(payloads, string(payload))
+		}
+	}
+	if len(userIDs) == 0 {
+		return nil
+	}
+
+	// Counter row of user stays locked until commit, so entries of user are committed in order of their numbers.
+	// Counter moves by all entries of user at once, they take numbers before it in order of records
+	query := `WITH entries AS (
+			SELECT user_id, type, payload,
+				row_number() OVER (PARTITION BY user_id ORDER BY n) AS num,
+				count(*) OVER (PARTITION BY user_id) AS total
+			FROM unnest($1::int[], $2::text[], $3::text[]) WITH ORDINALITY AS e(user_id, type, payload, n)
+		), counters AS (
+			INSERT INTO user_sync_state (user_id, last_seq)
+			SELECT user_id, MAX(total) FROM entries GROUP BY user_id ORDER BY user_id
+			ON CONFLICT (user_id) DO UPDATE SET last_seq = user_sync_state.last_seq + EXCLUDED.last_seq
+			RETURNING user_id, last_seq
+		)
+		INSERT INTO user_sync_log (user_id, seq, type, payload)
+		SELECT e.user_id, c.last_seq - e.total + e.num, e.type, e.payload::jsonb
+		FROM entries e JOIN counters c ON c.user_id = e.user_id`
+
+	_, err := tx.Exec(ctx, query, userIDs, types, payloads)
+	return err
+}
+
+// scrubSyncLog clears content of message in sync log entries which carry it (message.created, message.edited),
+// it runs in transaction which deletes message. Entries stay, so numbers of users have no gaps
+func scrubSyncLog(ctx context.Context, tx pgx.Tx, messageID int) error {
+	// Expression is the same as in index user_sync_log_message_idx
+	_, err := tx.Exec(ctx, `UPDATE user_sync_log SET payload = jsonb_set(payload, '{message,content}', '""')
+		WHERE payload #>> '{message,id}' = $1 AND payload #>> '{message,content}' <> ''`, strconv.Itoa(messageID))
+	return err
+}
+
+func (r *PostgresSyncRepo) GetEntries(ctx context.Context, userID int, since int64, limit int) ([]domain.SyncEntry, *utils.APIError) {
+	query := `SELECT seq, payload FROM user_sync_log
+		WHERE user_id = $1 AND seq > $2
+		ORDER BY seq
+		LIMIT $3`
+
+	rows, err := r.db.Query(ctx, query, userID, since, limit)
+	if err != nil {
+		logger.Error("Cannot get sync log",
+			zap.Int("User ID", userID),
+			zap.Int64("Since", since),
+			zap.Error(err))
+		return nil, ClassifyDBerror(err)
+	}
+	defer rows.Close()
+
+	entries := []domain.SyncEntry{}
+	for rows.Next() {
+		var entry domain.SyncEntry
+		var payload []byte
+		if err := rows.Scan(&entry.Seq, &payload); err != nil {
+			return nil, ClassifyDBerror(err)
+		}
+		if err := json.Unmarshal(payload, &entry.Event); err != nil {
+			logger.Error("Cannot decode sync event",
+				zap.Int("User ID", userID),
+				zap.Int64("Seq", entry.Seq),
+				zap.Error(err))
+			return nil, utils.NewAPIError(500, "Internal server error", "")
+		}
+		entries = append(entries, entry)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, ClassifyDBerror(err)
+	}
+
+	return entries, nil
+}
+
+func (r *PostgresSyncRepo) GetState(ctx context.Context, userID int) (*domain.SyncState, *utils.APIError) {
+	state := &domain.SyncState{}
+
+	err := r.db.QueryRow(ctx, `SELECT last_seq, compacted_seq FROM user_sync_state WHERE user_id = $1`, userID).
+		Scan(&state.LastSeq, &state.CompactedSeq)
+	// Nothing happened to user yet
+	if err == pgx.ErrNoRows {
+		return state, nil
+	}
+	if err != nil {
+		logger.Error("Cannot get sync state",
+			zap.Int("User ID", userID),
+			zap.Error(err))
+		return nil, ClassifyDBerror(err)
+	}
+
+	return state, nil
+}
+
+func (r *PostgresSyncRepo) Compact(ctx context.Context, before time.Time) (int64, *utils.APIError) {
+	// Users remember up to what number entries are gone, so clients behind it are told to resync
+	query := `WITH deleted AS (
+			DELETE FROM user_sync_log WHERE created_at < $1 RETURNING user_id, seq
+		), compacted AS (
+			UPDATE user_sync_state s SET compacted_seq = d.max_seq
+			FROM (SELECT user_id, MAX(seq) AS max_seq FROM deleted GROUP BY user_id) d
+			WHERE s.user_id = d.user_id AND s.compacted_seq < d.max_seq
+		)
+		SELECT COUNT(*) FROM deleted`
+
+	var deleted int64
+	if err := r.db.QueryRow(ctx, query, before).Scan(&deleted); err != nil {
+		logger.Error("Cannot compact sync log", zap.Error(err))
+		return 0, ClassifyDBerror(err)
+	}
+
+	return deleted, nil
+}
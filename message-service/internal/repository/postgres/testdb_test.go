@@ -0,0 +1,133 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	logger "message-service/internal"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Schema of both services, tests make their tables from it
+const initSQL = "../../../../database/init.sql"
+
+func TestMain(m *testing.M) {
+	logger.InitLogger()
+	os.Exit(m.Run())
+}
+
+// testDB gives pool which works in a new empty schema, it is dropped after test.
+// Tests which need Postgres are skipped unless TEST_DATABASE_URL is set
+func testDB(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	ctx := context.Background()
+	admin, err := pgx.Connect(ctx, url)
+	if err != nil {
+		t.Fatalf("cannot connect to test database: %v", err)
+	}
+
+	schema := fmt.Sprintf("test_%d", time.Now().UnixNano())
+	if _, err := admin.Exec(ctx, "CREATE SCHEMA "+schema); err != nil {
+		admin.Close(ctx)
+		t.Fatalf("cannot create schema: %v", err)
+	}
+	t.Cleanup(func() {
+		admin.Exec(ctx, "DROP SCHEMA "+schema+" CASCADE")
+		admin.Close(ctx)
+	})
+
+	config, err := pgxpool.ParseConfig(url)
+	if err != nil {
+		t.Fatalf("cannot parse TEST_DATABASE_URL: %v", err)
+	}
+	config.ConnConfig.RuntimeParams["search_path"] = schema
+
+	db, err := pgxpool.NewWithConfig(ctx, config)
+	if err != nil {
+		t.Fatalf("cannot open pool: %v", err)
+	}
+	t.Cleanup(db.Close)
+
+	return db
+}
+
+// testConn opens separate connection to the same schema, for repos which need their own one
+func testConn(t *testing.T, db *pgxpool.Pool) *pgx.Conn {
+	t.Helper()
+
+	ctx := context.Background()
+	conn, err := pgx.ConnectConfig(ctx, db.Config().ConnConfig)
+	if err != nil {
+		t.Fatalf("cannot connect to test database: %v", err)
+	}
+	t.Cleanup(func() { conn.Close(ctx) })
+
+	return conn
+}
+
+// testSchemaDB is testDB with tables of init.sql
+func testSchemaDB(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+
+	db := testDB(t)
+	execFile(t, db, initSQL)
+	return db
+}
+
+// execFile runs SQL script. Without arguments pgx sends it as one simple query, so it can have many statements
+func execFile(t *testing.T, db *pgxpool.Pool, path string) {
+	t.Helper()
+
+	script, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("cannot read %s: %v", path, err)
+	}
+	if _, err := db.Exec(context.Background(), string(script)); err != nil {
+		t.Fatalf("cannot run %s: %v", path, err)
+	}
+}
+
+func createUser(t *testing.T, db *pgxpool.Pool, username string) int {
+	t.Helper()
+
+	var id int
+	err := db.QueryRow(context.Background(), `INSERT INTO users (username, password) VALUES ($1, '') RETURNING id`, username).Scan(&id)
+	if err != nil {
+		t.Fatalf("cannot create user %s: %v", username, err)
+	}
+	return id
+}
+
+// createConversation makes conversation of given type, first of users is its owner
+func createConversation(t *testing.T, db *pgxpool.Pool, conversationType string, userIDs ...int) int {
+	t.Helper()
+
+	ctx := context.Background()
+	var id int
+	err := db.QueryRow(ctx, `INSERT INTO conversations (type, created_by) VALUES ($1, $2) RETURNING id`, conversationType, userIDs[0]).Scan(&id)
+	if err != nil {
+		t.Fatalf("cannot create conversation: %v", err)
+	}
+
+	for i, userID := range userIDs {
+		role := "member"
+		if i == 0 && conversationType != "direct" {
+			role = "owner"
+		}
+		if _, err := db.Exec(ctx, `INSERT INTO conversation_participants (conversation_id, user_id, role) VALUES ($1, $2, $3)`, id, userID, role); err != nil {
+			t.Fatalf("cannot add participant: %v", err)
+		}
+	}
+	return id
+}
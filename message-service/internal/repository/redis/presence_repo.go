@@ -0,0 +1,220 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"message-service/internal/domain"
+	"message-service/internal/utils"
+	"strconv"
+	"time"
+
+	logger "message-service/internal"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+const (
+	// Sorted set of sessions of user, score is when session stops counting as online (unix milliseconds)
+	presenceSessionsPrefix = "presence:sessions:"
+	// Unix milliseconds when user was seen
+	presenceLastSeenPrefix = "presence:last_seen:"
+	presenceSettingsPrefix = "presence:last_seen_visibility:"
+	// Set while user is typing in conversation: typing:<conversation_id>:<user_id>
+	typingPrefix = "typing:"
+	// Set of member IDs of conversation, typing checks them without Postgres
+	typingMembersPrefix = "typing:members:"
+	// Last seen of user who doesn't come back is forgotten
+	presenceLastSeenTTL = 90 * 24 * time.Hour
+)
+
+type RedisPresenceRepo struct {
+	client *redis.Client
+}
+
+func NewRedisPresenceRepo(client *redis.Client) *RedisPresenceRepo {
+	return &RedisPresenceRepo{client: client}
+}
+
+func (repo *RedisPresenceRepo) SetSession(ctx context.Context, userID int, sessionID string, expiresAt time.Time) *utils.APIError {
+	sessions := presenceSessionsPrefix + strconv.Itoa(userID)
+	now := time.Now()
+
+	pipe := repo.client.TxPipeline()
+	// Sessions of crashed instances are never closed, they just expire
+	pipe.ZRemRangeByScore(ctx, sessions, "-inf", strconv.FormatInt(now.UnixMilli(), 10))
+	pipe.ZAdd(ctx, sessions, redis.Z{Score: float64(expiresAt.UnixMilli()), Member: sessionID})
+	// Key lives until the latest session expires. NX sets it for new key, GT only moves it later,
+	// so short session (e.g. grace period after leave) doesn't cut longer ones
+	pipe.Do(ctx, "PEXPIREAT", sessions, expiresAt.UnixMilli(), "NX")
+	pipe.Do(ctx, "PEXPIREAT", sessions, expiresAt.UnixMilli(), "GT")
+	pipe.Set(ctx, presenceLastSeenPrefix+strconv.Itoa(userID), now.UnixMilli(), presenceLastSeenTTL)
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		logger.Error("Cannot update presence",
+			zap.Int("User ID", userID),
+			zap.Error(err))
+		return utils.NewAPIError(500, "Failed to update presence", err.Error())
+	}
+
+	return nil
+}
+
+func (repo *RedisPresenceRepo) GetPresence(ctx context.Context, userIDs []int) ([]domain.Presence, *utils.APIError) {
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+
+	// Three commands per user, all in one round trip
+	pipe := repo.client.Pipeline()
+	online := make([]*redis.IntCmd, len(userIDs))
+	lastSeen := make([]*redis.StringCmd, len(userIDs))
+	visibility := make([]*redis.StringCmd, len(userIDs))
+	for i, userID := range userIDs {
+		id := strconv.Itoa(userID)
+		online[i] = pipe.ZCount(ctx, presenceSessionsPrefix+id, "("+now, "+inf")
+		lastSeen[i] = pipe.Get(ctx, presenceLastSeenPrefix+id)
+		visibility[i] = pipe.Get(ctx, presenceSettingsPrefix+id)
+	}
+
+	// redis.Nil only says that some keys don't exist
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		logger.Error("Cannot get presence",
+			zap.Int("Users", len(userIDs)),
+			zap.Error(err))
+		return nil, utils.NewAPIError(500, "Failed to get presence", err.Error())
+	}
+
+	presences := make([]domain.Presence, len(userIDs))
+	for i, userID := range userIDs {
+		presences[i] = domain.Presence{
+			UserID:     userID,
+			Online:     online[i].Val() > 0,
+			Visibility: parseVisibility(visibility[i].Val()),
+		}
+
+		if ms, err := lastSeen[i].Int64(); err == nil {
+			seen := time.UnixMilli(ms).UTC()
+			presences[i].LastSeen = &seen
+		}
+	}
+
+	return presences, nil
+}
+
+func (repo *RedisPresenceRepo) GetLastSeenVisibility(ctx context.Context, userID int) (domain.LastSeenVisibility, *utils.APIError) {
+	value, err := repo.client.Get(ctx, presenceSettingsPrefix+strconv.Itoa(userID)).Result()
+	if err != nil && err != redis.Nil {
+		logger.Error("Cannot get presence settings",
+			zap.Int("User ID", userID),
+			zap.Error(err))
+		return "", utils.NewAPIError(500, "Failed to get presence settings", err.Error())
+	}
+
+	return parseVisibility(value), nil
+}
+
+func (repo *RedisPresenceRepo) SetLastSeenVisibility(ctx context.Context, userID int, visibility domain.LastSeenVisibility) *utils.APIError {
+	key := presenceSettingsPrefix + strconv.Itoa(userID)
+
+	// Default one isn't stored
+	var err error
+	if visibility == domain.LastSeenEverybody {
+		err = repo.client.Del(ctx, key).Err()
+	} else {
+		err = repo.client.Set(ctx, key, string(visibility), 0).Err()
+	}
+
+	if err != nil {
+		logger.Error("Cannot update presence settings",
+			zap.Int("User ID", userID),
+			zap.Error(err))
+		return utils.NewAPIError(500, "Failed to update presence settings", err.Error())
+	}
+
+	return nil
+}
+
+func (repo *RedisPresenceRepo) StartTyping(ctx context.Context, conversationID, userID int, ttl time.Duration) (bool, *utils.APIError) {
+	key := fmt.Sprintf("%s%d:%d", typingPrefix, conversationID, userID)
+
+	started, err := repo.client.SetNX(ctx, key, 1, ttl).Result()
+	if err != nil {
+		logger.Error("Cannot update typing",
+			zap.Int("User ID", userID),
+			zap.Int("Conversation ID", conversationID),
+			zap.Error(err))
+		return false, utils.NewAPIError(500, "Failed to update typing", err.Error())
+	}
+
+	return started, nil
+}
+
+func (repo *RedisPresenceRepo) GetTypingMembers(ctx context.Context, conversationID int) ([]int, bool, *utils.APIError) {
+	values, err := repo.client.SMembers(ctx, typingMembersPrefix+strconv.Itoa(conversationID)).Result()
+	if err != nil {
+		logger.Error("Cannot get typing members",
+			zap.Int("Conversation ID", conversationID),
+			zap.Error(err))
+		return nil, false, utils.NewAPIError(500, "Failed to get typing members", err.Error())
+	}
+
+	// Conversation always has members, empty set is missing one
+	if len(values) == 0 {
+		return nil, false, nil
+	}
+
+	userIDs := make([]int, 0, len(values))
+	for _, value := range values {
+		userID, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, false, nil
+		}
+		userIDs = append(userIDs, userID)
+	}
+
+	return userIDs, true, nil
+}
+
+func (repo *RedisPresenceRepo) SetTypingMembers(ctx context.Context, conversationID int, userIDs []int, ttl time.Duration) *utils.APIError {
+	if len(userIDs) == 0 {
+		return nil
+	}
+
+	key := typingMembersPrefix + strconv.Itoa(conversationID)
+	members := make([]any, 0, len(userIDs))
+	for _, userID := range userIDs {
+		members = append(members, userID)
+	}
+
+	// Old members are replaced, not merged
+	pipe := repo.client.TxPipeline()
+	pipe.Del(ctx, key)
+	pipe.SAdd(ctx, key, members...)
+	pipe.PExpire(ctx, key, ttl)
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		logger.Error("Cannot cache typing members",
+			zap.Int("Conversation ID", conversationID),
+			zap.Error(err))
+		return utils.NewAPIError(500, "Failed to cache typing members", err.Error())
+	}
+
+	return nil
+}
+
+func (repo *RedisPresenceRepo) ForgetTypingMembers(ctx context.Context, conversationID int) *utils.APIError {
+	if err := repo.client.Del(ctx, typingMembersPrefix+strconv.Itoa(conversationID)).Err(); err != nil {
+		logger.Error("Cannot forget typing members",
+			zap.Int("Conversation ID", conversationID),
+			zap.Error(err))
+		return utils.NewAPIError(500, "Failed to forget typing members", err.Error())
+	}
+
+	return nil
+}
+
+func parseVisibility(value string) domain.LastSeenVisibility {
+	if visibility := domain.LastSeenVisibility(value); visibility.IsValid() {
+		return visibility
+	}
+	return domain.LastSeenEverybody
+}
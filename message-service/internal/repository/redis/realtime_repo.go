@@ -0,0 +1,262 @@
+package repositories
+
+import (
+	"context"
+	"encoding/json"
+	"message-service/internal/domain"
+	"message-service/internal/utils"
+	"strconv"
+	"strings"
+	"time"
+
+	logger "message-service/internal"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+const (
+	// Stream with recent events of user, its IDs are IDs of events
+	realtimeStreamPrefix = "realtime:events:"
+	// Pub/sub channel which tells instances that user has new events
+	realtimeChannelPrefix = "realtime:notify:"
+	// Enough to resume after short disconnect, who was away longer reloads everything
+	realtimeStreamLength = 256
+	// Stream of user who is not online anymore disappears
+	realtimeStreamTTL = time.Hour
+)
+
+type RedisRealtimeRepo struct {
+	client *redis.Client
+	// One subscription per instance, channels of users are added when they connect
+	pubsub *redis.PubSub
+}
+
+func NewRedisRealtimeRepo(ctx context.Context, client *redis.Client) *RedisRealtimeRepo {
+	return &RedisRealtimeRepo{client: client, pubsub: client.Subscribe(ctx)}
+}
+
+func (repo *RedisRealtimeRepo) Publish(ctx context.Context, userIDs []int, event *domain.RealtimeEvent) *utils.APIError {
+	if len(userIDs) == 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		logger.Error("Cannot encode realtime event", zap.String("Type", string(event.Type)), zap.Error(err))
+		return utils.NewAPIError(500, "Failed to publish event", err.Error())
+	}
+
+	// Channel with thousands of subscribers is still one round trip
+	pipe := repo.client.Pipeline()
+	for _, userID := range userIDs {
+		channel := realtimeChannelPrefix + strconv.Itoa(userID)
+
+		// Ephemeral event goes in notification itself, others are read from stream by instances
+		if event.Ephemeral {
+			pipe.Publish(ctx, channel, data)
+			continue
+		}
+
+		stream := realtimeStreamPrefix + strconv.Itoa(userID)
+		pipe.XAdd(ctx, &redis.XAddArgs{
+			Stream: stream,
+			MaxLen: realtimeStreamLength,
+			Approx: true,
+			Values: map[string]any{"event": data},
+		})
+		pipe.Expire(ctx, stream, realtimeStreamTTL)
+		pipe.Publish(ctx, channel, "")
+	}
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		logger.Error("Cannot publish realtime event",
+			zap.String("Type", string(event.Type)),
+			zap.Int("Users", len(userIDs)),
+			zap.Error(err))
+		return utils.NewAPIError(500, "Failed to publish event", err.Error())
+	}
+
+	return nil
+}
+
+func (repo *RedisRealtimeRepo) GetEvents(ctx context.Context, userID int, fromID, toID string) ([]domain.RealtimeEvent, *utils.APIError) {
+	if toID == "" {
+		toID = "+"
+	}
+
+	messages, err := repo.client.XRange(ctx, realtimeStreamPrefix+strconv.Itoa(userID), fromID, toID).Result()
+	if err != nil {
+		logger.Error("Cannot read realtime events",
+			zap.Int("User ID", userID),
+			zap.Error(err))
+		return nil, utils.NewAPIError(500, "Failed to read events", err.Error())
+	}
+
+	return parseRealtimeEvents(messages), nil
+}
+
+func (repo *RedisRealtimeRepo) DeleteMessageEvents(ctx context.Context, userIDs []int, messageID int) *utils.APIError {
+	if len(userIDs) == 0 {
+		return nil
+	}
+
+	// Streams are short, whole ones are read in one round trip
+	pipe := repo.client.Pipeline()
+	reads := make([]*redis.XMessageSliceCmd, len(userIDs))
+	for i, userID := range userIDs {
+		reads[i] = pipe.XRange(ctx, realtimeStreamPrefix+strconv.Itoa(userID), "-", "+")
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		logger.Error("Cannot read realtime events of message",
+			zap.Int("Message ID", messageID),
+			zap.Error(err))
+		return utils.NewAPIError(500, "Failed to delete events", err.Error())
+	}
+
+	// Stream entries can't be changed, events with content are deleted. Client which resumes from one of them gets resync
+	deletes := 0
+	for i, userID := range userIDs {
+		var ids []string
+		for _, event := range parseRealtimeEvents(reads[i].Val()) {
+			if event.Message != nil && event.Message.MessageID == messageID {
+				ids = append(ids, event.ID)
+			}
+		}
+		if len(ids) > 0 {
+			pipe.XDel(ctx, realtimeStreamPrefix+strconv.Itoa(userID), ids...)
+			deletes++
+		}
+	}
+	if deletes == 0 {
+		return nil
+	}
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		logger.Error("Cannot delete realtime events of message",
+			zap.Int("Message ID", messageID),
+			zap.Error(err))
+		return utils.NewAPIError(500, "Failed to delete events", err.Error())
+	}
+
+	return nil
+}
+
+func (repo *RedisRealtimeRepo) GetEventsAfter(ctx context.Context, userID int, afterID string, limit int) ([]domain.RealtimeEvent, *utils.APIError) {
+	// "(" excludes afterID itself
+	messages, err := repo.client.XRangeN(ctx, realtimeStreamPrefix+strconv.Itoa(userID), "("+afterID, "+", int64(limit)).Result()
+	if err != nil {
+		logger.Error("Cannot read realtime events",
+			zap.Int("User ID", userID),
+			zap.String("After ID", afterID),
+			zap.Error(err))
+		return nil, utils.NewAPIError(500, "Failed to read events", err.Error())
+	}
+
+	return parseRealtimeEvents(messages), nil
+}
+
+func (repo *RedisRealtimeRepo) GetLastEventID(ctx context.Context, userID int) (string, *utils.APIError) {
+	messages, err := repo.client.XRevRangeN(ctx, realtimeStreamPrefix+strconv.Itoa(userID), "+", "-", 1).Result()
+	if err != nil {
+		logger.Error("Cannot read last realtime event",
+			zap.Int("User ID", userID),
+			zap.Error(err))
+		return "", utils.NewAPIError(500, "Failed to read events", err.Error())
+	}
+
+	if len(messages) == 0 {
+		return "0-0", nil
+	}
+	return messages[0].ID, nil
+}
+
+func (repo *RedisRealtimeRepo) Watch(ctx context.Context, userID int) *utils.APIError {
+	if err := repo.pubsub.Subscribe(ctx, realtimeChannelPrefix+strconv.Itoa(userID)); err != nil {
+		logger.Error("Cannot subscribe to user events",
+			zap.Int("User ID", userID),
+			zap.Error(err))
+		return utils.NewAPIError(500, "Failed to subscribe to events", err.Error())
+	}
+
+	return nil
+}
+
+func (repo *RedisRealtimeRepo) Unwatch(ctx context.Context, userID int) *utils.APIError {
+	if err := repo.pubsub.Unsubscribe(ctx, realtimeChannelPrefix+strconv.Itoa(userID)); err != nil {
+		logger.Error("Cannot unsubscribe from user events",
+			zap.Int("User ID", userID),
+			zap.Error(err))
+		return utils.NewAPIError(500, "Failed to unsubscribe from events", err.Error())
+	}
+
+	return nil
+}
+
+func (repo *RedisRealtimeRepo) Listen(ctx context.Context, handle func(userID int, event *domain.RealtimeEvent)) {
+	for ctx.Err() == nil {
+		message, err := repo.pubsub.Receive(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			logger.Error("Cannot receive user notifications", zap.Error(err))
+			time.Sleep(time.Second)
+			continue
+		}
+
+		switch message := message.(type) {
+		// Comes also when pubsub subscribes again after reconnect, what was published meanwhile is lost
+		case *redis.Subscription:
+			if message.Kind != "subscribe" {
+				continue
+			}
+			if userID, ok := realtimeUserID(message.Channel); ok {
+				handle(userID, nil)
+			}
+		case *redis.Message:
+			userID, ok := realtimeUserID(message.Channel)
+			if !ok {
+				continue
+			}
+			if message.Payload == "" {
+				handle(userID, nil)
+				continue
+			}
+
+			var event domain.RealtimeEvent
+			if err := json.Unmarshal([]byte(message.Payload), &event); err != nil {
+				logger.Warn("Skipping broken realtime event", zap.Int("User ID", userID), zap.Error(err))
+				continue
+			}
+			event.Ephemeral = true
+			handle(userID, &event)
+		}
+	}
+}
+
+func realtimeUserID(channel string) (int, bool) {
+	userID, err := strconv.Atoi(strings.TrimPrefix(channel, realtimeChannelPrefix))
+	return userID, err == nil
+}
+
+// Broken entries are skipped, client gets the rest
+func parseRealtimeEvents(messages []redis.XMessage) []domain.RealtimeEvent {
+	events := make([]domain.RealtimeEvent, 0, len(messages))
+	for _, message := range messages {
+		data, ok := message.Values["event"].(string)
+		if !ok {
+			continue
+		}
+
+		var event domain.RealtimeEvent
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			logger.Warn("Skipping broken realtime event", zap.String("Event ID", message.ID), zap.Error(err))
+			continue
+		}
+		event.ID = message.ID
+		events = append(events, event)
+	}
+
+	return events
+}
@@ -0,0 +1,60 @@
+package repositories
+
+import (
+	"context"
+	"encoding/json"
+	"message-service/internal/domain"
+	"message-service/internal/utils"
+	"time"
+
+	logger "message-service/internal"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// One-time ticket of realtime connection: realtime:ticket:<id>
+const realtimeTicketPrefix = "realtime:ticket:"
+
+type RedisTicketRepo struct {
+	client *redis.Client
+}
+
+func NewRedisTicketRepo(client *redis.Client) *RedisTicketRepo {
+	return &RedisTicketRepo{client: client}
+}
+
+func (repo *RedisTicketRepo) SaveTicket(ctx context.Context, id string, ticket *domain.RealtimeTicket, ttl time.Duration) *utils.APIError {
+	data, err := json.Marshal(ticket)
+	if err != nil {
+		return utils.NewAPIError(500, "Failed to save ticket", err.Error())
+	}
+
+	if err := repo.client.Set(ctx, realtimeTicketPrefix+id, data, ttl).Err(); err != nil {
+		logger.Error("Cannot save realtime ticket",
+			zap.Int("User ID", ticket.UserID),
+			zap.Error(err))
+		return utils.NewAPIError(500, "Failed to save ticket", err.Error())
+	}
+
+	return nil
+}
+
+func (repo *RedisTicketRepo) TakeTicket(ctx context.Context, id string) (*domain.RealtimeTicket, *utils.APIError) {
+	// Get and delete at once, so ticket can't be used twice
+	data, err := repo.client.GetDel(ctx, realtimeTicketPrefix+id).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		logger.Error("Cannot take realtime ticket", zap.Error(err))
+		return nil, utils.NewAPIError(500, "Failed to check ticket", err.Error())
+	}
+
+	var ticket domain.RealtimeTicket
+	if err := json.Unmarshal(data, &ticket); err != nil {
+		return nil, utils.NewAPIError(500, "Failed to check ticket", err.Error())
+	}
+
+	return &ticket, nil
+}
@@ -0,0 +1,101 @@
+package repositories
+
+import (
+	"context"
+	"message-service/internal/domain"
+	"message-service/internal/utils"
+	"strconv"
+	"strings"
+	"time"
+
+	logger "message-service/internal"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+const (
+	// Stream is written by auth service
+	userEventsStream = "events:users"
+	// All instances of message service share one group, so every event is handled once
+	userEventsGroup = "message-service"
+)
+
+type RedisUserEventRepo struct {
+	client *redis.Client
+}
+
+func NewRedisUserEventRepo(ctx context.Context, client *redis.Client) (*RedisUserEventRepo, error) {
+	// Create group (and stream if it doesn't exist). Group starts from the beginning of stream
+	err := client.XGroupCreateMkStream(ctx, userEventsStream, userEventsGroup, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return nil, err
+	}
+
+	return &RedisUserEventRepo{client: client}, nil
+}
+
+func (repo *RedisUserEventRepo) ReadUserEvents(ctx context.Context, consumer string, pending bool) ([]domain.UserEvent, *utils.APIError) {
+	// ">" means new events, "0" - events delivered to this consumer but not acknowledged
+	startID := ">"
+	if pending {
+		startID = "0"
+	}
+
+	streams, err := repo.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    userEventsGroup,
+		Consumer: consumer,
+		Streams:  []string{userEventsStream, startID},
+		Count:    100,
+		Block:    5 * time.Second,
+	}).Result()
+
+	if err != nil {
+		// Nothing new
+		if err == redis.Nil {
+			return []domain.UserEvent{}, nil
+		}
+		logger.Error("Cannot read user events",
+			zap.Error(err))
+		return nil, utils.NewAPIError(500, "Failed to read user events", err.Error())
+	}
+
+	events := []domain.UserEvent{}
+	for _, stream := range streams {
+		for _, message := range stream.Messages {
+			events = append(events, parseUserEvent(message))
+		}
+	}
+
+	return events, nil
+}
+
+func (repo *RedisUserEventRepo) AckUserEvent(ctx context.Context, eventID string) *utils.APIError {
+	if err := repo.client.XAck(ctx, userEventsStream, userEventsGroup, eventID).Err(); err != nil {
+		logger.Error("Cannot acknowledge user event",
+			zap.String("Event ID", eventID),
+			zap.Error(err))
+		return utils.NewAPIError(500, "Failed to acknowledge user event", err.Error())
+	}
+
+	return nil
+}
+
+// Broken fields are left empty, service will skip such event
+func parseUserEvent(message redis.XMessage) domain.UserEvent {
+	event := domain.UserEvent{ID: message.ID}
+
+	if eventType, ok := message.Values["type"].(string); ok {
+		event.Type = domain.EventType(eventType)
+	}
+
+	if userID, ok := message.Values["user_id"].(string); ok {
+		event.UserID, _ = strconv.Atoi(userID)
+	}
+
+	if occurredAt, ok := message.Values["occurred_at"].(string); ok {
+		event.OccurredAt, _ = time.Parse(time.RFC3339, occurredAt)
+	}
+
+	return event
+}
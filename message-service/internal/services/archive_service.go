@@ -0,0 +1,600 @@
+package services
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"message-service/internal/domain"
+	"message-service/internal/utils"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	logger "message-service/internal"
+
+	"go.uber.org/zap"
+)
+
+// Returned by callback of readFile when it has found what it needs
+var errStopReading = errors.New("stop reading")
+
+// ArchiveService reads messages of archived partitions. Archive is cold, every read unpacks file of conversation,
+// so it is used only when live partitions don't have enough messages
+type ArchiveService struct {
+	index domain.ArchiveIndexRepository
+	store domain.ArchiveStore
+}
+
+func NewArchiveService(index domain.ArchiveIndexRepository, store domain.ArchiveStore) *ArchiveService {
+	return &ArchiveService{index: index, store: store}
+}
+
+// GetConversationMessages works like MessageRepository.GetConversationMessages, but reads archive
+func (s *ArchiveService) GetConversationMessages(ctx context.Context, conversation *domain.Conversation, filter domain.ConversationFilter) ([]domain.Message, *utils.APIError) {
+	newer := filter.Cursor != nil && filter.Cursor.Newer
+
+	// Only archives which can have messages after (or before) cursor
+	start, end := filter.After, filter.Before
+	if filter.Cursor != nil {
+		if newer && (start.IsZero() || filter.Cursor.Timestamp.After(start)) {
+			start = filter.Cursor.Timestamp
+		}
+		if !newer && (end.IsZero() || filter.Cursor.Timestamp.Before(end)) {
+			// Cursor itself is excluded later, but its range must be read
+			end = filter.Cursor.Timestamp.Add(1)
+		}
+	}
+
+	entries, apiErr := s.index.GetArchivesInRange(ctx, start, end)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+
+	// Newest archives first when going back in history
+	if !newer {
+		for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+			entries[i], entries[j] = entries[j], entries[i]
+		}
+	}
+
+	var result []domain.Message
+
+	for _, entry := range entries {
+		messages, apiErr := s.readArchive(ctx, &entry, conversation, &filter)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+
+		sort.Slice(messages, func(i, j int) bool {
+			if newer {
+				return messageBefore(&messages[i], &messages[j])
+			}
+			return messageBefore(&messages[j], &messages[i])
+		})
+
+		result = append(result, messages...)
+		if len(result) >= filter.Limit {
+			return result[:filter.Limit], nil
+		}
+	}
+
+	return result, nil
+}
+
+// readArchive returns messages of conversation from one archived partition which pass filter
+func (s *ArchiveService) readArchive(ctx context.Context, entry *domain.ArchiveEntry, conversation *domain.Conversation, filter *domain.ConversationFilter) ([]domain.Message, *utils.APIError) {
+	// Only file of this conversation is unpacked, not whole partition
+	files, apiErr := s.index.GetArchiveFiles(ctx, entry.ID, conversation.ID)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+
+	var messages []domain.Message
+	for _, file := range files {
+		apiErr := s.readFile(ctx, file.Location, func(msg *domain.Message) error {
+			if inConversation(msg, conversation) && matchesFilter(msg, filter) {
+				messages = append(messages, *msg)
+			}
+			return nil
+		})
+		if apiErr != nil {
+			return nil, apiErr
+		}
+	}
+
+	if apiErr := s.applyChanges(ctx, messages); apiErr != nil {
+		return nil, apiErr
+	}
+	return messages, nil
+}
+
+// GetMessage finds archived message by ID and timestamp, conversation 0 means any. Returns nil if there is no such message
+func (s *ArchiveService) GetMessage(ctx context.Context, conversationID, messageID int, timestamp time.Time) (*domain.Message, *utils.APIError) {
+	entries, apiErr := s.index.GetArchivesInRange(ctx, timestamp, timestamp.Add(1))
+	if apiErr != nil {
+		return nil, apiErr
+	}
+
+	for _, entry := range entries {
+		files, apiErr := s.index.GetArchiveFiles(ctx, entry.ID, conversationID)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+
+		for _, file := range files {
+			var found *domain.Message
+			apiErr := s.readFile(ctx, file.Location, func(msg *domain.Message) error {
+				if msg.MessageID == messageID && msg.Timestamp.Equal(timestamp) {
+					found = msg
+					return errStopReading
+				}
+				return nil
+			})
+			if apiErr != nil {
+				return nil, apiErr
+			}
+
+			if found != nil {
+				messages := []domain.Message{*found}
+				if apiErr := s.applyChanges(ctx, messages); apiErr != nil {
+					return nil, apiErr
+				}
+				return &messages[0], nil
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+// applyChanges puts edits and deletions which were made after archiving into messages
+func (s *ArchiveService) applyChanges(ctx context.Context, messages []domain.Message) *utils.APIError {
+	ids := make([]int, 0, len(messages))
+	for i := range messages {
+		ids = append(ids, messages[i].MessageID)
+	}
+
+	changes, apiErr := s.index.GetArchivedMessageChanges(ctx, ids)
+	if apiErr != nil {
+		return apiErr
+	}
+
+	for i := range messages {
+		if change, ok := changes[messages[i].MessageID]; ok {
+			change.Apply(&messages[i])
+		}
+	}
+	return nil
+}
+
+// ForEachUserMessage calls fn for every archived message sent by user or to user, and for messages of his groups
+// which he can read. Groups map conversation to time from which he can read it, like ConversationRepository.GetUserGroups
+func (s *ArchiveService) ForEachUserMessage(ctx context.Context, userID int, groups map[int]time.Time, fn func(*domain.Message) error) *utils.APIError {
+	groupIDs := make([]int, 0, len(groups))
+	for conversationID := range groups {
+		groupIDs = append(groupIDs, conversationID)
+	}
+
+	files, apiErr := s.index.GetUserArchiveFiles(ctx, userID, groupIDs)
+	if apiErr != nil {
+		return apiErr
+	}
+
+	for _, file := range files {
+		// File has one conversation, so it is collected to apply changes in one query
+		var messages []domain.Message
+		apiErr := s.readFile(ctx, file.Location, func(msg *domain.Message) error {
+			if msg.SenderID != userID && msg.RecipientID != userID {
+				visibleSince, member := groups[msg.ConversationID]
+				if !member || msg.RecipientID != 0 || msg.Timestamp.Before(visibleSince) {
+					return nil
+				}
+			}
+			messages = append(messages, *msg)
+			return nil
+		})
+		if apiErr != nil {
+			return apiErr
+		}
+
+		if apiErr := s.applyChanges(ctx, messages); apiErr != nil {
+			return apiErr
+		}
+		for i := range messages {
+			if err := fn(&messages[i]); err != nil {
+				return utils.NewAPIError(500, "Cannot process message", err.Error())
+			}
+		}
+	}
+
+	return nil
+}
+
+// PurgeDeletedMessages writes deletions made before before into archive files, so deleted text is gone from them too.
+// Message stays in file as tombstone. Returns how many messages were purged
+func (s *ArchiveService) PurgeDeletedMessages(ctx context.Context, before time.Time) (int64, *utils.APIError) {
+	deleted, apiErr := s.index.GetDeletedArchivedMessages(ctx, before, 1000)
+	if apiErr != nil || len(deleted) == 0 {
+		return 0, apiErr
+	}
+
+	// Every file is rewritten once with all its deletions
+	files := make(map[int]domain.ArchiveFile)
+	changes := make(map[int][]domain.ArchivedMessageChange)
+	for _, change := range deleted {
+		entries, apiErr := s.index.GetArchivesInRange(ctx, change.Timestamp, change.Timestamp.Add(1))
+		if apiErr != nil {
+			return 0, apiErr
+		}
+
+		for _, entry := range entries {
+			entryFiles, apiErr := s.index.GetArchiveFiles(ctx, entry.ID, change.ConversationID)
+			if apiErr != nil {
+				return 0, apiErr
+			}
+			for _, file := range entryFiles {
+				files[file.ID] = file
+				changes[file.ID] = append(changes[file.ID], change)
+			}
+		}
+	}
+
+	for id, file := range files {
+		byMessage := make(map[int]domain.ArchivedMessageChange, len(changes[id]))
+		for _, change := range changes[id] {
+			byMessage[change.MessageID] = change
+		}
+
+		rewritten, apiErr := newArchiveFile(file.Key)
+		if apiErr != nil {
+			return 0, apiErr
+		}
+
+		apiErr = s.readFile(ctx, file.Location, func(msg *domain.Message) error {
+			if change, ok := byMessage[msg.MessageID]; ok {
+				change.Apply(msg)
+				// Same tombstone as purge leaves in Postgres
+				msg.DeletedBy = nil
+				msg.EditedAt = nil
+			}
+			return rewritten.Write(msg)
+		})
+		if apiErr != nil {
+			rewritten.Close()
+			return 0, apiErr
+		}
+
+		location, apiErr := rewritten.Put(ctx, s.store, file.Key)
+		if apiErr != nil {
+			return 0, apiErr
+		}
+
+		file.Location = location
+		file.Messages = rewritten.messages
+		file.UserIDs = rewritten.UserIDs()
+		if apiErr := s.index.UpdateArchiveFile(ctx, &file); apiErr != nil {
+			return 0, apiErr
+		}
+	}
+
+	// Only when all files have them, otherwise they are written again next time
+	if apiErr := s.index.DeleteArchivedMessageChanges(ctx, deleted); apiErr != nil {
+		return 0, apiErr
+	}
+	return int64(len(deleted)), nil
+}
+
+// DeleteUserMessages rewrites archive files without messages sent by user or to user, returns how many were removed
+func (s *ArchiveService) DeleteUserMessages(ctx context.Context, userID int) (int64, *utils.APIError) {
+	files, apiErr := s.index.GetUserArchiveFiles(ctx, userID, nil)
+	if apiErr != nil {
+		return 0, apiErr
+	}
+
+	var deleted int64
+	for _, file := range files {
+		rewritten, apiErr := newArchiveFile(file.Key)
+		if apiErr != nil {
+			return deleted, apiErr
+		}
+
+		apiErr = s.readFile(ctx, file.Location, func(msg *domain.Message) error {
+			if msg.SenderID == userID || msg.RecipientID == userID {
+				return nil
+			}
+			return rewritten.Write(msg)
+		})
+		if apiErr != nil {
+			rewritten.Close()
+			return deleted, apiErr
+		}
+
+		// Old files don't know their users, they are rewritten once to learn them
+		removed := file.Messages - rewritten.messages
+		if removed == 0 && file.UserIDs != nil {
+			rewritten.Close()
+			continue
+		}
+
+		location, apiErr := rewritten.Put(ctx, s.store, file.Key)
+		if apiErr != nil {
+			return deleted, apiErr
+		}
+
+		file.Location = location
+		file.Messages = rewritten.messages
+		file.UserIDs = rewritten.UserIDs()
+		if apiErr := s.index.UpdateArchiveFile(ctx, &file); apiErr != nil {
+			return deleted, apiErr
+		}
+		deleted += removed
+	}
+
+	return deleted, nil
+}
+
+// readFile calls fn for every message of archive file, stops if fn returns error
+func (s *ArchiveService) readFile(ctx context.Context, location string, fn func(*domain.Message) error) *utils.APIError {
+	file, apiErr := s.store.Get(ctx, location)
+	if apiErr != nil {
+		return apiErr
+	}
+	defer file.Close()
+
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		logger.Error("Cannot unpack archive", zap.String("Location", location), zap.Error(err))
+		return utils.NewAPIError(500, "Cannot read archive", err.Error())
+	}
+	defer reader.Close()
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		var msg domain.Message
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			logger.Error("Broken line in archive", zap.String("Location", location), zap.Error(err))
+			return utils.NewAPIError(500, "Cannot read archive", err.Error())
+		}
+
+		err := fn(&msg)
+		if err == errStopReading {
+			return nil
+		}
+		if err != nil {
+			return utils.NewAPIError(500, "Cannot process message", err.Error())
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		logger.Error("Cannot read archive", zap.String("Location", location), zap.Error(err))
+		return utils.NewAPIError(500, "Cannot read archive", err.Error())
+	}
+
+	return nil
+}
+
+// archiveFile packs messages to local temporary file, store needs its size, and S3 needs its hash before upload
+type archiveFile struct {
+	tmp      *os.File
+	writer   *gzip.Writer
+	encoder  *json.Encoder
+	messages int64
+	users    map[int]bool
+}
+
+func newArchiveFile(key string) (*archiveFile, *utils.APIError) {
+	tmp, err := os.CreateTemp("", filepath.Base(key)+".*")
+	if err != nil {
+		return nil, utils.NewAPIError(500, "Cannot create archive file", err.Error())
+	}
+
+	writer := gzip.NewWriter(tmp)
+	return &archiveFile{
+		tmp:     tmp,
+		writer:  writer,
+		encoder: json.NewEncoder(writer),
+		users:   make(map[int]bool),
+	}, nil
+}
+
+func (f *archiveFile) Write(msg *domain.Message) error {
+	f.messages++
+	f.users[msg.SenderID] = true
+	if msg.RecipientID != 0 {
+		f.users[msg.RecipientID] = true
+	}
+	return f.encoder.Encode(msg)
+}
+
+// UserIDs returns senders and recipients of written messages
+func (f *archiveFile) UserIDs() []int {
+	ids := make([]int, 0, len(f.users))
+	for id := range f.users {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// Put saves file to store under key and removes temporary one
+func (f *archiveFile) Put(ctx context.Context, store domain.ArchiveStore, key string) (string, *utils.APIError) {
+	defer f.Close()
+
+	if err := f.writer.Close(); err != nil {
+		return "", utils.NewAPIError(500, "Cannot write archive file", err.Error())
+	}
+
+	size, err := f.tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return "", utils.NewAPIError(500, "Cannot write archive file", err.Error())
+	}
+	if _, err := f.tmp.Seek(0, io.SeekStart); err != nil {
+		return "", utils.NewAPIError(500, "Cannot write archive file", err.Error())
+	}
+
+	return store.Put(ctx, key, f.tmp, size)
+}
+
+func (f *archiveFile) Close() {
+	f.tmp.Close()
+	os.Remove(f.tmp.Name())
+}
+
+// Archives made before conversations had IDs know only users of message
+func inConversation(msg *domain.Message, conversation *domain.Conversation) bool {
+	if msg.ConversationID != 0 {
+		return msg.ConversationID == conversation.ID
+	}
+	return conversation.DirectKey != nil && domain.ConversationKey(msg.SenderID, msg.RecipientID) == *conversation.DirectKey
+}
+
+func matchesFilter(msg *domain.Message, filter *domain.ConversationFilter) bool {
+	if !filter.After.IsZero() && !msg.Timestamp.After(filter.After) {
+		return false
+	}
+	if !filter.Before.IsZero() && !msg.Timestamp.Before(filter.Before) {
+		return false
+	}
+
+	if filter.Cursor != nil {
+		position := domain.Message{MessageID: filter.Cursor.ID, Timestamp: filter.Cursor.Timestamp}
+		if filter.Cursor.Newer {
+			return messageBefore(&position, msg)
+		}
+		return messageBefore(msg, &position)
+	}
+
+	return true
+}
+
+// messageBefore compares messages by (timestamp, id), like keyset pagination does
+func messageBefore(a, b *domain.Message) bool {
+	if a.Timestamp.Equal(b.Timestamp) {
+		return a.MessageID < b.MessageID
+	}
+	return a.Timestamp.Before(b.Timestamp)
+}
+
+// Archiver exports detached partitions to archive store and drops them from database
+type Archiver struct {
+	partitions domain.PartitionRepository
+	index      domain.ArchiveIndexRepository
+	store      domain.ArchiveStore
+}
+
+func NewArchiver(partitions domain.PartitionRepository, index domain.ArchiveIndexRepository, store domain.ArchiveStore) *Archiver {
+	return &Archiver{partitions: partitions, index: index, store: store}
+}
+
+// ArchivePending archives all detached partitions. Failed ones stay detached and are retried next time
+func (a *Archiver) ArchivePending(ctx context.Context) *utils.APIError {
+	entries, apiErr := a.index.GetPendingArchives(ctx, 100)
+	if apiErr != nil {
+		return apiErr
+	}
+
+	for _, entry := range entries {
+		if apiErr := a.archive(ctx, &entry); apiErr != nil {
+			logger.Error("Cannot archive partition, will retry later",
+				zap.String("Partition", entry.TableName),
+				zap.String("error", apiErr.Message),
+				zap.String("details", apiErr.Details))
+			return apiErr
+		}
+	}
+
+	return nil
+}
+
+func (a *Archiver) archive(ctx context.Context, entry *domain.ArchiveEntry) *utils.APIError {
+	// Every conversation gets own file, so reading it doesn't unpack whole partition.
+	// Messages come grouped by conversation, so only one file is open at a time
+	var files []domain.ArchiveFile
+	var current *archiveFile
+	var currentConversation int
+	var putErr *utils.APIError
+
+	put := func() *utils.APIError {
+		key := archiveFileKey(entry.TableName, currentConversation)
+		location, apiErr := current.Put(ctx, a.store, key)
+		if apiErr != nil {
+			return apiErr
+		}
+
+		file := domain.ArchiveFile{
+			ArchiveID: entry.ID,
+			Key:       key,
+			Location:  location,
+			Messages:  current.messages,
+			UserIDs:   current.UserIDs(),
+		}
+		// Messages without conversation are kept together, like in old archives
+		if currentConversation != 0 {
+			conversationID := currentConversation
+			file.ConversationID = &conversationID
+		}
+		files = append(files, file)
+		current = nil
+		return nil
+	}
+
+	apiErr := a.partitions.ForEachDetachedMessage(ctx, entry.TableName, func(msg *domain.Message) error {
+		if current != nil && msg.ConversationID != currentConversation {
+			if putErr = put(); putErr != nil {
+				return errors.New(putErr.Message)
+			}
+		}
+		if current == nil {
+			currentConversation = msg.ConversationID
+			if current, putErr = newArchiveFile(archiveFileKey(entry.TableName, currentConversation)); putErr != nil {
+				return errors.New(putErr.Message)
+			}
+		}
+		return current.Write(msg)
+	})
+	if putErr != nil {
+		return putErr
+	}
+	if apiErr != nil {
+		if current != nil {
+			current.Close()
+		}
+		return apiErr
+	}
+	if current != nil {
+		if apiErr := put(); apiErr != nil {
+			return apiErr
+		}
+	}
+
+	var count int64
+	for _, file := range files {
+		count += file.Messages
+	}
+
+	// Index first: if drop fails, messages are read from archive and table is just garbage
+	if apiErr := a.index.MarkArchived(ctx, entry.ID, files); apiErr != nil {
+		return apiErr
+	}
+	if apiErr := a.partitions.DropDetachedPartition(ctx, entry.TableName); apiErr != nil {
+		return apiErr
+	}
+
+	logger.Info("Archived partition",
+		zap.String("Partition", entry.TableName),
+		zap.Int("Files", len(files)),
+		zap.Int64("Messages", count))
+	return nil
+}
+
+func archiveFileKey(table string, conversationID int) string {
+	return strings.TrimPrefix(table, "archived_") + "." + strconv.Itoa(conversationID) + "." + domain.ArchiveFormatJSONLGzip
+}
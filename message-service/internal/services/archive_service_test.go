@@ -0,0 +1,218 @@
+package services
+
+import (
+	"context"
+	"message-service/internal/domain"
+	archiveRepos "message-service/internal/repository/archive"
+	"message-service/internal/utils"
+	"testing"
+	"time"
+)
+
+// fakeArchiveIndex keeps index of archive in memory, methods archive service doesn't use here panic
+type fakeArchiveIndex struct {
+	domain.ArchiveIndexRepository
+	entries []domain.ArchiveEntry
+	files   []domain.ArchiveFile
+	changes map[int]domain.ArchivedMessageChange
+}
+
+func (f *fakeArchiveIndex) GetArchivesInRange(ctx context.Context, start, end time.Time) ([]domain.ArchiveEntry, *utils.APIError) {
+	var entries []domain.ArchiveEntry
+	for _, entry := range f.entries {
+		if entry.RangeStart.Before(end) && entry.RangeEnd.After(start) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+func (f *fakeArchiveIndex) GetArchiveFiles(ctx context.Context, archiveID int, conversationID int) ([]domain.ArchiveFile, *utils.APIError) {
+	var files []domain.ArchiveFile
+	for _, file := range f.files {
+		if file.ArchiveID == archiveID && (conversationID == 0 || file.ConversationID == nil || *file.ConversationID == conversationID) {
+			files = append(files, file)
+		}
+	}
+	return files, nil
+}
+
+func (f *fakeArchiveIndex) UpdateArchiveFile(ctx context.Context, file *domain.ArchiveFile) *utils.APIError {
+	for i := range f.files {
+		if f.files[i].ID == file.ID {
+			f.files[i] = *file
+		}
+	}
+	return nil
+}
+
+func (f *fakeArchiveIndex) GetArchivedMessageChanges(ctx context.Context, messageIDs []int) (map[int]domain.ArchivedMessageChange, *utils.APIError) {
+	changes := make(map[int]domain.ArchivedMessageChange)
+	for _, id := range messageIDs {
+		if change, ok := f.changes[id]; ok {
+			changes[id] = change
+		}
+	}
+	return changes, nil
+}
+
+func (f *fakeArchiveIndex) GetDeletedArchivedMessages(ctx context.Context, before time.Time, limit int) ([]domain.ArchivedMessageChange, *utils.APIError) {
+	var deleted []domain.ArchivedMessageChange
+	for _, change := range f.changes {
+		if change.DeletedAt != nil && change.DeletedAt.Before(before) {
+			deleted = append(deleted, change)
+		}
+	}
+	return deleted, nil
+}
+
+func (f *fakeArchiveIndex) DeleteArchivedMessageChanges(ctx context.Context, changes []domain.ArchivedMessageChange) *utils.APIError {
+	for _, change := range changes {
+		delete(f.changes, change.MessageID)
+	}
+	return nil
+}
+
+// newTestArchive archives messages of one conversation into local store, as archiver does
+func newTestArchive(t *testing.T, start time.Time, messages []domain.Message) (*ArchiveService, *fakeArchiveIndex) {
+	t.Helper()
+
+	store, err := archiveRepos.NewLocalArchiveStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalArchiveStore: %v", err)
+	}
+
+	file, apiErr := newArchiveFile("test.jsonl.gz")
+	if apiErr != nil {
+		t.Fatalf("newArchiveFile: %v", apiErr.Message)
+	}
+	for i := range messages {
+		if err := file.Write(&messages[i]); err != nil {
+			t.Fatalf("cannot write message: %v", err)
+		}
+	}
+	location, apiErr := file.Put(context.Background(), store, "test.jsonl.gz")
+	if apiErr != nil {
+		t.Fatalf("cannot put archive: %v", apiErr.Message)
+	}
+
+	conversationID := messages[0].ConversationID
+	index := &fakeArchiveIndex{
+		entries: []domain.ArchiveEntry{{ID: 1, RangeStart: start, RangeEnd: start.AddDate(0, 0, 1), Status: domain.ArchiveArchived}},
+		files:   []domain.ArchiveFile{{ID: 1, ArchiveID: 1, ConversationID: &conversationID, Key: "test.jsonl.gz", Location: location}},
+		changes: make(map[int]domain.ArchivedMessageChange),
+	}
+	return NewArchiveService(index, store), index
+}
+
+func TestArchivedMessageChanges(t *testing.T) {
+	day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	sent := day.Add(time.Hour)
+	edited := day.AddDate(0, 2, 0)
+	// Purge cut-off is between old and recent deletion
+	oldDeletion := day.AddDate(0, 3, 0)
+	purgeBefore := oldDeletion.Add(time.Hour)
+	recentDeletion := purgeBefore.Add(time.Hour)
+	sender := 2
+
+	tests := []struct {
+		name string
+		// Nil - message was not changed after archiving
+		change *domain.ArchivedMessageChange
+		// What archive gives before and after purge
+		wantContent string
+		wantDeleted bool
+		// Change is written into file and forgotten
+		wantPurged bool
+	}{
+		{
+			name:        "not changed",
+			wantContent: "original",
+		},
+		{
+			name:        "edited",
+			change:      &domain.ArchivedMessageChange{Content: "edited", EditedAt: &edited},
+			wantContent: "edited",
+		},
+		{
+			name:        "deleted before purge cut-off",
+			change:      &domain.ArchivedMessageChange{DeletedAt: &oldDeletion, DeletedBy: &sender},
+			wantDeleted: true,
+			wantPurged:  true,
+		},
+		{
+			name:        "edited and deleted before purge cut-off",
+			change:      &domain.ArchivedMessageChange{EditedAt: &edited, DeletedAt: &oldDeletion, DeletedBy: &sender},
+			wantDeleted: true,
+			wantPurged:  true,
+		},
+		{
+			name:        "deleted after purge cut-off",
+			change:      &domain.ArchivedMessageChange{DeletedAt: &recentDeletion, DeletedBy: &sender},
+			wantDeleted: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			messages := []domain.Message{
+				{MessageID: 1, ConversationID: 5, SenderID: sender, RecipientID: 3, Content: "original", Timestamp: sent},
+				{MessageID: 2, ConversationID: 5, SenderID: 3, RecipientID: sender, Content: "neighbour", Timestamp: sent.Add(time.Minute)},
+			}
+			archive, index := newTestArchive(t, day, messages)
+			if tt.change != nil {
+				change := *tt.change
+				change.MessageID, change.Timestamp, change.ConversationID = 1, sent, 5
+				index.changes[1] = change
+			}
+
+			check := func(stage string) {
+				t.Helper()
+				msg, apiErr := archive.GetMessage(ctx, 5, 1, sent)
+				if apiErr != nil {
+					t.Fatalf("%s: GetMessage: %v", stage, apiErr.Message)
+				}
+				if msg == nil {
+					t.Fatalf("%s: message is not found", stage)
+				}
+				if msg.Content != tt.wantContent || (msg.DeletedAt != nil) != tt.wantDeleted {
+					t.Fatalf("%s: content %q, deleted at %v", stage, msg.Content, msg.DeletedAt)
+				}
+
+				neighbour, apiErr := archive.GetMessage(ctx, 5, 2, sent.Add(time.Minute))
+				if apiErr != nil || neighbour == nil || neighbour.Content != "neighbour" {
+					t.Fatalf("%s: other message is changed: %+v", stage, neighbour)
+				}
+			}
+
+			check("before purge")
+
+			purged, apiErr := archive.PurgeDeletedMessages(ctx, purgeBefore)
+			if apiErr != nil {
+				t.Fatalf("PurgeDeletedMessages: %v", apiErr.Message)
+			}
+			if (purged == 1) != tt.wantPurged {
+				t.Fatalf("purged %d messages", purged)
+			}
+			_, left := index.changes[1]
+			if tt.change != nil && left == tt.wantPurged {
+				t.Fatalf("change is left after purge: %v, want %v", left, !tt.wantPurged)
+			}
+
+			// Purged deletion is read from file now, it is a tombstone like in Postgres
+			check("after purge")
+			if tt.wantPurged {
+				msg, _ := archive.GetMessage(ctx, 5, 1, sent)
+				if msg.DeletedBy != nil || msg.EditedAt != nil {
+					t.Fatalf("purged message keeps deleted by %v and edited at %v", msg.DeletedBy, msg.EditedAt)
+				}
+			}
+
+			unknown, apiErr := archive.GetMessage(ctx, 5, 1, sent.Add(time.Second))
+			if apiErr != nil || unknown != nil {
+				t.Fatalf("message found by wrong timestamp: %+v", unknown)
+			}
+		})
+	}
+}
@@ -0,0 +1,325 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"message-service/internal/domain"
+	"message-service/internal/utils"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultInboxLimit = 30
+	maxInboxLimit     = 100
+
+	maxGroupTitleLength = 100
+	maxGroupMembers     = 500
+)
+
+type ConversationService struct {
+	repo domain.ConversationRepository
+	// For system messages about changes of group
+	messages domain.MessageRepository
+	events   domain.EventPublisher
+}
+
+func NewConversationService(repo domain.ConversationRepository, messages domain.MessageRepository, events domain.EventPublisher) *ConversationService {
+	return &ConversationService{repo: repo, messages: messages, events: events}
+}
+
+// GetConversations returns inbox of user, most recent conversations first, and cursor of the next page
+func (s *ConversationService) GetConversations(ctx context.Context, userID int, cursor string, limit int) ([]domain.ConversationSummary, string, *utils.APIError) {
+	if limit < 0 {
+		return nil, "", utils.NewAPIError(400, "Invalid limit", "")
+	}
+	if limit == 0 {
+		limit = defaultInboxLimit
+	}
+	if limit > maxInboxLimit {
+		limit = maxInboxLimit
+	}
+
+	var position *domain.ConversationCursor
+	if cursor != "" {
+		c, err := decodeInboxCursor(cursor)
+		if err != nil {
+			return nil, "", utils.NewAPIError(400, "Invalid cursor", "")
+		}
+		position = c
+	}
+
+	// One extra tells if there is the next page
+	conversations, apiErr := s.repo.GetConversations(ctx, userID, position, limit+1)
+	if apiErr != nil {
+		return nil, "", apiErr
+	}
+
+	if len(conversations) <= limit {
+		return conversations, "", nil
+	}
+
+	conversations = conversations[:limit]
+	last := conversations[limit-1]
+	return conversations, encodeInboxCursor(&domain.ConversationCursor{LastMessageAt: last.LastMessage.Timestamp, ConversationID: last.ConversationID}), nil
+}
+
+// CreateDirectConversation returns conversation of user with peer, it is created if they didn't talk before
+func (s *ConversationService) CreateDirectConversation(ctx context.Context, userID, peerID int) (*domain.Conversation, *utils.APIError) {
+	if peerID <= 0 {
+		return nil, utils.NewAPIError(400, "Invalid input data", "")
+	}
+	if userID == peerID {
+		return nil, utils.NewAPIError(418, "You can't talk to yourself", "")
+	}
+
+	return s.repo.GetOrCreateDirectConversation(ctx, userID, peerID)
+}
+
+// GetConversation returns conversation if user is its participant
+func (s *ConversationService) GetConversation(ctx context.Context, userID, conversationID int) (*domain.Conversation, *utils.APIError) {
+	conversation, apiErr := s.repo.GetConversation(ctx, conversationID, userID)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+
+	if conversation == nil || !conversation.HasParticipant(userID) {
+		return nil, utils.NewAPIError(404, "Conversation not found", "")
+	}
+
+	return conversation, nil
+}
+
+// CreateGroup creates group with title, creator becomes its member
+func (s *ConversationService) CreateGroup(ctx context.Context, creatorID int, title string, memberIDs []int, historyVisible bool) (*domain.Conversation, *utils.APIError) {
+	title = strings.TrimSpace(title)
+	if title == "" || len(title) > maxGroupTitleLength {
+		return nil, utils.NewAPIError(400, "Invalid title", "Title must be 1-100 characters")
+	}
+
+	members, apiErr := uniqueMembers(append([]int{creatorID}, memberIDs...))
+	if apiErr != nil {
+		return nil, apiErr
+	}
+
+	group := &domain.Conversation{Type: domain.GroupConversation, Title: title, CreatedBy: creatorID, HistoryVisible: historyVisible}
+	return s.repo.CreateConversation(ctx, group, members)
+}
+
+// CreateChannel creates channel, creator is its owner and the first publisher
+func (s *ConversationService) CreateChannel(ctx context.Context, creatorID int, title string) (*domain.Conversation, *utils.APIError) {
+	title = strings.TrimSpace(title)
+	if title == "" || len(title) > maxGroupTitleLength {
+		return nil, utils.NewAPIError(400, "Invalid title", "Title must be 1-100 characters")
+	}
+
+	// Subscribers see everything which was posted before them
+	channel := &domain.Conversation{Type: domain.ChannelConversation, Title: title, CreatedBy: creatorID, HistoryVisible: true}
+	return s.repo.CreateConversation(ctx, channel, []int{creatorID})
+}
+
+// Subscribe adds user to channel. Banned users can't subscribe
+func (s *ConversationService) Subscribe(ctx context.Context, userID, channelID int) (*domain.Conversation, *utils.APIError) {
+	channel, apiErr := s.getChannel(ctx, userID, channelID)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	if channel.HasParticipant(userID) {
+		return channel, nil
+	}
+
+	added, apiErr := s.repo.AddParticipants(ctx, channelID, []int{userID})
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	if added == 0 {
+		return nil, utils.NewAPIError(403, "You are banned in this channel", "")
+	}
+
+	return s.getChannel(ctx, userID, channelID)
+}
+
+// Unsubscribe removes user from channel. Owner can't leave his channel
+func (s *ConversationService) Unsubscribe(ctx context.Context, userID, channelID int) *utils.APIError {
+	channel, apiErr := s.getChannel(ctx, userID, channelID)
+	if apiErr != nil {
+		return apiErr
+	}
+
+	if channel.Role(userID) == domain.ConversationOwner {
+		return utils.NewAPIError(409, "Owner can't unsubscribe", "Transfer ownership first")
+	}
+
+	removed, apiErr := s.repo.RemoveParticipant(ctx, channelID, userID)
+	if apiErr != nil {
+		return apiErr
+	}
+	if !removed {
+		return utils.NewAPIError(404, "You are not subscribed", "")
+	}
+
+	return nil
+}
+
+// getChannel returns channel, anybody can see it
+func (s *ConversationService) getChannel(ctx context.Context, userID, channelID int) (*domain.Conversation, *utils.APIError) {
+	channel, apiErr := s.repo.GetConversation(ctx, channelID, userID)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+
+	if channel == nil || channel.Type != domain.ChannelConversation {
+		return nil, utils.NewAPIError(404, "Channel not found", "")
+	}
+
+	return channel, nil
+}
+
+// AddMembers adds users to group, only owner and admins can do it. New members see history from now
+// unless group has visible history. Banned users are skipped
+func (s *ConversationService) AddMembers(ctx context.Context, userID, conversationID int, memberIDs []int) (int64, *utils.APIError) {
+	group, apiErr := s.getGroup(ctx, userID, conversationID)
+	if apiErr != nil {
+		return 0, apiErr
+	}
+
+	if !group.Role(userID).CanModerate() {
+		return 0, utils.NewAPIError(403, "Only owner and admins can add members", "")
+	}
+
+	members, apiErr := uniqueMembers(memberIDs)
+	if apiErr != nil {
+		return 0, apiErr
+	}
+	if len(members) == 0 {
+		return 0, utils.NewAPIError(400, "Invalid input data", "user_ids is empty")
+	}
+	if len(group.Participants)+len(members) > maxGroupMembers {
+		return 0, utils.NewAPIError(400, "Too many members", "Group can have "+strconv.Itoa(maxGroupMembers)+" members")
+	}
+
+	added, apiErr := s.repo.AddParticipants(ctx, conversationID, members)
+	if apiErr != nil {
+		return 0, apiErr
+	}
+
+	if added > 0 {
+		s.events.ForgetMembers(ctx, conversationID)
+		sendSystemMessage(ctx, s.messages, s.events, group, userID, domain.SystemEvent{Action: domain.ActionMembersAdded, UserIDs: members})
+	}
+	return added, nil
+}
+
+// RemoveMember removes member from group. Owner and admins can remove members with lower role
+func (s *ConversationService) RemoveMember(ctx context.Context, userID, conversationID, memberID int) *utils.APIError {
+	group, apiErr := s.getGroup(ctx, userID, conversationID)
+	if apiErr != nil {
+		return apiErr
+	}
+
+	target := group.Participant(memberID)
+	if target == nil {
+		return utils.NewAPIError(404, "Member not found", "")
+	}
+
+	event := domain.SystemEvent{Action: domain.ActionMemberLeft}
+	if memberID == userID {
+		// Group can't stay without owner
+		if target.Role == domain.ConversationOwner && len(group.Participants) > 1 {
+			return utils.NewAPIError(409, "Owner can't leave group", "Transfer ownership first")
+		}
+	} else {
+		role := group.Role(userID)
+		if !role.CanModerate() || !role.Outranks(target.Role) {
+			return utils.NewAPIError(403, "You can't remove this member", "")
+		}
+		event = domain.SystemEvent{Action: domain.ActionMemberRemoved, UserIDs: []int{memberID}}
+	}
+
+	removed, apiErr := s.repo.RemoveParticipant(ctx, conversationID, memberID)
+	if apiErr != nil {
+		return apiErr
+	}
+	if !removed {
+		return utils.NewAPIError(404, "Member not found", "")
+	}
+	s.events.ForgetMembers(ctx, conversationID)
+
+	// Group of the last member was deleted with him, there is nobody to tell
+	if len(group.Participants) > 1 {
+		sendSystemMessage(ctx, s.messages, s.events, group, userID, event)
+	}
+	return nil
+}
+
+// Leave removes user from group
+func (s *ConversationService) Leave(ctx context.Context, userID, conversationID int) *utils.APIError {
+	return s.RemoveMember(ctx, userID, conversationID, userID)
+}
+
+// getGroup returns group if user is its member. Direct conversations can't be changed
+func (s *ConversationService) getGroup(ctx context.Context, userID, conversationID int) (*domain.Conversation, *utils.APIError) {
+	conversation, apiErr := s.GetConversation(ctx, userID, conversationID)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+
+	if conversation.Type != domain.GroupConversation {
+		return nil, utils.NewAPIError(400, "Conversation is not a group", "")
+	}
+
+	return conversation, nil
+}
+
+// uniqueMembers checks IDs of members and removes duplicates
+func uniqueMembers(memberIDs []int) ([]int, *utils.APIError) {
+	seen := make(map[int]bool, len(memberIDs))
+	members := make([]int, 0, len(memberIDs))
+
+	for _, memberID := range memberIDs {
+		if memberID <= 0 {
+			return nil, utils.NewAPIError(400, "Invalid input data", "Invalid user ID")
+		}
+		if seen[memberID] {
+			continue
+		}
+		seen[memberID] = true
+		members = append(members, memberID)
+	}
+
+	if len(members) > maxGroupMembers {
+		return nil, utils.NewAPIError(400, "Too many members", "Group can have "+strconv.Itoa(maxGroupMembers)+" members")
+	}
+
+	return members, nil
+}
+
+type inboxCursorPayload struct {
+	Timestamp      int64 `json:"t"`
+	ConversationID int   `json:"c"`
+}
+
+func encodeInboxCursor(c *domain.ConversationCursor) string {
+	data, _ := json.Marshal(inboxCursorPayload{Timestamp: c.LastMessageAt.UnixMicro(), ConversationID: c.ConversationID})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeInboxCursor(cursor string) (*domain.ConversationCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	var payload inboxCursorPayload
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return nil, err
+	}
+	if payload.ConversationID <= 0 {
+		return nil, errors.New("invalid cursor")
+	}
+
+	return &domain.ConversationCursor{LastMessageAt: time.UnixMicro(payload.Timestamp).UTC(), ConversationID: payload.ConversationID}, nil
+}
@@ -0,0 +1,354 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"message-service/internal/domain"
+	"message-service/internal/utils"
+	"net/url"
+	"strings"
+	"time"
+
+	logger "message-service/internal"
+
+	"go.uber.org/zap"
+)
+
+const maxAvatarURLLength = 500
+
+// GroupService is moderation of groups: settings, roles, bans and invite links
+type GroupService struct {
+	conversations domain.ConversationRepository
+	groups        domain.GroupRepository
+	messages      domain.MessageRepository
+	events        domain.EventPublisher
+}
+
+func NewGroupService(conversations domain.ConversationRepository, groups domain.GroupRepository, messages domain.MessageRepository, events domain.EventPublisher) *GroupService {
+	return &GroupService{conversations: conversations, groups: groups, messages: messages, events: events}
+}
+
+// UpdateGroup changes title and (or) avatar of group. Nil means don't change, empty avatar removes it
+func (s *GroupService) UpdateGroup(ctx context.Context, userID, conversationID int, title, avatarURL *string) (*domain.Conversation, *utils.APIError) {
+	group, apiErr := s.getModeratedGroup(ctx, userID, conversationID)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+
+	var events []domain.SystemEvent
+
+	if title != nil {
+		newTitle := strings.TrimSpace(*title)
+		if newTitle == "" || len(newTitle) > maxGroupTitleLength {
+			return nil, utils.NewAPIError(400, "Invalid title", "Title must be 1-100 characters")
+		}
+		if newTitle != group.Title {
+			group.Title = newTitle
+			events = append(events, domain.SystemEvent{Action: domain.ActionTitleChanged, Title: newTitle})
+		}
+	}
+
+	if avatarURL != nil && *avatarURL != group.AvatarURL {
+		if *avatarURL != "" && !validAvatarURL(*avatarURL) {
+			return nil, utils.NewAPIError(400, "Invalid avatar URL", "Use http or https URL")
+		}
+		group.AvatarURL = *avatarURL
+		events = append(events, domain.SystemEvent{Action: domain.ActionAvatarChanged, AvatarURL: group.AvatarURL})
+	}
+
+	if len(events) == 0 {
+		return group, nil
+	}
+
+	if apiErr := s.groups.UpdateGroup(ctx, group); apiErr != nil {
+		return nil, apiErr
+	}
+
+	for _, event := range events {
+		sendSystemMessage(ctx, s.messages, s.events, group, userID, event)
+	}
+	return group, nil
+}
+
+// SetRole changes role of member. Only owner can do it, giving owner role transfers ownership
+func (s *GroupService) SetRole(ctx context.Context, userID, conversationID, memberID int, role domain.ConversationRole) *utils.APIError {
+	if !role.IsValid() {
+		return utils.NewAPIError(400, "Invalid role", "")
+	}
+
+	group, apiErr := s.getGroup(ctx, userID, conversationID)
+	if apiErr != nil {
+		return apiErr
+	}
+
+	if group.Role(userID) != domain.ConversationOwner {
+		return utils.NewAPIError(403, "Only owner can change roles", "")
+	}
+	if memberID == userID {
+		return utils.NewAPIError(400, "You can't change your own role", "Give owner role to somebody else")
+	}
+
+	target, apiErr := s.getParticipant(ctx, group, memberID)
+	if apiErr != nil {
+		return apiErr
+	}
+	if target == nil {
+		return utils.NewAPIError(404, "Member not found", "")
+	}
+	if target.Role == role {
+		return nil
+	}
+
+	if role == domain.ConversationOwner {
+		if apiErr := s.groups.TransferOwnership(ctx, conversationID, userID, memberID); apiErr != nil {
+			return apiErr
+		}
+		sendSystemMessage(ctx, s.messages, s.events, group, userID, domain.SystemEvent{Action: domain.ActionOwnershipChanged, UserIDs: []int{memberID}})
+		return nil
+	}
+
+	updated, apiErr := s.groups.SetParticipantRole(ctx, conversationID, memberID, role)
+	if apiErr != nil {
+		return apiErr
+	}
+	if !updated {
+		return utils.NewAPIError(404, "Member not found", "")
+	}
+
+	sendSystemMessage(ctx, s.messages, s.events, group, userID, domain.SystemEvent{Action: domain.ActionRoleChanged, UserIDs: []int{memberID}, Role: role})
+	return nil
+}
+
+// Ban removes user from group and doesn't let him join again. Users who are not members can be banned too
+func (s *GroupService) Ban(ctx context.Context, userID, conversationID, targetID int) *utils.APIError {
+	group, apiErr := s.getModeratedGroup(ctx, userID, conversationID)
+	if apiErr != nil {
+		return apiErr
+	}
+
+	if targetID == userID {
+		return utils.NewAPIError(400, "You can't ban yourself", "")
+	}
+	target, apiErr := s.getParticipant(ctx, group, targetID)
+	if apiErr != nil {
+		return apiErr
+	}
+	if target != nil && !group.Role(userID).Outranks(target.Role) {
+		return utils.NewAPIError(403, "You can't ban this member", "")
+	}
+
+	if apiErr := s.groups.Ban(ctx, &domain.Ban{ConversationID: conversationID, UserID: targetID, BannedBy: userID}); apiErr != nil {
+		return apiErr
+	}
+	s.events.ForgetMembers(ctx, conversationID)
+
+	sendSystemMessage(ctx, s.messages, s.events, group, userID, domain.SystemEvent{Action: domain.ActionMemberBanned, UserIDs: []int{targetID}})
+	return nil
+}
+
+// Unban lets user join group again, he is not added back
+func (s *GroupService) Unban(ctx context.Context, userID, conversationID, targetID int) *utils.APIError {
+	group, apiErr := s.getModeratedGroup(ctx, userID, conversationID)
+	if apiErr != nil {
+		return apiErr
+	}
+
+	unbanned, apiErr := s.groups.Unban(ctx, conversationID, targetID)
+	if apiErr != nil {
+		return apiErr
+	}
+	if !unbanned {
+		return utils.NewAPIError(404, "Ban not found", "")
+	}
+
+	sendSystemMessage(ctx, s.messages, s.events, group, userID, domain.SystemEvent{Action: domain.ActionMemberUnbanned, UserIDs: []int{targetID}})
+	return nil
+}
+
+func (s *GroupService) GetBans(ctx context.Context, userID, conversationID int) ([]domain.Ban, *utils.APIError) {
+	if _, apiErr := s.getModeratedGroup(ctx, userID, conversationID); apiErr != nil {
+		return nil, apiErr
+	}
+
+	return s.groups.GetBans(ctx, conversationID)
+}
+
+// CreateInvite makes link to join group. maxUses = 0 means no limit, ttl = 0 means it never expires
+func (s *GroupService) CreateInvite(ctx context.Context, userID, conversationID, maxUses int, ttl time.Duration) (*domain.GroupInvite, *utils.APIError) {
+	if maxUses < 0 {
+		return nil, utils.NewAPIError(400, "Invalid max uses", "")
+	}
+	if ttl < 0 {
+		return nil, utils.NewAPIError(400, "Invalid expiry", "")
+	}
+
+	group, apiErr := s.getModeratedGroup(ctx, userID, conversationID)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+
+	randomBytes := make([]byte, 16)
+	if _, err := rand.Read(randomBytes); err != nil {
+		logger.Error("Cannot generate invite code",
+			zap.Error(err))
+		return nil, utils.NewAPIError(500, "Internal server error", "Please try again")
+	}
+
+	invite := &domain.GroupInvite{
+		Code:           hex.EncodeToString(randomBytes),
+		ConversationID: conversationID,
+		CreatedBy:      userID,
+		MaxUses:        maxUses,
+	}
+
+	// Timestamps are stored without time zone, in UTC
+	if ttl > 0 {
+		expiresAt := time.Now().UTC().Add(ttl)
+		invite.ExpiresAt = &expiresAt
+	}
+
+	if apiErr := s.groups.CreateInvite(ctx, invite); apiErr != nil {
+		return nil, apiErr
+	}
+
+	sendSystemMessage(ctx, s.messages, s.events, group, userID, domain.SystemEvent{Action: domain.ActionInviteCreated})
+	return invite, nil
+}
+
+func (s *GroupService) GetInvites(ctx context.Context, userID, conversationID int) ([]domain.GroupInvite, *utils.APIError) {
+	if _, apiErr := s.getModeratedGroup(ctx, userID, conversationID); apiErr != nil {
+		return nil, apiErr
+	}
+
+	return s.groups.GetInvites(ctx, conversationID)
+}
+
+func (s *GroupService) RevokeInvite(ctx context.Context, userID, conversationID int, code string) *utils.APIError {
+	group, apiErr := s.getModeratedGroup(ctx, userID, conversationID)
+	if apiErr != nil {
+		return apiErr
+	}
+
+	revoked, apiErr := s.groups.RevokeInvite(ctx, conversationID, code)
+	if apiErr != nil {
+		return apiErr
+	}
+	if !revoked {
+		return utils.NewAPIError(404, "Invite not found", "")
+	}
+
+	sendSystemMessage(ctx, s.messages, s.events, group, userID, domain.SystemEvent{Action: domain.ActionInviteRevoked})
+	return nil
+}
+
+// JoinByInvite adds user to group of invite and returns the group
+func (s *GroupService) JoinByInvite(ctx context.Context, userID int, code string) (*domain.Conversation, *utils.APIError) {
+	conversationID, joined, apiErr := s.groups.JoinByInvite(ctx, code, userID)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	if joined {
+		s.events.ForgetMembers(ctx, conversationID)
+	}
+
+	group, apiErr := s.getGroup(ctx, userID, conversationID)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+
+	// Thousands of subscribers would flood channel
+	if joined && group.Type == domain.GroupConversation {
+		sendSystemMessage(ctx, s.messages, s.events, group, userID, domain.SystemEvent{Action: domain.ActionMemberJoined})
+	}
+
+	return group, nil
+}
+
+// getGroup returns group or channel if user is its member. They are moderated the same way
+func (s *GroupService) getGroup(ctx context.Context, userID, conversationID int) (*domain.Conversation, *utils.APIError) {
+	group, apiErr := s.conversations.GetConversation(ctx, conversationID, userID)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+
+	if group == nil || !group.HasParticipant(userID) {
+		return nil, utils.NewAPIError(404, "Conversation not found", "")
+	}
+	if group.Type == domain.DirectConversation {
+		return nil, utils.NewAPIError(400, "Conversation is not a group or channel", "")
+	}
+
+	return group, nil
+}
+
+// getParticipant finds member of group. Plain subscribers of channel are not loaded with it, they are looked up in repository
+func (s *GroupService) getParticipant(ctx context.Context, group *domain.Conversation, userID int) (*domain.Participant, *utils.APIError) {
+	if participant := group.Participant(userID); participant != nil || group.Type != domain.ChannelConversation {
+		return participant, nil
+	}
+	return s.conversations.GetParticipant(ctx, group.ID, userID)
+}
+
+// getModeratedGroup returns group if user is its owner or admin
+func (s *GroupService) getModeratedGroup(ctx context.Context, userID, conversationID int) (*domain.Conversation, *utils.APIError) {
+	group, apiErr := s.getGroup(ctx, userID, conversationID)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+
+	if !group.Role(userID).CanModerate() {
+		return nil, utils.NewAPIError(403, "Only owner and admins can do it", "")
+	}
+
+	return group, nil
+}
+
+func validAvatarURL(avatarURL string) bool {
+	if len(avatarURL) > maxAvatarURLLength {
+		return false
+	}
+	parsed, err := url.Parse(avatarURL)
+	return err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != ""
+}
+
+// sendSystemMessage tells members of group what happened. Action is done already, so error is only logged
+func sendSystemMessage(ctx context.Context, messages domain.MessageRepository, events domain.EventPublisher, conversation *domain.Conversation, actorID int, event domain.SystemEvent) {
+	content, err := json.Marshal(event)
+	if err != nil {
+		logger.Error("Cannot encode system message", zap.String("Action", event.Action), zap.Error(err))
+		return
+	}
+
+	message := &domain.Message{
+		ConversationID: conversation.ID,
+		SenderID:       actorID,
+		Content:        string(content),
+		Type:           domain.SystemMessage,
+	}
+
+	realtimeEvent := &domain.RealtimeEvent{
+		Type:           domain.MessageCreatedEvent,
+		ConversationID: conversation.ID,
+		Message:        message,
+	}
+	record := conversationSyncRecord(conversation, realtimeEvent)
+
+	var apiErr *utils.APIError
+	if conversation.Type == domain.ChannelConversation {
+		_, apiErr = messages.SendChannelMessage(ctx, message, 0)
+	} else {
+		_, apiErr = messages.SendMessage(ctx, message, 0, record)
+	}
+
+	if apiErr != nil {
+		logger.Error("Cannot send system message",
+			zap.Int("Conversation ID", conversation.ID),
+			zap.String("Action", event.Action),
+			zap.String("Error", apiErr.Message))
+		return
+	}
+
+	publishSynced(ctx, events, conversation, record, realtimeEvent)
+}
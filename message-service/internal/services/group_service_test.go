@@ -0,0 +1,132 @@
+package services
+
+import (
+	"context"
+	"message-service/internal/domain"
+	"message-service/internal/utils"
+	"testing"
+)
+
+// fakeGroupRepo changes roles of conversations kept by fakeConversationRepo, methods which tests don't need panic
+type fakeGroupRepo struct {
+	domain.GroupRepository
+	conversations *fakeConversationRepo
+	banned        []int
+}
+
+// participant finds member or subscriber which repository would change
+func (r *fakeGroupRepo) participant(conversationID, userID int) *domain.Participant {
+	conversation := r.conversations.conversations[conversationID]
+	for _, participants := range [][]domain.Participant{conversation.Participants, r.conversations.subscribers[conversationID]} {
+		for i := range participants {
+			if participants[i].UserID == userID {
+				return &participants[i]
+			}
+		}
+	}
+	return nil
+}
+
+func (r *fakeGroupRepo) SetParticipantRole(ctx context.Context, conversationID, userID int, role domain.ConversationRole) (bool, *utils.APIError) {
+	participant := r.participant(conversationID, userID)
+	if participant == nil {
+		return false, nil
+	}
+	participant.Role = role
+	return true, nil
+}
+
+func (r *fakeGroupRepo) TransferOwnership(ctx context.Context, conversationID, fromID, toID int) *utils.APIError {
+	r.participant(conversationID, fromID).Role = domain.ConversationAdmin
+	r.participant(conversationID, toID).Role = domain.ConversationOwner
+	return nil
+}
+
+func (r *fakeGroupRepo) Ban(ctx context.Context, ban *domain.Ban) *utils.APIError {
+	r.banned = append(r.banned, ban.UserID)
+	return nil
+}
+
+func TestGroupModeration(t *testing.T) {
+	const (
+		owner      = 1
+		admin      = 2
+		subscriber = 3
+		stranger   = 9
+	)
+
+	tests := []struct {
+		name string
+		// Channel has subscribers which are not loaded with it, group has everybody loaded
+		conversationType domain.ConversationType
+		userID           int
+		targetID         int
+		// Empty role means ban
+		role     domain.ConversationRole
+		wantCode int
+		// Role of target after action, empty if he is not a member
+		wantRole   domain.ConversationRole
+		wantBanned bool
+		// Nothing changes, so members are not told
+		unchanged bool
+	}{
+		{name: "owner makes subscriber admin", conversationType: domain.ChannelConversation, userID: owner, targetID: subscriber, role: domain.ConversationAdmin, wantRole: domain.ConversationAdmin},
+		{name: "owner gives channel to subscriber", conversationType: domain.ChannelConversation, userID: owner, targetID: subscriber, role: domain.ConversationOwner, wantRole: domain.ConversationOwner},
+		{name: "subscriber keeps his role", conversationType: domain.ChannelConversation, userID: owner, targetID: subscriber, role: domain.ConversationMember, wantRole: domain.ConversationMember, unchanged: true},
+		{name: "owner demotes admin", conversationType: domain.ChannelConversation, userID: owner, targetID: admin, role: domain.ConversationMember, wantRole: domain.ConversationMember},
+		{name: "admin can't change roles", conversationType: domain.ChannelConversation, userID: admin, targetID: subscriber, role: domain.ConversationAdmin, wantCode: 403, wantRole: domain.ConversationMember},
+		{name: "role of stranger", conversationType: domain.ChannelConversation, userID: owner, targetID: stranger, role: domain.ConversationAdmin, wantCode: 404},
+		{name: "member of group", conversationType: domain.GroupConversation, userID: owner, targetID: subscriber, role: domain.ConversationAdmin, wantRole: domain.ConversationAdmin},
+		{name: "admin bans subscriber", conversationType: domain.ChannelConversation, userID: admin, targetID: subscriber, wantRole: domain.ConversationMember, wantBanned: true},
+		{name: "admin bans stranger", conversationType: domain.ChannelConversation, userID: admin, targetID: stranger, wantBanned: true},
+		{name: "admin can't ban owner", conversationType: domain.ChannelConversation, userID: admin, targetID: owner, wantCode: 403, wantRole: domain.ConversationOwner},
+		{name: "subscriber can't ban", conversationType: domain.ChannelConversation, userID: subscriber, targetID: admin, wantCode: 404, wantRole: domain.ConversationAdmin},
+		{name: "admin bans member of group", conversationType: domain.GroupConversation, userID: admin, targetID: subscriber, wantRole: domain.ConversationMember, wantBanned: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			members := []domain.Participant{{UserID: owner, Role: domain.ConversationOwner}, {UserID: admin, Role: domain.ConversationAdmin}}
+			subscribers := []domain.Participant{{UserID: subscriber, Role: domain.ConversationMember}}
+			if tt.conversationType != domain.ChannelConversation {
+				members, subscribers = append(members, subscribers...), nil
+			}
+			conversations := &fakeConversationRepo{
+				conversations: map[int]*domain.Conversation{1: {ID: 1, Type: tt.conversationType, Participants: members}},
+				subscribers:   map[int][]domain.Participant{1: subscribers},
+			}
+			groups := &fakeGroupRepo{conversations: conversations}
+			messages := &fakeMessageRepo{}
+			service := NewGroupService(conversations, groups, messages, &fakeEvents{})
+
+			var apiErr *utils.APIError
+			if tt.role != "" {
+				apiErr = service.SetRole(context.Background(), tt.userID, 1, tt.targetID, tt.role)
+			} else {
+				apiErr = service.Ban(context.Background(), tt.userID, 1, tt.targetID)
+			}
+
+			code := 0
+			if apiErr != nil {
+				code = apiErr.Code
+			}
+			if code != tt.wantCode {
+				t.Fatalf("got error %d, want %d", code, tt.wantCode)
+			}
+
+			var role domain.ConversationRole
+			if target := groups.participant(1, tt.targetID); target != nil {
+				role = target.Role
+			}
+			if role != tt.wantRole {
+				t.Errorf("target has role %q, want %q", role, tt.wantRole)
+			}
+			if banned := len(groups.banned) > 0; banned != tt.wantBanned {
+				t.Errorf("banned %v, want %v", banned, tt.wantBanned)
+			}
+			if told := tt.wantCode == 0 && !tt.unchanged; told != (len(messages.sent) > 0) {
+				t.Errorf("%d system messages sent, want them %v", len(messages.sent), told)
+			}
+		})
+	}
+}
@@ -0,0 +1,13 @@
+package services
+
+import (
+	"os"
+	"testing"
+
+	logger "message-service/internal"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitLogger()
+	os.Exit(m.Run())
+}
@@ -2,58 +2,765 @@ package services
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"message-service/internal/domain"
 	"message-service/internal/utils"
+	"time"
+)
+
+// How many messages of conversation are returned at once
+const (
+	defaultConversationLimit = 50
+	maxConversationLimit     = 200
 )
 
 type MessageService struct {
-	repo domain.MessageRepository
+	repo          domain.MessageRepository
+	conversations domain.ConversationRepository
+	// Old messages which are moved out of database
+	archive *ArchiveService
+	// Pushes changes to online users
+	events domain.EventPublisher
+	// How many messages guest can send per day
+	guestDailyLimit int
+	// How long after sending message can be edited, 0 - always
+	editWindow time.Duration
+	// How long after sending sender can delete message for everyone, 0 - always
+	deleteWindow time.Duration
 }
 
-func NewMessageService(repo domain.MessageRepository) *MessageService {
-	return &MessageService{repo: repo}
+func NewMessageService(repo domain.MessageRepository, conversations domain.ConversationRepository, archive *ArchiveService, events domain.EventPublisher, guestDailyLimit int, editWindow, deleteWindow time.Duration) *MessageService {
+	return &MessageService{
+		repo:            repo,
+		conversations:   conversations,
+		archive:         archive,
+		events:          events,
+		guestDailyLimit: guestDailyLimit,
+		editWindow:      editWindow,
+		deleteWindow:    deleteWindow,
+	}
 }
-func (s *MessageService) CreateMessage(ctx context.Context, recipientID, senderID int, content string) (*domain.Message, *utils.APIError) {
+
+// CreateMessage sends message to direct conversation with recipient, conversation is created if it is the first message
+func (s *MessageService) CreateMessage(ctx context.Context, recipientID, senderID int, senderRole domain.UserRole, content string) (*domain.Message, *utils.APIError) {
 
 	if recipientID == senderID {
 		return nil, utils.NewAPIError(418, "You can't send a message to yourself", "")
 	}
 
-	if len(content) == 0 {
-		return nil, utils.NewAPIError(400, "Content cannot be empty", "")
+	if apiErr := checkContent(content); apiErr != nil {
+		return nil, apiErr
 	}
 
-	if len(content) > 5000 {
-		return nil, utils.NewAPIError(400, "Content exceeds maximum length", "")
+	conversation, apiErr := s.conversations.GetOrCreateDirectConversation(ctx, senderID, recipientID)
+	if apiErr != nil {
+		return nil, apiErr
 	}
 
+	return s.send(ctx, conversation, senderID, senderRole, content)
+}
+
+// SendConversationMessage sends message to conversation with ID conversationID
+func (s *MessageService) SendConversationMessage(ctx context.Context, conversationID, senderID int, senderRole domain.UserRole, content string) (*domain.Message, *utils.APIError) {
+	conversation, apiErr := s.getConversation(ctx, senderID, conversationID)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+
+	if conversation.Type == domain.ChannelConversation && !conversation.Role(senderID).CanModerate() {
+		return nil, utils.NewAPIError(403, "Only publishers can post in channel", "")
+	}
+
+	if apiErr := checkContent(content); apiErr != nil {
+		return nil, apiErr
+	}
+
+	return s.send(ctx, conversation, senderID, senderRole, content)
+}
+
+func (s *MessageService) send(ctx context.Context, conversation *domain.Conversation, senderID int, senderRole domain.UserRole, content string) (*domain.Message, *utils.APIError) {
 	message := &domain.Message{
-		RecipientID: recipientID,
-		SenderID:    senderID,
-		Content:     content,
+		ConversationID: conversation.ID,
+		RecipientID:    conversation.Peer(senderID),
+		SenderID:       senderID,
+		Content:        content,
 	}
 
-	message, apiErr := s.repo.SendMessage(ctx, message)
+	// Sender gets it too, his other devices show it
+	event := &domain.RealtimeEvent{
+		Type:           domain.MessageCreatedEvent,
+		ConversationID: conversation.ID,
+		Message:        message,
+	}
+	record := conversationSyncRecord(conversation, event)
 
+	// Limit is checked by repository in the same transaction, so parallel sends can't go over it
+	dailyLimit := 0
+	if senderRole == domain.RoleGuest {
+		dailyLimit = s.guestDailyLimit
+	}
+
+	var apiErr *utils.APIError
+	if conversation.Type == domain.ChannelConversation {
+		message, apiErr = s.repo.SendChannelMessage(ctx, message, dailyLimit)
+	} else {
+		message, apiErr = s.repo.SendMessage(ctx, message, dailyLimit, record)
+	}
+	if apiErr != nil && apiErr.Code == 429 {
+		return nil, utils.NewAPIError(429, "Daily message limit for guests is reached", "Upgrade your account to send more")
+	}
 	if apiErr != nil {
 		return nil, apiErr
 	}
 
+	publishSynced(ctx, s.events, conversation, record, event)
 	return message, nil
 }
 
-func (s *MessageService) GetConversationMessages(ctx context.Context, user1ID, user2ID int) (*[]domain.Message, *utils.APIError) {
+func checkContent(content string) *utils.APIError {
+	if len(content) == 0 {
+		return utils.NewAPIError(400, "Content cannot be empty", "")
+	}
+
+	if len(content) > 5000 {
+		return utils.NewAPIError(400, "Content exceeds maximum length", "")
+	}
+
+	return nil
+}
+
+// getConversation returns conversation if user is its participant.
+// Others get 404, they don't even know that conversation exists
+func (s *MessageService) getConversation(ctx context.Context, userID, conversationID int) (*domain.Conversation, *utils.APIError) {
+	conversation, apiErr := s.conversations.GetConversation(ctx, conversationID, userID)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+
+	if conversation == nil || !conversation.HasParticipant(userID) {
+		return nil, utils.NewAPIError(404, "Conversation not found", "")
+	}
+
+	return conversation, nil
+}
+
+// GetDirectConversationMessages works like GetConversationMessages, but conversation is found by the other user
+func (s *MessageService) GetDirectConversationMessages(ctx context.Context, userID, peerID int, filter domain.ConversationFilter, cursor string, around int) (*domain.ConversationPage, *utils.APIError) {
+	conversation, apiErr := s.conversations.GetDirectConversation(ctx, userID, peerID)
+	if apiErr != nil {
+		return nil, apiErr
+	}
 
-	messages, apiErr := s.repo.GetMessagesBetweenUsers(ctx, user1ID, user2ID)
+	// They didn't talk yet
+	if conversation == nil {
+		if around != 0 {
+			return nil, utils.NewAPIError(404, "Message not found", "")
+		}
+		return buildConversationPage(nil, false, false), nil
+	}
+
+	return s.getConversationMessages(ctx, userID, conversation, filter, cursor, around)
+}
+
+// GetConversationMessages returns one page of conversation. Page starts from cursor if it is set,
+// or it is a window around message with ID around, or just newest messages
+func (s *MessageService) GetConversationMessages(ctx context.Context, userID, conversationID int, filter domain.ConversationFilter, cursor string, around int) (*domain.ConversationPage, *utils.APIError) {
+	conversation, apiErr := s.getConversation(ctx, userID, conversationID)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+
+	return s.getConversationMessages(ctx, userID, conversation, filter, cursor, around)
+}
+
+func (s *MessageService) getConversationMessages(ctx context.Context, userID int, conversation *domain.Conversation, filter domain.ConversationFilter, cursor string, around int) (*domain.ConversationPage, *utils.APIError) {
+	// Timestamps are stored without time zone, in UTC
+	filter.After = filter.After.UTC()
+	filter.Before = filter.Before.UTC()
+
+	if !filter.After.IsZero() && !filter.Before.IsZero() && !filter.After.Before(filter.Before) {
+		return nil, utils.NewAPIError(400, "Invalid time range", "after must be earlier than before")
+	}
+
+	// New group members don't see what was before them
+	if since := conversation.VisibleSince(userID); !since.IsZero() && !filter.After.After(since) {
+		filter.After = since.Add(-time.Microsecond)
+	}
+
+	if filter.Limit < 0 {
+		return nil, utils.NewAPIError(400, "Invalid limit", "")
+	}
+	if filter.Limit == 0 {
+		filter.Limit = defaultConversationLimit
+	}
+	if filter.Limit > maxConversationLimit {
+		filter.Limit = maxConversationLimit
+	}
+
+	if cursor != "" && around != 0 {
+		return nil, utils.NewAPIError(400, "Invalid input data", "Use either cursor or around")
+	}
+
+	if around != 0 {
+		return s.getMessagesAround(ctx, userID, conversation.ID, filter, around)
+	}
+
+	if cursor != "" {
+		c, err := decodeCursor(cursor)
+		if err != nil {
+			return nil, utils.NewAPIError(400, "Invalid cursor", "")
+		}
+		filter.Cursor = c
+	}
 
+	// One extra message tells us if there is something after this page
+	limit := filter.Limit
+	filter.Limit++
+	messages, apiErr := s.repo.GetConversationMessages(ctx, conversation.ID, filter)
 	if apiErr != nil {
 		return nil, utils.NewAPIError(404, "Conversation or messages not found", "")
 	}
 
-	return messages, nil
+	page := *messages
+	newer := filter.Cursor != nil && filter.Cursor.Newer
+
+	// Archived messages are older than everything in database. Going back, they are needed only if database
+	// ran out of messages. Going forward from archived cursor, archive comes first
+	if s.archive != nil && (newer || len(page) < filter.Limit) {
+		archiveFilter := filter
+		if !newer {
+			archiveFilter.Limit = filter.Limit - len(page)
+		}
+
+		archived, apiErr := s.archive.GetConversationMessages(ctx, conversation, archiveFilter)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+
+		if newer {
+			page = append(archived, page...)
+		} else {
+			page = append(page, archived...)
+		}
+	}
+
+	hasMore := len(page) > limit
+	if hasMore {
+		page = page[:limit]
+	}
+
+	if newer {
+		reverseMessages(page)
+	}
+
+	// Older messages exist, if we cut the page going back in history, or if we went forward from cursor
+	hasOlder := (!newer && hasMore) || (newer && len(page) > 0)
+	s.markDelivered(ctx, userID, page)
+	if apiErr := s.hideMessages(ctx, userID, page); apiErr != nil {
+		return nil, apiErr
+	}
+
+	// Newer messages can come at any time, so client can always ask for them
+	return buildConversationPage(page, hasOlder, true), nil
 }
 
-func (s *MessageService) UpdateMessageStatus(ctx context.Context, messageID int, status domain.MessageStatus) *utils.APIError {
+// getMessagesAround returns message with ID messageID and messages around it, half of limit on each side
+func (s *MessageService) getMessagesAround(ctx context.Context, userID, conversationID int, filter domain.ConversationFilter, messageID int) (*domain.ConversationPage, *utils.APIError) {
+	pivot, apiErr := s.repo.GetMessageByID(ctx, messageID, time.Time{})
+	if apiErr != nil {
+		return nil, apiErr
+	}
+
+	// Don't tell if message exists in other conversation
+	if pivot == nil || pivot.ConversationID != conversationID ||
+		(!filter.After.IsZero() && !pivot.Timestamp.After(filter.After)) {
+		return nil, utils.NewAPIError(404, "Message not found", "")
+	}
+
+	half := filter.Limit / 2
+
+	filter.Limit = half + 1
+	filter.Cursor = &domain.MessageCursor{Timestamp: pivot.Timestamp, ID: pivot.MessageID, Newer: true}
+	newer, apiErr := s.repo.GetConversationMessages(ctx, conversationID, filter)
+	if apiErr != nil {
+		return nil, utils.NewAPIError(404, "Conversation or messages not found", "")
+	}
+
+	filter.Cursor = &domain.MessageCursor{Timestamp: pivot.Timestamp, ID: pivot.MessageID}
+	older, apiErr := s.repo.GetConversationMessages(ctx, conversationID, filter)
+	if apiErr != nil {
+		return nil, utils.NewAPIError(404, "Conversation or messages not found", "")
+	}
+
+	newerPage := *newer
+	if len(newerPage) > half {
+		newerPage = newerPage[:half]
+	}
+	reverseMessages(newerPage)
+
+	olderPage := *older
+	hasOlder := len(olderPage) > half
+	if hasOlder {
+		olderPage = olderPage[:half]
+	}
+
+	page := make([]domain.Message, 0, len(newerPage)+1+len(olderPage))
+	page = append(page, newerPage...)
+	page = append(page, *pivot)
+	page = append(page, olderPage...)
+
+	s.markDelivered(ctx, userID, page)
+	if apiErr := s.hideMessages(ctx, userID, page); apiErr != nil {
+		return nil, apiErr
+	}
+
+	return buildConversationPage(page, hasOlder, true), nil
+}
+
+// hideMessages turns messages which reader deleted for himself into placeholders
+func (s *MessageService) hideMessages(ctx context.Context, readerID int, page []domain.Message) *utils.APIError {
+	messageIDs := make([]int, 0, len(page))
+	for _, msg := range page {
+		messageIDs = append(messageIDs, msg.MessageID)
+	}
+
+	hidden, apiErr := s.repo.GetHiddenMessageIDs(ctx, readerID, messageIDs)
+	if apiErr != nil {
+		return apiErr
+	}
+
+	for i := range page {
+		if hidden[page[i].MessageID] {
+			page[i].Hidden = true
+			page[i].Content = ""
+		}
+	}
+	return nil
+}
+
+// markDelivered marks messages which reader just got as delivered, page is updated too
+func (s *MessageService) markDelivered(ctx context.Context, readerID int, page []domain.Message) {
+	var pending []domain.Message
+	for _, msg := range page {
+		if msg.RecipientID == readerID && msg.Status == string(domain.Sent) {
+			pending = append(pending, msg)
+		}
+	}
+	if len(pending) == 0 {
+		return
+	}
+
+	records := make(map[int]*domain.SyncRecord, len(pending))
+	for _, msg := range pending {
+		records[msg.MessageID] = newSyncRecord([]int{msg.SenderID, readerID}, &domain.RealtimeEvent{
+			Type:           domain.MessageStatusEvent,
+			ConversationID: msg.ConversationID,
+			MessageID:      msg.MessageID,
+			Status:         domain.Delivered,
+		})
+	}
+
+	// Reader still gets messages, status is not a reason to fail
+	delivered, apiErr := s.repo.MarkDelivered(ctx, readerID, pending, records)
+	if apiErr != nil {
+		return
+	}
+
+	for i := range page {
+		if deliveredAt, ok := delivered[page[i].MessageID]; ok {
+			page[i].Status = string(domain.Delivered)
+			page[i].DeliveredAt = &deliveredAt
+
+			record := records[page[i].MessageID]
+			s.events.Publish(ctx, record.UserIDs, record.Event)
+		}
+	}
+}
+
+// MarkDirectConversationRead marks all messages which reader got from peer up to message messageID as read
+func (s *MessageService) MarkDirectConversationRead(ctx context.Context, readerID, peerID, messageID int) (int64, *utils.APIError) {
+	if readerID == peerID {
+		return 0, utils.NewAPIError(400, "Invalid input data", "")
+	}
+
+	conversation, apiErr := s.conversations.GetDirectConversation(ctx, readerID, peerID)
+	if apiErr != nil {
+		return 0, apiErr
+	}
+	if conversation == nil {
+		return 0, utils.NewAPIError(404, "Message not found", "")
+	}
+
+	return s.markRead(ctx, readerID, conversation, messageID)
+}
+
+// MarkConversationRead marks all messages which reader got in conversation up to message messageID as read
+func (s *MessageService) MarkConversationRead(ctx context.Context, readerID, conversationID, messageID int) (int64, *utils.APIError) {
+	conversation, apiErr := s.getConversation(ctx, readerID, conversationID)
+	if apiErr != nil {
+		return 0, apiErr
+	}
+
+	return s.markRead(ctx, readerID, conversation, messageID)
+}
 
-	return s.repo.UpdateMessageStatus(ctx, messageID, string(status))
+func (s *MessageService) markRead(ctx context.Context, readerID int, conversation *domain.Conversation, messageID int) (int64, *utils.APIError) {
+	upTo, apiErr := s.repo.GetMessageByID(ctx, messageID, time.Time{})
+	if apiErr != nil {
+		return 0, apiErr
+	}
+
+	if upTo == nil || upTo.ConversationID != conversation.ID {
+		return 0, utils.NewAPIError(404, "Message not found", "")
+	}
+
+	event := &domain.RealtimeEvent{
+		Type:           domain.ConversationReadEvent,
+		ConversationID: conversation.ID,
+		UserID:         readerID,
+		MessageID:      upTo.MessageID,
+	}
+	// Nobody sees who read channel, only other devices of reader
+	record := conversationSyncRecord(conversation, event)
+	if conversation.Type == domain.ChannelConversation {
+		record = newSyncRecord([]int{readerID}, event)
+	}
+
+	marked, apiErr := s.repo.MarkConversationRead(ctx, readerID, conversation.ID, upTo, record)
+	if apiErr != nil {
+		return 0, apiErr
+	}
+
+	s.events.Publish(ctx, record.UserIDs, record.Event)
+	return marked, nil
+}
+
+func buildConversationPage(messages []domain.Message, hasOlder, hasNewer bool) *domain.ConversationPage {
+	page := &domain.ConversationPage{Messages: messages}
+	if page.Messages == nil {
+		page.Messages = []domain.Message{}
+	}
+	if len(messages) == 0 {
+		return page
+	}
+
+	if hasOlder {
+		last := messages[len(messages)-1]
+		page.NextCursor = encodeCursor(&domain.MessageCursor{Timestamp: last.Timestamp, ID: last.MessageID})
+	}
+	if hasNewer {
+		first := messages[0]
+		page.PrevCursor = encodeCursor(&domain.MessageCursor{Timestamp: first.Timestamp, ID: first.MessageID, Newer: true})
+	}
+
+	return page
+}
+
+func reverseMessages(messages []domain.Message) {
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+}
+
+// Cursor is opaque for client: base64 of JSON, so we can change it later
+type cursorPayload struct {
+	Timestamp int64 `json:"t"`
+	ID        int   `json:"i"`
+	Newer     bool  `json:"n,omitempty"`
+}
+
+func encodeCursor(c *domain.MessageCursor) string {
+	data, _ := json.Marshal(cursorPayload{Timestamp: c.Timestamp.UnixMicro(), ID: c.ID, Newer: c.Newer})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(cursor string) (*domain.MessageCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	var payload cursorPayload
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return nil, err
+	}
+	if payload.ID <= 0 {
+		return nil, errors.New("invalid cursor")
+	}
+
+	// Postgres keeps microseconds
+	return &domain.MessageCursor{
+		Timestamp: time.UnixMicro(payload.Timestamp).UTC(),
+		ID:        payload.ID,
+		Newer:     payload.Newer,
+	}, nil
+}
+
+// UpdateMessageStatus changes status of message. Only recipient can do it, and status can't go back.
+// Timestamp of message is optional, it just makes search faster
+func (s *MessageService) UpdateMessageStatus(ctx context.Context, userID, messageID int, timestamp time.Time, status domain.MessageStatus) *utils.APIError {
+	message, apiErr := s.repo.GetMessageByID(ctx, messageID, timestamp.UTC())
+	if apiErr != nil {
+		return apiErr
+	}
+
+	if message != nil && message.RecipientID == 0 {
+		// Group message, members have read position instead of status
+		if _, apiErr := s.getConversation(ctx, userID, message.ConversationID); apiErr != nil {
+			return utils.NewAPIError(404, "Message not found", "")
+		}
+		return utils.NewAPIError(400, "Group messages have no status", "Use POST /conversations/:id/read")
+	}
+
+	// Strangers don't even know that message exists
+	if message == nil || (message.RecipientID != userID && message.SenderID != userID) {
+		return utils.NewAPIError(404, "Message not found", "")
+	}
+
+	if message.RecipientID != userID {
+		return utils.NewAPIError(403, "Only recipient can change message status", "")
+	}
+
+	current := domain.MessageStatus(message.Status)
+	if current == status {
+		return nil
+	}
+
+	if !current.CanMoveTo(status) {
+		return utils.NewAPIError(409, "Message status can't go back", "Message is already "+message.Status)
+	}
+
+	record := newSyncRecord([]int{message.SenderID, message.RecipientID}, &domain.RealtimeEvent{
+		Type:           domain.MessageStatusEvent,
+		ConversationID: message.ConversationID,
+		MessageID:      messageID,
+		Status:         status,
+	})
+	updated, apiErr := s.repo.UpdateMessageStatus(ctx, messageID, message.Timestamp, current, status, record)
+	if apiErr != nil {
+		return apiErr
+	}
+
+	// Somebody changed status between our read and write
+	if !updated {
+		return utils.NewAPIError(409, "Message status was changed", "Please try again")
+	}
+
+	s.events.Publish(ctx, record.UserIDs, record.Event)
+	return nil
+}
+
+// DeleteMessage leaves tombstone instead of message. Sender can delete his messages,
+// owner and admins of group can delete messages of others
+func (s *MessageService) DeleteMessage(ctx context.Context, userID, conversationID, messageID int, timestamp time.Time) *utils.APIError {
+	conversation, apiErr := s.getConversation(ctx, userID, conversationID)
+	if apiErr != nil {
+		return apiErr
+	}
+
+	message, archived, apiErr := s.getMessage(ctx, conversationID, messageID, timestamp.UTC())
+	if apiErr != nil {
+		return apiErr
+	}
+	if message == nil || message.ConversationID != conversationID || message.DeletedAt != nil {
+		return utils.NewAPIError(404, "Message not found", "")
+	}
+
+	// Log of admin actions stays
+	if message.Type == domain.SystemMessage {
+		return utils.NewAPIError(403, "System messages can't be deleted", "")
+	}
+
+	moderated := message.SenderID != userID
+	if moderated && !conversation.Role(userID).CanModerate() {
+		return utils.NewAPIError(403, "You can't delete this message", "")
+	}
+	// Moderators clean up any time
+	if !conversation.Role(userID).CanModerate() && s.deleteWindow > 0 && time.Since(message.Timestamp) > s.deleteWindow {
+		return utils.NewAPIError(403, "Message is too old to delete for everyone", "You can delete it for yourself")
+	}
+
+	event := &domain.RealtimeEvent{
+		Type:           domain.MessageDeletedEvent,
+		ConversationID: conversationID,
+		UserID:         userID,
+		MessageID:      messageID,
+	}
+	record := conversationSyncRecord(conversation, event)
+	var deleted bool
+	if archived {
+		deleted, apiErr = s.repo.DeleteArchivedMessage(ctx, message, userID, record)
+	} else {
+		deleted, apiErr = s.repo.DeleteMessage(ctx, messageID, message.Timestamp, userID, record)
+	}
+	if apiErr != nil {
+		return apiErr
+	}
+	if !deleted {
+		return utils.NewAPIError(404, "Message not found", "")
+	}
+
+	publishSynced(ctx, s.events, conversation, record, event)
+
+	if moderated {
+		sendSystemMessage(ctx, s.repo, s.events, conversation, userID, domain.SystemEvent{Action: domain.ActionMessageDeleted, MessageID: messageID, UserIDs: []int{message.SenderID}})
+	}
+	return nil
+}
+
+// EditMessage replaces content of message. Only sender can do it, within edit window. Old content goes to edit history
+func (s *MessageService) EditMessage(ctx context.Context, userID, messageID int, timestamp time.Time, content string) (*domain.Message, *utils.APIError) {
+	if apiErr := checkContent(content); apiErr != nil {
+		return nil, apiErr
+	}
+
+	message, conversation, archived, apiErr := s.getVisibleMessage(ctx, userID, messageID, timestamp)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+
+	if message.SenderID != userID || message.Type == domain.SystemMessage {
+		return nil, utils.NewAPIError(403, "Only sender can edit message", "")
+	}
+	if s.editWindow > 0 && time.Since(message.Timestamp) > s.editWindow {
+		return nil, utils.NewAPIError(403, "Message is too old to edit", "")
+	}
+
+	// Nothing to save in history
+	if message.Content == content {
+		return message, nil
+	}
+
+	// Repository puts edited message into event
+	event := &domain.RealtimeEvent{
+		Type:           domain.MessageEditedEvent,
+		ConversationID: conversation.ID,
+		UserID:         userID,
+	}
+	record := conversationSyncRecord(conversation, event)
+	var edited *domain.Message
+	if archived {
+		edited, apiErr = s.repo.EditArchivedMessage(ctx, message, content, record)
+	} else {
+		edited, apiErr = s.repo.EditMessage(ctx, messageID, message.Timestamp, content, record)
+	}
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	if edited == nil {
+		return nil, utils.NewAPIError(404, "Message not found", "")
+	}
+
+	event.Message = edited
+	publishSynced(ctx, s.events, conversation, record, event)
+	return edited, nil
+}
+
+// GetMessageEdits returns message with its previous versions, oldest first
+func (s *MessageService) GetMessageEdits(ctx context.Context, userID, messageID int, timestamp time.Time) (*domain.Message, []domain.MessageEdit, *utils.APIError) {
+	message, _, _, apiErr := s.getVisibleMessage(ctx, userID, messageID, timestamp)
+	if apiErr != nil {
+		return nil, nil, apiErr
+	}
+
+	// Message which user deleted for himself is gone for him with its history
+	hidden, apiErr := s.repo.GetHiddenMessageIDs(ctx, userID, []int{messageID})
+	if apiErr != nil {
+		return nil, nil, apiErr
+	}
+	if hidden[messageID] {
+		return nil, nil, utils.NewAPIError(404, "Message not found", "")
+	}
+
+	edits, apiErr := s.repo.GetMessageEdits(ctx, messageID, message.Timestamp)
+	if apiErr != nil {
+		return nil, nil, apiErr
+	}
+
+	return message, edits, nil
+}
+
+// getMessage looks for message in live partitions, then in archive. Archive is searched only when timestamp is known,
+// conversation 0 means any. Archived says where message was found
+func (s *MessageService) getMessage(ctx context.Context, conversationID, messageID int, timestamp time.Time) (*domain.Message, bool, *utils.APIError) {
+	message, apiErr := s.repo.GetMessageByID(ctx, messageID, timestamp)
+	if apiErr != nil || message != nil || s.archive == nil || timestamp.IsZero() {
+		return message, false, apiErr
+	}
+
+	message, apiErr = s.archive.GetMessage(ctx, conversationID, messageID, timestamp)
+	return message, message != nil, apiErr
+}
+
+// getVisibleMessage returns message which is not deleted, if user can see it in its conversation
+func (s *MessageService) getVisibleMessage(ctx context.Context, userID, messageID int, timestamp time.Time) (*domain.Message, *domain.Conversation, bool, *utils.APIError) {
+	message, archived, apiErr := s.getMessage(ctx, 0, messageID, timestamp.UTC())
+	if apiErr != nil {
+		return nil, nil, false, apiErr
+	}
+	if message == nil || message.DeletedAt != nil {
+		return nil, nil, false, utils.NewAPIError(404, "Message not found", "")
+	}
+
+	conversation, apiErr := s.getConversation(ctx, userID, message.ConversationID)
+	if apiErr != nil {
+		return nil, nil, false, apiErr
+	}
+
+	// New group members don't see what was before them
+	if since := conversation.VisibleSince(userID); !since.IsZero() && message.Timestamp.Before(since) {
+		return nil, nil, false, utils.NewAPIError(404, "Message not found", "")
+	}
+
+	return message, conversation, archived, nil
+}
+
+// HideMessage deletes message only for user, others still see it. Works for any message which user sees
+func (s *MessageService) HideMessage(ctx context.Context, userID, conversationID, messageID int, timestamp time.Time) *utils.APIError {
+	conversation, apiErr := s.getConversation(ctx, userID, conversationID)
+	if apiErr != nil {
+		return apiErr
+	}
+
+	message, _, apiErr := s.getMessage(ctx, conversationID, messageID, timestamp.UTC())
+	if apiErr != nil {
+		return apiErr
+	}
+	if message == nil || message.ConversationID != conversationID {
+		return utils.NewAPIError(404, "Message not found", "")
+	}
+	if since := conversation.VisibleSince(userID); !since.IsZero() && message.Timestamp.Before(since) {
+		return utils.NewAPIError(404, "Message not found", "")
+	}
+
+	// Other devices of user hide it too
+	record := newSyncRecord([]int{userID}, &domain.RealtimeEvent{
+		Type:           domain.MessageHiddenEvent,
+		ConversationID: conversationID,
+		UserID:         userID,
+		MessageID:      messageID,
+	})
+	if apiErr := s.repo.HideMessage(ctx, userID, message, record); apiErr != nil {
+		return apiErr
+	}
+
+	s.events.Publish(ctx, record.UserIDs, record.Event)
+	return nil
+}
+
+// ExportUserMessages goes through all messages of user, it is used for personal data export
+func (s *MessageService) ExportUserMessages(ctx context.Context, userID int, fn func(*domain.Message) error) *utils.APIError {
+	// Archived messages are older than live ones, so they go first
+	if s.archive != nil {
+		groups, apiErr := s.conversations.GetUserGroups(ctx, userID)
+		if apiErr != nil {
+			return apiErr
+		}
+		if apiErr := s.archive.ForEachUserMessage(ctx, userID, groups, fn); apiErr != nil {
+			return apiErr
+		}
+	}
+	return s.repo.ForEachUserMessage(ctx, userID, fn)
 }
@@ -0,0 +1,166 @@
+package services
+
+import (
+	"context"
+	"message-service/internal/domain"
+	"message-service/internal/utils"
+	"testing"
+	"time"
+)
+
+// fakeMessageRepo keeps messages in memory, methods which tests don't need panic
+type fakeMessageRepo struct {
+	domain.MessageRepository
+	messages map[int]*domain.Message
+	// Status is changed by somebody else between read and write
+	conflict bool
+	sent     []*domain.Message
+}
+
+func (r *fakeMessageRepo) GetMessageByID(ctx context.Context, messageID int, timestamp time.Time) (*domain.Message, *utils.APIError) {
+	message, ok := r.messages[messageID]
+	if !ok {
+		return nil, nil
+	}
+	copied := *message
+	return &copied, nil
+}
+
+func (r *fakeMessageRepo) UpdateMessageStatus(ctx context.Context, messageID int, timestamp time.Time, from, to domain.MessageStatus, record *domain.SyncRecord) (bool, *utils.APIError) {
+	message := r.messages[messageID]
+	if r.conflict || message.Status != string(from) {
+		return false, nil
+	}
+	message.Status = string(to)
+	return true, nil
+}
+
+func (r *fakeMessageRepo) SendMessage(ctx context.Context, message *domain.Message, dailyLimit int, record *domain.SyncRecord) (*domain.Message, *utils.APIError) {
+	r.sent = append(r.sent, message)
+	return message, nil
+}
+
+func (r *fakeMessageRepo) SendChannelMessage(ctx context.Context, message *domain.Message, dailyLimit int) (*domain.Message, *utils.APIError) {
+	r.sent = append(r.sent, message)
+	return message, nil
+}
+
+// fakeConversationRepo keeps conversations in memory, methods which tests don't need panic
+type fakeConversationRepo struct {
+	domain.ConversationRepository
+	conversations map[int]*domain.Conversation
+	// Channel subscribers, they are not loaded with conversation
+	subscribers map[int][]domain.Participant
+}
+
+func (r *fakeConversationRepo) GetConversation(ctx context.Context, conversationID, userID int) (*domain.Conversation, *utils.APIError) {
+	conversation, ok := r.conversations[conversationID]
+	if !ok {
+		return nil, nil
+	}
+	copied := *conversation
+	copied.Participants = append([]domain.Participant(nil), conversation.Participants...)
+	return &copied, nil
+}
+
+func (r *fakeConversationRepo) GetParticipant(ctx context.Context, conversationID, userID int) (*domain.Participant, *utils.APIError) {
+	conversation, ok := r.conversations[conversationID]
+	if !ok {
+		return nil, nil
+	}
+	for _, participants := range [][]domain.Participant{conversation.Participants, r.subscribers[conversationID]} {
+		for _, participant := range participants {
+			if participant.UserID == userID {
+				return &participant, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// fakeEvents remembers published events
+type fakeEvents struct {
+	published []*domain.RealtimeEvent
+}
+
+func (e *fakeEvents) Publish(ctx context.Context, userIDs []int, event *domain.RealtimeEvent) {
+	e.published = append(e.published, event)
+}
+
+func (e *fakeEvents) PublishToConversation(ctx context.Context, conversation *domain.Conversation, event *domain.RealtimeEvent) {
+	e.published = append(e.published, event)
+}
+
+func (e *fakeEvents) ForgetMembers(ctx context.Context, conversationID int) {}
+
+func TestUpdateMessageStatus(t *testing.T) {
+	const (
+		sender    = 1
+		recipient = 2
+		member    = 3
+		stranger  = 9
+		// Direct message from sender to recipient and group message of sender
+		directID = 10
+		groupID  = 11
+	)
+
+	tests := []struct {
+		name     string
+		userID   int
+		message  int
+		status   domain.MessageStatus
+		current  domain.MessageStatus
+		conflict bool
+		// 0 - no error
+		wantCode   int
+		wantStatus domain.MessageStatus
+	}{
+		{name: "recipient got message", userID: recipient, message: directID, current: domain.Sent, status: domain.Delivered, wantStatus: domain.Delivered},
+		{name: "recipient read message", userID: recipient, message: directID, current: domain.Delivered, status: domain.Watched, wantStatus: domain.Watched},
+		{name: "read without delivery", userID: recipient, message: directID, current: domain.Sent, status: domain.Watched, wantStatus: domain.Watched},
+		{name: "same status again", userID: recipient, message: directID, current: domain.Watched, status: domain.Watched, wantStatus: domain.Watched},
+		{name: "status goes back", userID: recipient, message: directID, current: domain.Watched, status: domain.Delivered, wantCode: 409, wantStatus: domain.Watched},
+		{name: "changed at the same time", userID: recipient, message: directID, current: domain.Sent, status: domain.Delivered, conflict: true, wantCode: 409, wantStatus: domain.Sent},
+		{name: "sender", userID: sender, message: directID, current: domain.Sent, status: domain.Watched, wantCode: 403, wantStatus: domain.Sent},
+		{name: "stranger", userID: stranger, message: directID, current: domain.Sent, status: domain.Watched, wantCode: 404, wantStatus: domain.Sent},
+		{name: "no such message", userID: recipient, message: 99, status: domain.Watched, wantCode: 404},
+		{name: "group member", userID: member, message: groupID, current: domain.Sent, status: domain.Watched, wantCode: 400, wantStatus: domain.Sent},
+		{name: "group stranger", userID: stranger, message: groupID, current: domain.Sent, status: domain.Watched, wantCode: 404, wantStatus: domain.Sent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sent := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+			repo := &fakeMessageRepo{
+				messages: map[int]*domain.Message{
+					directID: {MessageID: directID, ConversationID: 1, SenderID: sender, RecipientID: recipient, Status: string(tt.current), Timestamp: sent},
+					groupID:  {MessageID: groupID, ConversationID: 2, SenderID: sender, Status: string(tt.current), Timestamp: sent},
+				},
+				conflict: tt.conflict,
+			}
+			conversations := &fakeConversationRepo{conversations: map[int]*domain.Conversation{
+				2: {ID: 2, Type: domain.GroupConversation, Participants: []domain.Participant{{UserID: sender}, {UserID: member}}},
+			}}
+			events := &fakeEvents{}
+			service := NewMessageService(repo, conversations, nil, events, 0, 0, 0)
+
+			apiErr := service.UpdateMessageStatus(context.Background(), tt.userID, tt.message, time.Time{}, tt.status)
+			code := 0
+			if apiErr != nil {
+				code = apiErr.Code
+			}
+			if code != tt.wantCode {
+				t.Fatalf("got code %d, want %d", code, tt.wantCode)
+			}
+
+			if message, ok := repo.messages[tt.message]; ok && domain.MessageStatus(message.Status) != tt.wantStatus {
+				t.Fatalf("status is %s, want %s", message.Status, tt.wantStatus)
+			}
+			// Event goes out only when status was really changed
+			changed := tt.wantCode == 0 && tt.current != tt.status
+			if (len(events.published) == 1) != changed {
+				t.Fatalf("published %d events", len(events.published))
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package services
+
+import (
+	"context"
+	"message-service/internal/domain"
+	"message-service/internal/utils"
+	"time"
+
+	logger "message-service/internal"
+
+	"go.uber.org/zap"
+)
+
+// PartitionManager creates range partitions of messages table in advance and removes old ones.
+// All instances run it, but only one which holds the lock does the work
+type PartitionManager struct {
+	repo   domain.PartitionRepository
+	scheme domain.PartitionScheme
+	// Partitions are created to cover this many days ahead
+	precreateDays int
+	// Partitions which ended more than this many days ago are removed, 0 keeps them forever
+	retentionDays int
+	action        domain.RetentionAction
+	// Used only with archive action
+	archiver *Archiver
+	interval time.Duration
+}
+
+func NewPartitionManager(repo domain.PartitionRepository, scheme domain.PartitionScheme, precreateDays, retentionDays int, action domain.RetentionAction, archiver *Archiver, interval time.Duration) *PartitionManager {
+	return &PartitionManager{
+		repo:          repo,
+		scheme:        scheme,
+		precreateDays: precreateDays,
+		retentionDays: retentionDays,
+		action:        action,
+		archiver:      archiver,
+		interval:      interval,
+	}
+}
+
+// Run blocks until context is cancelled, so start it in goroutine
+func (m *PartitionManager) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		m.managePartitions(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *PartitionManager) managePartitions(ctx context.Context) {
+	locked, apiErr := m.repo.TryLock(ctx)
+	if apiErr != nil || !locked {
+		return
+	}
+	defer m.repo.Unlock(ctx)
+
+	// Safety net: if some day has no partition, messages go here instead of failing
+	if apiErr := m.repo.CreateDefaultPartition(ctx); apiErr != nil {
+		return
+	}
+
+	partitions, apiErr := m.repo.ListPartitions(ctx)
+	if apiErr != nil {
+		return
+	}
+
+	// Partitions are in UTC, as well as timestamps of messages
+	now := time.Now().UTC()
+	until := now.AddDate(0, 0, m.precreateDays)
+
+	for start := m.scheme.Granularity.PeriodStart(now); start.Before(until); start = m.scheme.Granularity.NextPeriod(start) {
+		created, apiErr := m.createPeriod(ctx, partitions, start)
+		if apiErr != nil {
+			return
+		}
+		partitions = append(partitions, created...)
+	}
+
+	if m.retentionDays <= 0 {
+		return
+	}
+
+	oldest := domain.Daily.PeriodStart(now).AddDate(0, 0, -m.retentionDays)
+	for _, partition := range partitions {
+		if partition.End.After(oldest) {
+			continue
+		}
+
+		switch m.action {
+		case domain.RetentionDrop:
+			apiErr = m.repo.DropPartition(ctx, &partition)
+		default:
+			apiErr = m.repo.DetachPartition(ctx, &partition)
+		}
+
+		if apiErr != nil {
+			logger.Error("Cannot remove old partition, will retry later",
+				zap.String("Partition", partition.Name),
+				zap.String("error", apiErr.Message))
+			return
+		}
+	}
+
+	// Also picks up partitions which failed to archive last time
+	if m.action == domain.RetentionArchive && m.archiver != nil {
+		m.archiver.ArchivePending(ctx)
+	}
+}
+
+// createPeriod makes partition for period which starts at start. If granularity was changed and old partitions
+// cover part of the period, the rest is covered with daily partitions until table is re-partitioned
+func (m *PartitionManager) createPeriod(ctx context.Context, partitions []domain.Partition, start time.Time) ([]domain.Partition, *utils.APIError) {
+	partition := m.scheme.Partition(start)
+	if !overlapsAny(partitions, partition.Start, partition.End) {
+		if apiErr := m.repo.CreatePartition(ctx, &partition); apiErr != nil {
+			return nil, apiErr
+		}
+		return []domain.Partition{partition}, nil
+	}
+
+	daily := domain.PartitionScheme{Granularity: domain.Daily, HashBuckets: m.scheme.HashBuckets}
+
+	var created []domain.Partition
+	for day := start; day.Before(partition.End); day = day.AddDate(0, 0, 1) {
+		dayPartition := daily.Partition(day)
+		if overlapsAny(partitions, dayPartition.Start, dayPartition.End) {
+			continue
+		}
+		if apiErr := m.repo.CreatePartition(ctx, &dayPartition); apiErr != nil {
+			return created, apiErr
+		}
+		created = append(created, dayPartition)
+	}
+
+	return created, nil
+}
+
+func overlapsAny(partitions []domain.Partition, start, end time.Time) bool {
+	for _, partition := range partitions {
+		if partition.Overlaps(start, end) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,89 @@
+package services
+
+import (
+	"context"
+	"message-service/internal/domain"
+	"message-service/internal/utils"
+	"time"
+)
+
+const (
+	// Session which didn't send heartbeat for that long is offline. Heartbeats come every ping period of connection
+	presenceTTL = 2 * time.Minute
+	// Closed session stays online a bit, so long poll between requests and quick reconnect don't blink
+	presenceGrace = 30 * time.Second
+	// How many users can be asked at once
+	maxPresenceUsers = 100
+)
+
+// PresenceService tracks who is online by heartbeats of real-time connections
+type PresenceService struct {
+	repo domain.PresenceRepository
+	// Only people who talk with user see his presence
+	conversations domain.ConversationRepository
+}
+
+func NewPresenceService(repo domain.PresenceRepository, conversations domain.ConversationRepository) *PresenceService {
+	return &PresenceService{repo: repo, conversations: conversations}
+}
+
+// Heartbeat keeps connection online, call it when connection opens and when client answers
+func (s *PresenceService) Heartbeat(ctx context.Context, connection *Connection) {
+	// Errors are logged by repository, client stays connected anyway
+	s.repo.SetSession(ctx, connection.UserID, connection.ID, time.Now().Add(presenceTTL))
+}
+
+// Leave is called when connection is closed
+func (s *PresenceService) Leave(ctx context.Context, connection *Connection) {
+	s.repo.SetSession(ctx, connection.UserID, connection.ID, time.Now().Add(presenceGrace))
+}
+
+// GetPresence says who of users is online. Strangers, who have no direct conversation or group with viewer,
+// and users who chose to hide last seen look offline and never seen
+func (s *PresenceService) GetPresence(ctx context.Context, viewerID int, userIDs []int) ([]domain.Presence, *utils.APIError) {
+	if len(userIDs) == 0 {
+		return nil, utils.NewAPIError(400, "Invalid input data", "user_ids are required")
+	}
+	if len(userIDs) > maxPresenceUsers {
+		return nil, utils.NewAPIError(400, "Too many users", "Ask at most 100 users at once")
+	}
+
+	presences, apiErr := s.repo.GetPresence(ctx, userIDs)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+
+	peers, apiErr := s.conversations.GetConversationPeers(ctx, viewerID, userIDs)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+
+	for i := range presences {
+		if presences[i].UserID == viewerID {
+			continue
+		}
+		if !peers[presences[i].UserID] || presences[i].Visibility == domain.LastSeenNobody {
+			presences[i].Online = false
+			presences[i].LastSeen = nil
+		}
+	}
+
+	return presences, nil
+}
+
+func (s *PresenceService) GetSettings(ctx context.Context, userID int) (*domain.PresenceSettings, *utils.APIError) {
+	visibility, apiErr := s.repo.GetLastSeenVisibility(ctx, userID)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+
+	return &domain.PresenceSettings{LastSeen: visibility}, nil
+}
+
+func (s *PresenceService) UpdateSettings(ctx context.Context, userID int, settings *domain.PresenceSettings) *utils.APIError {
+	if !settings.LastSeen.IsValid() {
+		return utils.NewAPIError(400, "Invalid input data", "last_seen must be everybody or nobody")
+	}
+
+	return s.repo.SetLastSeenVisibility(ctx, userID, settings.LastSeen)
+}
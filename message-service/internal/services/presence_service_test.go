@@ -0,0 +1,101 @@
+package services
+
+import (
+	"context"
+	"message-service/internal/domain"
+	"message-service/internal/utils"
+	"slices"
+	"testing"
+	"time"
+)
+
+// fakePresenceRepo keeps presence of users in memory, methods which tests don't need panic
+type fakePresenceRepo struct {
+	domain.PresenceRepository
+	presences map[int]domain.Presence
+}
+
+func (r *fakePresenceRepo) GetPresence(ctx context.Context, userIDs []int) ([]domain.Presence, *utils.APIError) {
+	presences := make([]domain.Presence, 0, len(userIDs))
+	for _, userID := range userIDs {
+		presence, ok := r.presences[userID]
+		if !ok {
+			presence = domain.Presence{UserID: userID, Visibility: domain.LastSeenEverybody}
+		}
+		presences = append(presences, presence)
+	}
+	return presences, nil
+}
+
+// GetConversationPeers finds members of direct conversations and groups of user, like repository does
+func (r *fakeConversationRepo) GetConversationPeers(ctx context.Context, userID int, userIDs []int) (map[int]bool, *utils.APIError) {
+	peers := make(map[int]bool)
+	for _, conversation := range r.conversations {
+		if conversation.Type == domain.ChannelConversation || !conversation.HasParticipant(userID) {
+			continue
+		}
+		for _, participant := range conversation.Participants {
+			if slices.Contains(userIDs, participant.UserID) {
+				peers[participant.UserID] = true
+			}
+		}
+	}
+	return peers, nil
+}
+
+func TestGetPresence(t *testing.T) {
+	const (
+		viewer = 1
+		peer   = 2
+		// In group with viewer
+		member = 3
+		// Subscribes the same channel as viewer
+		subscriber = 4
+		stranger   = 5
+	)
+
+	seen := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		userID     int
+		visibility domain.LastSeenVisibility
+		wantOnline bool
+		wantSeen   bool
+	}{
+		{name: "peer", userID: peer, visibility: domain.LastSeenEverybody, wantOnline: true, wantSeen: true},
+		{name: "member of group", userID: member, visibility: domain.LastSeenEverybody, wantOnline: true, wantSeen: true},
+		{name: "peer hides last seen", userID: peer, visibility: domain.LastSeenNobody},
+		{name: "subscriber of the same channel", userID: subscriber, visibility: domain.LastSeenEverybody},
+		{name: "stranger", userID: stranger, visibility: domain.LastSeenEverybody},
+		{name: "self", userID: viewer, visibility: domain.LastSeenEverybody, wantOnline: true, wantSeen: true},
+		{name: "self hiding last seen", userID: viewer, visibility: domain.LastSeenNobody, wantOnline: true, wantSeen: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			presence := &fakePresenceRepo{presences: map[int]domain.Presence{
+				tt.userID: {UserID: tt.userID, Online: true, LastSeen: &seen, Visibility: tt.visibility},
+			}}
+			conversations := &fakeConversationRepo{conversations: map[int]*domain.Conversation{
+				1: {ID: 1, Type: domain.DirectConversation, Participants: []domain.Participant{{UserID: viewer}, {UserID: peer}}},
+				2: {ID: 2, Type: domain.GroupConversation, Participants: []domain.Participant{{UserID: viewer}, {UserID: member}}},
+				3: {ID: 3, Type: domain.ChannelConversation, Participants: []domain.Participant{{UserID: viewer}, {UserID: subscriber}}},
+			}}
+			service := NewPresenceService(presence, conversations)
+
+			presences, apiErr := service.GetPresence(context.Background(), viewer, []int{tt.userID})
+			if apiErr != nil {
+				t.Fatalf("cannot get presence: %v", apiErr.Message)
+			}
+			if len(presences) != 1 {
+				t.Fatalf("got %d presences, want 1", len(presences))
+			}
+
+			got := presences[0]
+			if got.Online != tt.wantOnline || (got.LastSeen != nil) != tt.wantSeen {
+				t.Errorf("online %v, last seen %v; want online %v, seen %v", got.Online, got.LastSeen, tt.wantOnline, tt.wantSeen)
+			}
+		})
+	}
+}
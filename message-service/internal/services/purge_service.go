@@ -0,0 +1,62 @@
+package services
+
+import (
+	"context"
+	"message-service/internal/domain"
+	"time"
+
+	logger "message-service/internal"
+
+	"go.uber.org/zap"
+)
+
+// PurgeService clears tombstones of deleted messages after retention. They stay in conversations as placeholders,
+// but who deleted them and what was hidden is forgotten
+type PurgeService struct {
+	repo domain.MessageRepository
+	// Deleted archived messages are purged from archive files
+	archive *ArchiveService
+	// Tombstones older than that are cleared, 0 keeps them whole forever
+	retention time.Duration
+	interval  time.Duration
+}
+
+func NewPurgeService(repo domain.MessageRepository, archive *ArchiveService, retention, interval time.Duration) *PurgeService {
+	return &PurgeService{repo: repo, archive: archive, retention: retention, interval: interval}
+}
+
+// Run purges deleted messages. It blocks until context is cancelled, so start it in goroutine
+func (s *PurgeService) Run(ctx context.Context) {
+	if s.retention <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		// Timestamps are stored without time zone, in UTC
+		before := time.Now().UTC().Add(-s.retention)
+		purged, apiErr := s.repo.PurgeDeletedMessages(ctx, before)
+		if apiErr == nil && purged > 0 {
+			logger.Info("Purged deleted messages", zap.Int64("Purged", purged))
+		}
+
+		if s.archive != nil {
+			purged, apiErr := s.archive.PurgeDeletedMessages(ctx, before)
+			if apiErr != nil {
+				logger.Error("Cannot purge deleted archived messages, will retry later",
+					zap.String("error", apiErr.Message),
+					zap.String("details", apiErr.Details))
+			} else if purged > 0 {
+				logger.Info("Purged deleted archived messages", zap.Int64("Purged", purged))
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
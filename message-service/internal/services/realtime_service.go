@@ -0,0 +1,604 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"message-service/internal/domain"
+	"message-service/internal/utils"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	logger "message-service/internal"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// Events of user which this instance keeps, so quick reconnect doesn't go to Redis
+	realtimeBacklogSize = 256
+	// Events waiting to be written to one connection. When it is full, connection is dropped
+	connectionBufferSize = 64
+	// Instance stops watching user who has no connections for that long
+	realtimeResumeWindow = 2 * time.Minute
+	// How many stored events are read at once
+	realtimeReadBatch = 100
+	// Typing is sent to others at most so often, client repeats it while user types
+	typingThrottle = 3 * time.Second
+	// Others stop showing typing if it wasn't repeated
+	typingTTL = 6 * time.Second
+	// Members are cached for typing, changes drop them earlier
+	typingMembersTTL = time.Minute
+	// Position of user who has no events yet
+	emptyEventID = "0-0"
+)
+
+// Connection is one connected client. It reads events from Events until the channel is closed,
+// hub closes it when client is too slow to read them
+type Connection struct {
+	// Random, presence counts sessions by it
+	ID     string
+	UserID int
+	Events chan domain.RealtimeEvent
+	// ID of the last event before connection, client which got nothing resumes from it
+	StartID string
+}
+
+// realtimeUser is state of user who is online on this instance or was online recently
+type realtimeUser struct {
+	connections map[*Connection]struct{}
+	backlog     []domain.RealtimeEvent
+	// ID of the last stored event which was given to connections
+	lastID string
+	// When the last connection was closed
+	leftAt time.Time
+	// Closed when instance watches user and lastID is read, connections wait for it outside s.mu
+	ready chan struct{}
+	// Set before ready is closed if watching failed, user is removed then
+	err *utils.APIError
+	// Catch up of user is running, and notification came while it was reading
+	catchingUp   bool
+	catchUpAgain bool
+}
+
+// isReady tells whether user gets events yet, s.mu must be held
+func (user *realtimeUser) isReady() bool {
+	select {
+	case <-user.ready:
+		return user.err == nil
+	default:
+		return false
+	}
+}
+
+// RealtimeService pushes events to connected clients. Events go through Redis, so client gets them
+// whatever instance he is connected to, and can resume on another instance
+type RealtimeService struct {
+	conversations domain.ConversationRepository
+	events        domain.RealtimeEventRepository
+	// Typing state, so it is not sent on every key press
+	presence domain.PresenceRepository
+
+	mu    sync.Mutex
+	users map[int]*realtimeUser
+	// Users who were forgotten and are being unwatched. Channel is closed when it is done,
+	// new watch of the user waits for it, otherwise late Unwatch would cancel it
+	unwatching map[int]chan struct{}
+}
+
+func NewRealtimeService(
+	conversations domain.ConversationRepository,
+	events domain.RealtimeEventRepository,
+	presence domain.PresenceRepository,
+) *RealtimeService {
+	return &RealtimeService{
+		conversations: conversations,
+		events:        events,
+		presence:      presence,
+		users:         make(map[int]*realtimeUser),
+		unwatching:    make(map[int]chan struct{}),
+	}
+}
+
+// Run delivers events of users who are connected here and forgets users who went offline.
+// It blocks until context is cancelled, so start it in goroutine
+func (s *RealtimeService) Run(ctx context.Context) {
+	go s.events.Listen(ctx, func(userID int, event *domain.RealtimeEvent) {
+		if event != nil {
+			s.mu.Lock()
+			s.deliver(userID, *event)
+			s.mu.Unlock()
+			return
+		}
+		s.startCatchUp(ctx, userID)
+	})
+
+	ticker := time.NewTicker(realtimeResumeWindow / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			var forgotten []int
+			s.mu.Lock()
+			for userID, user := range s.users {
+				if user.isReady() && len(user.connections) == 0 && now.Sub(user.leftAt) > realtimeResumeWindow {
+					s.forget(userID)
+					forgotten = append(forgotten, userID)
+				}
+			}
+			s.mu.Unlock()
+
+			for _, userID := range forgotten {
+				s.unwatch(ctx, userID)
+			}
+		}
+	}
+}
+
+// Connect registers new connection of user. It returns events which client missed after lastEventID,
+// or resync event if they are gone. Empty lastEventID means fresh client, it gets only new events.
+// Redis is called outside s.mu, so connecting users don't wait for each other
+func (s *RealtimeService) Connect(ctx context.Context, userID int, lastEventID string) (*Connection, []domain.RealtimeEvent, *utils.APIError) {
+	randomBytes := make([]byte, 8)
+	if _, err := rand.Read(randomBytes); err != nil {
+		logger.Error("Cannot generate connection ID",
+			zap.Error(err))
+		return nil, nil, utils.NewAPIError(500, "Internal server error", "Please try again")
+	}
+
+	s.mu.Lock()
+	user := s.users[userID]
+	if user == nil {
+		user = &realtimeUser{connections: make(map[*Connection]struct{}), ready: make(chan struct{})}
+		s.users[userID] = user
+		s.mu.Unlock()
+		// Other connections of user don't depend on this request, so it is not cancelled with it
+		s.watch(context.WithoutCancel(ctx), userID, user)
+	} else {
+		s.mu.Unlock()
+	}
+
+	select {
+	case <-user.ready:
+	case <-ctx.Done():
+		return nil, nil, utils.NewAPIError(503, "Connection cancelled", ctx.Err().Error())
+	}
+	if user.err != nil {
+		return nil, nil, user.err
+	}
+
+	s.mu.Lock()
+	// User could be forgotten while we waited, then it is watched again
+	if s.users[userID] != user {
+		s.mu.Unlock()
+		return s.Connect(ctx, userID, lastEventID)
+	}
+	connection := &Connection{
+		ID:      hex.EncodeToString(randomBytes),
+		UserID:  userID,
+		Events:  make(chan domain.RealtimeEvent, connectionBufferSize),
+		StartID: user.lastID,
+	}
+	// Events after StartID go to Events from now on, so missed ones are read only up to it
+	user.connections[connection] = struct{}{}
+	missed, found := backlogAfter(user.backlog, lastEventID)
+	s.mu.Unlock()
+
+	if found {
+		return connection, missed, nil
+	}
+
+	missed, apiErr := s.missedEvents(ctx, userID, lastEventID, connection.StartID)
+	if apiErr != nil {
+		s.Disconnect(connection)
+		return nil, nil, apiErr
+	}
+
+	return connection, missed, nil
+}
+
+// watch subscribes instance to events of user and reads where they start, then marks user ready
+func (s *RealtimeService) watch(ctx context.Context, userID int, user *realtimeUser) {
+	s.mu.Lock()
+	unwatching := s.unwatching[userID]
+	s.mu.Unlock()
+	if unwatching != nil {
+		<-unwatching
+	}
+
+	// Watched first, so event stored after lastID is read always comes with notification
+	apiErr := s.events.Watch(ctx, userID)
+	var lastID string
+	if apiErr == nil {
+		lastID, apiErr = s.events.GetLastEventID(ctx, userID)
+	}
+
+	s.mu.Lock()
+	if apiErr != nil {
+		s.forget(userID)
+		user.err = apiErr
+	} else {
+		user.lastID = lastID
+	}
+	close(user.ready)
+	s.mu.Unlock()
+
+	if apiErr != nil {
+		s.unwatch(ctx, userID)
+	}
+}
+
+// forget removes user, s.mu must be held. Then unwatch must be called outside s.mu
+func (s *RealtimeService) forget(userID int) {
+	delete(s.users, userID)
+	s.unwatching[userID] = make(chan struct{})
+}
+
+// unwatch stops notifications about forgotten user and lets new watch of him go on
+func (s *RealtimeService) unwatch(ctx context.Context, userID int) {
+	// Error is logged by repository, notifications of user are just ignored then
+	s.events.Unwatch(ctx, userID)
+
+	s.mu.Lock()
+	close(s.unwatching[userID])
+	delete(s.unwatching, userID)
+	s.mu.Unlock()
+}
+
+// Disconnect must be called when client is gone, also after hub dropped connection
+func (s *RealtimeService) Disconnect(connection *Connection) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user := s.users[connection.UserID]
+	if user == nil {
+		return
+	}
+
+	delete(user.connections, connection)
+	if len(user.connections) == 0 {
+		user.leftAt = time.Now()
+	}
+}
+
+// Publish stores event for every user and notifies instances where they are connected.
+// Delivery is at least once, clients skip events with IDs which they have seen.
+// Sync log got event with the action already, see newSyncRecord
+func (s *RealtimeService) Publish(ctx context.Context, userIDs []int, event *domain.RealtimeEvent) {
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now().UTC()
+	}
+
+	// Errors are logged by repository, action which caused event is done anyway
+	s.deleteMessageEvents(ctx, userIDs, event)
+	s.events.Publish(ctx, userIDs, event)
+}
+
+func (s *RealtimeService) PublishToConversation(ctx context.Context, conversation *domain.Conversation, event *domain.RealtimeEvent) {
+	if conversation.Type != domain.ChannelConversation {
+		var userIDs []int
+		for _, participant := range conversation.Participants {
+			userIDs = append(userIDs, participant.UserID)
+		}
+		s.Publish(ctx, userIDs, event)
+		return
+	}
+
+	// Channel doesn't load its subscribers
+	userIDs, apiErr := s.conversations.GetParticipantIDs(ctx, conversation.ID)
+	if apiErr != nil {
+		logger.Error("Cannot publish event to channel",
+			zap.Int("Conversation ID", conversation.ID),
+			zap.String("Type", string(event.Type)),
+			zap.String("Error", apiErr.Message))
+		return
+	}
+
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now().UTC()
+	}
+	s.deleteMessageEvents(ctx, userIDs, event)
+	s.events.Publish(ctx, userIDs, event)
+}
+
+// deleteMessageEvents removes stored events with content of message which is deleted now,
+// so nobody reads it after resume. Sync log is cleaned by repository with the message
+func (s *RealtimeService) deleteMessageEvents(ctx context.Context, userIDs []int, event *domain.RealtimeEvent) {
+	if event.Type == domain.MessageDeletedEvent {
+		s.events.DeleteMessageEvents(ctx, userIDs, event.MessageID)
+	}
+}
+
+// newSyncRecord makes record of event for users, repository saves it to sync log with the action
+func newSyncRecord(userIDs []int, event *domain.RealtimeEvent) *domain.SyncRecord {
+	// Sync log and realtime get the same time
+	event.CreatedAt = time.Now().UTC()
+	return &domain.SyncRecord{UserIDs: userIDs, Event: event}
+}
+
+// conversationSyncRecord makes record of event for all members of conversation. Events of channel
+// don't go to sync log, it would be a row per subscriber, so it is nil for them.
+// Clients which were offline get them from inbox and messages of channel
+func conversationSyncRecord(conversation *domain.Conversation, event *domain.RealtimeEvent) *domain.SyncRecord {
+	if conversation.Type == domain.ChannelConversation {
+		return nil
+	}
+
+	userIDs := make([]int, 0, len(conversation.Participants))
+	for _, participant := range conversation.Participants {
+		userIDs = append(userIDs, participant.UserID)
+	}
+	return newSyncRecord(userIDs, event)
+}
+
+// publishSynced sends event after the action which saved its record, events without record go to everybody in conversation
+func publishSynced(ctx context.Context, events domain.EventPublisher, conversation *domain.Conversation, record *domain.SyncRecord, event *domain.RealtimeEvent) {
+	if record != nil {
+		events.Publish(ctx, record.UserIDs, record.Event)
+		return
+	}
+	events.PublishToConversation(ctx, conversation, event)
+}
+
+// ForgetMembers drops cached members of conversation, so typing reads new ones
+func (s *RealtimeService) ForgetMembers(ctx context.Context, conversationID int) {
+	// Error is logged by repository, cache expires anyway
+	s.presence.ForgetTypingMembers(ctx, conversationID)
+}
+
+// SendTyping tells other members of conversation that user is typing. Others show it until expires_at,
+// repeated calls within typingThrottle are skipped
+func (s *RealtimeService) SendTyping(ctx context.Context, userID, conversationID int) *utils.APIError {
+	members, apiErr := s.typingMembers(ctx, userID, conversationID)
+	if apiErr != nil {
+		return apiErr
+	}
+
+	var others []int
+	member := false
+	for _, memberID := range members {
+		if memberID == userID {
+			member = true
+		} else {
+			others = append(others, memberID)
+		}
+	}
+	if !member {
+		return utils.NewAPIError(404, "Conversation not found", "")
+	}
+
+	// Only members start typing, strangers never touch Redis keys of conversation
+	started, apiErr := s.presence.StartTyping(ctx, conversationID, userID, typingThrottle)
+	if apiErr != nil {
+		return apiErr
+	}
+	if !started {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	expiresAt := now.Add(typingTTL)
+	s.Publish(ctx, others, &domain.RealtimeEvent{
+		Type:           domain.TypingEvent,
+		ConversationID: conversationID,
+		UserID:         userID,
+		CreatedAt:      now,
+		ExpiresAt:      &expiresAt,
+		Ephemeral:      true,
+	})
+	return nil
+}
+
+// typingMembers returns members of conversation. They are cached in Redis, so key presses don't go to Postgres
+func (s *RealtimeService) typingMembers(ctx context.Context, userID, conversationID int) ([]int, *utils.APIError) {
+	members, found, apiErr := s.presence.GetTypingMembers(ctx, conversationID)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	if found {
+		return members, nil
+	}
+
+	conversation, apiErr := s.conversations.GetConversation(ctx, conversationID, userID)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+
+	if conversation == nil || !conversation.HasParticipant(userID) {
+		return nil, utils.NewAPIError(404, "Conversation not found", "")
+	}
+	if conversation.Type == domain.ChannelConversation {
+		return nil, utils.NewAPIError(400, "Nobody types in channel", "")
+	}
+
+	members = make([]int, 0, len(conversation.Participants))
+	for _, participant := range conversation.Participants {
+		members = append(members, participant.UserID)
+	}
+
+	// Typing works without cache, it is only slower
+	s.presence.SetTypingMembers(ctx, conversationID, members, typingMembersTTL)
+	return members, nil
+}
+
+// startCatchUp reads stored events of user in own goroutine, so slow read doesn't hold notifications of others.
+// User has only one catch up at a time, which keeps events in order. Notification which comes meanwhile
+// makes it read once more
+func (s *RealtimeService) startCatchUp(ctx context.Context, userID int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user := s.users[userID]
+	if user == nil || !user.isReady() {
+		return
+	}
+	if user.catchingUp {
+		user.catchUpAgain = true
+		return
+	}
+
+	user.catchingUp = true
+	go s.catchUp(ctx, userID, user)
+}
+
+// catchUp reads stored events of user which this instance didn't deliver yet
+func (s *RealtimeService) catchUp(ctx context.Context, userID int, user *realtimeUser) {
+	for {
+		s.mu.Lock()
+		// User was forgotten, new one has own catch up
+		if s.users[userID] != user {
+			s.mu.Unlock()
+			return
+		}
+		user.catchUpAgain = false
+		lastID := user.lastID
+		s.mu.Unlock()
+
+		events, apiErr := s.events.GetEventsAfter(ctx, userID, lastID, realtimeReadBatch)
+
+		s.mu.Lock()
+		for _, event := range events {
+			s.deliver(userID, event)
+		}
+		// Error is logged by repository, the next notification tries again
+		if apiErr != nil || (len(events) < realtimeReadBatch && !user.catchUpAgain) {
+			user.catchingUp = false
+			s.mu.Unlock()
+			return
+		}
+		s.mu.Unlock()
+	}
+}
+
+// deliver gives event to all connections of user, s.mu must be held
+func (s *RealtimeService) deliver(userID int, event domain.RealtimeEvent) {
+	// Users who are not connected here are delivered by other instances.
+	// Events which come before user is ready are older than its lastID
+	user := s.users[userID]
+	if user == nil || !user.isReady() {
+		return
+	}
+
+	// Backlog of instance must not keep content of deleted message either
+	if event.Type == domain.MessageDeletedEvent {
+		for i := range user.backlog {
+			if message := user.backlog[i].Message; message != nil && message.MessageID == event.MessageID {
+				redacted := *message
+				redacted.Content = ""
+				redacted.DeletedAt = &event.CreatedAt
+				user.backlog[i].Message = &redacted
+			}
+		}
+	}
+
+	// Ephemeral events have no ID, client can't resume from them
+	if event.ID != "" {
+		// The same event can be read twice, e.g. by catch up after reconnect
+		if !eventIDAfter(event.ID, user.lastID) {
+			return
+		}
+		user.lastID = event.ID
+		user.backlog = append(user.backlog, event)
+		if len(user.backlog) > realtimeBacklogSize {
+			user.backlog = user.backlog[len(user.backlog)-realtimeBacklogSize:]
+		}
+	}
+
+	for connection := range user.connections {
+		select {
+		case connection.Events <- event:
+		default:
+			// Client doesn't read, we won't wait for it. It resumes after reconnect
+			logger.Warn("Dropping slow realtime connection", zap.Int("User ID", userID))
+			delete(user.connections, connection)
+			close(connection.Events)
+			if len(user.connections) == 0 {
+				user.leftAt = time.Now()
+			}
+		}
+	}
+}
+
+// backlogAfter returns events of backlog after lastEventID, s.mu must be held.
+// Empty lastEventID means client wants nothing missed
+func backlogAfter(backlog []domain.RealtimeEvent, lastEventID string) ([]domain.RealtimeEvent, bool) {
+	if lastEventID == "" {
+		return nil, true
+	}
+
+	for i := range backlog {
+		if backlog[i].ID == lastEventID {
+			return append([]domain.RealtimeEvent(nil), backlog[i+1:]...), true
+		}
+	}
+
+	return nil, false
+}
+
+// missedEvents reads stored events after lastEventID up to toID, which connection got as StartID
+func (s *RealtimeService) missedEvents(ctx context.Context, userID int, lastEventID, toID string) ([]domain.RealtimeEvent, *utils.APIError) {
+	// Client has everything which was delivered
+	if lastEventID == toID {
+		return nil, nil
+	}
+
+	// Client was connected to another instance or long ago. ID which we didn't give out yet is wrong too
+	resync := []domain.RealtimeEvent{{Type: domain.ResyncEvent, CreatedAt: time.Now().UTC()}}
+	if _, _, ok := parseEventID(lastEventID); !ok || eventIDAfter(lastEventID, toID) {
+		return resync, nil
+	}
+
+	events, apiErr := s.events.GetEvents(ctx, userID, lastEventID, toID)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+
+	// Client connected when user had no events, all of them are new to it
+	if lastEventID == emptyEventID {
+		return events, nil
+	}
+
+	// lastEventID is trimmed from stream, something after it is lost too
+	if len(events) == 0 || events[0].ID != lastEventID {
+		return resync, nil
+	}
+
+	return events[1:], nil
+}
+
+// eventIDAfter compares IDs of Redis stream: "<milliseconds>-<sequence>". Broken ID is never after
+func eventIDAfter(id, other string) bool {
+	ms, seq, ok := parseEventID(id)
+	if !ok {
+		return false
+	}
+	otherMs, otherSeq, ok := parseEventID(other)
+	if !ok {
+		return true
+	}
+
+	return ms > otherMs || (ms == otherMs && seq > otherSeq)
+}
+
+func parseEventID(id string) (uint64, uint64, bool) {
+	msPart, seqPart, found := strings.Cut(id, "-")
+	if !found {
+		return 0, 0, false
+	}
+
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	seq, err := strconv.ParseUint(seqPart, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+
+	return ms, seq, true
+}
@@ -0,0 +1,264 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"message-service/internal/domain"
+	"message-service/internal/utils"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRealtimeEvents keeps stored events of users in memory, their IDs are "1-<number>".
+// Methods which tests don't need panic
+type fakeRealtimeEvents struct {
+	domain.RealtimeEventRepository
+
+	mu     sync.Mutex
+	events map[int][]domain.RealtimeEvent
+	// Read of user takes events and then waits until channel is closed
+	gates     map[int]chan struct{}
+	watchErr  *utils.APIError
+	unwatched []int
+}
+
+func newFakeRealtimeEvents() *fakeRealtimeEvents {
+	return &fakeRealtimeEvents{events: make(map[int][]domain.RealtimeEvent), gates: make(map[int]chan struct{})}
+}
+
+// store appends events of user with numbers from first to last
+func (r *fakeRealtimeEvents) store(userID, first, last int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for number := first; number <= last; number++ {
+		r.events[userID] = append(r.events[userID], domain.RealtimeEvent{ID: fmt.Sprintf("1-%d", number), Type: domain.MessageCreatedEvent})
+	}
+}
+
+func (r *fakeRealtimeEvents) Watch(ctx context.Context, userID int) *utils.APIError {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.watchErr
+}
+
+func (r *fakeRealtimeEvents) Unwatch(ctx context.Context, userID int) *utils.APIError {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.unwatched = append(r.unwatched, userID)
+	return nil
+}
+
+func (r *fakeRealtimeEvents) GetLastEventID(ctx context.Context, userID int) (string, *utils.APIError) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	events := r.events[userID]
+	if len(events) == 0 {
+		return emptyEventID, nil
+	}
+	return events[len(events)-1].ID, nil
+}
+
+func (r *fakeRealtimeEvents) GetEvents(ctx context.Context, userID int, fromID, toID string) ([]domain.RealtimeEvent, *utils.APIError) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var events []domain.RealtimeEvent
+	for _, event := range r.events[userID] {
+		if !eventIDAfter(fromID, event.ID) && !eventIDAfter(event.ID, toID) {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+func (r *fakeRealtimeEvents) GetEventsAfter(ctx context.Context, userID int, afterID string, limit int) ([]domain.RealtimeEvent, *utils.APIError) {
+	r.mu.Lock()
+	var events []domain.RealtimeEvent
+	for _, event := range r.events[userID] {
+		if eventIDAfter(event.ID, afterID) && len(events) < limit {
+			events = append(events, event)
+		}
+	}
+	gate := r.gates[userID]
+	r.mu.Unlock()
+
+	if gate != nil {
+		<-gate
+	}
+	return events, nil
+}
+
+// eventIDs gives IDs of events, resync event is "resync"
+func eventIDs(events []domain.RealtimeEvent) []string {
+	ids := []string{}
+	for _, event := range events {
+		if event.Type == domain.ResyncEvent {
+			ids = append(ids, "resync")
+		} else {
+			ids = append(ids, event.ID)
+		}
+	}
+	return ids
+}
+
+// receive reads count events of connection, test fails if they don't come in time
+func receive(t *testing.T, connection *Connection, count int) []string {
+	t.Helper()
+
+	var events []domain.RealtimeEvent
+	for len(events) < count {
+		select {
+		case event := <-connection.Events:
+			events = append(events, event)
+		case <-time.After(time.Second):
+			t.Fatalf("got events %v, want %d", eventIDs(events), count)
+		}
+	}
+	return eventIDs(events)
+}
+
+func TestEventIDAfter(t *testing.T) {
+	tests := []struct {
+		id, other string
+		want      bool
+	}{
+		{id: "2-0", other: "1-5", want: true},
+		{id: "1-6", other: "1-5", want: true},
+		{id: "1-5", other: "1-5", want: false},
+		{id: "1-10", other: "1-9", want: true},
+		{id: "1-5", other: "2-0", want: false},
+		{id: "1-1", other: emptyEventID, want: true},
+		{id: "1-1", other: "", want: true},
+		{id: "broken", other: "1-1", want: false},
+		{id: "1-x", other: "1-1", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.id+" after "+tt.other, func(t *testing.T) {
+			if got := eventIDAfter(tt.id, tt.other); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConnectMissedEvents(t *testing.T) {
+	tests := []struct {
+		name string
+		// Events which Redis still has, older ones are trimmed
+		first, last int
+		lastEventID string
+		want        []string
+	}{
+		{name: "fresh client", first: 1, last: 5, lastEventID: "", want: []string{}},
+		{name: "nothing missed", first: 1, last: 5, lastEventID: "1-5", want: []string{}},
+		{name: "missed some", first: 1, last: 5, lastEventID: "1-3", want: []string{"1-4", "1-5"}},
+		{name: "had no events", first: 1, last: 2, lastEventID: emptyEventID, want: []string{"1-1", "1-2"}},
+		{name: "missed trimmed events", first: 3, last: 5, lastEventID: "1-1", want: []string{"resync"}},
+		{name: "ID from future", first: 1, last: 5, lastEventID: "1-9", want: []string{"resync"}},
+		{name: "broken ID", first: 1, last: 5, lastEventID: "broken", want: []string{"resync"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events := newFakeRealtimeEvents()
+			events.store(1, tt.first, tt.last)
+			service := NewRealtimeService(nil, events, nil)
+
+			_, missed, apiErr := service.Connect(context.Background(), 1, tt.lastEventID)
+			if apiErr != nil {
+				t.Fatalf("cannot connect: %v", apiErr.Message)
+			}
+			if got := eventIDs(missed); !slices.Equal(got, tt.want) {
+				t.Errorf("missed %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCatchUp(t *testing.T) {
+	ctx := context.Background()
+	events := newFakeRealtimeEvents()
+	service := NewRealtimeService(nil, events, nil)
+
+	connections := map[int]*Connection{}
+	for _, userID := range []int{1, 2} {
+		events.store(userID, 1, 2)
+		connection, _, apiErr := service.Connect(ctx, userID, "")
+		if apiErr != nil {
+			t.Fatalf("cannot connect: %v", apiErr.Message)
+		}
+		connections[userID] = connection
+	}
+
+	// Reads of user 1 hang, user 2 must get his events anyway
+	gate := make(chan struct{})
+	events.mu.Lock()
+	events.gates[1] = gate
+	events.mu.Unlock()
+
+	events.store(1, 3, 3)
+	events.store(2, 3, 3)
+	service.startCatchUp(ctx, 1)
+	service.startCatchUp(ctx, 2)
+	if got := receive(t, connections[2], 1); !slices.Equal(got, []string{"1-3"}) {
+		t.Fatalf("user 2 got %v, want [1-3]", got)
+	}
+
+	// Stored while the first read is running, it is read once more after it
+	events.store(1, 4, 4)
+	service.startCatchUp(ctx, 1)
+	close(gate)
+	if got := receive(t, connections[1], 2); !slices.Equal(got, []string{"1-3", "1-4"}) {
+		t.Fatalf("user 1 got %v, want [1-3 1-4]", got)
+	}
+
+	// Delivered events are in backlog of instance, reconnect doesn't read Redis
+	events.mu.Lock()
+	events.events[1] = nil
+	events.mu.Unlock()
+	_, missed, apiErr := service.Connect(ctx, 1, "1-3")
+	if apiErr != nil {
+		t.Fatalf("cannot reconnect: %v", apiErr.Message)
+	}
+	if got := eventIDs(missed); !slices.Equal(got, []string{"1-4"}) {
+		t.Errorf("missed %v, want [1-4]", got)
+	}
+}
+
+func TestConnectWatchFails(t *testing.T) {
+	ctx := context.Background()
+	events := newFakeRealtimeEvents()
+	events.watchErr = utils.NewAPIError(500, "Failed to subscribe to events", "")
+	service := NewRealtimeService(nil, events, nil)
+
+	if _, _, apiErr := service.Connect(ctx, 1, ""); apiErr == nil || apiErr.Code != 500 {
+		t.Fatalf("got error %v, want 500", apiErr)
+	}
+	if !slices.Equal(events.unwatched, []int{1}) {
+		t.Fatalf("unwatched %v, want [1]", events.unwatched)
+	}
+
+	// Failed user is forgotten, the next connection watches him again
+	events.mu.Lock()
+	events.watchErr = nil
+	events.mu.Unlock()
+	done := make(chan *utils.APIError)
+	go func() {
+		_, _, apiErr := service.Connect(ctx, 1, "")
+		done <- apiErr
+	}()
+
+	select {
+	case apiErr := <-done:
+		if apiErr != nil {
+			t.Fatalf("cannot connect: %v", apiErr.Message)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("connection waits for failed watch")
+	}
+}
@@ -0,0 +1,212 @@
+package services
+
+import (
+	"context"
+	"message-service/internal/domain"
+	"message-service/internal/utils"
+	"time"
+
+	logger "message-service/internal"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// How many times swap is retried if messages table is busy
+	swapAttempts = 10
+	// How many changes made during copy are applied to staging tables in one transaction
+	changesBatch = 10000
+)
+
+// Repartitioner moves existing messages to partitions of new scheme without stopping the service.
+// Each group of new partitions is filled in background, then swapped with old ones in one short transaction
+type Repartitioner struct {
+	repo   domain.PartitionRepository
+	scheme domain.PartitionScheme
+	dryRun bool
+}
+
+func NewRepartitioner(repo domain.PartitionRepository, scheme domain.PartitionScheme, dryRun bool) *Repartitioner {
+	return &Repartitioner{repo: repo, scheme: scheme, dryRun: dryRun}
+}
+
+// partitionGroup is a set of new partitions which replace a set of old ones. Their ranges must be the same,
+// e.g. weeks which cross boundary of month are in one group with both monthly partitions
+type partitionGroup struct {
+	partitions []domain.Partition
+	old        []domain.Partition
+}
+
+func (r *Repartitioner) Run(ctx context.Context) *utils.APIError {
+	// Partition manager must not create partitions of old scheme while we work
+	if apiErr := r.lock(ctx); apiErr != nil {
+		return apiErr
+	}
+	defer r.repo.Unlock(ctx)
+
+	partitions, apiErr := r.repo.ListPartitions(ctx)
+	if apiErr != nil {
+		return apiErr
+	}
+
+	groups := r.planGroups(partitions)
+	logger.Info("Re-partitioning plan is ready", zap.Int("Groups", len(groups)))
+
+	for _, group := range groups {
+		if r.dryRun {
+			logger.Info("Would replace partitions",
+				zap.Strings("Old", partitionNames(group.old)),
+				zap.Strings("New", partitionNames(group.partitions)))
+			continue
+		}
+
+		if apiErr := r.repartitionGroup(ctx, &group); apiErr != nil {
+			logger.Error("Cannot re-partition, stopping",
+				zap.Strings("Old", partitionNames(group.old)),
+				zap.String("error", apiErr.Message))
+			return apiErr
+		}
+	}
+
+	return nil
+}
+
+func (r *Repartitioner) lock(ctx context.Context) *utils.APIError {
+	for attempt := 0; attempt < 60; attempt++ {
+		locked, apiErr := r.repo.TryLock(ctx)
+		if apiErr != nil {
+			return apiErr
+		}
+		if locked {
+			return nil
+		}
+		time.Sleep(time.Second)
+	}
+	return utils.NewAPIError(409, "Partitions are managed by somebody else", "Try again later")
+}
+
+// planGroups splits range covered by existing partitions into groups of new partitions.
+// Groups which already match new scheme are skipped
+func (r *Repartitioner) planGroups(partitions []domain.Partition) []partitionGroup {
+	if len(partitions) == 0 {
+		return nil
+	}
+
+	g := r.scheme.Granularity
+	from := g.PeriodStart(partitions[0].Start)
+	to := partitions[0].End
+	for _, partition := range partitions {
+		if partition.End.After(to) {
+			to = partition.End
+		}
+	}
+
+	var targets []domain.Partition
+	for start := from; start.Before(to); start = g.NextPeriod(start) {
+		targets = append(targets, r.scheme.Partition(start))
+	}
+
+	var groups []partitionGroup
+	for i := 0; i < len(targets); {
+		group := partitionGroup{}
+		start := targets[i].Start
+		end := targets[i].End
+
+		// Grow group until no old partition sticks out of it
+		for i < len(targets) && targets[i].Start.Before(end) {
+			group.partitions = append(group.partitions, targets[i])
+			if targets[i].End.After(end) {
+				end = targets[i].End
+			}
+			for _, partition := range partitions {
+				if partition.Overlaps(targets[i].Start, targets[i].End) && partition.End.After(end) {
+					end = partition.End
+				}
+			}
+			i++
+		}
+
+		for _, partition := range partitions {
+			if partition.Overlaps(start, end) {
+				group.old = append(group.old, partition)
+			}
+		}
+
+		if len(group.partitions) == 1 && len(group.old) == 1 && samePartition(&group.partitions[0], &group.old[0]) {
+			continue
+		}
+		groups = append(groups, group)
+	}
+
+	return groups
+}
+
+func (r *Repartitioner) repartitionGroup(ctx context.Context, group *partitionGroup) *utils.APIError {
+	// Started before copy, so every change copy could miss is recorded
+	if apiErr := r.repo.StartChangeTracking(ctx); apiErr != nil {
+		return apiErr
+	}
+	// Swap stops it itself, this is for the case it is never reached
+	defer r.repo.StopChangeTracking(context.Background())
+
+	for _, partition := range group.partitions {
+		if apiErr := r.repo.CreateStagingPartition(ctx, &partition); apiErr != nil {
+			return apiErr
+		}
+
+		copied, apiErr := r.repo.CopyToStaging(ctx, &partition)
+		if apiErr != nil {
+			return apiErr
+		}
+		logger.Info("Copied messages to new partition",
+			zap.String("Partition", partition.Name),
+			zap.Int64("Messages", copied))
+	}
+
+	var apiErr *utils.APIError
+	for attempt := 0; attempt < swapAttempts; attempt++ {
+		if apiErr := r.catchUp(ctx, group); apiErr != nil {
+			return apiErr
+		}
+
+		apiErr = r.repo.SwapPartitions(ctx, group.partitions, group.old)
+		if apiErr == nil {
+			logger.Info("Replaced partitions",
+				zap.Strings("Old", partitionNames(group.old)),
+				zap.Strings("New", partitionNames(group.partitions)))
+			return nil
+		}
+		// Only busy table is worth retry
+		if apiErr.Code != 503 {
+			return apiErr
+		}
+		time.Sleep(time.Duration(attempt+1) * time.Second)
+	}
+
+	return apiErr
+}
+
+// catchUp applies changes made during copy outside of swap, so table is locked only for the last few of them
+func (r *Repartitioner) catchUp(ctx context.Context, group *partitionGroup) *utils.APIError {
+	for {
+		applied, apiErr := r.repo.ApplyChanges(ctx, group.partitions, changesBatch)
+		if apiErr != nil {
+			return apiErr
+		}
+		if applied < changesBatch {
+			return nil
+		}
+	}
+}
+
+func samePartition(a, b *domain.Partition) bool {
+	return a.Name == b.Name && a.Start.Equal(b.Start) && a.End.Equal(b.End) && a.HashBuckets == b.HashBuckets
+}
+
+func partitionNames(partitions []domain.Partition) []string {
+	names := make([]string, 0, len(partitions))
+	for _, partition := range partitions {
+		names = append(names, partition.Name)
+	}
+	return names
+}
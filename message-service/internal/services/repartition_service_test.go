@@ -0,0 +1,99 @@
+package services
+
+import (
+	"message-service/internal/domain"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPlanGroups(t *testing.T) {
+	daily := domain.PartitionScheme{Granularity: domain.Daily}
+	weekly := domain.PartitionScheme{Granularity: domain.Weekly}
+	monthly := domain.PartitionScheme{Granularity: domain.Monthly}
+	// Monday
+	jan1 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	// Monday of week which crosses end of January
+	jan29 := time.Date(2024, 1, 29, 0, 0, 0, 0, time.UTC)
+
+	type group struct {
+		partitions []string
+		old        []string
+	}
+
+	tests := []struct {
+		name       string
+		partitions []domain.Partition
+		scheme     domain.PartitionScheme
+		want       []group
+	}{
+		{
+			name:   "nothing to do",
+			scheme: weekly,
+		},
+		{
+			name:       "already in scheme",
+			partitions: []domain.Partition{weekly.Partition(jan1), weekly.Partition(jan1.AddDate(0, 0, 7))},
+			scheme:     weekly,
+		},
+		{
+			name:       "days into week",
+			partitions: []domain.Partition{daily.Partition(jan1), daily.Partition(jan1.AddDate(0, 0, 1)), daily.Partition(jan1.AddDate(0, 0, 2))},
+			scheme:     weekly,
+			want: []group{
+				{partitions: []string{"messages_2024_w01"}, old: []string{"messages_2024_01_01", "messages_2024_01_02", "messages_2024_01_03"}},
+			},
+		},
+		{
+			name:       "days into two weeks",
+			partitions: []domain.Partition{daily.Partition(jan1.AddDate(0, 0, 6)), daily.Partition(jan1.AddDate(0, 0, 7))},
+			scheme:     weekly,
+			want: []group{
+				{partitions: []string{"messages_2024_w01"}, old: []string{"messages_2024_01_07"}},
+				{partitions: []string{"messages_2024_w02"}, old: []string{"messages_2024_01_08"}},
+			},
+		},
+		{
+			name:       "week across months",
+			partitions: []domain.Partition{weekly.Partition(jan29), weekly.Partition(jan29.AddDate(0, 0, 7))},
+			scheme:     monthly,
+			want: []group{
+				{partitions: []string{"messages_2024_01", "messages_2024_02"}, old: []string{"messages_2024_w05", "messages_2024_w06"}},
+			},
+		},
+		{
+			name:       "month into weeks",
+			partitions: []domain.Partition{monthly.Partition(time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC))},
+			scheme:     weekly,
+			want: []group{
+				{
+					partitions: []string{"messages_2024_w05", "messages_2024_w06", "messages_2024_w07", "messages_2024_w08", "messages_2024_w09"},
+					old:        []string{"messages_2024_02"},
+				},
+			},
+		},
+		{
+			name:       "hash buckets added",
+			partitions: []domain.Partition{daily.Partition(jan1)},
+			scheme:     domain.PartitionScheme{Granularity: domain.Daily, HashBuckets: 4},
+			want: []group{
+				{partitions: []string{"messages_2024_01_01"}, old: []string{"messages_2024_01_01"}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRepartitioner(nil, tt.scheme, false)
+
+			var got []group
+			for _, g := range r.planGroups(tt.partitions) {
+				got = append(got, group{partitions: partitionNames(g.partitions), old: partitionNames(g.old)})
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got groups %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package services
+
+import (
+	"context"
+	"message-service/internal/domain"
+	"message-service/internal/utils"
+	"time"
+
+	logger "message-service/internal"
+
+	"go.uber.org/zap"
+)
+
+// How many entries of sync log are returned at once
+const (
+	defaultSyncLimit = 100
+	maxSyncLimit     = 500
+)
+
+// SyncService gives clients which were offline everything that happened to them, in order
+type SyncService struct {
+	repo domain.SyncRepository
+	// Entries older than that are deleted, 0 keeps them forever
+	retention time.Duration
+	interval  time.Duration
+}
+
+func NewSyncService(repo domain.SyncRepository, retention, interval time.Duration) *SyncService {
+	return &SyncService{repo: repo, retention: retention, interval: interval}
+}
+
+// GetSync returns entries after since. If some of them are deleted already, page says to resync
+func (s *SyncService) GetSync(ctx context.Context, userID int, since int64, limit int) (*domain.SyncPage, *utils.APIError) {
+	if since < 0 {
+		return nil, utils.NewAPIError(400, "Invalid input data", "since can't be negative")
+	}
+
+	if limit <= 0 {
+		limit = defaultSyncLimit
+	}
+	if limit > maxSyncLimit {
+		limit = maxSyncLimit
+	}
+
+	// One more to know if there is more
+	entries, apiErr := s.repo.GetEntries(ctx, userID, since, limit+1)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+
+	// Numbers of user have no gaps, so the next one must be since + 1
+	if len(entries) > 0 && entries[0].Seq == since+1 {
+		page := &domain.SyncPage{Entries: entries, HasMore: len(entries) > limit}
+		if page.HasMore {
+			page.Entries = entries[:limit]
+		}
+		page.NextSeq = page.Entries[len(page.Entries)-1].Seq
+		return page, nil
+	}
+
+	state, apiErr := s.repo.GetState(ctx, userID)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+
+	// Nothing new
+	if len(entries) == 0 && since == state.LastSeq {
+		return &domain.SyncPage{Entries: []domain.SyncEntry{}, NextSeq: since}, nil
+	}
+
+	// Entries after since are compacted, or client has number which we never gave
+	return &domain.SyncPage{Entries: []domain.SyncEntry{}, NextSeq: state.LastSeq, Resync: true}, nil
+}
+
+// Run compacts sync log. It blocks until context is cancelled, so start it in goroutine
+func (s *SyncService) Run(ctx context.Context) {
+	if s.retention <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		// Timestamps are stored without time zone, in UTC
+		deleted, apiErr := s.repo.Compact(ctx, time.Now().UTC().Add(-s.retention))
+		if apiErr == nil && deleted > 0 {
+			logger.Info("Compacted sync log", zap.Int64("Deleted", deleted))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"message-service/internal/domain"
+	"message-service/internal/utils"
+	"time"
+
+	logger "message-service/internal"
+
+	"go.uber.org/zap"
+)
+
+// Ticket is used right after it is issued, so it lives only a few seconds
+const RealtimeTicketTTL = 30 * time.Second
+
+// TicketService issues one-time tickets for /ws and /events. Browsers can't set Authorization header there,
+// and token in URL would end up in access logs of every proxy
+type TicketService struct {
+	tickets domain.RealtimeTicketRepository
+}
+
+func NewTicketService(tickets domain.RealtimeTicketRepository) *TicketService {
+	return &TicketService{tickets: tickets}
+}
+
+// IssueTicket makes ticket for user whose token expires at tokenExpiresAt
+func (s *TicketService) IssueTicket(ctx context.Context, userID int, role domain.UserRole, tokenExpiresAt time.Time) (string, *utils.APIError) {
+	randomBytes := make([]byte, 32)
+	if _, err := rand.Read(randomBytes); err != nil {
+		logger.Error("Cannot generate ticket", zap.Error(err))
+		return "", utils.NewAPIError(500, "Internal server error", "Please try again")
+	}
+	id := hex.EncodeToString(randomBytes)
+
+	ticket := &domain.RealtimeTicket{UserID: userID, Role: role, TokenExpiresAt: tokenExpiresAt}
+	if apiErr := s.tickets.SaveTicket(ctx, id, ticket, RealtimeTicketTTL); apiErr != nil {
+		return "", apiErr
+	}
+
+	return id, nil
+}
+
+// RedeemTicket returns ticket and makes it unusable. Returns nil if ticket is unknown, used or expired
+func (s *TicketService) RedeemTicket(ctx context.Context, id string) (*domain.RealtimeTicket, *utils.APIError) {
+	ticket, apiErr := s.tickets.TakeTicket(ctx, id)
+	if apiErr != nil || ticket == nil {
+		return nil, apiErr
+	}
+
+	// Token may expire before ticket does
+	if !ticket.TokenExpiresAt.IsZero() && time.Now().After(ticket.TokenExpiresAt) {
+		return nil, nil
+	}
+
+	return ticket, nil
+}
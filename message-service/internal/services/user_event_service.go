@@ -0,0 +1,133 @@
+package services
+
+import (
+	"context"
+	"message-service/internal/domain"
+	"message-service/internal/utils"
+	"time"
+
+	logger "message-service/internal"
+
+	"go.uber.org/zap"
+)
+
+// Failed event is retried with growing pause, so database which is down isn't hammered
+const (
+	userEventRetryMin = time.Second
+	userEventRetryMax = time.Minute
+)
+
+// UserEventService consumes events of auth service about users
+type UserEventService struct {
+	events   domain.UserEventRepository
+	messages domain.MessageRepository
+	archive  *ArchiveService
+	policy   domain.ErasurePolicy
+	consumer string
+}
+
+func NewUserEventService(events domain.UserEventRepository, messages domain.MessageRepository, archive *ArchiveService, policy domain.ErasurePolicy, consumer string) *UserEventService {
+	return &UserEventService{
+		events:   events,
+		messages: messages,
+		archive:  archive,
+		policy:   policy,
+		consumer: consumer,
+	}
+}
+
+// Run blocks until context is cancelled, so start it in goroutine
+func (s *UserEventService) Run(ctx context.Context) {
+	// First finish events which we took before restart
+	pending := true
+
+	for ctx.Err() == nil {
+		events, apiErr := s.events.ReadUserEvents(ctx, s.consumer, pending)
+		if apiErr != nil {
+			time.Sleep(time.Second)
+			continue
+		}
+
+		if pending && len(events) == 0 {
+			pending = false
+			continue
+		}
+
+		for _, event := range events {
+			// Events are handled in order, the next one waits until this one succeeds
+			if !s.handleWithRetry(ctx, &event) {
+				return
+			}
+			s.events.AckUserEvent(ctx, event.ID)
+		}
+	}
+}
+
+// handleWithRetry handles event until it succeeds. Returns false if context was cancelled before that
+func (s *UserEventService) handleWithRetry(ctx context.Context, event *domain.UserEvent) bool {
+	backoff := userEventRetryMin
+
+	for {
+		apiErr := s.handleEvent(ctx, event)
+		if apiErr == nil {
+			return true
+		}
+
+		logger.Error("Cannot handle user event, will retry",
+			zap.String("Event ID", event.ID),
+			zap.String("Type", string(event.Type)),
+			zap.Duration("Retry in", backoff),
+			zap.String("error", apiErr.Message))
+
+		select {
+		case <-ctx.Done():
+			// Not acknowledged event will come back after restart
+			return false
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > userEventRetryMax {
+			backoff = userEventRetryMax
+		}
+	}
+}
+
+// Events can come more than once, so handling must be idempotent
+func (s *UserEventService) handleEvent(ctx context.Context, event *domain.UserEvent) *utils.APIError {
+	switch event.Type {
+	case domain.UserDeletedEvent:
+		if event.UserID == 0 {
+			logger.Warn("Skipping broken user event", zap.String("Event ID", event.ID))
+			return nil
+		}
+		return s.handleUserDeleted(ctx, event.UserID)
+	default:
+		// Not our business
+		return nil
+	}
+}
+
+func (s *UserEventService) handleUserDeleted(ctx context.Context, userID int) *utils.APIError {
+	if s.policy == domain.ErasurePolicyAnonymize {
+		logger.Info("User deleted, messages are kept anonymized", zap.Int("User ID", userID))
+		return nil
+	}
+
+	// Both steps can be repeated, so if one fails the whole event is simply retried
+	archived, apiErr := s.archive.DeleteUserMessages(ctx, userID)
+	if apiErr != nil {
+		return apiErr
+	}
+
+	deleted, apiErr := s.messages.DeleteUserMessages(ctx, userID)
+	if apiErr != nil {
+		return apiErr
+	}
+
+	logger.Info("User deleted, messages erased",
+		zap.Int("User ID", userID),
+		zap.Int64("Messages", deleted),
+		zap.Int64("Archived messages", archived))
+	return nil
+}